@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/pipelines"
+	"github.com/thethan/goqueue/pkg/queue"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const defaultDryRunLimit = 100
+
+// dryRunCommand evaluates the jobs of configuration files' pipelines and
+// reports what they would have done to each of them.
+func dryRunCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("dry-run", stderr)
+	var files configFiles
+	flags.Var(&files, "config", "configuration file, can be repeated (default $"+envConfig+", comma separated)")
+	limit := flags.Int("limit", defaultDryRunLimit, "number of jobs evaluated, 0 for every job")
+	format := flags.String("format", "text", "report format: text or json")
+	var logging logging
+	logging.register(flags)
+
+	if err := parse(flags, args); err != nil {
+		return err
+	}
+
+	if *format != "text" && *format != "json" {
+		return usageError{fmt.Errorf("unknown format %q", *format)}
+	}
+
+	paths, err := files.paths()
+	if err != nil {
+		return err
+	}
+
+	logging.setup()
+	defer logs.Sync()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	results := make(map[string][]pipelines.JobReport, len(paths))
+	for _, path := range paths {
+		dryRun, err := queue.BuildDryRun(ctx, path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		reports, err := dryRun.Run(ctx, *limit)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		results[path] = reports
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if len(paths) == 1 {
+			return encoder.Encode(results[paths[0]])
+		}
+
+		return encoder.Encode(results)
+	}
+
+	for _, path := range paths {
+		if len(paths) > 1 {
+			fmt.Fprintf(stdout, "%s\n\n", path)
+		}
+
+		writeReports(stdout, results[path])
+	}
+
+	return nil
+}
+
+// writeReports writes the reports of a dry run as text, followed by how many
+// times each call would have been made.
+func writeReports(w io.Writer, reports []pipelines.JobReport) {
+	counts := map[string]int{}
+	count := func(calls []pipelines.Call) {
+		for _, call := range calls {
+			counts[describe(call)]++
+		}
+	}
+
+	for _, report := range reports {
+		fmt.Fprintf(w, "job %s\n", report.Job)
+		for _, tree := range report.Trees {
+			result := "not matched"
+			switch {
+			case tree.Error != "":
+				result = "error: " + tree.Error
+			case tree.Matched:
+				result = "matched"
+			}
+
+			branch := ""
+			if tree.Branch != "" {
+				branch = ", " + tree.Branch + " branch"
+			}
+
+			if tree.Return {
+				branch += ", returned"
+			}
+
+			fmt.Fprintf(w, "  %s: %s%s\n", tree.Name, result, branch)
+			for _, call := range tree.Calls {
+				fmt.Fprintf(w, "    %s\n", describe(call))
+			}
+
+			count(tree.Calls)
+		}
+
+		for _, call := range report.Calls {
+			fmt.Fprintf(w, "  %s\n", describe(call))
+		}
+
+		count(report.Calls)
+		for _, err := range report.Errors {
+			fmt.Fprintf(w, "  error: %s\n", err)
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	calls := make([]string, 0, len(counts))
+	for call := range counts {
+		calls = append(calls, call)
+	}
+
+	sort.Strings(calls)
+
+	fmt.Fprintf(w, "jobs evaluated: %d\n", len(reports))
+	for _, call := range calls {
+		fmt.Fprintf(w, "  %s: %d\n", call, counts[call])
+	}
+}
+
+func describe(call pipelines.Call) string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", call.Kind, call.Target, call.Action))
+}
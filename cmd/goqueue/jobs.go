@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/pkg/redis/redis/tx"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultBatchSize = 100
+	defaultSample    = 5
+)
+
+// stdin is where confirmations are read from.
+var stdin io.Reader = os.Stdin
+
+func jobCommands() []command {
+	return []command{
+		{name: "move", summary: "move the matching jobs of a queue to another queue", run: moveJobsCommand},
+		{name: "requeue", summary: "put the matching jobs of a queue back to be run, such as retrying sidekiq jobs now", run: requeueJobsCommand},
+		{name: "delete", summary: "delete the matching jobs of a queue", run: deleteJobsCommand},
+	}
+}
+
+// jobsCommand changes the jobs of a queue in bulk. The jobs are selected
+// with -where, counted and sampled for confirmation, then changed a batch
+// at a time, each job on its own, and the payload of each job is written to
+// an undo file before it is changed.
+func jobsCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		jobsUsage(stderr)
+		return flag.ErrHelp
+	}
+
+	for _, cmd := range jobCommands() {
+		if cmd.name == args[0] {
+			return cmd.run(ctx, args[1:], stdout, stderr)
+		}
+	}
+
+	jobsUsage(stderr)
+
+	return usageError{fmt.Errorf("unknown subcommand %q", args[0])}
+}
+
+func jobsUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: goqueue jobs <subcommand> [flags] <queue> [destination]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "subcommands:")
+	for _, cmd := range jobCommands() {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+}
+
+// bulk is the flags of the jobs subcommands.
+type bulk struct {
+	inspection inspection
+	where      conditions
+	all        bool
+	limit      int
+	rate       float64
+	batchSize  int64
+	sample     int
+	yes        bool
+	undo       string
+}
+
+func (b *bulk) register(flags *flag.FlagSet) {
+	b.inspection.register(flags)
+	flags.Var(&b.where, "where", "select jobs matching 'element operator comparison', can be repeated")
+	flags.BoolVar(&b.all, "all", false, "select every job of the queue, instead of -where")
+	flags.IntVar(&b.limit, "limit", 0, "number of jobs changed, 0 for every selected job")
+	flags.Float64Var(&b.rate, "rate", 0, "jobs changed a second, 0 for no limit")
+	flags.Int64Var(&b.batchSize, "batch-size", defaultBatchSize, "jobs read and changed at a time")
+	flags.IntVar(&b.sample, "sample", defaultSample, "number of selected jobs shown before confirming")
+	flags.BoolVar(&b.yes, "yes", false, "change the jobs without asking for confirmation")
+	flags.StringVar(&b.undo, "undo", "", "file the payloads of changed jobs are appended to (default goqueue-undo-<time>.jsonl)")
+}
+
+func (b *bulk) check() error {
+	switch {
+	case len(b.where.checks) == 0 && !b.all:
+		return usageError{errors.New("select jobs with -where, or every job with -all")}
+	case len(b.where.checks) > 0 && b.all:
+		return usageError{errors.New("-where and -all can't be used together")}
+	case b.limit < 0 || b.rate < 0 || b.sample < 0:
+		return usageError{errors.New("-limit, -rate and -sample can't be negative")}
+	case b.batchSize <= 0:
+		return usageError{errors.New("-batch-size has to be positive")}
+	}
+
+	if b.undo == "" {
+		b.undo = fmt.Sprintf("goqueue-undo-%s.jsonl", time.Now().UTC().Format("20060102T150405Z"))
+	}
+
+	return nil
+}
+
+// change is what a subcommand does to a job, which leaves the queue it was
+// read from when it succeeds.
+type change func(ctx context.Context, j job.Job) error
+
+// undoRecord is a line of the undo file: the payload of a job as it was in
+// Queue before Command changed it.
+type undoRecord struct {
+	Command string `json:"command"`
+	Queue   string `json:"queue"`
+	To      string `json:"to,omitempty"`
+	Payload string `json:"payload,omitempty"`
+	// PayloadBase64 holds payloads that aren't text, such as msgpack jobs
+	PayloadBase64 []byte `json:"payloadBase64,omitempty"`
+}
+
+func moveJobsCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("jobs move", stderr)
+	var bulk bulk
+	bulk.register(flags)
+
+	names, err := parseQueues(flags, args, "queue", "destination queue")
+	if err != nil {
+		return err
+	}
+
+	if names[0] == names[1] {
+		return usageError{errors.New("can't move jobs to the queue they are in")}
+	}
+
+	if err := bulk.check(); err != nil {
+		return err
+	}
+
+	// built together, queues on the same redis data source share a client
+	// and their jobs are moved in a transaction
+	queueMap, _, err := bulk.inspection.queues(ctx, names...)
+	if err != nil {
+		return err
+	}
+
+	source, destination := queueMap[names[0]], queueMap[names[1]]
+
+	defer logs.Sync()
+
+	fn := func(ctx context.Context, j job.Job) error {
+		return tx.Move(ctx, destination, source, j)
+	}
+
+	return bulk.run(ctx, "move", names[0], names[1], source, fn, stdout)
+}
+
+func requeueJobsCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("jobs requeue", stderr)
+	var bulk bulk
+	bulk.register(flags)
+
+	name, err := parseQueue(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if err := bulk.check(); err != nil {
+		return err
+	}
+
+	source, inspector, err := bulk.inspection.queue(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	defer logs.Sync()
+
+	actionName, ok := inspector.RequeueAction(name)
+	actionQueue, isActionQueue := source.(queues.ActionQueue)
+	if !ok || !isActionQueue {
+		return fmt.Errorf("queue %s has no action to requeue jobs with", name)
+	}
+
+	action, ok := actionQueue.Action(actionName)
+	if !ok {
+		return fmt.Errorf("queue %s has no action %q", name, actionName)
+	}
+
+	fn := func(ctx context.Context, j job.Job) error {
+		return queues.Collect(func(errChan chan error) {
+			action(ctx, j, &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		})
+	}
+
+	return bulk.run(ctx, "requeue", name, "", source, fn, stdout)
+}
+
+func deleteJobsCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("jobs delete", stderr)
+	var bulk bulk
+	bulk.register(flags)
+
+	name, err := parseQueue(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if err := bulk.check(); err != nil {
+		return err
+	}
+
+	source, _, err := bulk.inspection.queue(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	defer logs.Sync()
+
+	fn := func(ctx context.Context, j job.Job) error {
+		return queues.Collect(func(errChan chan error) {
+			source.RemoveItems(ctx, j, &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		})
+	}
+
+	return bulk.run(ctx, "delete", name, "", source, fn, stdout)
+}
+
+// run selects the jobs of the queue name, asks for confirmation and changes
+// them with fn. Jobs that could not be changed are reported and left where
+// they are, the rest of the jobs are still changed. Jobs moved but not
+// removed from name are reported as left in both queues.
+func (b *bulk) run(ctx context.Context, command, name, to string, q queues.Queue, fn change, stdout io.Writer) error {
+	source, ok := q.(queues.PageQueue)
+	if !ok {
+		return fmt.Errorf("queue %s can't be inspected", name)
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	selected, sample, err := b.selectJobs(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	if selected == 0 {
+		fmt.Fprintf(stdout, "no jobs of %s selected\n", name)
+		return nil
+	}
+
+	fmt.Fprintf(stdout, "%d jobs of %s selected", selected, name)
+	if len(sample) > 0 {
+		fmt.Fprintf(stdout, ", the first %d:", len(sample))
+	}
+
+	fmt.Fprintln(stdout)
+
+	w := (&output{format: "jsonl"}).writer(stdout)
+	for _, j := range sample {
+		if err := w.write(j); err != nil {
+			return err
+		}
+	}
+
+	if !b.yes {
+		target := ""
+		if to != "" {
+			target = " to " + to
+		}
+
+		fmt.Fprintf(stdout, "%s %d jobs of %s%s? [y/N] ", command, selected, name, target)
+		answer, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			return errors.New("not confirmed, no jobs changed")
+		}
+	}
+
+	file, err := os.OpenFile(b.undo, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	undo := &undoFile{file: file, record: undoRecord{Command: command, Queue: name, To: to}}
+	changed, failed, duplicated, err := b.apply(ctx, source, fn, undo)
+
+	fmt.Fprintf(stdout, "%d jobs changed, %d failed, undo file %s\n", changed, failed, b.undo)
+	if duplicated > 0 {
+		fmt.Fprintf(stdout, "%d jobs were pushed to %s but could not be removed from %s, they are in both queues\n", duplicated, to, name)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("could not %s %d jobs", command, failed)
+	}
+
+	return nil
+}
+
+// selectJobs counts the jobs that would be changed, up to -limit, and
+// returns the first -sample of them.
+func (b *bulk) selectJobs(ctx context.Context, source queues.PageQueue) (int, []job.Job, error) {
+	selected := 0
+	sample := make([]job.Job, 0, b.sample)
+	err := queues.Scan(ctx, source, 0, b.batchSize, func(j job.Job) (bool, error) {
+		matched, err := b.match(ctx, j)
+		if err != nil || !matched {
+			return true, err
+		}
+
+		selected++
+		if len(sample) < b.sample {
+			sample = append(sample, j)
+		}
+
+		return b.limit == 0 || selected < b.limit, nil
+	})
+
+	return selected, sample, err
+}
+
+func (b *bulk) match(ctx context.Context, j job.Job) (bool, error) {
+	if b.all {
+		return true, nil
+	}
+
+	return b.where.match(ctx, j)
+}
+
+// apply changes the selected jobs a batch at a time, writing each job to
+// undo before changing it. Changed jobs leave the queue, so each batch
+// starts after the jobs of the previous one that are still there. It returns
+// how many jobs were changed, how many failed and how many of those were
+// left in both queues by a move.
+func (b *bulk) apply(ctx context.Context, source queues.PageQueue, fn change, undo *undoFile) (int, int, int, error) {
+	var tick <-chan time.Time
+	if b.rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / b.rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	total, err := source.Count(ctx)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	done, failed, duplicated := 0, 0, 0
+	for offset := int64(0); offset < total; {
+		jobs, err := source.Page(ctx, offset, b.batchSize)
+		if err != nil {
+			return done, failed, duplicated, err
+		}
+
+		removed := int64(0)
+		for _, j := range jobs {
+			if b.limit > 0 && done >= b.limit {
+				return done, failed, duplicated, nil
+			}
+
+			matched, err := b.match(ctx, j)
+			if err != nil || !matched {
+				continue
+			}
+
+			if tick != nil {
+				select {
+				case <-ctx.Done():
+					return done, failed, duplicated, ctx.Err()
+				case <-tick:
+				}
+			}
+
+			if err := ctx.Err(); err != nil {
+				return done, failed, duplicated, err
+			}
+
+			size, err := undo.write(j)
+			if err != nil {
+				return done, failed, duplicated, err
+			}
+
+			if err := fn(ctx, j); err != nil {
+				// the job is still in the queue, the undo file only has
+				// the jobs that left it
+				if err := undo.discard(size); err != nil {
+					return done, failed, duplicated, err
+				}
+
+				failed++
+				if errors.Is(err, queues.ErrNotRemoved) {
+					logs.Error(ctx, "job left in both queues", logs.WithError(err), logs.WithValue("job", string(j.Original())))
+					duplicated++
+
+					continue
+				}
+
+				logs.Error(ctx, "could not change job", logs.WithError(err), logs.WithValue("job", string(j.Original())))
+
+				continue
+			}
+
+			removed++
+			done++
+		}
+
+		logs.Info(ctx, "changed batch of jobs", logs.WithValue("offset", offset), logs.WithValue("changed", removed), logs.WithValue("total", done))
+
+		offset += b.batchSize - removed
+		total -= removed
+	}
+
+	return done, failed, duplicated, nil
+}
+
+// undoFile is the undo file of a run, each record is synced before its job
+// is changed so a payload is never lost, even if the run is killed. A job
+// that was being changed when that happened may be in it unchanged.
+type undoFile struct {
+	file   *os.File
+	record undoRecord
+}
+
+// write appends j to the file and syncs it, returning the size of the file
+// before it, which discard takes it back to.
+func (u *undoFile) write(j job.Job) (int64, error) {
+	info, err := u.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := writeUndo(u.file, u.record, j); err != nil {
+		_ = u.file.Truncate(info.Size())
+		return 0, err
+	}
+
+	return info.Size(), u.file.Sync()
+}
+
+// discard removes the records written after size, of jobs that weren't
+// changed.
+func (u *undoFile) discard(size int64) error {
+	if err := u.file.Truncate(size); err != nil {
+		return err
+	}
+
+	return u.file.Sync()
+}
+
+// writeUndo appends the payload of j to the undo file.
+func writeUndo(w io.Writer, record undoRecord, j job.Job) error {
+	if payload := j.Original(); utf8.Valid(payload) {
+		record.Payload = string(payload)
+	} else {
+		record.PayloadBase64 = payload
+	}
+
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(encoded, '\n'))
+
+	return err
+}
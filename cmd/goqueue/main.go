@@ -0,0 +1,184 @@
+// Command goqueue runs the pipelines described by goqueue configuration
+// files.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/thethan/goqueue/internal/logs"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	envConfig          = "GOQUEUE_CONFIG"
+	envLogLevel        = "LOG_LEVEL"
+	envLogFormat       = "LOG_FORMAT"
+	envMetricsExporter = "METRICS_EXPORTER"
+	envMetricsAddr     = "METRICS_ADDR"
+)
+
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+)
+
+// command is a goqueue subcommand, run with the arguments that follow its
+// name.
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string, stdout, stderr io.Writer) error
+}
+
+func commands() []command {
+	return []command{
+		{name: "run", summary: "run the pipelines of configuration files until interrupted", run: runCommand},
+		{name: "dry-run", summary: "report what pipelines would do to their jobs without doing it", run: dryRunCommand},
+		{name: "validate", summary: "check configuration files without connecting to their data sources", run: validateCommand},
+		{name: "queues", summary: "list, count, peek at, search and dump the jobs of queues", run: queuesCommand},
+		{name: "jobs", summary: "move, requeue or delete the matching jobs of a queue in bulk", run: jobsCommand},
+	}
+}
+
+// usageError is an error in how a command was called.
+type usageError struct {
+	error
+}
+
+func main() {
+	os.Exit(execute(context.Background(), os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// execute runs the command named by args and returns the exit code.
+func execute(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		usage(stderr)
+		return exitUsage
+	}
+
+	for _, cmd := range commands() {
+		if cmd.name != args[0] {
+			continue
+		}
+
+		err := cmd.run(ctx, args[1:], stdout, stderr)
+		var usageErr usageError
+		switch {
+		case err == nil:
+			return exitOK
+		case errors.Is(err, flag.ErrHelp):
+			return exitUsage
+		case errors.As(err, &usageErr):
+			fmt.Fprintf(stderr, "goqueue %s: %v\n", cmd.name, err)
+			return exitUsage
+		default:
+			fmt.Fprintf(stderr, "goqueue %s: %v\n", cmd.name, err)
+			return exitError
+		}
+	}
+
+	fmt.Fprintf(stderr, "goqueue: unknown command %q\n", args[0])
+	usage(stderr)
+
+	return exitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: goqueue <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run goqueue <command> -h for a command's flags.")
+}
+
+// newFlagSet returns the flags of a command, which report errors to stderr.
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet("goqueue "+name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	return flags
+}
+
+// parse parses a command's flags, which take no arguments.
+func parse(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+
+		return usageError{err}
+	}
+
+	if flags.NArg() > 0 {
+		return usageError{fmt.Errorf("unexpected arguments %s", strings.Join(flags.Args(), " "))}
+	}
+
+	return nil
+}
+
+// configFiles is the -config flag, which can be repeated.
+type configFiles []string
+
+func (c *configFiles) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *configFiles) Set(value string) error {
+	*c = append(*c, value)
+	return nil
+}
+
+// paths returns the configuration files given by -config, or else by the
+// comma separated GOQUEUE_CONFIG.
+func (c *configFiles) paths() ([]string, error) {
+	if len(*c) > 0 {
+		return *c, nil
+	}
+
+	paths := make([]string, 0)
+	for _, path := range strings.Split(os.Getenv(envConfig), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, usageError{fmt.Errorf("no configuration file, set -config or %s", envConfig)}
+	}
+
+	return paths, nil
+}
+
+// logging is the -log-level and -log-format flags, which default to
+// LOG_LEVEL and LOG_FORMAT.
+type logging struct {
+	level  string
+	format string
+}
+
+func (l *logging) register(flags *flag.FlagSet) {
+	flags.StringVar(&l.level, "log-level", os.Getenv(envLogLevel), "log level: debug, info, warn or error (default info)")
+	flags.StringVar(&l.format, "log-format", os.Getenv(envLogFormat), "log format: console or json (default console)")
+}
+
+// setup replaces the logger configured from the environment.
+func (l *logging) setup() {
+	logs.New(logs.LogFormat(strings.ToLower(l.format)), logs.LogLevel(strings.ToUpper(l.level)))
+}
+
+func getenv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return fallback
+}
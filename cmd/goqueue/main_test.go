@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/internal/queuetest"
+	"github.com/thethan/goqueue/pkg/redis/redis/tx"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const configuration = `
+name: memory
+queues:
+  - name: retry
+    memory:
+      jobs:
+        - '{"class":"WebhookWorker","jid":"1"}'
+  - name: dead
+    memory:
+      type: fifo
+conditionals:
+  - name: isWebhook
+    operator: "=="
+    element: class
+    comparison: WebhookWorker
+pipeline:
+  getItems:
+    - name: retry
+  decisionTree:
+    - name: isWebhook
+      success:
+        name: pushToDead
+        pushItems:
+          - name: dead
+`
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestExecute(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		stderr := &bytes.Buffer{}
+		code := execute(ctx, []string{"run", "-config", writeConfig(t, configuration), "-metrics", "none"}, &bytes.Buffer{}, stderr)
+		assert.Equal(t, exitOK, code, stderr.String())
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		t.Setenv(envConfig, "")
+
+		for name, test := range map[string]struct {
+			args []string
+			code int
+		}{
+			"no command":       {args: nil, code: exitUsage},
+			"unknown command":  {args: []string{"start"}, code: exitUsage},
+			"unknown flag":     {args: []string{"run", "-verbose"}, code: exitUsage},
+			"no config":        {args: []string{"run"}, code: exitUsage},
+			"unknown metrics":  {args: []string{"run", "-config", writeConfig(t, configuration), "-metrics", "statsd"}, code: exitUsage},
+			"missing config":   {args: []string{"run", "-config", filepath.Join(t.TempDir(), "missing.yaml"), "-metrics", "none"}, code: exitError},
+			"invalid config":   {args: []string{"run", "-config", writeConfig(t, "queues: ["), "-metrics", "none"}, code: exitError},
+			"unknown operator": {args: []string{"run", "-config", writeConfig(t, "conditionals:\n  - name: a\n    operator: '~'\n"), "-metrics", "none"}, code: exitError},
+		} {
+			t.Run(name, func(t *testing.T) {
+				stderr := &bytes.Buffer{}
+				assert.Equal(t, test.code, execute(context.Background(), test.args, &bytes.Buffer{}, stderr))
+				assert.NotEmpty(t, stderr.String())
+			})
+		}
+	})
+}
+
+func TestValidateCommand(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		path := writeConfig(t, configuration)
+		stdout := &bytes.Buffer{}
+
+		assert.Equal(t, exitOK, execute(context.Background(), []string{"validate", "-config", path}, stdout, &bytes.Buffer{}))
+		assert.Equal(t, path+": ok\n", stdout.String())
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		path := writeConfig(t, "queues:\n  - name: retry\n    memory:\n      kind: fifo\npipeline:\n  getItems:\n    - name: retyr\n")
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+		assert.Equal(t, exitError, execute(context.Background(), []string{"validate", "-config", path}, stdout, stderr))
+		assert.Equal(t, path+`:4:7: unknown field "kind" in queues[0].memory`+"\n"+path+`:7:13: pipeline: unknown queue "retyr"`+"\n", stdout.String())
+		assert.Equal(t, "goqueue validate: found 2 problems\n", stderr.String())
+	})
+}
+
+func TestDryRunCommand(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		path := writeConfig(t, configuration)
+		stdout := &bytes.Buffer{}
+
+		assert.Equal(t, exitOK, execute(context.Background(), []string{"dry-run", "-config", path, "-log-level", "error"}, stdout, &bytes.Buffer{}))
+		assert.Equal(t, `job {"class":"WebhookWorker","jid":"1"}
+  isWebhook: matched, success branch
+    push dead
+
+jobs evaluated: 1
+  push dead: 1
+`, stdout.String())
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		path := writeConfig(t, configuration)
+
+		assert.Equal(t, exitUsage, execute(context.Background(), []string{"dry-run", "-config", path, "-format", "yaml"}, &bytes.Buffer{}, &bytes.Buffer{}))
+		assert.Equal(t, exitError, execute(context.Background(), []string{"dry-run", "-config", writeConfig(t, "queues: {}")}, &bytes.Buffer{}, &bytes.Buffer{}))
+	})
+}
+
+func TestQueuesCommand(t *testing.T) {
+	path := writeConfig(t, `
+queues:
+  - name: retry
+    memory:
+      jobs:
+        - '{"class":"WebhookWorker","jid":"1","retry_count":5}'
+        - '{"class":"MailWorker","jid":"2"}'
+        - '{"class":"WebhookWorker","jid":"3","retry_count":1}'
+  - name: dead
+    format: yaml
+    memory:
+      type: fifo
+      jobs:
+        - 'class: MailWorker'
+  - name: archive
+    file:
+      path: archive.jsonl
+  - name: webhooks
+    http:
+      dataSource: server
+dataSources:
+  - name: server
+    http:
+      addr: 127.0.0.1:0
+      token: secret
+pipeline:
+  getItems:
+    - name: retry
+`)
+
+	t.Run("success", func(t *testing.T) {
+		for name, test := range map[string]struct {
+			args   []string
+			output string
+		}{
+			"list": {
+				args:   []string{"list"},
+				output: "NAME      BACKEND  TYPE  KEY            FORMAT\nretry     memory                        json\ndead      memory   fifo                 yaml\narchive   file           archive.jsonl  json\nwebhooks  http                          json\n",
+			},
+			"count": {
+				args:   []string{"count", "retry"},
+				output: "3\n",
+			},
+			"count matches": {
+				args:   []string{"count", "-where", "class == WebhookWorker", "retry"},
+				output: "2\n",
+			},
+			"peek": {
+				args:   []string{"peek", "retry", "-offset", "1", "-limit", "1"},
+				output: "JOB\n" + `{"class":"MailWorker","jid":"2"}` + "\n",
+			},
+			"search": {
+				args:   []string{"search", "retry", "-where", "class == WebhookWorker", "-where", "retry_count > 2", "-fields", "jid,retry_count,queue"},
+				output: "JID  RETRY_COUNT  QUEUE\n1    5            \n",
+			},
+			"search json": {
+				args:   []string{"search", "retry", "-where", "class == WebhookWorker", "-limit", "1", "-format", "json"},
+				output: "[\n  {\n    \"class\": \"WebhookWorker\",\n    \"jid\": \"1\",\n    \"retry_count\": 5\n  }\n]\n",
+			},
+			"dump": {
+				args:   []string{"dump", "dead"},
+				output: `{"class":"MailWorker"}` + "\n",
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				args := append([]string{"queues", test.args[0], "-config", path, "-log-level", "error"}, test.args[1:]...)
+				stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+				assert.Equal(t, exitOK, execute(context.Background(), args, stdout, stderr), stderr.String())
+				assert.Equal(t, test.output, stdout.String())
+			})
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		for name, test := range map[string]struct {
+			args []string
+			code int
+		}{
+			"no subcommand":      {args: []string{"queues"}, code: exitUsage},
+			"unknown subcommand": {args: []string{"queues", "purge"}, code: exitUsage},
+			"no queue":           {args: []string{"queues", "peek", "-config", path}, code: exitUsage},
+			"two queues":         {args: []string{"queues", "peek", "-config", path, "retry", "dead"}, code: exitUsage},
+			"no condition":       {args: []string{"queues", "search", "-config", path, "retry"}, code: exitUsage},
+			"unknown operator":   {args: []string{"queues", "search", "-config", path, "-where", "class ~ Mail", "retry"}, code: exitUsage},
+			"unknown format":     {args: []string{"queues", "dump", "-config", path, "-format", "csv", "retry"}, code: exitUsage},
+			"unknown queue":      {args: []string{"queues", "count", "-config", path, "missing"}, code: exitError},
+			"not pageable":       {args: []string{"queues", "count", "-config", path, "-log-level", "error", "webhooks"}, code: exitError},
+		} {
+			t.Run(name, func(t *testing.T) {
+				stderr := &bytes.Buffer{}
+				assert.Equal(t, test.code, execute(context.Background(), test.args, &bytes.Buffer{}, stderr))
+				assert.NotEmpty(t, stderr.String())
+			})
+		}
+	})
+}
+
+func TestJobsCommand(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, `
+dataSources:
+  - name: db
+    sqlite:
+      path: `+filepath.Join(dir, "queue.db")+`
+queues:
+  - name: seed
+    memory:
+      jobs:
+        - '{"class":"WebhookWorker","jid":"1"}'
+        - '{"class":"MailWorker","jid":"2"}'
+        - '{"class":"WebhookWorker","jid":"3"}'
+  - name: default
+    sqlite:
+      dataSource: db
+      queue: webhooks
+  - name: dead
+    sqlite:
+      dataSource: db
+      queue: webhooks
+      state: dead
+pipeline:
+  getItems:
+    - name: seed
+`)
+
+	run := func(t *testing.T, input string, args ...string) (int, string) {
+		stdin = strings.NewReader(input)
+		t.Cleanup(func() {
+			stdin = os.Stdin
+		})
+
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		code := execute(context.Background(), append(args, "-config", path, "-log-level", "error"), stdout, stderr)
+		if code != exitOK {
+			return code, stderr.String()
+		}
+
+		return code, stdout.String()
+	}
+
+	count := func(t *testing.T, name string) string {
+		code, output := run(t, "", "queues", "count", name)
+		require.Equal(t, exitOK, code, output)
+
+		return strings.TrimSpace(output)
+	}
+
+	t.Run("success", func(t *testing.T) {
+		undo := filepath.Join(dir, "undo.jsonl")
+
+		code, output := run(t, "y\n", "jobs", "move", "seed", "dead", "-all", "-sample", "1", "-undo", undo)
+		require.Equal(t, exitOK, code, output)
+		assert.Equal(t, `3 jobs of seed selected, the first 1:
+{"class":"WebhookWorker","jid":"1"}
+move 3 jobs of seed to dead? [y/N] 3 jobs changed, 0 failed, undo file `+undo+"\n", output)
+		assert.Equal(t, "3", count(t, "dead"))
+
+		undone, err := os.ReadFile(undo)
+		require.Nil(t, err)
+		assert.Equal(t, `{"command":"move","queue":"seed","to":"dead","payload":"{\"class\":\"WebhookWorker\",\"jid\":\"1\"}"}`, strings.Split(string(undone), "\n")[0])
+
+		code, output = run(t, "", "jobs", "requeue", "-where", "class == WebhookWorker", "-yes", "-undo", undo, "dead")
+		require.Equal(t, exitOK, code, output)
+		assert.Equal(t, "2", count(t, "default"))
+		assert.Equal(t, "1", count(t, "dead"))
+
+		code, output = run(t, "", "jobs", "delete", "-where", "class == WebhookWorker", "-yes", "-undo", undo, "dead")
+		require.Equal(t, exitOK, code, output)
+		assert.Equal(t, "no jobs of dead selected\n", output)
+
+		code, output = run(t, "", "jobs", "delete", "-all", "-limit", "1", "-rate", "100", "-batch-size", "1", "-yes", "-undo", undo, "default")
+		require.Equal(t, exitOK, code, output)
+		assert.Equal(t, "1", count(t, "default"))
+
+		undone, err = os.ReadFile(undo)
+		require.Nil(t, err)
+		assert.Len(t, strings.Split(strings.TrimSpace(string(undone)), "\n"), 6)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		code, _ := run(t, "n\n", "jobs", "delete", "-all", "-undo", filepath.Join(dir, "refused.jsonl"), "dead")
+		assert.Equal(t, exitError, code)
+		assert.Equal(t, "1", count(t, "dead"))
+		assert.NoFileExists(t, filepath.Join(dir, "refused.jsonl"))
+
+		for name, test := range map[string]struct {
+			args []string
+			code int
+		}{
+			"no subcommand":       {args: []string{"jobs"}, code: exitUsage},
+			"unknown subcommand":  {args: []string{"jobs", "purge"}, code: exitUsage},
+			"no selection":        {args: []string{"jobs", "delete", "dead"}, code: exitUsage},
+			"where and all":       {args: []string{"jobs", "delete", "-all", "-where", "jid == 1", "dead"}, code: exitUsage},
+			"no destination":      {args: []string{"jobs", "move", "-all", "dead"}, code: exitUsage},
+			"same queue":          {args: []string{"jobs", "move", "-all", "dead", "dead"}, code: exitUsage},
+			"negative limit":      {args: []string{"jobs", "delete", "-all", "-limit", "-1", "dead"}, code: exitUsage},
+			"unknown destination": {args: []string{"jobs", "move", "-all", "dead", "missing"}, code: exitError},
+			"nothing to requeue":  {args: []string{"jobs", "requeue", "-all", "-yes", "default"}, code: exitError},
+		} {
+			t.Run(name, func(t *testing.T) {
+				code, _ := run(t, "", test.args...)
+				assert.Equal(t, test.code, code)
+			})
+		}
+	})
+}
+
+func TestMoveJobsCommand(t *testing.T) {
+	server := miniredis.RunT(t)
+	path := writeConfig(t, `
+dataSources:
+  - name: cache
+    redis:
+      host: `+server.Addr()+`
+queues:
+  - name: retry
+    redis:
+      dataSource: cache
+      type: sidekiq
+      key: retry
+  - name: dead
+    redis:
+      dataSource: cache
+      type: sidekiq
+      key: dead
+pipeline:
+  getItems:
+    - name: retry
+`)
+
+	t.Run("success", func(t *testing.T) {
+		_, err := server.ZAdd("retry", 1, `{"class":"WebhookWorker","jid":"1","queue":"default"}`)
+		require.Nil(t, err)
+		_, err = server.ZAdd("retry", 2, `{"class":"MailWorker","jid":"2","queue":"default"}`)
+		require.Nil(t, err)
+
+		// the queues share the data source's client, so jobs are moved in a
+		// transaction
+		var inspection inspection
+		require.Nil(t, inspection.files.Set(path))
+		queueMap, _, err := inspection.queues(context.Background(), "retry", "dead")
+		require.Nil(t, err)
+		source, ok := queueMap["retry"].(tx.Queue)
+		require.True(t, ok)
+		destination, ok := queueMap["dead"].(tx.Queue)
+		require.True(t, ok)
+		assert.Same(t, source.Client(), destination.Client())
+
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		args := []string{"jobs", "move", "-where", "class == WebhookWorker", "-yes", "-undo", filepath.Join(t.TempDir(), "undo.jsonl"), "-config", path, "-log-level", "error", "retry", "dead"}
+		require.Equal(t, exitOK, execute(context.Background(), args, stdout, stderr), stderr.String())
+		assert.Contains(t, stdout.String(), "1 jobs changed, 0 failed")
+
+		retried, err := server.ZMembers("retry")
+		require.Nil(t, err)
+		assert.Equal(t, []string{`{"class":"MailWorker","jid":"2","queue":"default"}`}, retried)
+
+		dead, err := server.ZMembers("dead")
+		require.Nil(t, err)
+		require.Len(t, dead, 1)
+		assert.Contains(t, dead[0], `"jid":"1"`)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		var inspection inspection
+		require.Nil(t, inspection.files.Set(path))
+		_, _, err := inspection.queues(context.Background(), "retry", "missing")
+		assert.EqualError(t, err, `unknown queue "missing"`)
+	})
+}
+
+// sliceQueue pages through jobs, the jobs that were changed leave it.
+type sliceQueue struct {
+	jobs []job.Job
+}
+
+func (q *sliceQueue) Count(ctx context.Context) (int64, error) {
+	return int64(len(q.jobs)), nil
+}
+
+func (q *sliceQueue) Page(ctx context.Context, offset, count int64) ([]job.Job, error) {
+	if offset >= int64(len(q.jobs)) {
+		return nil, nil
+	}
+
+	end := offset + count
+	if end > int64(len(q.jobs)) {
+		end = int64(len(q.jobs))
+	}
+
+	return append([]job.Job(nil), q.jobs[offset:end]...), nil
+}
+
+func (q *sliceQueue) remove(j job.Job) {
+	for idx := range q.jobs {
+		if q.jobs[idx] == j {
+			q.jobs = append(q.jobs[:idx], q.jobs[idx+1:]...)
+			return
+		}
+	}
+}
+
+func TestBulk_apply(t *testing.T) {
+	open := func(t *testing.T) (*undoFile, string) {
+		path := filepath.Join(t.TempDir(), "undo.jsonl")
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		require.Nil(t, err)
+		t.Cleanup(func() {
+			_ = file.Close()
+		})
+
+		return &undoFile{file: file, record: undoRecord{Command: "move", Queue: "retry", To: "dead"}}, path
+	}
+
+	payloads := func(t *testing.T, path string) []string {
+		undone, err := os.ReadFile(path)
+		require.Nil(t, err)
+
+		payloads := make([]string, 0)
+		for _, line := range strings.Fields(string(undone)) {
+			var record undoRecord
+			require.Nil(t, json.Unmarshal([]byte(line), &record))
+			payloads = append(payloads, record.Payload)
+		}
+
+		return payloads
+	}
+
+	t.Run("success", func(t *testing.T) {
+		undo, path := open(t)
+		source := &sliceQueue{jobs: []job.Job{queuetest.MakeJob(t, `{"jid":"1"}`), queuetest.MakeJob(t, `{"jid":"2"}`)}}
+
+		b := &bulk{all: true, batchSize: 1}
+		changed, failed, duplicated, err := b.apply(context.Background(), source, func(ctx context.Context, j job.Job) error {
+			// the job is in the undo file before it is changed
+			written := payloads(t, path)
+			assert.Equal(t, string(j.Original()), written[len(written)-1])
+			source.remove(j)
+
+			return nil
+		}, undo)
+		require.Nil(t, err)
+		assert.Equal(t, []int{2, 0, 0}, []int{changed, failed, duplicated})
+		assert.Equal(t, []string{`{"jid":"1"}`, `{"jid":"2"}`}, payloads(t, path))
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		undo, path := open(t)
+		source := &sliceQueue{jobs: []job.Job{queuetest.MakeJob(t, `{"jid":"1"}`), queuetest.MakeJob(t, `{"jid":"2"}`), queuetest.MakeJob(t, `{"jid":"3"}`)}}
+
+		b := &bulk{all: true, batchSize: 10}
+		changed, failed, duplicated, err := b.apply(context.Background(), source, func(ctx context.Context, j job.Job) error {
+			switch string(j.Original()) {
+			case `{"jid":"1"}`:
+				return fmt.Errorf("could not push job: %w", assert.AnError)
+			case `{"jid":"2"}`:
+				return fmt.Errorf("%w: %w", queues.ErrNotRemoved, assert.AnError)
+			}
+
+			source.remove(j)
+
+			return nil
+		}, undo)
+		require.Nil(t, err)
+		assert.Equal(t, []int{1, 2, 1}, []int{changed, failed, duplicated})
+		// only the job that left the queue can be undone
+		assert.Equal(t, []string{`{"jid":"3"}`}, payloads(t, path))
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/thethan/goqueue/internal/logs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	promexporter "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMetricsExporter = "prometheus"
+	defaultMetricsAddr     = ":9464"
+	shutdownTimeout        = 5 * time.Second
+)
+
+// metrics is the -metrics and -metrics-addr flags, which default to
+// METRICS_EXPORTER and METRICS_ADDR.
+type metrics struct {
+	exporter string
+	addr     string
+}
+
+func (m *metrics) register(flags *flag.FlagSet) {
+	flags.StringVar(&m.exporter, "metrics", getenv(envMetricsExporter, defaultMetricsExporter),
+		"metrics exporter: prometheus, otlp (configured by the OTEL_EXPORTER_OTLP_* variables) or none")
+	flags.StringVar(&m.addr, "metrics-addr", getenv(envMetricsAddr, defaultMetricsAddr), "address prometheus metrics are served on, at /metrics")
+}
+
+// setup sets the global meter provider pipelines record to and returns a
+// function that flushes and stops it.
+func (m *metrics) setup(ctx context.Context) (func(), error) {
+	switch m.exporter {
+	case "none", "":
+		return func() {}, nil
+	case "prometheus":
+		return m.prometheus(ctx)
+	case "otlp":
+		exporter, err := otlpmetricgrpc.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		provider := metric.NewMeterProvider(metric.WithReader(metric.NewPeriodicReader(exporter)))
+		otel.SetMeterProvider(provider)
+
+		return func() {
+			shutdown(ctx, "meter provider", provider.Shutdown)
+		}, nil
+	}
+
+	return nil, usageError{fmt.Errorf("unknown metrics exporter %q", m.exporter)}
+}
+
+// prometheus serves the metrics at /metrics on the metrics address.
+func (m *metrics) prometheus(ctx context.Context) (func(), error) {
+	registry := prometheus.NewRegistry()
+	exporter, err := promexporter.New(promexporter.WithRegisterer(registry))
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", m.addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not serve metrics: %w", err)
+	}
+
+	provider := metric.NewMeterProvider(metric.WithReader(exporter))
+	otel.SetMeterProvider(provider)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logs.Error(ctx, "metrics server stopped", logs.WithError(err))
+		}
+	}()
+
+	logs.Info(ctx, "serving metrics", logs.WithValue("addr", listener.Addr().String()))
+
+	return func() {
+		shutdown(ctx, "metrics server", server.Shutdown)
+		shutdown(ctx, "meter provider", provider.Shutdown)
+	}, nil
+}
+
+func shutdown(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := fn(shutdownCtx); err != nil {
+		logs.Error(ctx, "could not shut down "+name, logs.WithError(err))
+	}
+}
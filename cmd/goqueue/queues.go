@@ -0,0 +1,705 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/thethan/goqueue/internal/conditionals"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/pkg/queue"
+	"io"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+const (
+	defaultPageSize    = 100
+	defaultPeekLimit   = 10
+	defaultSearchLimit = 100
+)
+
+func queueCommands() []command {
+	return []command{
+		{name: "list", summary: "list the queues of configuration files", run: listQueuesCommand},
+		{name: "count", summary: "count the jobs of a queue, or those matching -where", run: countQueueCommand},
+		{name: "peek", summary: "show the next jobs of a queue without taking them", run: peekQueueCommand},
+		{name: "search", summary: "show the jobs of a queue matching -where", run: searchQueueCommand},
+		{name: "dump", summary: "write every job of a queue, one json object per line by default", run: dumpQueueCommand},
+	}
+}
+
+// queuesCommand inspects the queues of configuration files. Queues are read
+// a page at a time without claiming or removing their jobs, so queues being
+// worked on can change while they are read.
+func queuesCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		queuesUsage(stderr)
+		return flag.ErrHelp
+	}
+
+	for _, cmd := range queueCommands() {
+		if cmd.name == args[0] {
+			return cmd.run(ctx, args[1:], stdout, stderr)
+		}
+	}
+
+	queuesUsage(stderr)
+
+	return usageError{fmt.Errorf("unknown subcommand %q", args[0])}
+}
+
+func queuesUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: goqueue queues <subcommand> [flags] [queue]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "subcommands:")
+	for _, cmd := range queueCommands() {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+}
+
+// conditions is the -where flag, which can be repeated. Jobs have to match
+// every condition.
+type conditions struct {
+	expressions []string
+	checks      []conditionals.CheckFunc
+}
+
+func (c *conditions) String() string {
+	return strings.Join(c.expressions, " and ")
+}
+
+func (c *conditions) Set(value string) error {
+	check, err := queue.ParseCondition(value)
+	if err != nil {
+		return err
+	}
+
+	c.expressions = append(c.expressions, value)
+	c.checks = append(c.checks, check)
+
+	return nil
+}
+
+// match reports whether j matches every condition.
+func (c *conditions) match(ctx context.Context, j job.Job) (bool, error) {
+	for _, check := range c.checks {
+		matched, err := check(ctx, j)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// inspection is the flags the queues subcommands share.
+type inspection struct {
+	files   configFiles
+	logging logging
+}
+
+func (i *inspection) register(flags *flag.FlagSet) {
+	flags.Var(&i.files, "config", "configuration file, can be repeated (default $"+envConfig+", comma separated)")
+	i.logging.register(flags)
+}
+
+// open returns the queue called name, which has to be one that can be
+// paged through.
+func (i *inspection) open(ctx context.Context, name string) (queues.PageQueue, error) {
+	q, _, err := i.queue(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	pageQueue, ok := q.(queues.PageQueue)
+	if !ok {
+		return nil, fmt.Errorf("queue %s can't be inspected", name)
+	}
+
+	return pageQueue, nil
+}
+
+// queue builds the queue called name, which has to be declared in exactly
+// one of the configuration files, and returns it with the inspector of its
+// file.
+func (i *inspection) queue(ctx context.Context, name string) (queues.Queue, *queue.Inspector, error) {
+	queueMap, inspectors, err := i.queues(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return queueMap[name], inspectors[name], nil
+}
+
+// queues builds the queues called names, each of which has to be declared
+// in exactly one of the configuration files, and returns them with the
+// inspectors of their files. The queues of a file are built together, so
+// queues on the same data source share its client.
+func (i *inspection) queues(ctx context.Context, names ...string) (map[string]queues.Queue, map[string]*queue.Inspector, error) {
+	paths, err := i.files.paths()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	i.logging.setup()
+
+	found := map[string]string{}
+	inspectors := map[string]*queue.Inspector{}
+	for _, path := range paths {
+		candidate, err := queue.NewInspector(ctx, path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		for _, name := range names {
+			if !candidate.Has(name) {
+				continue
+			}
+
+			if _, ok := inspectors[name]; ok {
+				return nil, nil, fmt.Errorf("queue %s is declared in both %s and %s", name, found[name], path)
+			}
+
+			found[name], inspectors[name] = path, candidate
+		}
+	}
+
+	for _, name := range names {
+		if _, ok := inspectors[name]; !ok {
+			return nil, nil, fmt.Errorf("unknown queue %q", name)
+		}
+	}
+
+	queueMap := map[string]queues.Queue{}
+	for _, path := range paths {
+		declared := make([]string, 0, len(names))
+		for _, name := range names {
+			if found[name] == path {
+				declared = append(declared, name)
+			}
+		}
+
+		if len(declared) == 0 {
+			continue
+		}
+
+		built, err := inspectors[declared[0]].Queues(declared...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		for _, name := range declared {
+			queueMap[name] = built[name]
+		}
+	}
+
+	return queueMap, inspectors, nil
+}
+
+// parseQueue parses a subcommand's flags, which take the queue's name as
+// their only argument, before or after the flags.
+func parseQueue(flags *flag.FlagSet, args []string) (string, error) {
+	names, err := parseQueues(flags, args, "queue")
+	if err != nil {
+		return "", err
+	}
+
+	return names[0], nil
+}
+
+// parseQueues parses a subcommand's flags, which take the names of queues as
+// arguments, before, between or after the flags. names are what the
+// arguments are, reported when they are missing.
+func parseQueues(flags *flag.FlagSet, args []string, names ...string) ([]string, error) {
+	queueNames := make([]string, 0, len(names))
+	for {
+		if err := flags.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+
+			return nil, usageError{err}
+		}
+
+		if flags.NArg() == 0 || len(queueNames) == len(names) {
+			break
+		}
+
+		queueNames = append(queueNames, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+
+	if flags.NArg() > 0 {
+		return nil, usageError{fmt.Errorf("unexpected arguments %s", strings.Join(flags.Args(), " "))}
+	}
+
+	if len(queueNames) < len(names) {
+		return nil, usageError{fmt.Errorf("missing %s name", names[len(queueNames)])}
+	}
+
+	return queueNames, nil
+}
+
+func listQueuesCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("queues list", stderr)
+	var inspection inspection
+	inspection.register(flags)
+	format := flags.String("format", "table", "output format: table, json or jsonl")
+
+	if err := parse(flags, args); err != nil {
+		return err
+	}
+
+	if *format != "table" && *format != "json" && *format != "jsonl" {
+		return usageError{fmt.Errorf("unknown format %q", *format)}
+	}
+
+	paths, err := inspection.files.paths()
+	if err != nil {
+		return err
+	}
+
+	inspection.logging.setup()
+	defer logs.Sync()
+
+	infos := make(map[string][]queue.QueueInfo, len(paths))
+	for _, path := range paths {
+		inspector, err := queue.NewInspector(ctx, path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		infos[path] = inspector.List()
+	}
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if len(paths) == 1 {
+			return encoder.Encode(infos[paths[0]])
+		}
+
+		return encoder.Encode(infos)
+	case "jsonl":
+		encoder := json.NewEncoder(stdout)
+		for _, path := range paths {
+			for _, info := range infos[path] {
+				if err := encoder.Encode(info); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	for idx, path := range paths {
+		if len(paths) > 1 {
+			if idx > 0 {
+				fmt.Fprintln(stdout)
+			}
+
+			fmt.Fprintf(stdout, "%s\n\n", path)
+		}
+
+		table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "NAME\tBACKEND\tTYPE\tKEY\tFORMAT")
+		for _, info := range infos[path] {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", info.Name, info.Backend, info.Type, info.Key, info.Format)
+		}
+
+		if err := table.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func countQueueCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("queues count", stderr)
+	var inspection inspection
+	inspection.register(flags)
+	var where conditions
+	flags.Var(&where, "where", "only count jobs matching 'element operator comparison', can be repeated")
+	pageSize := flags.Int64("page-size", defaultPageSize, "jobs read at a time when counting matches")
+
+	name, err := parseQueue(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if *pageSize <= 0 {
+		return usageError{errors.New("-page-size must be positive")}
+	}
+
+	pageQueue, err := inspection.open(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	defer logs.Sync()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(where.checks) == 0 {
+		n, err := pageQueue.Count(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(stdout, n)
+
+		return nil
+	}
+
+	n := 0
+	err = queues.Scan(ctx, pageQueue, 0, *pageSize, func(j job.Job) (bool, error) {
+		matched, err := where.match(ctx, j)
+		if matched {
+			n++
+		}
+
+		return true, err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(stdout, n)
+
+	return nil
+}
+
+func peekQueueCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("queues peek", stderr)
+	var inspection inspection
+	inspection.register(flags)
+	offset := flags.Int64("offset", 0, "number of jobs skipped")
+	limit := flags.Int64("limit", defaultPeekLimit, "number of jobs shown")
+	var output output
+	output.register(flags, "table")
+
+	name, err := parseQueue(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if *offset < 0 || *limit <= 0 {
+		return usageError{errors.New("-offset can't be negative and -limit has to be positive")}
+	}
+
+	if err := output.check(); err != nil {
+		return err
+	}
+
+	pageQueue, err := inspection.open(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	defer logs.Sync()
+
+	jobs, err := pageQueue.Page(ctx, *offset, *limit)
+	if err != nil {
+		return err
+	}
+
+	w := output.writer(stdout)
+	for _, j := range jobs {
+		if err := w.write(j); err != nil {
+			return err
+		}
+	}
+
+	return w.flush()
+}
+
+func searchQueueCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("queues search", stderr)
+	var inspection inspection
+	inspection.register(flags)
+	var where conditions
+	flags.Var(&where, "where", "show jobs matching 'element operator comparison', can be repeated")
+	limit := flags.Int("limit", defaultSearchLimit, "number of matching jobs shown, 0 for every match")
+	pageSize := flags.Int64("page-size", defaultPageSize, "jobs read at a time")
+	var output output
+	output.register(flags, "table")
+
+	name, err := parseQueue(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(where.checks) == 0 {
+		return usageError{errors.New("search needs at least one -where condition")}
+	}
+
+	if *limit < 0 || *pageSize <= 0 {
+		return usageError{errors.New("-limit can't be negative and -page-size has to be positive")}
+	}
+
+	if err := output.check(); err != nil {
+		return err
+	}
+
+	pageQueue, err := inspection.open(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	defer logs.Sync()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w := output.writer(stdout)
+	found := 0
+	err = queues.Scan(ctx, pageQueue, 0, *pageSize, func(j job.Job) (bool, error) {
+		matched, err := where.match(ctx, j)
+		if err != nil || !matched {
+			return true, err
+		}
+
+		found++
+		if err := w.write(j); err != nil {
+			return false, err
+		}
+
+		return *limit == 0 || found < *limit, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.flush()
+}
+
+func dumpQueueCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("queues dump", stderr)
+	var inspection inspection
+	inspection.register(flags)
+	pageSize := flags.Int64("page-size", defaultPageSize, "jobs read at a time")
+	var output output
+	output.register(flags, "jsonl")
+
+	name, err := parseQueue(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if *pageSize <= 0 {
+		return usageError{errors.New("-page-size must be positive")}
+	}
+
+	if err := output.check(); err != nil {
+		return err
+	}
+
+	pageQueue, err := inspection.open(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	defer logs.Sync()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w := output.writer(stdout)
+	err = queues.Scan(ctx, pageQueue, 0, *pageSize, func(j job.Job) (bool, error) {
+		return true, w.write(j)
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.flush()
+}
+
+// output is the -format and -fields flags of the subcommands writing jobs.
+type output struct {
+	format string
+	fields string
+}
+
+func (o *output) register(flags *flag.FlagSet, format string) {
+	flags.StringVar(&o.format, "format", format, "output format: table, json or jsonl")
+	flags.StringVar(&o.fields, "fields", "", "comma separated fields written instead of whole jobs")
+}
+
+func (o *output) check() error {
+	switch o.format {
+	case "table", "json", "jsonl":
+		return nil
+	}
+
+	return usageError{fmt.Errorf("unknown format %q", o.format)}
+}
+
+func (o *output) writer(w io.Writer) *jobWriter {
+	writer := &jobWriter{
+		format:  o.format,
+		w:       w,
+		builder: job.NewBuilder(&job.Configuration{Type: job.JsonRawJobType}),
+		jobs:    make([]json.RawMessage, 0),
+	}
+
+	for _, field := range strings.Split(o.fields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			writer.fields = append(writer.fields, field)
+		}
+	}
+
+	if o.format == "table" {
+		writer.table = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	}
+
+	return writer
+}
+
+// jobWriter writes jobs as a table, a json array or json lines. Jobs are
+// written as json whatever their queue's format.
+type jobWriter struct {
+	format  string
+	fields  []string
+	w       io.Writer
+	builder *job.Builder
+	table   *tabwriter.Writer
+	// jobs are held until flush for json arrays
+	jobs   []json.RawMessage
+	header bool
+}
+
+func (w *jobWriter) write(j job.Job) error {
+	if w.table != nil {
+		return w.row(j)
+	}
+
+	encoded, err := w.encode(j)
+	if err != nil {
+		return err
+	}
+
+	if w.format == "json" {
+		w.jobs = append(w.jobs, encoded)
+		return nil
+	}
+
+	_, err = fmt.Fprintf(w.w, "%s\n", encoded)
+
+	return err
+}
+
+// row writes the job, or its fields, as a row of the table.
+func (w *jobWriter) row(j job.Job) error {
+	if !w.header {
+		w.header = true
+		header := []string{"JOB"}
+		if len(w.fields) > 0 {
+			header = make([]string, len(w.fields))
+			for idx, field := range w.fields {
+				header[idx] = strings.ToUpper(field)
+			}
+		}
+
+		fmt.Fprintln(w.table, strings.Join(header, "\t"))
+	}
+
+	if len(w.fields) == 0 {
+		encoded, err := w.encode(j)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w.table, "%s\n", encoded)
+
+		return err
+	}
+
+	columns := make([]string, len(w.fields))
+	for idx, field := range w.fields {
+		value, ok := fieldValue(j, field)
+		if !ok {
+			continue
+		}
+
+		if s, isString := value.(string); isString {
+			columns[idx] = s
+			continue
+		}
+
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		columns[idx] = string(encoded)
+	}
+
+	_, err := fmt.Fprintln(w.table, strings.Join(columns, "\t"))
+
+	return err
+}
+
+// encode returns the job, or an object of its fields, as compact json.
+func (w *jobWriter) encode(j job.Job) (json.RawMessage, error) {
+	if len(w.fields) > 0 {
+		values := make(map[string]interface{}, len(w.fields))
+		for _, field := range w.fields {
+			if value, ok := fieldValue(j, field); ok {
+				values[field] = value
+			}
+		}
+
+		return json.Marshal(values)
+	}
+
+	encoded, err := w.builder.Encode(j)
+	if err != nil {
+		return nil, err
+	}
+
+	compact := &bytes.Buffer{}
+	if err := json.Compact(compact, encoded); err != nil {
+		return nil, err
+	}
+
+	return compact.Bytes(), nil
+}
+
+// flush writes what the format holds back until every job is written.
+func (w *jobWriter) flush() error {
+	switch {
+	case w.table != nil:
+		return w.table.Flush()
+	case w.format == "json":
+		encoder := json.NewEncoder(w.w)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(w.jobs)
+	}
+
+	return nil
+}
+
+// fieldValue returns the value of the job's field, false when it has none.
+func fieldValue(j job.Job, field string) (interface{}, bool) {
+	value, err := j.GetValue(field)
+	if err != nil || value.Kind() == reflect.Invalid || !value.CanInterface() {
+		return nil, false
+	}
+
+	return value.Interface(), true
+}
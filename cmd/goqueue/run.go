@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/pipelines"
+	"github.com/thethan/goqueue/pkg/queue"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runCommand builds the pipeline of every configuration file and runs them
+// until interrupted or one of them fails.
+func runCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("run", stderr)
+	var files configFiles
+	flags.Var(&files, "config", "configuration file, can be repeated (default $"+envConfig+", comma separated)")
+	var logging logging
+	logging.register(flags)
+	var metrics metrics
+	metrics.register(flags)
+
+	if err := parse(flags, args); err != nil {
+		return err
+	}
+
+	paths, err := files.paths()
+	if err != nil {
+		return err
+	}
+
+	logging.setup()
+	defer logs.Sync()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownMetrics, err := metrics.setup(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer shutdownMetrics()
+
+	processPipelines := make([]pipelines.ProcessPipeline, 0, len(paths))
+	for _, path := range paths {
+		pipeline, err := queue.BuildPipeline(ctx, path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		processPipelines = append(processPipelines, pipeline)
+	}
+
+	return start(ctx, paths, processPipelines)
+}
+
+// start runs the pipelines until the context is done, stopping them all when
+// one of them fails.
+func start(ctx context.Context, paths []string, processPipelines []pipelines.ProcessPipeline) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errChan := make(chan error, len(processPipelines))
+	for i, pipeline := range processPipelines {
+		go func(path string, pipeline pipelines.ProcessPipeline) {
+			err := pipeline.Start(ctx)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", path, err)
+				cancel()
+			}
+
+			errChan <- err
+		}(paths[i], pipeline)
+	}
+
+	logs.Info(ctx, "started pipelines", logs.WithValue("count", len(processPipelines)))
+
+	var first error
+	for range processPipelines {
+		if err := <-errChan; err != nil && first == nil {
+			first = err
+		}
+	}
+
+	logs.Info(ctx, "stopped pipelines")
+
+	return first
+}
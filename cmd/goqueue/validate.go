@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/thethan/goqueue/pkg/queue"
+	"io"
+	"os"
+)
+
+// validateCommand checks configuration files without connecting to their
+// data sources, printing each problem as file:line:column: message.
+func validateCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("validate", stderr)
+	var files configFiles
+	flags.Var(&files, "config", "configuration file, can be repeated (default $"+envConfig+", comma separated)")
+
+	if err := parse(flags, args); err != nil {
+		return err
+	}
+
+	paths, err := files.paths()
+	if err != nil {
+		return err
+	}
+
+	found := 0
+	for _, path := range paths {
+		problems, err := validate(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		if len(problems) == 0 {
+			fmt.Fprintf(stdout, "%s: ok\n", path)
+			continue
+		}
+
+		for _, problem := range problems {
+			fmt.Fprintf(stdout, "%s:%s\n", path, problem.Error())
+		}
+
+		found += len(problems)
+	}
+
+	switch found {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("found 1 problem")
+	}
+
+	return fmt.Errorf("found %d problems", found)
+}
+
+// validate returns the problems of a configuration file, or an error when it
+// can't be read.
+func validate(path string) (queue.Problems, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	_, err = queue.ValidateConfiguration(file)
+	var problems queue.Problems
+	if errors.As(err, &problems) {
+		return problems, nil
+	}
+
+	return nil, err
+}
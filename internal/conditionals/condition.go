@@ -2,45 +2,118 @@ package conditionals
 
 import (
 	"context"
+	"errors"
 	"github.com/thethan/goqueue/internal/job"
 	"github.com/thethan/goqueue/internal/logs"
+	"math/big"
 	"reflect"
-	"regexp"
-	"strconv"
 	"strings"
 )
 
 type condition struct {
 	operator   Operator
 	element    string
+	path       job.Path
+	pathErr    error
 	comparison reflect.Value
+	// number is the exact value of a numeric comparison
+	number  *big.Rat
+	missing Missing
 }
 
-func NewCondition(element string, operator Operator, comparison interface{}) condition {
+type ConditionOption func(c *condition)
+
+// WithMissing sets how the condition evaluates when its element is not in
+// the job. The default is MissingFalse.
+func WithMissing(missing Missing) ConditionOption {
+	return func(c *condition) {
+		c.missing = missing
+	}
+}
+
+func NewCondition(element string, operator Operator, comparison interface{}, opts ...ConditionOption) condition {
 	compVal := reflect.ValueOf(comparison)
+	number, _ := job.ToRat(compVal)
 	switch compVal.Kind() {
 	case reflect.Int, reflect.Int16, reflect.Int32, reflect.Int64:
 		compVal = reflect.ValueOf(float64(compVal.Int()))
 	}
 
-	return condition{element: element, operator: operator, comparison: compVal}
-}
+	path, err := job.ParsePath(element)
 
-func (c condition) Evaluate(ctx context.Context, job job.Job) bool {
-	//t := reflect.TypeOf(job)
-	element := c.element
-	if n := strings.IndexByte(c.element, '['); n >= 0 {
-		element = element[:n]
+	c := condition{element: element, path: path, pathErr: err, operator: operator, comparison: compVal, number: number, missing: MissingFalse}
+	for _, opt := range opts {
+		opt(&c)
 	}
 
-	r, err := job.GetValue(element)
+	return c
+}
+
+func (c condition) Evaluate(ctx context.Context, job job.Job) bool {
+	ok, err := c.Check(ctx, job)
 	if err != nil {
 		logs.Warn(ctx, "error getting value", logs.WithError(err), logs.WithValue("element", c.element))
 		return false
 	}
 
+	return ok
+}
+
+// Check evaluates the condition. Elements that are missing from the job, or
+// that can't be reached because a parent has the wrong type, evaluate
+// according to the condition's Missing setting.
+func (c condition) Check(ctx context.Context, j job.Job) (bool, error) {
+	if c.pathErr != nil {
+		return false, c.pathErr
+	}
+
+	// a wildcard matches when any of the values it selects matches
+	if c.path.HasWildcard() {
+		values, err := j.GetValues(c.element)
+		if err != nil {
+			return c.onMissing(ctx, err)
+		}
+
+		for _, v := range values {
+			if c.eval(v) {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+
+	r, err := j.GetValue(c.element)
+	if err != nil {
+		return c.onMissing(ctx, err)
+	}
+
 	// this is assuming that the field is an attribute of the job
-	return c.eval(r)
+	return c.eval(r), nil
+}
+
+func (c condition) onMissing(ctx context.Context, err error) (bool, error) {
+	if !isMissing(err) {
+		return false, err
+	}
+
+	switch c.missing {
+	case MissingTrue:
+		return true, nil
+	case MissingError:
+		return false, err
+	}
+
+	logs.Debug(ctx, "element missing from job", logs.WithError(err), logs.WithValue("element", c.element))
+
+	return false, nil
+}
+
+func isMissing(err error) bool {
+	return errors.Is(err, job.ErrFieldNotFound) ||
+		errors.Is(err, job.ErrNotAnObject) ||
+		errors.Is(err, job.ErrNotAnArray) ||
+		errors.Is(err, job.ErrIndexOutOfRange)
 }
 
 /**
@@ -58,19 +131,28 @@ getMethod is not used
 //}
 
 func (c condition) eval(v reflect.Value) bool {
-
-	if v.Kind() == reflect.String {
-		return c.stringEval(v)
+	// json jobs hold numbers as json.Number, which is a string kind
+	if job.IsNumber(v) {
+		return c.numberEval(v)
 	}
 
-	if v.Kind() == reflect.Slice {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return false
+	case reflect.String:
+		return c.stringEval(v)
+	case reflect.Slice:
 		return c.sliceEval(v)
 	}
 
-	return c.intEval(v)
+	return false
 }
 
 func (c condition) stringEval(v reflect.Value) bool {
+	if c.comparison.Kind() != reflect.String {
+		return false
+	}
+
 	mainString := v.String()
 	subString := c.comparison.String()
 
@@ -83,64 +165,50 @@ func (c condition) stringEval(v reflect.Value) bool {
 
 	return false
 }
-func (c condition) intEval(v reflect.Value) bool {
-	switch c.operator {
-	case GreaterThan:
-		return v.Float() > c.comparison.Float()
-	case Equal:
-		floatVal := v.Float()
-		compVal := c.comparison.Float()
-		_ = floatVal
-		_ = compVal
-
-		return v.Float() == c.comparison.Float()
-	}
-
-	return false
-}
 
-func (c condition) sliceEval(v reflect.Value) bool {
-
-	reg, err := regexp.Compile("\\[(.*?)]")
-	if err != nil {
-		logs.Warn(context.Background(), "could not get regex", logs.WithError(err))
+// numberEval compares numbers exactly, so big integers and decimals such as
+// 0.1 compare by their written value rather than their float64 value.
+func (c condition) numberEval(v reflect.Value) bool {
+	if c.number == nil {
 		return false
 	}
 
-	stringsts := reg.FindAllString(c.element, 1)
-
-	if len(stringsts) == 0 {
-		logs.Warn(context.Background(), "length of slice is greater than 1", logs.WithError(err))
+	value, ok := job.ToRat(v)
+	if !ok {
 		return false
 	}
 
-	strIdx := strings.Replace(strings.Replace(stringsts[0], "]", "", -1), "[", "", -1)
-	idx, err := strconv.Atoi(strIdx)
-	if err != nil {
-		logs.Warn(context.Background(), "string inside of [] was not an int", logs.WithError(err))
-		return false
-	}
+	cmp := value.Cmp(c.number)
 
-	slice, ok := v.Interface().([]interface{})
-	if !ok {
-		logs.Warn(context.Background(), "slice as not of []interface")
-		return false
+	switch c.operator {
+	case GreaterThan:
+		return cmp > 0
+	case GreaterThanEqualTo:
+		return cmp >= 0
+	case LessThan:
+		return cmp < 0
+	case LessThanEqualTo:
+		return cmp <= 0
+	case Equal:
+		return cmp == 0
 	}
-	if idx > len(slice)-1 {
-		logs.Warn(context.Background(), "string inside of [] was not an int", logs.WithError(err))
 
+	return false
+}
+
+// sliceEval checks if a slice contains the comparison. Use an index or a
+// wildcard in the element to compare the values of a slice.
+func (c condition) sliceEval(v reflect.Value) bool {
+	if c.operator != Contains {
 		return false
 	}
 
-	value := reflect.ValueOf(slice[idx])
-	if value.Kind() != c.comparison.Kind() {
-		//logs.Warn(context.Background(), "not comparing kind",
-		//	logs.WithValue("slice[idx]", slice[idx]),
-		//	logs.WithValue("comp", c.comparison.Kind()),
-		//	logs.WithValue("value", value.Kind()))
-
-		return false
+	equal := condition{operator: Equal, comparison: c.comparison, number: c.number}
+	for idx := 0; idx < v.Len(); idx++ {
+		if equal.eval(reflect.ValueOf(v.Index(idx).Interface())) {
+			return true
+		}
 	}
 
-	return c.eval(value)
+	return false
 }
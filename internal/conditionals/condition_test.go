@@ -202,3 +202,117 @@ func TestCondition_FromSlice(t *testing.T) {
 		})
 	})
 }
+
+func TestCondition_Paths(t *testing.T) {
+	ctx := context.Background()
+	jsonString := `{"args": [{"id": 5, "type": "user"}, {"id": 7, "type": "org"}], "payload": {"items": ["a", "b"]}}`
+
+	builder := job2.NewBuilder(&job2.Configuration{Type: "json"})
+	job, err := builder.MakeJob([]byte(jsonString))
+	require.Nil(t, err)
+
+	t.Run("nested index", func(t *testing.T) {
+		assert.True(t, NewCondition("args[1].id", Equal, 7).Evaluate(ctx, job))
+		assert.False(t, NewCondition("args[0].id", Equal, 7).Evaluate(ctx, job))
+	})
+	t.Run("negative index", func(t *testing.T) {
+		assert.True(t, NewCondition("payload.items[-1]", Equal, "b").Evaluate(ctx, job))
+	})
+	t.Run("wildcard matches any", func(t *testing.T) {
+		assert.True(t, NewCondition("args[*].type", Equal, "org").Evaluate(ctx, job))
+		assert.False(t, NewCondition("args[*].id", GreaterThan, 7).Evaluate(ctx, job))
+	})
+	t.Run("slice contains", func(t *testing.T) {
+		assert.True(t, NewCondition("payload.items", Contains, "a").Evaluate(ctx, job))
+		assert.False(t, NewCondition("payload.items", Contains, "c").Evaluate(ctx, job))
+	})
+	t.Run("mismatched kinds", func(t *testing.T) {
+		assert.False(t, NewCondition("args[0].id", Equal, "5").Evaluate(ctx, job))
+		assert.False(t, NewCondition("args[0].type", GreaterThan, 5).Evaluate(ctx, job))
+	})
+	t.Run("invalid path", func(t *testing.T) {
+		assert.False(t, NewCondition("args[", Equal, 5).Evaluate(ctx, job))
+	})
+}
+
+func TestCondition_Missing(t *testing.T) {
+	ctx := context.Background()
+	jsonString := `{"retry_count": 3, "args": [1]}`
+
+	builder := job2.NewBuilder(&job2.Configuration{Type: "json"})
+	job, err := builder.MakeJob([]byte(jsonString))
+	require.Nil(t, err)
+
+	t.Run("false by default", func(t *testing.T) {
+		ok, err := NewCondition("error_message", Contains, "boom").Check(ctx, job)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	})
+	t.Run("true", func(t *testing.T) {
+		ok, err := NewCondition("error_message", Contains, "boom", WithMissing(MissingTrue)).Check(ctx, job)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	})
+	t.Run("error", func(t *testing.T) {
+		_, err := NewCondition("error_message", Contains, "boom", WithMissing(MissingError)).Check(ctx, job)
+		assert.ErrorIs(t, err, job2.ErrFieldNotFound)
+
+		_, err = NewCondition("args[4]", Equal, 1, WithMissing(MissingError)).Check(ctx, job)
+		assert.ErrorIs(t, err, job2.ErrIndexOutOfRange)
+
+		assert.False(t, NewCondition("args[4]", Equal, 1, WithMissing(MissingError)).Evaluate(ctx, job))
+	})
+	t.Run("present field is not affected", func(t *testing.T) {
+		ok, err := NewCondition("retry_count", Equal, 3, WithMissing(MissingError)).Check(ctx, job)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	})
+}
+
+func TestCondition_Formats(t *testing.T) {
+	ctx := context.Background()
+	payloads := map[job2.RawJobType]string{
+		job2.JsonRawJobType: `{"retry_count": 4, "error_message": "Error runnning: Nil Error", "args": [5, "some message"]}`,
+		job2.YamlRawJobType: "retry_count: 4\nerror_message: 'Error runnning: Nil Error'\nargs: [5, some message]\n",
+	}
+
+	for format, payload := range payloads {
+		t.Run(string(format), func(t *testing.T) {
+			builder := job2.NewBuilder(&job2.Configuration{Type: format})
+			job, err := builder.MakeJob([]byte(payload))
+			require.Nil(t, err)
+
+			assert.True(t, NewCondition("retry_count", GreaterThan, 3).Evaluate(ctx, job))
+			assert.True(t, NewCondition("retry_count", Equal, int64(4)).Evaluate(ctx, job))
+			assert.True(t, NewCondition("error_message", Contains, "Nil Error").Evaluate(ctx, job))
+			assert.True(t, NewCondition("args[0]", Equal, 5).Evaluate(ctx, job))
+			assert.False(t, NewCondition("args[1]", Equal, 5).Evaluate(ctx, job))
+		})
+	}
+}
+
+func TestCondition_Numbers(t *testing.T) {
+	ctx := context.Background()
+	jsonString := `{"id": 12345678901234567891, "amount": 0.1, "retry_count": 3, "args": [18446744073709551615, 2.50]}`
+	builder := job2.NewBuilder(&job2.Configuration{Type: "json"})
+	job, err := builder.MakeJob([]byte(jsonString))
+	require.Nil(t, err)
+
+	t.Run("success", func(t *testing.T) {
+		assert.True(t, NewCondition("id", Equal, uint64(12345678901234567891)).Evaluate(ctx, job))
+		assert.True(t, NewCondition("id", GreaterThan, uint64(12345678901234567890)).Evaluate(ctx, job))
+		assert.True(t, NewCondition("amount", Equal, 0.1).Evaluate(ctx, job))
+		assert.True(t, NewCondition("retry_count", GreaterThanEqualTo, 3).Evaluate(ctx, job))
+		assert.True(t, NewCondition("retry_count", LessThan, 3.5).Evaluate(ctx, job))
+		assert.True(t, NewCondition("retry_count", LessThanEqualTo, 3).Evaluate(ctx, job))
+		assert.True(t, NewCondition("args", Contains, uint64(18446744073709551615)).Evaluate(ctx, job))
+		assert.True(t, NewCondition("args[*]", Equal, 2.5).Evaluate(ctx, job))
+	})
+	t.Run("failure", func(t *testing.T) {
+		// both round to the same float64
+		assert.False(t, NewCondition("id", Equal, uint64(12345678901234567890)).Evaluate(ctx, job))
+		assert.False(t, NewCondition("retry_count", LessThan, 3).Evaluate(ctx, job))
+		assert.False(t, NewCondition("retry_count", Equal, "3").Evaluate(ctx, job))
+		assert.False(t, NewCondition("id", Contains, "123").Evaluate(ctx, job))
+	})
+}
@@ -18,3 +18,18 @@ const (
 )
 
 type ConditionFunc func(ctx context.Context, job job.Job) bool
+
+// CheckFunc is a ConditionFunc that can report that it could not be
+// evaluated, for example because the element is missing from the job.
+type CheckFunc func(ctx context.Context, job job.Job) (bool, error)
+
+// Missing is how a condition evaluates when its element is not in the job.
+type Missing string
+
+const (
+	MissingFalse Missing = "false"
+	MissingTrue  Missing = "true"
+	// MissingError makes Check return the lookup error so the decision tree
+	// can route the job to its error or failure branch
+	MissingError Missing = "error"
+)
@@ -9,7 +9,6 @@ import (
 	"os/exec"
 	"reflect"
 	"regexp"
-	"strconv"
 	"strings"
 )
 
@@ -26,6 +25,12 @@ func NewExecutor(configuration *Configuration) (*executor, error) {
 		return nil, err
 	}
 
+	for _, v := range reg.FindAllString(configuration.Sprintf, -1) {
+		if _, err := job.ParsePath(v[1 : len(v)-1]); err != nil {
+			return nil, err
+		}
+	}
+
 	return &executor{
 		configuration: configuration,
 		reg:           reg,
@@ -85,13 +90,14 @@ func buildString(cmdString string, stringToReplace string, j job.Job) (string, e
 func getValueFrom(val reflect.Value) string {
 	var newVal string
 
+	// numbers are rendered as written in the job, without exponents
+	if job.IsNumber(val) {
+		return job.FormatNumber(val)
+	}
+
 	switch val.Kind() {
 	case reflect.String:
 		newVal = val.String()
-	case reflect.Int, reflect.Int16, reflect.Int32, reflect.Int64:
-		newVal = strconv.FormatInt(val.Int(), 10)
-	case reflect.Float32, reflect.Float64:
-		newVal = fmt.Sprint(val.Float())
 	case reflect.Slice:
 		strSlice := make([]string, val.Len())
 		interfaceSLice := val.Interface().([]interface{})
@@ -3,9 +3,12 @@ package executers
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/job"
 	"reflect"
 	"testing"
 )
@@ -15,12 +18,42 @@ type mockJob struct {
 	maps map[string]interface{}
 }
 
-func (m mockJob) GetValue(key string) (reflect.Value, error) {
+func (m *mockJob) GetValue(key string) (reflect.Value, error) {
 	args := m.Called(key)
 	return args.Get(0).(reflect.Value), args.Error(1)
 }
 
-func (m mockJob) Raw() []byte {
+func (m *mockJob) GetValues(key string) ([]reflect.Value, error) {
+	args := m.Called(key)
+	return args.Get(0).([]reflect.Value), args.Error(1)
+}
+
+func (m *mockJob) SetValue(key string, value interface{}) error {
+	args := m.Called(key, value)
+	return args.Error(0)
+}
+
+func (m *mockJob) Delete(key string) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *mockJob) Raw() []byte {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *mockJob) Original() []byte {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *mockJob) Clone() job.Data {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *mockJob) Replace(clone job.Data) error {
 	//TODO implement me
 	panic("implement me")
 }
@@ -53,7 +86,26 @@ func TestExecutors(t *testing.T) {
 			mock.AssertExpectationsForObjects(t, job)
 		})
 	})
+	t.Run("numbers are rendered exactly", func(t *testing.T) {
+		for expected, value := range map[string]interface{}{
+			"12345678901234567891":   json.Number("12345678901234567891"),
+			"0.1":                    json.Number("0.1"),
+			"1000000000000000000000": 1e21,
+			"12388":                  float64(12388),
+			"18446744073709551615":   uint64(18446744073709551615),
+			"-4":                     int8(-4),
+			"1,0.5,some":             []interface{}{json.Number("1"), 0.5, "some"},
+		} {
+			assert.Equal(t, expected, getValueFrom(reflect.ValueOf(value)))
+		}
+	})
 	t.Run("failure", func(t *testing.T) {
+		t.Run("invalid path", func(t *testing.T) {
+			_, err := NewExecutor(&Configuration{
+				Sprintf: "bundle exec {args[}",
+			})
 
+			require.NotNil(t, err)
+		})
 	})
 }
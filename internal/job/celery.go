@@ -0,0 +1,264 @@
+package job
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// celeryAliases are shortcuts to the parts of a celery message conditions
+// usually look at. The body of a protocol 2 message is [args, kwargs, embed].
+var celeryAliases = map[string]string{
+	"args":    "body[0]",
+	"kwargs":  "body[1]",
+	"task":    "headers.task",
+	"id":      "headers.id",
+	"retries": "headers.retries",
+}
+
+// celeryJob is a celery message as kombu stores it in redis. The message's
+// base64 body is decoded into the body field so it can be read and set like
+// the rest of the message, and re-encoded when the job is.
+type celeryJob struct {
+	*treeJob
+}
+
+func (c *celeryJob) GetValue(key string) (reflect.Value, error) {
+	return c.treeJob.GetValue(celeryKey(key))
+}
+
+func (c *celeryJob) GetValues(key string) ([]reflect.Value, error) {
+	return c.treeJob.GetValues(celeryKey(key))
+}
+
+func (c *celeryJob) SetValue(key string, value interface{}) error {
+	return c.treeJob.SetValue(celeryKey(key), value)
+}
+
+func (c *celeryJob) Delete(key string) error {
+	return c.treeJob.Delete(celeryKey(key))
+}
+
+func (c *celeryJob) Clone() Data {
+	return &celeryJob{treeJob: c.treeJob.Clone().(*treeJob)}
+}
+
+func (c *celeryJob) Replace(clone Data) error {
+	other, ok := clone.(*celeryJob)
+	if !ok {
+		return fmt.Errorf("can't replace a celery job with %T", clone)
+	}
+
+	return c.treeJob.Replace(other.treeJob)
+}
+
+func (c *celeryJob) validate() error {
+	return validate(c.config, c)
+}
+
+// celeryKey expands the aliases at the start of key.
+func celeryKey(key string) string {
+	for alias, path := range celeryAliases {
+		if key == alias || strings.HasPrefix(key, alias+".") || strings.HasPrefix(key, alias+"[") {
+			return path + key[len(alias):]
+		}
+	}
+
+	return key
+}
+
+func makeCeleryJob(config *Configuration, bts []byte) (*celeryJob, error) {
+	var envelope map[string]interface{}
+	if err := unmarshalNumbers(bts, &envelope); err != nil {
+		return nil, err
+	}
+
+	body, ok := envelope["body"].(string)
+	if !ok {
+		return nil, errors.New("celery message has no body")
+	}
+
+	decoded, err := decodeCeleryBody(envelope, body)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope["body"] = decoded
+
+	tree, err := makeTreeJob(config, bts, envelope, encodeCelery)
+	if err != nil {
+		return nil, err
+	}
+
+	return &celeryJob{treeJob: tree}, nil
+}
+
+func decodeCeleryBody(envelope map[string]interface{}, body string) (interface{}, error) {
+	bts := []byte(body)
+	if celeryBase64(envelope) {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode celery body: %w", err)
+		}
+
+		bts = decoded
+	}
+
+	if !celeryJson(envelope) {
+		return string(bts), nil
+	}
+
+	var decoded interface{}
+	if err := unmarshalNumbers(bts, &decoded); err != nil {
+		return nil, fmt.Errorf("could not decode celery body: %w", err)
+	}
+
+	return decoded, nil
+}
+
+// encodeCelery encodes a celery message, or wraps a job from another system
+// in a new message using its task or class, args and kwargs.
+func encodeCelery(tree map[string]interface{}) ([]byte, error) {
+	if _, ok := tree["headers"]; !ok {
+		message, err := newCeleryMessage(tree)
+		if err != nil {
+			return nil, err
+		}
+
+		tree = message
+	}
+
+	envelope := make(map[string]interface{}, len(tree))
+	for key := range tree {
+		envelope[key] = tree[key]
+	}
+
+	body, ok := envelope["body"].(string)
+	if celeryJson(envelope) {
+		bts, err := json.Marshal(denormaliseNumbers(envelope["body"]))
+		if err != nil {
+			return nil, err
+		}
+
+		body, ok = string(bts), true
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("celery body must be a string, got %T", envelope["body"])
+	}
+
+	if celeryBase64(envelope) {
+		body = base64.StdEncoding.EncodeToString([]byte(body))
+	}
+
+	envelope["body"] = body
+
+	return json.Marshal(denormaliseNumbers(envelope))
+}
+
+func newCeleryMessage(tree map[string]interface{}) (map[string]interface{}, error) {
+	task, _ := tree["task"].(string)
+	if task == "" {
+		task, _ = tree["class"].(string)
+	}
+
+	if task == "" {
+		return nil, errors.New("could not make celery message: job has no task or class")
+	}
+
+	id, _ := tree["id"].(string)
+	if id == "" {
+		id, _ = tree["jid"].(string)
+	}
+
+	if id == "" {
+		generated, err := newUUID()
+		if err != nil {
+			return nil, err
+		}
+
+		id = generated
+	}
+
+	deliveryTag, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	queue, _ := tree["queue"].(string)
+	if queue == "" {
+		queue = "celery"
+	}
+
+	args, ok := tree["args"].([]interface{})
+	if !ok {
+		args = []interface{}{}
+	}
+
+	kwargs, ok := tree["kwargs"].(map[string]interface{})
+	if !ok {
+		kwargs = map[string]interface{}{}
+	}
+
+	return map[string]interface{}{
+		"body": []interface{}{args, kwargs, map[string]interface{}{
+			"callbacks": nil,
+			"errbacks":  nil,
+			"chain":     nil,
+			"chord":     nil,
+		}},
+		"content-encoding": "utf-8",
+		"content-type":     "application/json",
+		"headers": map[string]interface{}{
+			"lang":      "py",
+			"task":      task,
+			"id":        id,
+			"root_id":   id,
+			"parent_id": nil,
+			"group":     nil,
+			"retries":   0,
+			"eta":       nil,
+			"expires":   nil,
+		},
+		"properties": map[string]interface{}{
+			"correlation_id": id,
+			"delivery_mode":  2,
+			"delivery_info": map[string]interface{}{
+				"exchange":    "",
+				"routing_key": queue,
+			},
+			"priority":      0,
+			"body_encoding": "base64",
+			"delivery_tag":  deliveryTag,
+		},
+	}, nil
+}
+
+func celeryBase64(envelope map[string]interface{}) bool {
+	properties, _ := envelope["properties"].(map[string]interface{})
+	encoding, _ := properties["body_encoding"].(string)
+
+	return encoding == "base64"
+}
+
+func celeryJson(envelope map[string]interface{}) bool {
+	contentType, _ := envelope["content-type"].(string)
+
+	return contentType == "" || contentType == "application/json"
+}
+
+func newUUID() (string, error) {
+	bts := make([]byte, 16)
+	if _, err := rand.Read(bts); err != nil {
+		return "", err
+	}
+
+	bts[6] = bts[6]&0x0f | 0x40
+	bts[8] = bts[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", bts[0:4], bts[4:6], bts[6:8], bts[8:10], bts[10:]), nil
+}
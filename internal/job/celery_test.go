@@ -0,0 +1,117 @@
+package job
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func celeryMessage(t *testing.T, body string) []byte {
+	message, err := json.Marshal(map[string]interface{}{
+		"body":             base64.StdEncoding.EncodeToString([]byte(body)),
+		"content-encoding": "utf-8",
+		"content-type":     "application/json",
+		"headers": map[string]interface{}{
+			"lang":    "py",
+			"task":    "tasks.send_email",
+			"id":      "7c3f6b1e-0b5a-4d0e-9a55-6a3c1f0e8b21",
+			"retries": 2,
+		},
+		"properties": map[string]interface{}{
+			"body_encoding": "base64",
+			"delivery_tag":  "b3b3d1a2-6c3e-4f2b-8f0e-2d4d7f1f9a11",
+			"delivery_info": map[string]interface{}{"exchange": "", "routing_key": "celery"},
+		},
+	})
+	require.Nil(t, err)
+
+	return message
+}
+
+func TestCeleryJob(t *testing.T) {
+	builder := NewBuilder(&Configuration{Type: CeleryRawJobType})
+	message := celeryMessage(t, `[[42, "welcome"], {"locale": "en"}, {"callbacks": null}]`)
+
+	t.Run("success", func(t *testing.T) {
+		t.Run("body and aliases", func(t *testing.T) {
+			j, err := builder.MakeJob(message)
+			require.Nil(t, err)
+
+			for key, expected := range map[string]interface{}{
+				"task":                     "tasks.send_email",
+				"headers.task":             "tasks.send_email",
+				"retries":                  int64(2),
+				"args[0]":                  int64(42),
+				"body[0][1]":               "welcome",
+				"kwargs.locale":            "en",
+				"properties.body_encoding": "base64",
+			} {
+				value, err := j.GetValue(key)
+				require.Nil(t, err, key)
+				assert.Equal(t, expected, value.Interface(), key)
+			}
+
+			assert.Equal(t, message, j.Raw())
+		})
+		t.Run("body is re-encoded", func(t *testing.T) {
+			j, err := builder.MakeJob(message)
+			require.Nil(t, err)
+			require.Nil(t, j.SetValue("kwargs.locale", "fr"))
+			require.Nil(t, j.SetValue("retries", 3))
+
+			reread, err := builder.MakeJob(j.Raw())
+			require.Nil(t, err)
+
+			locale, err := reread.GetValue("kwargs.locale")
+			require.Nil(t, err)
+			assert.Equal(t, "fr", locale.String())
+
+			var envelope map[string]interface{}
+			require.Nil(t, json.Unmarshal(j.Raw(), &envelope))
+			body, err := base64.StdEncoding.DecodeString(envelope["body"].(string))
+			require.Nil(t, err)
+			assert.JSONEq(t, `[[42, "welcome"], {"locale": "fr"}, {"callbacks": null}]`, string(body))
+			assert.Equal(t, message, j.Original())
+		})
+		t.Run("other jobs are wrapped in a message", func(t *testing.T) {
+			j, err := NewBuilder(&Configuration{Type: JsonRawJobType}).MakeJob([]byte(`{"class":"tasks.add","args":[1,2],"jid":"abc","queue":"math"}`))
+			require.Nil(t, err)
+
+			bts, err := builder.Encode(j)
+			require.Nil(t, err)
+
+			message, err := builder.MakeJob(bts)
+			require.Nil(t, err)
+
+			for key, expected := range map[string]interface{}{
+				"task":                                 "tasks.add",
+				"id":                                   "abc",
+				"args[1]":                              int64(2),
+				"properties.delivery_info.routing_key": "math",
+			} {
+				value, err := message.GetValue(key)
+				require.Nil(t, err, key)
+				assert.Equal(t, expected, value.Interface(), key)
+			}
+		})
+	})
+	t.Run("failure", func(t *testing.T) {
+		t.Run("no body", func(t *testing.T) {
+			_, err := builder.MakeJob([]byte(`{"headers":{}}`))
+			assert.NotNil(t, err)
+		})
+		t.Run("bad base64", func(t *testing.T) {
+			_, err := builder.MakeJob([]byte(`{"body":"!!","properties":{"body_encoding":"base64"}}`))
+			assert.NotNil(t, err)
+		})
+		t.Run("no task", func(t *testing.T) {
+			j, err := NewBuilder(&Configuration{Type: JsonRawJobType}).MakeJob([]byte(`{"args":[]}`))
+			require.Nil(t, err)
+
+			_, err = builder.Encode(j)
+			assert.NotNil(t, err)
+		})
+	})
+}
@@ -6,14 +6,41 @@ const (
 	JsonRawJobType  RawJobType = "json"
 	ProtoRawJobType RawJobType = "proto"
 
-	YamlRawJobType RawJobType = "yaml"
+	YamlRawJobType    RawJobType = "yaml"
+	MsgpackRawJobType RawJobType = "msgpack"
+	// CeleryRawJobType is a celery message as kombu stores it in redis
+	CeleryRawJobType RawJobType = "celery"
 )
 
+type FieldType string
+
+const (
+	AnyField     FieldType = "any"
+	StringField  FieldType = "string"
+	NumberField  FieldType = "number"
+	IntegerField FieldType = "integer"
+	BoolField    FieldType = "bool"
+	ObjectField  FieldType = "object"
+	ArrayField   FieldType = "array"
+)
+
+// Field declares a field of a job's schema. Name is a path to the field, see
+// Path. A missing field is given Default when one is set, otherwise it is an
+// error if the field is Required.
 type Field struct {
-	Name    string `json:"name" yaml:"name"`
-	Type    string `json:"type" yaml:"type"`
-	Default string `json:"default" yaml:"default"`
+	Name     string      `json:"name" yaml:"name"`
+	Type     FieldType   `json:"type" yaml:"type"`
+	Required bool        `json:"required" yaml:"required"`
+	Default  interface{} `json:"default" yaml:"default"`
 }
 type Configuration struct {
 	Type RawJobType `json:"type" yaml:"type"`
+	// Fields is the schema every job is validated against when it is made
+	Fields []Field `json:"fields" yaml:"fields"`
+
+	// DescriptorSet is the path to a FileDescriptorSet containing
+	// MessageType, the fully qualified name of the message proto jobs are
+	// encoded as
+	DescriptorSet string `json:"descriptorSet" yaml:"descriptorSet"`
+	MessageType   string `json:"messageType" yaml:"messageType"`
 }
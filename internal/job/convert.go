@@ -0,0 +1,96 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// encodable is implemented by the jobs this package makes so they can be
+// re-encoded in another format.
+type encodable interface {
+	configuration() *Configuration
+	toTree() map[string]interface{}
+}
+
+// Encode returns j encoded in the builder's format. Jobs already in that
+// format are returned as j.Raw(), others are converted through their fields
+// so jobs can be moved between queues with different formats.
+func (jF *Builder) Encode(j Data) ([]byte, error) {
+	if jF.config == nil {
+		return nil, errors.New("invalid configuration")
+	}
+
+	e, ok := j.(encodable)
+	if !ok || sameFormat(e.configuration(), jF.config) {
+		return j.Raw(), nil
+	}
+
+	tree := e.toTree()
+	switch formatOf(jF.config) {
+	case JsonRawJobType:
+		return json.Marshal(tree)
+	case YamlRawJobType:
+		return encodeYaml(tree)
+	case MsgpackRawJobType:
+		return encodeMsgpack(tree)
+	case CeleryRawJobType:
+		return encodeCelery(tree)
+	case ProtoRawJobType:
+		messageType, err := jF.messageType()
+		if err != nil {
+			return nil, err
+		}
+
+		msg := messageType.New()
+		for key, value := range tree {
+			if err := setMessagePath(msg, Path{{Type: KeyPart, Key: key}}, value); err != nil {
+				return nil, err
+			}
+		}
+
+		return proto.MarshalOptions{Deterministic: true}.Marshal(msg.Interface())
+	}
+
+	return nil, errors.New("invalid job type")
+}
+
+func formatOf(config *Configuration) RawJobType {
+	if config == nil || config.Type == "" {
+		return JsonRawJobType
+	}
+
+	return config.Type
+}
+
+func sameFormat(a, b *Configuration) bool {
+	if formatOf(a) != formatOf(b) {
+		return false
+	}
+
+	return formatOf(a) != ProtoRawJobType || a.MessageType == b.MessageType
+}
+
+func (j *jsonJob) configuration() *Configuration {
+	return j.config
+}
+
+func (j *jsonJob) toTree() map[string]interface{} {
+	return j.jsonMap
+}
+
+func (t *treeJob) configuration() *Configuration {
+	return t.config
+}
+
+func (t *treeJob) toTree() map[string]interface{} {
+	return t.tree
+}
+
+func (p *protoJob) configuration() *Configuration {
+	return p.config
+}
+
+func (p *protoJob) toTree() map[string]interface{} {
+	return p.tree
+}
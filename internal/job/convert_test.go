@@ -0,0 +1,68 @@
+package job
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBuilder_Encode(t *testing.T) {
+	jsonBuilder := NewBuilder(&Configuration{Type: JsonRawJobType})
+	source, err := jsonBuilder.MakeJob([]byte(`{"class":"WebhookWorker","retry_count":2,"args":[1,"a"]}`))
+	require.Nil(t, err)
+
+	t.Run("same format returns raw", func(t *testing.T) {
+		bts, err := jsonBuilder.Encode(source)
+		require.Nil(t, err)
+		assert.Equal(t, source.Raw(), bts)
+	})
+	for _, format := range []RawJobType{YamlRawJobType, MsgpackRawJobType} {
+		t.Run(string(format), func(t *testing.T) {
+			builder := NewBuilder(&Configuration{Type: format})
+			bts, err := builder.Encode(source)
+			require.Nil(t, err)
+
+			converted, err := builder.MakeJob(bts)
+			require.Nil(t, err)
+
+			value, err := converted.GetValue("args[1]")
+			require.Nil(t, err)
+			assert.Equal(t, "a", value.String())
+
+			// and back again
+			back, err := jsonBuilder.Encode(converted)
+			require.Nil(t, err)
+			assert.JSONEq(t, string(source.Raw()), string(back))
+		})
+	}
+	t.Run("proto", func(t *testing.T) {
+		protoBuilder, encoded := writeTestDescriptorSet(t)
+		protoSource, err := protoBuilder.MakeJob(encoded)
+		require.Nil(t, err)
+
+		bts, err := jsonBuilder.Encode(protoSource)
+		require.Nil(t, err)
+
+		converted, err := jsonBuilder.MakeJob(bts)
+		require.Nil(t, err)
+
+		value, err := converted.GetValue("tags.team")
+		require.Nil(t, err)
+		assert.Equal(t, "payments", value.String())
+
+		back, err := protoBuilder.Encode(converted)
+		require.Nil(t, err)
+
+		reread, err := protoBuilder.MakeJob(back)
+		require.Nil(t, err)
+
+		state, err := reread.GetValue("state")
+		require.Nil(t, err)
+		assert.Equal(t, "RETRY", state.String())
+	})
+	t.Run("raw jobs are not converted", func(t *testing.T) {
+		bts, err := NewBuilder(&Configuration{Type: MsgpackRawJobType}).Encode(NewRawJob([]byte("not json")))
+		require.Nil(t, err)
+		assert.Equal(t, []byte("not json"), bts)
+	})
+}
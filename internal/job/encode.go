@@ -0,0 +1,204 @@
+package job
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// marshalPreserving encodes value as json using original, the bytes value was
+// decoded from, as a guide. Keys keep the order they had in original with new
+// keys appended in sorted order, and scalars that have not changed are written
+// exactly as they appeared so numbers too large for a float64 survive a
+// round trip.
+func marshalPreserving(value interface{}, original []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(original)))
+	err := encodePreserving(buf, value, bytes.TrimSpace(original))
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encodePreserving(buf *bytes.Buffer, value interface{}, original []byte) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return encodeObject(buf, v, original)
+	case []interface{}:
+		return encodeArray(buf, v, original)
+	case float64:
+		if len(original) > 0 && isNumberLiteral(original) {
+			f, err := strconv.ParseFloat(string(original), 64)
+			if err == nil && f == v {
+				buf.Write(original)
+				return nil
+			}
+		}
+	case json.Number:
+		if len(original) > 0 && string(original) == string(v) {
+			buf.Write(original)
+			return nil
+		}
+	case string:
+		if len(original) > 0 && original[0] == '"' {
+			var s string
+			if err := json.Unmarshal(original, &s); err == nil && s == v {
+				buf.Write(original)
+				return nil
+			}
+		}
+	}
+
+	bts, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	buf.Write(bts)
+
+	return nil
+}
+
+func encodeObject(buf *bytes.Buffer, m map[string]interface{}, original []byte) error {
+	members := objectMembers(original)
+	written := make(map[string]bool, len(m))
+
+	buf.WriteByte('{')
+	writeMember := func(key string, raw []byte) error {
+		if len(written) > 0 {
+			buf.WriteByte(',')
+		}
+		written[key] = true
+
+		keyBytes, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+
+		buf.Write(keyBytes)
+		buf.WriteByte(':')
+
+		return encodePreserving(buf, m[key], raw)
+	}
+
+	for _, member := range members {
+		if _, ok := m[member.key]; !ok || written[member.key] {
+			continue
+		}
+
+		if err := writeMember(member.key, member.raw); err != nil {
+			return err
+		}
+	}
+
+	added := make([]string, 0)
+	for key := range m {
+		if !written[key] {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(added)
+
+	for _, key := range added {
+		if err := writeMember(key, nil); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+
+	return nil
+}
+
+func encodeArray(buf *bytes.Buffer, slice []interface{}, original []byte) error {
+	elements := arrayElements(original)
+
+	buf.WriteByte('[')
+	for idx := range slice {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+
+		var raw []byte
+		if idx < len(elements) {
+			raw = elements[idx]
+		}
+
+		if err := encodePreserving(buf, slice[idx], raw); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+
+	return nil
+}
+
+type rawMember struct {
+	key string
+	raw json.RawMessage
+}
+
+// objectMembers returns the members of a json object in the order they were
+// written, or nil if original is not an object.
+func objectMembers(original []byte) []rawMember {
+	if len(original) == 0 || original[0] != '{' {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(original))
+	if _, err := dec.Token(); err != nil {
+		return nil
+	}
+
+	members := make([]rawMember, 0)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return members
+		}
+
+		key, ok := tok.(string)
+		if !ok {
+			return members
+		}
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return members
+		}
+
+		members = append(members, rawMember{key: key, raw: raw})
+	}
+
+	return members
+}
+
+// arrayElements returns the raw elements of a json array, or nil if original
+// is not an array.
+func arrayElements(original []byte) []json.RawMessage {
+	if len(original) == 0 || original[0] != '[' {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(original))
+	if _, err := dec.Token(); err != nil {
+		return nil
+	}
+
+	elements := make([]json.RawMessage, 0)
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return elements
+		}
+
+		elements = append(elements, raw)
+	}
+
+	return elements
+}
+
+func isNumberLiteral(bts []byte) bool {
+	return bts[0] == '-' || (bts[0] >= '0' && bts[0] <= '9')
+}
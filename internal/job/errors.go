@@ -0,0 +1,18 @@
+package job
+
+import "errors"
+
+var (
+	// ErrFieldNotFound is returned when a path names a key that is not in the
+	// job. A key that is present with a null value is not an error.
+	ErrFieldNotFound = errors.New("field not found")
+	// ErrNotAnObject is returned when a path reads a key from a value that is
+	// not an object.
+	ErrNotAnObject = errors.New("not an object")
+	// ErrNotAnArray is returned when a path indexes a value that is not an
+	// array.
+	ErrNotAnArray = errors.New("not an array")
+	// ErrIndexOutOfRange is returned when a path indexes past either end of
+	// an array.
+	ErrIndexOutOfRange = errors.New("index out of range")
+)
@@ -2,8 +2,10 @@ package job
 
 import (
 	"errors"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"io"
 	"reflect"
+	"sync"
 )
 
 type Job interface {
@@ -11,8 +13,27 @@ type Job interface {
 }
 
 type Data interface {
+	// GetValue returns the value at key, see Path for the syntax. A key with
+	// a wildcard returns all matches as a slice.
 	GetValue(key string) (reflect.Value, error)
+	// GetValues returns every value matched by key.
+	GetValues(key string) ([]reflect.Value, error)
+	// SetValue sets the value at key, creating any missing intermediate objects.
+	SetValue(key string, value interface{}) error
+	// Delete removes key from the job. Deleting a missing key is not an error.
+	Delete(key string) error
+	// Raw returns the serialised job, re-encoded if it has been modified.
 	Raw() []byte
+	// Original returns the bytes the job was built from, which is what the
+	// queue the job was read from still holds.
+	Original() []byte
+	// Clone returns a deep copy of the job that can be modified without
+	// affecting the original.
+	Clone() Data
+	// Replace makes the job hold the contents of clone, a Clone of it that
+	// is not used afterwards, so changes made to a copy can be kept by the
+	// job itself.
+	Replace(clone Data) error
 }
 
 type JobWrapper struct {
@@ -25,6 +46,12 @@ type JobWrapper struct {
 
 type Builder struct {
 	config *Configuration
+
+	// protoType is loaded from the descriptor set the first time a proto job
+	// is made
+	protoOnce sync.Once
+	protoType protoreflect.MessageType
+	protoErr  error
 }
 
 func NewBuilder(configuration *Configuration) *Builder {
@@ -33,14 +60,55 @@ func NewBuilder(configuration *Configuration) *Builder {
 	}
 }
 
+// MakeJob decodes bts into a job and validates it against the configured
+// fields. A job that decodes but fails validation is returned along with an
+// error wrapping ErrInvalidJob so it can be quarantined.
 func (jF *Builder) MakeJob(bts []byte) (Data, error) {
 	if jF.config == nil {
 		return nil, errors.New("invalid configuration")
 	}
 
-	if jF.config.Type == "json" {
-		return makeJsonJob(jF.config, bts)
+	var j interface {
+		Data
+		validate() error
+	}
+	var err error
+
+	switch jF.config.Type {
+	case JsonRawJobType:
+		j, err = makeJsonJob(jF.config, bts)
+	case ProtoRawJobType:
+		messageType, typeErr := jF.messageType()
+		if typeErr != nil {
+			return nil, typeErr
+		}
+
+		j, err = makeProtoJob(jF.config, messageType, bts)
+	case YamlRawJobType:
+		j, err = makeYamlJob(jF.config, bts)
+	case MsgpackRawJobType:
+		j, err = makeMsgpackJob(jF.config, bts)
+	case CeleryRawJobType:
+		j, err = makeCeleryJob(jF.config, bts)
+	default:
+		return nil, errors.New("invalid job type")
+	}
+
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, errors.New("invalid job type")
+	if err := j.validate(); err != nil {
+		return j, err
+	}
+
+	return j, nil
+}
+
+func (jF *Builder) messageType() (protoreflect.MessageType, error) {
+	jF.protoOnce.Do(func() {
+		jF.protoType, jF.protoErr = loadMessageType(jF.config)
+	})
+
+	return jF.protoType, jF.protoErr
 }
@@ -1,55 +1,176 @@
 package job
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"reflect"
-	"strings"
 )
 
 type jsonJob struct {
 	config  *Configuration
 	bytes   []byte
 	jsonMap map[string]interface{}
+
+	// raw holds the re-encoded job once it has been modified, bytes always
+	// holds the payload the job was built from
+	raw   []byte
+	dirty bool
 }
 
 func (j *jsonJob) GetValue(key string) (reflect.Value, error) {
-	keys := strings.Split(key, ".")
-	jsonMap := j.jsonMap
-	return getKindAndValueFromJsonMapFromKeys(keys, jsonMap)
+	return getTreeValue(j.jsonMap, key)
+}
+
+func (j *jsonJob) GetValues(key string) ([]reflect.Value, error) {
+	return getTreeValues(j.jsonMap, key)
+}
+
+func (j *jsonJob) SetValue(key string, value interface{}) error {
+	path, err := ParsePath(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = path.Set(j.jsonMap, normaliseJsonValue(value))
+	if err != nil {
+		return fmt.Errorf("could not set %s: %w", key, err)
+	}
+
+	j.dirty = true
+
+	return nil
 }
 
+func (j *jsonJob) Delete(key string) error {
+	path, err := ParsePath(key)
+	if err != nil {
+		return err
+	}
+
+	_, deleted := path.Delete(j.jsonMap)
+	if deleted {
+		j.dirty = true
+	}
+
+	return nil
+}
+
+// Raw returns the original payload until the job is modified, after which
+// it is re-encoded keeping the original key order and number formatting.
 func (j *jsonJob) Raw() []byte {
+	if j.dirty {
+		// the map only holds values decoded from json or normalised by
+		// SetValue so a marshal error leaves the last good encoding in place
+		bts, err := marshalPreserving(j.jsonMap, j.bytes)
+		if err == nil {
+			j.raw = bts
+			j.dirty = false
+		}
+	}
+
+	if j.raw != nil {
+		return j.raw
+	}
+
 	return j.bytes
 }
 
-func getKindAndValueFromJsonMapFromKeys(keys []string, jsonMap map[string]interface{}) (reflect.Value, error) {
-	val := reflect.Value{}
-	var err error
-	for idx := range keys {
-		k := keys[idx]
-		val, err = getKindAndValueFromJsonMapFromKey(k, jsonMap)
-		if err != nil {
-			return reflect.Value{}, err
-		}
+func (j *jsonJob) Original() []byte {
+	return j.bytes
+}
 
-		if val.Kind() == reflect.Map {
-			jsonMap = val.Interface().(map[string]interface{})
-		}
+func (j *jsonJob) Clone() Data {
+	clone := &jsonJob{
+		config:  j.config,
+		bytes:   j.bytes,
+		jsonMap: deepCopy(j.jsonMap).(map[string]interface{}),
+		dirty:   j.dirty || j.raw != nil,
 	}
 
-	return val, err
+	return clone
 }
 
-func getKindAndValueFromJsonMapFromKey(key string, jsonMap map[string]interface{}) (reflect.Value, error) {
-	val, ok := jsonMap[key]
+func (j *jsonJob) Replace(clone Data) error {
+	c, ok := clone.(*jsonJob)
 	if !ok {
-		return reflect.Value{}, nil
+		return fmt.Errorf("can't replace a json job with %T", clone)
+	}
+
+	*j = *c
+
+	return nil
+}
+
+// getTreeValue returns the value at key in a decoded job. A path containing
+// a wildcard returns every match as a []interface{}, otherwise a missing
+// field is reported with one of the Err values in errors.go.
+func getTreeValue(root map[string]interface{}, key string) (reflect.Value, error) {
+	path, err := ParsePath(key)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	if path.HasWildcard() {
+		return reflect.ValueOf(path.Get(root)), nil
+	}
+
+	value, err := path.Lookup(root)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	return reflect.ValueOf(value), nil
+}
+
+// getTreeValues returns every value matched by key. Wildcards match nothing
+// rather than fail when elements are missing the rest of the path.
+func getTreeValues(root map[string]interface{}, key string) ([]reflect.Value, error) {
+	path, err := ParsePath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !path.HasWildcard() {
+		value, err := path.Lookup(root)
+		if err != nil {
+			return nil, err
+		}
+
+		return []reflect.Value{reflect.ValueOf(value)}, nil
+	}
+
+	values := path.Get(root)
+	reflectValues := make([]reflect.Value, len(values))
+	for idx := range values {
+		reflectValues[idx] = reflect.ValueOf(values[idx])
 	}
 
-	reflectVal := reflect.ValueOf(val)
+	return reflectValues, nil
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key := range v {
+			m[key] = deepCopy(v[key])
+		}
+
+		return m
+	case []interface{}:
+		slice := make([]interface{}, len(v))
+		for idx := range v {
+			slice[idx] = deepCopy(v[idx])
+		}
+
+		return slice
+	}
 
-	return reflectVal, nil
+	return value
 }
+
 func makeJsonJob(config *Configuration, bts []byte) (*jsonJob, error) {
 	jJob := jsonJob{
 		config:  config,
@@ -65,15 +186,68 @@ func makeJsonJob(config *Configuration, bts []byte) (*jsonJob, error) {
 	return &jJob, nil
 }
 
+// unmarshalJsonJob decodes numbers as json.Number so integers too large for
+// a float64 keep their exact value.
 func unmarshalJsonJob(j *jsonJob) error {
-	err := json.Unmarshal(j.bytes, &j.jsonMap)
-	if err != nil {
+	return unmarshalNumbers(j.bytes, &j.jsonMap)
+}
+
+// unmarshalNumbers is json.Unmarshal with numbers decoded as json.Number.
+func unmarshalNumbers(bts []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(bts))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(v); err != nil {
 		return err
 	}
 
+	if decoder.More() {
+		return errors.New("invalid character after top-level value")
+	}
+
 	return nil
 }
 
-func (j *jsonJob) validate() bool {
-	return false
+func (j *jsonJob) validate() error {
+	return validate(j.config, j)
+}
+
+// normaliseJsonValue is normaliseValue with numbers as json.Number, the type
+// json jobs are decoded with.
+func normaliseJsonValue(value interface{}) interface{} {
+	return normaliseValue(value, toJsonNumber)
+}
+
+// normaliseValue converts values to the types encoding/json decodes into so
+// that set values compare the same way as values read from the payload.
+// Numbers are converted with number so jobs hold a single number type.
+func normaliseValue(value interface{}, number func(value interface{}) interface{}) interface{} {
+	val := reflect.ValueOf(value)
+	if IsNumber(val) {
+		return number(value)
+	}
+
+	switch val.Kind() {
+	case reflect.Slice:
+		if _, ok := value.([]byte); ok {
+			return value
+		}
+
+		slice := make([]interface{}, val.Len())
+		for i := range slice {
+			slice[i] = normaliseValue(val.Index(i).Interface(), number)
+		}
+
+		return slice
+	case reflect.Map:
+		m := make(map[string]interface{}, val.Len())
+		iter := val.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = normaliseValue(iter.Value().Interface(), number)
+		}
+
+		return m
+	}
+
+	return value
 }
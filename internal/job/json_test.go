@@ -83,3 +83,142 @@ func TestJsonJob_validate(t *testing.T) {
 		})
 	})
 }
+
+func TestJsonJob_SetValue(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		t.Run("preserves key order and numbers", func(t *testing.T) {
+			jsonSting := `{"class":"WebhookWorker","args":[12345678901234567891,{"id":1.50}],"retry_count":4,"jid":"abc"}`
+
+			j, err := makeJsonJob(&Configuration{}, []byte(jsonSting))
+			require.Nil(t, err)
+
+			require.Nil(t, j.SetValue("retry_count", 0))
+			require.Nil(t, j.SetValue("queue", "default"))
+
+			assert.Equal(t, `{"class":"WebhookWorker","args":[12345678901234567891,{"id":1.50}],"retry_count":0,"jid":"abc","queue":"default"}`, string(j.Raw()))
+			assert.Equal(t, jsonSting, string(j.Original()))
+		})
+		t.Run("indexed path", func(t *testing.T) {
+			jsonSting := `{"args":[1,{"id":"a"}]}`
+
+			j, err := makeJsonJob(&Configuration{}, []byte(jsonSting))
+			require.Nil(t, err)
+
+			require.Nil(t, j.SetValue("args[1].id", "b"))
+
+			value, err := j.GetValue("args[1].id")
+			require.Nil(t, err)
+			assert.Equal(t, "b", value.String())
+			assert.Equal(t, `{"args":[1,{"id":"b"}]}`, string(j.Raw()))
+		})
+		t.Run("creates intermediate objects", func(t *testing.T) {
+			j, err := makeJsonJob(&Configuration{}, []byte(`{}`))
+			require.Nil(t, err)
+
+			require.Nil(t, j.SetValue("payload.meta.attempt", 2))
+			assert.Equal(t, `{"payload":{"meta":{"attempt":2}}}`, string(j.Raw()))
+		})
+		t.Run("nested numbers keep their exact value", func(t *testing.T) {
+			j, err := makeJsonJob(&Configuration{}, []byte(`{}`))
+			require.Nil(t, err)
+
+			require.Nil(t, j.SetValue("meta", map[string]interface{}{"ids": []int64{9007199254740993}}))
+			assert.Equal(t, `{"meta":{"ids":[9007199254740993]}}`, string(j.Raw()))
+		})
+		t.Run("unmodified job returns original bytes", func(t *testing.T) {
+			jsonSting := `{"name": "test",  "id": 1}`
+
+			j, err := makeJsonJob(&Configuration{}, []byte(jsonSting))
+			require.Nil(t, err)
+
+			assert.Equal(t, jsonSting, string(j.Raw()))
+		})
+	})
+	t.Run("failure", func(t *testing.T) {
+		t.Run("index out of range", func(t *testing.T) {
+			j, err := makeJsonJob(&Configuration{}, []byte(`{"args":[1]}`))
+			require.Nil(t, err)
+
+			assert.NotNil(t, j.SetValue("args[3]", 2))
+		})
+		t.Run("through a string", func(t *testing.T) {
+			j, err := makeJsonJob(&Configuration{}, []byte(`{"name":"test"}`))
+			require.Nil(t, err)
+
+			assert.NotNil(t, j.SetValue("name.first", "a"))
+		})
+	})
+}
+
+func TestJsonJob_Delete(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		j, err := makeJsonJob(&Configuration{}, []byte(`{"error_message":"boom","args":[1,2,3],"jid":"abc"}`))
+		require.Nil(t, err)
+
+		require.Nil(t, j.Delete("error_message"))
+		require.Nil(t, j.Delete("args[1]"))
+		require.Nil(t, j.Delete("missing.key"))
+
+		assert.Equal(t, `{"args":[1,3],"jid":"abc"}`, string(j.Raw()))
+	})
+}
+
+func TestJsonJob_Clone(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		j, err := makeJsonJob(&Configuration{}, []byte(`{"payload":{"id":1}}`))
+		require.Nil(t, err)
+
+		clone := j.Clone()
+		require.Nil(t, clone.SetValue("payload.id", 2))
+
+		assert.Equal(t, `{"payload":{"id":1}}`, string(j.Raw()))
+		assert.Equal(t, `{"payload":{"id":2}}`, string(clone.Raw()))
+		assert.Equal(t, string(j.Original()), string(clone.Original()))
+	})
+}
+
+func TestJsonJob_Replace(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		j, err := makeJsonJob(&Configuration{}, []byte(`{"payload":{"id":1}}`))
+		require.Nil(t, err)
+
+		clone := j.Clone()
+		require.Nil(t, clone.SetValue("payload.id", 2))
+		require.Nil(t, j.Replace(clone))
+
+		assert.Equal(t, `{"payload":{"id":2}}`, string(j.Raw()))
+		assert.Equal(t, `{"payload":{"id":1}}`, string(j.Original()))
+	})
+	t.Run("failure", func(t *testing.T) {
+		j, err := makeJsonJob(&Configuration{}, []byte(`{"payload":{"id":1}}`))
+		require.Nil(t, err)
+
+		assert.NotNil(t, j.Replace(NewRawJob([]byte(`{}`))))
+	})
+}
+
+func TestJsonJob_GetValue_errors(t *testing.T) {
+	jsonSting := `{"name":"test","error_message":null,"args":[1,2],"payload":{"test":"test"}}`
+
+	j, err := makeJsonJob(&Configuration{}, []byte(jsonSting))
+	require.Nil(t, err)
+
+	t.Run("null is not missing", func(t *testing.T) {
+		value, err := j.GetValue("error_message")
+		require.Nil(t, err)
+		assert.False(t, value.IsValid())
+	})
+	for key, expected := range map[string]error{
+		"missing":         ErrFieldNotFound,
+		"payload.missing": ErrFieldNotFound,
+		"name.first":      ErrNotAnObject,
+		"payload[0]":      ErrNotAnArray,
+		"args[2]":         ErrIndexOutOfRange,
+		"args[-3]":        ErrIndexOutOfRange,
+	} {
+		t.Run(key, func(t *testing.T) {
+			_, err := j.GetValue(key)
+			assert.ErrorIs(t, err, expected)
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package job
+
+import (
+	"bytes"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func makeMsgpackJob(config *Configuration, bts []byte) (*treeJob, error) {
+	var decoded interface{}
+	if err := msgpack.Unmarshal(bts, &decoded); err != nil {
+		return nil, err
+	}
+
+	return makeTreeJob(config, bts, decoded, encodeMsgpack)
+}
+
+// encodeMsgpack writes integers in the smallest type that holds them as most
+// producers do.
+func encodeMsgpack(tree map[string]interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{})
+	enc := msgpack.NewEncoder(buf)
+	enc.UseCompactInts(true)
+	enc.SetSortMapKeys(true)
+
+	if err := enc.Encode(denormaliseNumbers(tree)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
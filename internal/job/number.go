@@ -0,0 +1,151 @@
+package job
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+)
+
+var jsonNumberType = reflect.TypeOf(json.Number(""))
+
+// IsNumber reports whether val is a number read from a job. Json jobs hold
+// numbers as json.Number so they keep their exact value, the other formats
+// hold int64, uint64 and float64.
+func IsNumber(val reflect.Value) bool {
+	if !val.IsValid() {
+		return false
+	}
+
+	if val.Type() == jsonNumberType {
+		return true
+	}
+
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+
+	return false
+}
+
+// ToRat returns the exact value of a number read from a job.
+func ToRat(val reflect.Value) (*big.Rat, bool) {
+	if !IsNumber(val) {
+		return nil, false
+	}
+
+	if val.Type() == jsonNumberType {
+		return new(big.Rat).SetString(val.String())
+	}
+
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Rat).SetInt64(val.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Rat).SetUint64(val.Uint()), true
+	}
+
+	// floats are read as their shortest decimal so 0.1 is exactly 1/10
+	f := val.Float()
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, false
+	}
+
+	return new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+}
+
+// ToFloat64 returns a number read from a job as a float64, which may lose
+// precision for integers larger than 2^53.
+func ToFloat64(val reflect.Value) (float64, bool) {
+	if !IsNumber(val) {
+		return 0, false
+	}
+
+	if val.Type() == jsonNumberType {
+		f, err := strconv.ParseFloat(val.String(), 64)
+		return f, err == nil
+	}
+
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(val.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(val.Uint()), true
+	}
+
+	return val.Float(), true
+}
+
+// FormatNumber renders a number read from a job exactly, without exponents
+// for whole floats.
+func FormatNumber(val reflect.Value) string {
+	if val.Type() == jsonNumberType {
+		return val.String()
+	}
+
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(val.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(val.Uint(), 10)
+	}
+
+	return strconv.FormatFloat(val.Float(), 'f', -1, 64)
+}
+
+// toJsonNumber converts a number set on a json job into a json.Number so the
+// job holds a single number type. Values that aren't finite numbers are
+// returned unchanged.
+func toJsonNumber(value interface{}) interface{} {
+	val := reflect.ValueOf(value)
+	if !IsNumber(val) || val.Type() == jsonNumberType {
+		return value
+	}
+
+	if val.Kind() == reflect.Float32 || val.Kind() == reflect.Float64 {
+		if _, ok := ToRat(val); !ok {
+			return value
+		}
+	}
+
+	return json.Number(FormatNumber(val))
+}
+
+// toTreeNumber converts a number set on or decoded into a tree job into an
+// int64, or a uint64 above math.MaxInt64, so integers keep their exact value,
+// and other numbers into a float64.
+func toTreeNumber(value interface{}) interface{} {
+	if number, ok := value.(json.Number); ok {
+		if i, err := number.Int64(); err == nil {
+			return i
+		}
+
+		if u, err := strconv.ParseUint(number.String(), 10, 64); err == nil {
+			return u
+		}
+
+		f, _ := ToFloat64(reflect.ValueOf(number))
+
+		return f
+	}
+
+	val := reflect.ValueOf(value)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if val.Uint() > math.MaxInt64 {
+			return val.Uint()
+		}
+
+		return int64(val.Uint())
+	case reflect.Float32, reflect.Float64:
+		return val.Float()
+	}
+
+	return value
+}
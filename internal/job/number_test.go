@@ -0,0 +1,64 @@
+package job
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"reflect"
+	"testing"
+)
+
+func TestJsonJob_numbers(t *testing.T) {
+	jsonSting := `{"id":12345678901234567891,"amount":0.1,"small":1e2,"retry_count":3}`
+
+	j, err := makeJsonJob(&Configuration{}, []byte(jsonSting))
+	require.Nil(t, err)
+
+	t.Run("success", func(t *testing.T) {
+		t.Run("big integers are exact", func(t *testing.T) {
+			value, err := j.GetValue("id")
+			require.Nil(t, err)
+			assert.Equal(t, json.Number("12345678901234567891"), value.Interface())
+			assert.Equal(t, "12345678901234567891", FormatNumber(value))
+
+			rat, ok := ToRat(value)
+			require.True(t, ok)
+			assert.Equal(t, "12345678901234567891", rat.RatString())
+		})
+		t.Run("decimals are exact", func(t *testing.T) {
+			value, err := j.GetValue("amount")
+			require.Nil(t, err)
+
+			rat, ok := ToRat(value)
+			require.True(t, ok)
+			assert.Equal(t, "1/10", rat.RatString())
+		})
+		t.Run("set values are json numbers", func(t *testing.T) {
+			clone := j.Clone()
+			require.Nil(t, clone.SetValue("retry_count", 4))
+			require.Nil(t, clone.SetValue("amount", 2.5))
+
+			value, err := clone.GetValue("retry_count")
+			require.Nil(t, err)
+			assert.Equal(t, json.Number("4"), value.Interface())
+			assert.Equal(t, `{"id":12345678901234567891,"amount":2.5,"small":1e2,"retry_count":4}`, string(clone.Raw()))
+		})
+		t.Run("formats", func(t *testing.T) {
+			assert.Equal(t, "1000000000000000000000", FormatNumber(reflect.ValueOf(1e21)))
+			assert.Equal(t, "0.5", FormatNumber(reflect.ValueOf(0.5)))
+			assert.Equal(t, "-3", FormatNumber(reflect.ValueOf(int8(-3))))
+		})
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		t.Run("strings are not numbers", func(t *testing.T) {
+			assert.False(t, IsNumber(reflect.ValueOf("12")))
+			_, ok := ToFloat64(reflect.ValueOf("12"))
+			assert.False(t, ok)
+		})
+		t.Run("trailing data", func(t *testing.T) {
+			_, err := makeJsonJob(&Configuration{}, []byte(`{"id":1} {"id":2}`))
+			assert.NotNil(t, err)
+		})
+	})
+}
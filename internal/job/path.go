@@ -0,0 +1,465 @@
+package job
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type PathPartType int
+
+const (
+	KeyPart PathPartType = iota
+	IndexPart
+	WildcardPart
+)
+
+// PathPart is one step of a Path, an object key, an array index or a
+// wildcard matching every element of an array or value of an object.
+type PathPart struct {
+	Type  PathPartType
+	Key   string
+	Index int
+}
+
+// Path is a parsed path into a job. It is the single syntax used for job
+// fields by conditionals, executors and transforms:
+//
+//	retry_count            a top level key
+//	payload.items          nested keys
+//	args[0].id             array indexes, which can be chained
+//	args[-1]               negative indexes count back from the end
+//	payload["a.b"]         quoted keys may contain dots or brackets
+//	"a.b".c                quoted keys can also be dotted
+//	args[*].id             wildcards match every element
+type Path []PathPart
+
+func ParsePath(path string) (Path, error) {
+	if path == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+
+	parts := make(Path, 0)
+	expectKey := true
+	for i := 0; i < len(path); {
+		switch c := path[i]; {
+		case c == '.':
+			if expectKey {
+				return nil, fmt.Errorf("empty key at %d in path %q", i, path)
+			}
+
+			expectKey = true
+			i++
+		case c == '[':
+			end, part, err := parseBracket(path, i)
+			if err != nil {
+				return nil, err
+			}
+
+			parts = append(parts, part)
+			expectKey = false
+			i = end
+		case c == '"' || c == '\'':
+			if !expectKey {
+				return nil, fmt.Errorf("unexpected quote at %d in path %q", i, path)
+			}
+
+			end, key, err := parseQuoted(path, i)
+			if err != nil {
+				return nil, err
+			}
+
+			parts = append(parts, PathPart{Type: KeyPart, Key: key})
+			expectKey = false
+			i = end
+		default:
+			if !expectKey {
+				return nil, fmt.Errorf("unexpected %q at %d in path %q", c, i, path)
+			}
+
+			end := i
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+
+			parts = append(parts, PathPart{Type: KeyPart, Key: path[i:end]})
+			expectKey = false
+			i = end
+		}
+	}
+
+	if expectKey {
+		return nil, fmt.Errorf("path %q ends with a dot", path)
+	}
+
+	return parts, nil
+}
+
+// MustParsePath is ParsePath for paths known to be valid.
+func MustParsePath(path string) Path {
+	p, err := ParsePath(path)
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
+// parseBracket parses the bracketed part starting at path[start] and returns
+// the index after the closing bracket.
+func parseBracket(path string, start int) (int, PathPart, error) {
+	i := start + 1
+	if i < len(path) && (path[i] == '"' || path[i] == '\'') {
+		end, key, err := parseQuoted(path, i)
+		if err != nil {
+			return 0, PathPart{}, err
+		}
+
+		if end >= len(path) || path[end] != ']' {
+			return 0, PathPart{}, fmt.Errorf("unclosed bracket at %d in path %q", start, path)
+		}
+
+		return end + 1, PathPart{Type: KeyPart, Key: key}, nil
+	}
+
+	end := strings.IndexByte(path[i:], ']')
+	if end < 0 {
+		return 0, PathPart{}, fmt.Errorf("unclosed bracket at %d in path %q", start, path)
+	}
+
+	content := path[i : i+end]
+	if content == "*" {
+		return i + end + 1, PathPart{Type: WildcardPart}, nil
+	}
+
+	idx, err := strconv.Atoi(content)
+	if err != nil {
+		return 0, PathPart{}, fmt.Errorf("invalid index %q in path %q", content, path)
+	}
+
+	return i + end + 1, PathPart{Type: IndexPart, Index: idx}, nil
+}
+
+// parseQuoted parses the quoted key starting at path[start] and returns the
+// index after the closing quote. A backslash escapes the next character.
+func parseQuoted(path string, start int) (int, string, error) {
+	quote := path[start]
+	key := strings.Builder{}
+	for i := start + 1; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			if i+1 < len(path) {
+				i++
+				key.WriteByte(path[i])
+			}
+		case quote:
+			return i + 1, key.String(), nil
+		default:
+			key.WriteByte(path[i])
+		}
+	}
+
+	return 0, "", fmt.Errorf("unclosed quote at %d in path %q", start, path)
+}
+
+func (p Path) HasWildcard() bool {
+	for _, part := range p {
+		if part.Type == WildcardPart {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p Path) String() string {
+	b := strings.Builder{}
+	for idx, part := range p {
+		switch part.Type {
+		case KeyPart:
+			if strings.ContainsAny(part.Key, `.[]"'`) || part.Key == "" {
+				b.WriteString(`["` + strings.ReplaceAll(part.Key, `"`, `\"`) + `"]`)
+				continue
+			}
+
+			if idx > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(part.Key)
+		case IndexPart:
+			b.WriteString("[" + strconv.Itoa(part.Index) + "]")
+		case WildcardPart:
+			b.WriteString("[*]")
+		}
+	}
+
+	return b.String()
+}
+
+// Lookup returns the single value at the path below root. Missing keys,
+// indexes past the end of an array and reading through a value of the wrong
+// type return ErrFieldNotFound, ErrIndexOutOfRange, ErrNotAnObject or
+// ErrNotAnArray. Use Get for paths with wildcards.
+func (p Path) Lookup(root interface{}) (interface{}, error) {
+	current := root
+	for idx, part := range p {
+		switch part.Type {
+		case KeyPart:
+			m, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrNotAnObject, p.describe(idx))
+			}
+
+			current, ok = m[part.Key]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrFieldNotFound, p[:idx+1])
+			}
+		case IndexPart:
+			slice, ok := current.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrNotAnArray, p.describe(idx))
+			}
+
+			i, ok := resolveIndex(part.Index, len(slice))
+			if !ok {
+				return nil, fmt.Errorf("%w: %s has %d elements", ErrIndexOutOfRange, p[:idx+1], len(slice))
+			}
+
+			current = slice[i]
+		case WildcardPart:
+			return nil, fmt.Errorf("cannot look up a single value for %s", p)
+		}
+	}
+
+	return current, nil
+}
+
+// describe names the value part idx of the path is read from.
+func (p Path) describe(idx int) string {
+	if idx == 0 {
+		return "the job"
+	}
+
+	return p[:idx].String()
+}
+
+// Get returns every value in root matched by the path. root is a tree of
+// map[string]interface{} and []interface{} as produced by decoding a job.
+func (p Path) Get(root interface{}) []interface{} {
+	current := []interface{}{root}
+	for _, part := range p {
+		next := make([]interface{}, 0, len(current))
+		for _, value := range current {
+			next = append(next, getPart(value, part)...)
+		}
+
+		current = next
+	}
+
+	return current
+}
+
+func getPart(value interface{}, part PathPart) []interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		switch part.Type {
+		case KeyPart:
+			if child, ok := v[part.Key]; ok {
+				return []interface{}{child}
+			}
+		case WildcardPart:
+			values := make([]interface{}, 0, len(v))
+			for _, key := range sortedKeys(v) {
+				values = append(values, v[key])
+			}
+
+			return values
+		}
+	case []interface{}:
+		switch part.Type {
+		case IndexPart:
+			if idx, ok := resolveIndex(part.Index, len(v)); ok {
+				return []interface{}{v[idx]}
+			}
+		case WildcardPart:
+			return append([]interface{}{}, v...)
+		}
+	}
+
+	return nil
+}
+
+// Set sets value at the path below root, creating missing objects on the
+// way, and returns the updated root. A wildcard sets every matching value.
+func (p Path) Set(root interface{}, value interface{}) (interface{}, error) {
+	return setPath(root, p, value)
+}
+
+func setPath(container interface{}, parts Path, value interface{}) (interface{}, error) {
+	part := parts[0]
+	switch c := container.(type) {
+	case map[string]interface{}:
+		keys := []string{part.Key}
+		switch part.Type {
+		case IndexPart:
+			return nil, fmt.Errorf("cannot index an object with [%d]", part.Index)
+		case WildcardPart:
+			keys = sortedKeys(c)
+		}
+
+		for _, key := range keys {
+			if len(parts) == 1 {
+				c[key] = value
+				continue
+			}
+
+			child, ok := c[key]
+			if !ok || child == nil {
+				if parts[1].Type != KeyPart {
+					return nil, fmt.Errorf("%s does not exist", key)
+				}
+
+				child = map[string]interface{}{}
+			}
+
+			updated, err := setPath(child, parts[1:], value)
+			if err != nil {
+				return nil, err
+			}
+
+			c[key] = updated
+		}
+
+		return c, nil
+	case []interface{}:
+		indexes := make([]int, 0, len(c))
+		switch part.Type {
+		case KeyPart:
+			return nil, fmt.Errorf("cannot read key %s from an array", part.Key)
+		case IndexPart:
+			idx, ok := resolveIndex(part.Index, len(c))
+			if !ok {
+				return nil, fmt.Errorf("index %d out of range", part.Index)
+			}
+
+			indexes = append(indexes, idx)
+		case WildcardPart:
+			for idx := range c {
+				indexes = append(indexes, idx)
+			}
+		}
+
+		for _, idx := range indexes {
+			if len(parts) == 1 {
+				c[idx] = value
+				continue
+			}
+
+			updated, err := setPath(c[idx], parts[1:], value)
+			if err != nil {
+				return nil, err
+			}
+
+			c[idx] = updated
+		}
+
+		return c, nil
+	}
+
+	return nil, fmt.Errorf("cannot set a field on a %T", container)
+}
+
+// Delete removes the values at the path below root and returns the updated
+// root and whether anything was removed.
+func (p Path) Delete(root interface{}) (interface{}, bool) {
+	return deletePath(root, p)
+}
+
+func deletePath(container interface{}, parts Path) (interface{}, bool) {
+	part := parts[0]
+	switch c := container.(type) {
+	case map[string]interface{}:
+		keys := []string{part.Key}
+		switch part.Type {
+		case IndexPart:
+			return c, false
+		case WildcardPart:
+			keys = sortedKeys(c)
+		}
+
+		deleted := false
+		for _, key := range keys {
+			child, ok := c[key]
+			if !ok {
+				continue
+			}
+
+			if len(parts) == 1 {
+				delete(c, key)
+				deleted = true
+				continue
+			}
+
+			updated, childDeleted := deletePath(child, parts[1:])
+			c[key] = updated
+			deleted = deleted || childDeleted
+		}
+
+		return c, deleted
+	case []interface{}:
+		switch part.Type {
+		case KeyPart:
+			return c, false
+		case WildcardPart:
+			if len(parts) == 1 {
+				return []interface{}{}, len(c) > 0
+			}
+
+			deleted := false
+			for idx := range c {
+				updated, childDeleted := deletePath(c[idx], parts[1:])
+				c[idx] = updated
+				deleted = deleted || childDeleted
+			}
+
+			return c, deleted
+		}
+
+		idx, ok := resolveIndex(part.Index, len(c))
+		if !ok {
+			return c, false
+		}
+
+		if len(parts) == 1 {
+			return append(c[:idx:idx], c[idx+1:]...), true
+		}
+
+		updated, deleted := deletePath(c[idx], parts[1:])
+		c[idx] = updated
+
+		return c, deleted
+	}
+
+	return container, false
+}
+
+// resolveIndex turns a possibly negative index into a position in a slice of
+// length n.
+func resolveIndex(idx int, n int) (int, bool) {
+	if idx < 0 {
+		idx += n
+	}
+
+	return idx, idx >= 0 && idx < n
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
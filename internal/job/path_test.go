@@ -0,0 +1,99 @@
+package job
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		for path, expected := range map[string]Path{
+			"retry_count": {{Type: KeyPart, Key: "retry_count"}},
+			"payload.items[2]": {
+				{Type: KeyPart, Key: "payload"},
+				{Type: KeyPart, Key: "items"},
+				{Type: IndexPart, Index: 2},
+			},
+			"args[0].id": {
+				{Type: KeyPart, Key: "args"},
+				{Type: IndexPart, Index: 0},
+				{Type: KeyPart, Key: "id"},
+			},
+			"args[-1]": {
+				{Type: KeyPart, Key: "args"},
+				{Type: IndexPart, Index: -1},
+			},
+			`payload["a.b"].c`: {
+				{Type: KeyPart, Key: "payload"},
+				{Type: KeyPart, Key: "a.b"},
+				{Type: KeyPart, Key: "c"},
+			},
+			`"a.b".c`: {
+				{Type: KeyPart, Key: "a.b"},
+				{Type: KeyPart, Key: "c"},
+			},
+			"args[*].id": {
+				{Type: KeyPart, Key: "args"},
+				{Type: WildcardPart},
+				{Type: KeyPart, Key: "id"},
+			},
+			"matrix[1][0]": {
+				{Type: KeyPart, Key: "matrix"},
+				{Type: IndexPart, Index: 1},
+				{Type: IndexPart, Index: 0},
+			},
+		} {
+			t.Run(path, func(t *testing.T) {
+				parsed, err := ParsePath(path)
+				require.Nil(t, err)
+				assert.Equal(t, expected, parsed)
+
+				reparsed, err := ParsePath(parsed.String())
+				require.Nil(t, err)
+				assert.Equal(t, parsed, reparsed)
+			})
+		}
+	})
+	t.Run("failure", func(t *testing.T) {
+		for _, path := range []string{"", "a..b", "a.", ".a", "a[", "a[x]", `a["b`, "a[0]b", `a."b`} {
+			t.Run(path, func(t *testing.T) {
+				_, err := ParsePath(path)
+				assert.NotNil(t, err)
+			})
+		}
+	})
+}
+
+func TestJsonJob_GetValues(t *testing.T) {
+	jsonSting := `{"args":[{"id":1},{"id":2},{"name":"x"}],"payload":{"a.b":{"c":"dotted"}}}`
+
+	j, err := makeJsonJob(&Configuration{}, []byte(jsonSting))
+	require.Nil(t, err)
+
+	t.Run("wildcard", func(t *testing.T) {
+		values, err := j.GetValues("args[*].id")
+		require.Nil(t, err)
+		require.Len(t, values, 2)
+		assert.Equal(t, "1", values[0].String())
+		assert.Equal(t, "2", values[1].String())
+	})
+	t.Run("negative index", func(t *testing.T) {
+		value, err := j.GetValue("args[-1].name")
+		require.Nil(t, err)
+		assert.Equal(t, "x", value.String())
+	})
+	t.Run("quoted key", func(t *testing.T) {
+		value, err := j.GetValue(`payload["a.b"].c`)
+		require.Nil(t, err)
+		assert.Equal(t, "dotted", value.String())
+	})
+	t.Run("wildcard set", func(t *testing.T) {
+		clone := j.Clone()
+		require.Nil(t, clone.SetValue("args[*].seen", true))
+
+		values, err := clone.GetValues("args[*].seen")
+		require.Nil(t, err)
+		assert.Len(t, values, 3)
+	})
+}
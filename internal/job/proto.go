@@ -0,0 +1,675 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+)
+
+// protoJob is a job encoded as a protobuf message. Fields are addressed by
+// their proto names and read through a tree built from the message, where
+// integers are int64 or uint64 so they keep their exact value, floats are
+// float64 and enums are their value names. Changes are applied to the message itself so fields that are not
+// touched keep their exact values when the job is re-encoded.
+type protoJob struct {
+	config *Configuration
+	bytes  []byte
+	msg    protoreflect.Message
+	tree   map[string]interface{}
+
+	raw   []byte
+	dirty bool
+}
+
+// loadMessageType reads the message type named in config from its
+// descriptor set, a FileDescriptorSet as written by
+// protoc --include_imports --descriptor_set_out.
+func loadMessageType(config *Configuration) (protoreflect.MessageType, error) {
+	if config.DescriptorSet == "" || config.MessageType == "" {
+		return nil, errors.New("proto jobs require a descriptorSet and messageType")
+	}
+
+	bts, err := os.ReadFile(config.DescriptorSet)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(bts, set); err != nil {
+		return nil, fmt.Errorf("could not read descriptor set %s: %w", config.DescriptorSet, err)
+	}
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("could not read descriptor set %s: %w", config.DescriptorSet, err)
+	}
+
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(config.MessageType))
+	if err != nil {
+		return nil, fmt.Errorf("could not find message %s: %w", config.MessageType, err)
+	}
+
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", config.MessageType)
+	}
+
+	return dynamicpb.NewMessageType(md), nil
+}
+
+func makeProtoJob(config *Configuration, messageType protoreflect.MessageType, bts []byte) (*protoJob, error) {
+	msg := messageType.New()
+	if err := proto.Unmarshal(bts, msg.Interface()); err != nil {
+		return nil, err
+	}
+
+	return &protoJob{
+		config: config,
+		bytes:  bts,
+		msg:    msg,
+		tree:   messageToTree(msg),
+	}, nil
+}
+
+func (p *protoJob) GetValue(key string) (reflect.Value, error) {
+	return getTreeValue(p.tree, key)
+}
+
+func (p *protoJob) GetValues(key string) ([]reflect.Value, error) {
+	return getTreeValues(p.tree, key)
+}
+
+func (p *protoJob) SetValue(key string, value interface{}) error {
+	path, err := ParsePath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := setMessagePath(p.msg, path, value); err != nil {
+		return fmt.Errorf("could not set %s: %w", key, err)
+	}
+
+	p.tree = messageToTree(p.msg)
+	p.dirty = true
+
+	return nil
+}
+
+func (p *protoJob) Delete(key string) error {
+	path, err := ParsePath(key)
+	if err != nil {
+		return err
+	}
+
+	if deleteMessagePath(p.msg, path) {
+		p.tree = messageToTree(p.msg)
+		p.dirty = true
+	}
+
+	return nil
+}
+
+func (p *protoJob) Raw() []byte {
+	if p.dirty {
+		bts, err := proto.MarshalOptions{Deterministic: true}.Marshal(p.msg.Interface())
+		if err == nil {
+			p.raw = bts
+			p.dirty = false
+		}
+	}
+
+	if p.raw != nil {
+		return p.raw
+	}
+
+	return p.bytes
+}
+
+func (p *protoJob) Original() []byte {
+	return p.bytes
+}
+
+func (p *protoJob) Clone() Data {
+	msg := proto.Clone(p.msg.Interface()).ProtoReflect()
+
+	return &protoJob{
+		config: p.config,
+		bytes:  p.bytes,
+		msg:    msg,
+		tree:   messageToTree(msg),
+		dirty:  p.dirty || p.raw != nil,
+	}
+}
+
+func (p *protoJob) Replace(clone Data) error {
+	c, ok := clone.(*protoJob)
+	if !ok {
+		return fmt.Errorf("can't replace a proto job with %T", clone)
+	}
+
+	*p = *c
+
+	return nil
+}
+
+func (p *protoJob) validate() error {
+	return validate(p.config, p)
+}
+
+func messageToTree(m protoreflect.Message) map[string]interface{} {
+	tree := map[string]interface{}{}
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		// unset messages, optional fields and oneofs are missing rather than
+		// zero, everything else reads as its default
+		if fd.HasPresence() && !m.Has(fd) {
+			continue
+		}
+
+		tree[string(fd.Name())] = fieldToTree(fd, m.Get(fd))
+	}
+
+	return tree
+}
+
+func fieldToTree(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		slice := make([]interface{}, list.Len())
+		for i := range slice {
+			slice[i] = singularToTree(fd, list.Get(i))
+		}
+
+		return slice
+	case fd.IsMap():
+		m := map[string]interface{}{}
+		v.Map().Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+			m[key.String()] = singularToTree(fd.MapValue(), value)
+			return true
+		})
+
+		return m
+	}
+
+	return singularToTree(fd, v)
+}
+
+func singularToTree(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return v.Bool()
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return v.Int()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return v.Uint()
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float()
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BytesKind:
+		return string(v.Bytes())
+	case protoreflect.EnumKind:
+		if value := fd.Enum().Values().ByNumber(v.Enum()); value != nil {
+			return string(value.Name())
+		}
+
+		return int64(v.Enum())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return messageToTree(v.Message())
+	}
+
+	return nil
+}
+
+func setMessagePath(m protoreflect.Message, path Path, value interface{}) error {
+	part := path[0]
+	if part.Type != KeyPart {
+		return fmt.Errorf("%w: %s", ErrNotAnArray, m.Descriptor().FullName())
+	}
+
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(part.Key))
+	if fd == nil {
+		return fmt.Errorf("%s has no field %s", m.Descriptor().FullName(), part.Key)
+	}
+
+	if len(path) == 1 {
+		v, err := fieldFromTree(fd, m.NewField(fd), value)
+		if err != nil {
+			return err
+		}
+
+		m.Set(fd, v)
+
+		return nil
+	}
+
+	switch {
+	case fd.IsList():
+		return setListPath(fd, m.Mutable(fd).List(), path[1:], value)
+	case fd.IsMap():
+		return setMapPath(fd, m.Mutable(fd).Map(), path[1:], value)
+	case fd.Message() != nil:
+		return setMessagePath(m.Mutable(fd).Message(), path[1:], value)
+	}
+
+	return fmt.Errorf("%w: %s", ErrNotAnObject, fd.Name())
+}
+
+func setListPath(fd protoreflect.FieldDescriptor, list protoreflect.List, path Path, value interface{}) error {
+	indexes, err := listIndexes(list, path[0])
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range indexes {
+		if len(path) == 1 {
+			v, err := singularFromTree(fd, list.NewElement, value)
+			if err != nil {
+				return err
+			}
+
+			list.Set(idx, v)
+			continue
+		}
+
+		if fd.Message() == nil {
+			return fmt.Errorf("%w: %s[%d]", ErrNotAnObject, fd.Name(), idx)
+		}
+
+		msg := list.Get(idx).Message()
+		if err := setMessagePath(msg, path[1:], value); err != nil {
+			return err
+		}
+
+		list.Set(idx, protoreflect.ValueOfMessage(msg))
+	}
+
+	return nil
+}
+
+func setMapPath(fd protoreflect.FieldDescriptor, m protoreflect.Map, path Path, value interface{}) error {
+	keys, err := mapKeys(fd, m, path[0])
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if len(path) == 1 {
+			v, err := singularFromTree(fd.MapValue(), m.NewValue, value)
+			if err != nil {
+				return err
+			}
+
+			m.Set(key, v)
+			continue
+		}
+
+		if fd.MapValue().Message() == nil {
+			return fmt.Errorf("%w: %s[%s]", ErrNotAnObject, fd.Name(), key.String())
+		}
+
+		if err := setMessagePath(m.Mutable(key).Message(), path[1:], value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func deleteMessagePath(m protoreflect.Message, path Path) bool {
+	part := path[0]
+	if part.Type != KeyPart {
+		return false
+	}
+
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(part.Key))
+	if fd == nil || (!m.Has(fd) && len(path) > 1) {
+		return false
+	}
+
+	if len(path) == 1 {
+		deleted := m.Has(fd)
+		m.Clear(fd)
+
+		return deleted
+	}
+
+	switch {
+	case fd.IsList():
+		list := m.Mutable(fd).List()
+		indexes, err := listIndexes(list, path[1])
+		if err != nil {
+			return false
+		}
+
+		if len(path) == 2 {
+			// remove from the end so earlier indexes stay valid
+			for i := len(indexes) - 1; i >= 0; i-- {
+				for j := indexes[i]; j < list.Len()-1; j++ {
+					list.Set(j, list.Get(j+1))
+				}
+				list.Truncate(list.Len() - 1)
+			}
+
+			return len(indexes) > 0
+		}
+
+		deleted := false
+		for _, idx := range indexes {
+			if fd.Message() == nil {
+				continue
+			}
+
+			msg := list.Get(idx).Message()
+			deleted = deleteMessagePath(msg, path[2:]) || deleted
+			list.Set(idx, protoreflect.ValueOfMessage(msg))
+		}
+
+		return deleted
+	case fd.IsMap():
+		mp := m.Mutable(fd).Map()
+		keys, err := mapKeys(fd, mp, path[1])
+		if err != nil {
+			return false
+		}
+
+		deleted := false
+		for _, key := range keys {
+			if !mp.Has(key) {
+				continue
+			}
+
+			if len(path) == 2 {
+				mp.Clear(key)
+				deleted = true
+				continue
+			}
+
+			if fd.MapValue().Message() != nil {
+				deleted = deleteMessagePath(mp.Mutable(key).Message(), path[2:]) || deleted
+			}
+		}
+
+		return deleted
+	case fd.Message() != nil:
+		return deleteMessagePath(m.Mutable(fd).Message(), path[1:])
+	}
+
+	return false
+}
+
+func listIndexes(list protoreflect.List, part PathPart) ([]int, error) {
+	switch part.Type {
+	case IndexPart:
+		idx, ok := resolveIndex(part.Index, list.Len())
+		if !ok {
+			return nil, fmt.Errorf("%w: [%d]", ErrIndexOutOfRange, part.Index)
+		}
+
+		return []int{idx}, nil
+	case WildcardPart:
+		indexes := make([]int, list.Len())
+		for i := range indexes {
+			indexes[i] = i
+		}
+
+		return indexes, nil
+	}
+
+	return nil, fmt.Errorf("%w: cannot read key %s", ErrNotAnObject, part.Key)
+}
+
+func mapKeys(fd protoreflect.FieldDescriptor, m protoreflect.Map, part PathPart) ([]protoreflect.MapKey, error) {
+	switch part.Type {
+	case KeyPart:
+		key, err := singularFromTree(fd.MapKey(), nil, part.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		return []protoreflect.MapKey{key.MapKey()}, nil
+	case WildcardPart:
+		keys := make([]protoreflect.MapKey, 0, m.Len())
+		m.Range(func(key protoreflect.MapKey, _ protoreflect.Value) bool {
+			keys = append(keys, key)
+			return true
+		})
+
+		return keys, nil
+	}
+
+	return nil, fmt.Errorf("%w: cannot index map %s", ErrNotAnArray, fd.Name())
+}
+
+// fieldFromTree converts a tree value into the value of field fd. empty is a
+// new value of the field for lists, maps and messages to be filled in.
+func fieldFromTree(fd protoreflect.FieldDescriptor, empty protoreflect.Value, value interface{}) (protoreflect.Value, error) {
+	switch {
+	case fd.IsList():
+		slice, ok := value.([]interface{})
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("%s is repeated, got %T", fd.Name(), value)
+		}
+
+		list := empty.List()
+		for _, elem := range slice {
+			v, err := singularFromTree(fd, list.NewElement, elem)
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+
+			list.Append(v)
+		}
+
+		return empty, nil
+	case fd.IsMap():
+		tree, ok := value.(map[string]interface{})
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("%s is a map, got %T", fd.Name(), value)
+		}
+
+		m := empty.Map()
+		for key, elem := range tree {
+			k, err := singularFromTree(fd.MapKey(), nil, key)
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+
+			v, err := singularFromTree(fd.MapValue(), m.NewValue, elem)
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+
+			m.Set(k.MapKey(), v)
+		}
+
+		return empty, nil
+	}
+
+	return singularFromTree(fd, func() protoreflect.Value { return empty }, value)
+}
+
+// singularFromTree converts a tree value into a single value of kind fd.
+// newMessage returns an empty message when fd is a message.
+func singularFromTree(fd protoreflect.FieldDescriptor, newMessage func() protoreflect.Value, value interface{}) (protoreflect.Value, error) {
+	mismatch := fmt.Errorf("%s is %s, got %T", fd.Name(), fd.Kind(), value)
+	outOfRange := fmt.Errorf("%s is %s, %v is out of range", fd.Name(), fd.Kind(), value)
+
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		b, ok := value.(bool)
+		if !ok {
+			return protoreflect.Value{}, mismatch
+		}
+
+		return protoreflect.ValueOfBool(b), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		i, ok := toInt64(value)
+		if !ok {
+			return protoreflect.Value{}, mismatch
+		}
+
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return protoreflect.Value{}, outOfRange
+		}
+
+		return protoreflect.ValueOfInt32(int32(i)), nil
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		i, ok := toInt64(value)
+		if !ok {
+			return protoreflect.Value{}, mismatch
+		}
+
+		return protoreflect.ValueOfInt64(i), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		i, ok := toUint64(value)
+		if !ok {
+			return protoreflect.Value{}, mismatch
+		}
+
+		if i > math.MaxUint32 {
+			return protoreflect.Value{}, outOfRange
+		}
+
+		return protoreflect.ValueOfUint32(uint32(i)), nil
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		i, ok := toUint64(value)
+		if !ok {
+			return protoreflect.Value{}, mismatch
+		}
+
+		return protoreflect.ValueOfUint64(i), nil
+	case protoreflect.FloatKind:
+		f, ok := toFloat64(value)
+		if !ok {
+			return protoreflect.Value{}, mismatch
+		}
+
+		return protoreflect.ValueOfFloat32(float32(f)), nil
+	case protoreflect.DoubleKind:
+		f, ok := toFloat64(value)
+		if !ok {
+			return protoreflect.Value{}, mismatch
+		}
+
+		return protoreflect.ValueOfFloat64(f), nil
+	case protoreflect.StringKind:
+		s, ok := value.(string)
+		if !ok {
+			return protoreflect.Value{}, mismatch
+		}
+
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		switch b := value.(type) {
+		case string:
+			return protoreflect.ValueOfBytes([]byte(b)), nil
+		case []byte:
+			return protoreflect.ValueOfBytes(b), nil
+		}
+
+		return protoreflect.Value{}, mismatch
+	case protoreflect.EnumKind:
+		if name, ok := value.(string); ok {
+			enumValue := fd.Enum().Values().ByName(protoreflect.Name(name))
+			if enumValue == nil {
+				return protoreflect.Value{}, fmt.Errorf("%s has no value %s", fd.Enum().FullName(), name)
+			}
+
+			return protoreflect.ValueOfEnum(enumValue.Number()), nil
+		}
+
+		i, ok := toInt64(value)
+		if !ok {
+			return protoreflect.Value{}, mismatch
+		}
+
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return protoreflect.Value{}, outOfRange
+		}
+
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(i)), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		tree, ok := value.(map[string]interface{})
+		if !ok {
+			return protoreflect.Value{}, mismatch
+		}
+
+		msg := newMessage()
+		for key, elem := range tree {
+			if err := setMessagePath(msg.Message(), Path{{Type: KeyPart, Key: key}}, elem); err != nil {
+				return protoreflect.Value{}, err
+			}
+		}
+
+		return msg, nil
+	}
+
+	return protoreflect.Value{}, mismatch
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		return i, err == nil
+	case json.Number:
+		i, err := v.Int64()
+		return i, err == nil
+	}
+
+	val := reflect.ValueOf(value)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(val.Uint()), val.Uint() <= math.MaxInt64
+	case reflect.Float32, reflect.Float64:
+		f := val.Float()
+		return int64(f), f >= math.MinInt64 && f < math.MaxInt64 && f == float64(int64(f))
+	}
+
+	return 0, false
+}
+
+// toUint64 is toInt64 for unsigned fields, which can hold numbers above
+// math.MaxInt64 but none below zero.
+func toUint64(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case string:
+		i, err := strconv.ParseUint(v, 10, 64)
+		return i, err == nil
+	case json.Number:
+		i, err := strconv.ParseUint(v.String(), 10, 64)
+		return i, err == nil
+	}
+
+	val := reflect.ValueOf(value)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(val.Int()), val.Int() >= 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return val.Uint(), true
+	case reflect.Float32, reflect.Float64:
+		f := val.Float()
+		return uint64(f), f >= 0 && f < math.MaxUint64 && f == float64(uint64(f))
+	}
+
+	return 0, false
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	return ToFloat64(reflect.ValueOf(value))
+}
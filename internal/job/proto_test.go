@@ -0,0 +1,268 @@
+package job
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testDescriptor describes
+//
+//	message Job {
+//	  string class = 1;
+//	  repeated Arg args = 2;
+//	  int64 retry_count = 3;
+//	  map<string, string> tags = 4;
+//	  State state = 5;
+//	  Arg meta = 6;
+//	  int32 attempts = 7;
+//	  uint32 size = 8;
+//	  uint64 offset = 9;
+//	}
+//	message Arg { int64 id = 1; string name = 2; }
+//	enum State { NEW = 0; RETRY = 1; }
+func testDescriptor() *descriptorpb.FileDescriptorProto {
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	field := func(name string, number int32, label *descriptorpb.FieldDescriptorProto_Label, fieldType descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    label,
+			Type:     fieldType.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+
+		return f
+	}
+
+	return &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("job.proto"),
+		Package: proto.String("goqueue.test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Job"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("class", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("args", 2, repeated, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".goqueue.test.Arg"),
+					field("retry_count", 3, optional, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+					field("tags", 4, repeated, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".goqueue.test.Job.TagsEntry"),
+					field("state", 5, optional, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".goqueue.test.State"),
+					field("meta", 6, optional, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".goqueue.test.Arg"),
+					field("attempts", 7, optional, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+					field("size", 8, optional, descriptorpb.FieldDescriptorProto_TYPE_UINT32, ""),
+					field("offset", 9, optional, descriptorpb.FieldDescriptorProto_TYPE_UINT64, ""),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{
+					{
+						Name: proto.String("TagsEntry"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							field("key", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+							field("value", 2, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+						},
+						Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+					},
+				},
+			},
+			{
+				Name: proto.String("Arg"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+					field("name", 2, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				},
+			},
+		},
+		EnumType: []*descriptorpb.EnumDescriptorProto{
+			{
+				Name: proto.String("State"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{
+					{Name: proto.String("NEW"), Number: proto.Int32(0)},
+					{Name: proto.String("RETRY"), Number: proto.Int32(1)},
+				},
+			},
+		},
+	}
+}
+
+// writeTestDescriptorSet writes the test descriptor set and returns a builder
+// for goqueue.test.Job and an encoded job.
+func writeTestDescriptorSet(t *testing.T) (*Builder, []byte) {
+	t.Helper()
+
+	fileDescriptor := testDescriptor()
+	bts, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{fileDescriptor}})
+	require.Nil(t, err)
+
+	descriptorSet := filepath.Join(t.TempDir(), "job.pb")
+	require.Nil(t, os.WriteFile(descriptorSet, bts, 0o600))
+
+	file, err := protodesc.NewFile(fileDescriptor, nil)
+	require.Nil(t, err)
+
+	md := file.Messages().ByName("Job")
+	msg := dynamicpb.NewMessage(md)
+	msg.Set(md.Fields().ByName("class"), protoreflect.ValueOfString("WebhookWorker"))
+	msg.Set(md.Fields().ByName("retry_count"), protoreflect.ValueOfInt64(3))
+	msg.Set(md.Fields().ByName("state"), protoreflect.ValueOfEnum(1))
+
+	args := msg.Mutable(md.Fields().ByName("args")).List()
+	for _, id := range []int64{12345678901234567, 2} {
+		arg := args.NewElement()
+		arg.Message().Set(file.Messages().ByName("Arg").Fields().ByName("id"), protoreflect.ValueOfInt64(id))
+		args.Append(arg)
+	}
+
+	tags := msg.Mutable(md.Fields().ByName("tags")).Map()
+	tags.Set(protoreflect.ValueOfString("team").MapKey(), protoreflect.ValueOfString("payments"))
+
+	encoded, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	require.Nil(t, err)
+
+	builder := NewBuilder(&Configuration{Type: ProtoRawJobType, DescriptorSet: descriptorSet, MessageType: "goqueue.test.Job"})
+
+	return builder, encoded
+}
+
+func TestProtoJob_GetValue(t *testing.T) {
+	builder, encoded := writeTestDescriptorSet(t)
+
+	j, err := builder.MakeJob(encoded)
+	require.Nil(t, err)
+
+	for key, expected := range map[string]interface{}{
+		"class":       "WebhookWorker",
+		"retry_count": int64(3),
+		"state":       "RETRY",
+		"args[0].id":  int64(12345678901234567),
+		"args[1].id":  int64(2),
+		"tags.team":   "payments",
+	} {
+		t.Run(key, func(t *testing.T) {
+			value, err := j.GetValue(key)
+			require.Nil(t, err)
+			assert.Equal(t, expected, value.Interface())
+		})
+	}
+
+	t.Run("wildcard", func(t *testing.T) {
+		values, err := j.GetValues("args[*].id")
+		require.Nil(t, err)
+		assert.Len(t, values, 2)
+	})
+	t.Run("unset message is missing", func(t *testing.T) {
+		_, err := j.GetValue("meta.id")
+		assert.ErrorIs(t, err, ErrFieldNotFound)
+	})
+	t.Run("unmodified raw is original", func(t *testing.T) {
+		assert.Equal(t, encoded, j.Raw())
+	})
+}
+
+func TestProtoJob_SetValue(t *testing.T) {
+	builder, encoded := writeTestDescriptorSet(t)
+
+	j, err := builder.MakeJob(encoded)
+	require.Nil(t, err)
+
+	require.Nil(t, j.SetValue("retry_count", 0))
+	require.Nil(t, j.SetValue("state", "NEW"))
+	require.Nil(t, j.SetValue("args[1].name", "second"))
+	require.Nil(t, j.SetValue("tags.owner", "ops"))
+	require.Nil(t, j.SetValue("meta", map[string]interface{}{"id": 7}))
+	require.Nil(t, j.Delete("args[0]"))
+	assert.NotNil(t, j.SetValue("class", 1))
+	assert.NotNil(t, j.SetValue("unknown", 1))
+
+	// round trip the modified message through the builder
+	reread, err := builder.MakeJob(j.Raw())
+	require.Nil(t, err)
+
+	for key, expected := range map[string]interface{}{
+		"retry_count":  int64(0),
+		"state":        "NEW",
+		"args[0].name": "second",
+		"tags.owner":   "ops",
+		"meta.id":      int64(7),
+	} {
+		value, err := reread.GetValue(key)
+		require.Nil(t, err, key)
+		assert.Equal(t, expected, value.Interface(), key)
+	}
+
+	args, err := reread.GetValues("args[*]")
+	require.Nil(t, err)
+	assert.Len(t, args, 1)
+	assert.Equal(t, encoded, j.Original())
+}
+
+func TestProtoJob_SetValue_range(t *testing.T) {
+	builder, encoded := writeTestDescriptorSet(t)
+
+	t.Run("success", func(t *testing.T) {
+		j, err := builder.MakeJob(encoded)
+		require.Nil(t, err)
+
+		require.Nil(t, j.SetValue("attempts", math.MinInt32))
+		require.Nil(t, j.SetValue("size", uint64(math.MaxUint32)))
+		require.Nil(t, j.SetValue("offset", json.Number("18446744073709551615")))
+
+		protoJob := j.(*protoJob)
+		fields := protoJob.msg.Descriptor().Fields()
+		assert.Equal(t, int64(math.MinInt32), protoJob.msg.Get(fields.ByName("attempts")).Int())
+		assert.Equal(t, uint64(math.MaxUint32), protoJob.msg.Get(fields.ByName("size")).Uint())
+		assert.Equal(t, uint64(math.MaxUint64), protoJob.msg.Get(fields.ByName("offset")).Uint())
+
+		offset, err := j.GetValue("offset")
+		require.Nil(t, err)
+		assert.Equal(t, uint64(math.MaxUint64), offset.Interface())
+	})
+	t.Run("failure", func(t *testing.T) {
+		j, err := builder.MakeJob(encoded)
+		require.Nil(t, err)
+
+		for key, value := range map[string]interface{}{
+			"attempts":    int64(math.MaxInt32) + 1,
+			"size":        int64(math.MaxUint32) + 1,
+			"offset":      -1,
+			"retry_count": uint64(math.MaxUint64),
+			"state":       int64(math.MaxInt32) + 1,
+		} {
+			assert.NotNil(t, j.SetValue(key, value), key)
+		}
+
+		// the job is left as it was
+		assert.Equal(t, encoded, j.Raw())
+	})
+}
+
+func TestProtoJob_precision(t *testing.T) {
+	builder, encoded := writeTestDescriptorSet(t)
+
+	j, err := builder.MakeJob(encoded)
+	require.Nil(t, err)
+
+	clone := j.Clone()
+	require.Nil(t, clone.SetValue("class", "OtherWorker"))
+
+	// fields that were not set keep their exact value in the message
+	protoJob := clone.(*protoJob)
+	id := protoJob.msg.Get(protoJob.msg.Descriptor().Fields().ByName("args")).List().Get(0).Message()
+	assert.Equal(t, int64(12345678901234567), id.Get(id.Descriptor().Fields().ByName("id")).Int())
+
+	class, err := j.GetValue("class")
+	require.Nil(t, err)
+	assert.Equal(t, "WebhookWorker", class.String())
+}
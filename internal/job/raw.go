@@ -0,0 +1,56 @@
+package job
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// rawJob is a payload that could not be decoded. It carries the bytes so the
+// payload can still be moved to a quarantine queue.
+type rawJob struct {
+	bytes []byte
+}
+
+// NewRawJob wraps bytes that could not be made into a job. It has no fields.
+func NewRawJob(bts []byte) Data {
+	return &rawJob{bytes: bts}
+}
+
+func (r *rawJob) GetValue(key string) (reflect.Value, error) {
+	return reflect.Value{}, fmt.Errorf("%w: %s", ErrFieldNotFound, key)
+}
+
+func (r *rawJob) GetValues(key string) ([]reflect.Value, error) {
+	return nil, fmt.Errorf("%w: %s", ErrFieldNotFound, key)
+}
+
+func (r *rawJob) SetValue(key string, value interface{}) error {
+	return fmt.Errorf("could not set %s: job could not be decoded", key)
+}
+
+func (r *rawJob) Delete(key string) error {
+	return nil
+}
+
+func (r *rawJob) Raw() []byte {
+	return r.bytes
+}
+
+func (r *rawJob) Original() []byte {
+	return r.bytes
+}
+
+func (r *rawJob) Clone() Data {
+	return &rawJob{bytes: r.bytes}
+}
+
+func (r *rawJob) Replace(clone Data) error {
+	c, ok := clone.(*rawJob)
+	if !ok {
+		return fmt.Errorf("can't replace a raw job with %T", clone)
+	}
+
+	*r = *c
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrInvalidJob is wrapped by the errors returned when a job does not match
+// its schema.
+var ErrInvalidJob = errors.New("invalid job")
+
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+// ValidationError lists every field of a job that did not match the schema.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for idx := range e.Errors {
+		msgs[idx] = e.Errors[idx].Error()
+	}
+
+	return ErrInvalidJob.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidJob
+}
+
+// validate checks j against the fields of config, setting defaults for
+// missing fields.
+func validate(config *Configuration, j Data) error {
+	if config == nil || len(config.Fields) == 0 {
+		return nil
+	}
+
+	validationErr := &ValidationError{}
+	for _, field := range config.Fields {
+		if err := validateField(field, j); err != nil {
+			validationErr.Errors = append(validationErr.Errors, FieldError{Field: field.Name, Err: err})
+		}
+	}
+
+	if len(validationErr.Errors) > 0 {
+		return validationErr
+	}
+
+	return nil
+}
+
+func validateField(field Field, j Data) error {
+	val, err := j.GetValue(field.Name)
+	if errors.Is(err, ErrFieldNotFound) {
+		if field.Default != nil {
+			return j.SetValue(field.Name, field.Default)
+		}
+
+		if field.Required {
+			return errors.New("is required")
+		}
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !val.IsValid() {
+		if field.Required {
+			return errors.New("is null")
+		}
+
+		return nil
+	}
+
+	if !matchesType(field.Type, val) {
+		return fmt.Errorf("expected %s got %s", field.Type, val.Kind())
+	}
+
+	return nil
+}
+
+func matchesType(fieldType FieldType, val reflect.Value) bool {
+	switch fieldType {
+	case AnyField, "":
+		return true
+	case StringField:
+		return val.Kind() == reflect.String && !IsNumber(val)
+	case NumberField:
+		return IsNumber(val)
+	case IntegerField:
+		rat, ok := ToRat(val)
+		return ok && rat.IsInt()
+	case BoolField:
+		return val.Kind() == reflect.Bool
+	case ObjectField:
+		return val.Kind() == reflect.Map
+	case ArrayField:
+		return val.Kind() == reflect.Slice
+	}
+
+	return false
+}
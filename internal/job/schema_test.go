@@ -0,0 +1,60 @@
+package job
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBuilder_MakeJob_schema(t *testing.T) {
+	builder := NewBuilder(&Configuration{Type: JsonRawJobType, Fields: []Field{
+		{Name: "class", Type: StringField, Required: true},
+		{Name: "args", Type: ArrayField, Required: true},
+		{Name: "retry_count", Type: IntegerField, Default: 0},
+		{Name: "payload.id", Type: NumberField},
+	}})
+
+	t.Run("success", func(t *testing.T) {
+		t.Run("valid job", func(t *testing.T) {
+			j, err := builder.MakeJob([]byte(`{"class":"WebhookWorker","args":[],"retry_count":2}`))
+			require.Nil(t, err)
+
+			value, err := j.GetValue("retry_count")
+			require.Nil(t, err)
+			assert.Equal(t, "2", FormatNumber(value))
+		})
+		t.Run("default is set", func(t *testing.T) {
+			j, err := builder.MakeJob([]byte(`{"class":"WebhookWorker","args":[]}`))
+			require.Nil(t, err)
+
+			assert.Equal(t, `{"class":"WebhookWorker","args":[],"retry_count":0}`, string(j.Raw()))
+			assert.Equal(t, `{"class":"WebhookWorker","args":[]}`, string(j.Original()))
+		})
+	})
+	t.Run("failure", func(t *testing.T) {
+		t.Run("reports every field", func(t *testing.T) {
+			j, err := builder.MakeJob([]byte(`{"class":1,"retry_count":1.5,"payload":{"id":"x"}}`))
+			require.NotNil(t, j)
+			require.ErrorIs(t, err, ErrInvalidJob)
+
+			validationErr, ok := err.(*ValidationError)
+			require.True(t, ok)
+
+			fields := make([]string, 0)
+			for _, fieldErr := range validationErr.Errors {
+				fields = append(fields, fieldErr.Field)
+			}
+			assert.Equal(t, []string{"class", "args", "retry_count", "payload.id"}, fields)
+		})
+		t.Run("required null", func(t *testing.T) {
+			_, err := builder.MakeJob([]byte(`{"class":null,"args":[]}`))
+			assert.ErrorIs(t, err, ErrInvalidJob)
+		})
+		t.Run("not json", func(t *testing.T) {
+			j, err := builder.MakeJob([]byte(`not json`))
+			assert.Nil(t, j)
+			assert.NotNil(t, err)
+			assert.NotErrorIs(t, err, ErrInvalidJob)
+		})
+	})
+}
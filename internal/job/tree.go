@@ -0,0 +1,191 @@
+package job
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// treeJob is a job decoded into the same tree of maps and slices as a json
+// job, for formats that don't need their own encoder. Integers are held as
+// int64, or uint64 when they don't fit, so they keep their exact value.
+type treeJob struct {
+	config *Configuration
+	bytes  []byte
+	tree   map[string]interface{}
+	encode func(tree map[string]interface{}) ([]byte, error)
+
+	raw   []byte
+	dirty bool
+}
+
+func (t *treeJob) GetValue(key string) (reflect.Value, error) {
+	return getTreeValue(t.tree, key)
+}
+
+func (t *treeJob) GetValues(key string) ([]reflect.Value, error) {
+	return getTreeValues(t.tree, key)
+}
+
+func (t *treeJob) SetValue(key string, value interface{}) error {
+	path, err := ParsePath(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = path.Set(t.tree, normaliseValue(value, toTreeNumber))
+	if err != nil {
+		return fmt.Errorf("could not set %s: %w", key, err)
+	}
+
+	t.dirty = true
+
+	return nil
+}
+
+func (t *treeJob) Delete(key string) error {
+	path, err := ParsePath(key)
+	if err != nil {
+		return err
+	}
+
+	if _, deleted := path.Delete(t.tree); deleted {
+		t.dirty = true
+	}
+
+	return nil
+}
+
+func (t *treeJob) Raw() []byte {
+	if t.dirty {
+		bts, err := t.encode(t.tree)
+		if err == nil {
+			t.raw = bts
+			t.dirty = false
+		}
+	}
+
+	if t.raw != nil {
+		return t.raw
+	}
+
+	return t.bytes
+}
+
+func (t *treeJob) Original() []byte {
+	return t.bytes
+}
+
+func (t *treeJob) Clone() Data {
+	return &treeJob{
+		config: t.config,
+		bytes:  t.bytes,
+		tree:   deepCopy(t.tree).(map[string]interface{}),
+		encode: t.encode,
+		dirty:  t.dirty || t.raw != nil,
+	}
+}
+
+func (t *treeJob) Replace(clone Data) error {
+	c, ok := clone.(*treeJob)
+	if !ok {
+		return fmt.Errorf("can't replace a job with %T", clone)
+	}
+
+	*t = *c
+
+	return nil
+}
+
+func (t *treeJob) validate() error {
+	return validate(t.config, t)
+}
+
+// normaliseDecoded converts a value decoded from yaml or msgpack into the
+// types a tree job holds: int64, uint64 and float64 numbers, string keys, and
+// strings for binary data and timestamps.
+func normaliseDecoded(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []interface{}:
+		slice := make([]interface{}, len(v))
+		for idx := range v {
+			slice[idx] = normaliseDecoded(v[idx])
+		}
+
+		return slice
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key := range v {
+			m[key] = normaliseDecoded(v[key])
+		}
+
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key := range v {
+			m[fmt.Sprint(key)] = normaliseDecoded(v[key])
+		}
+
+		return m
+	}
+
+	return normaliseValue(value, toTreeNumber)
+}
+
+// denormaliseNumbers turns json.Number and whole float64 numbers, such as
+// those of json jobs or set by transforms, into integers so formats with an
+// integer type don't re-encode every number as a float.
+func denormaliseNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return u
+		}
+
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+
+		return string(v)
+	case float64:
+		if math.Abs(v) < 1<<63 && v == math.Trunc(v) {
+			return int64(v)
+		}
+	case []interface{}:
+		slice := make([]interface{}, len(v))
+		for idx := range v {
+			slice[idx] = denormaliseNumbers(v[idx])
+		}
+
+		return slice
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key := range v {
+			m[key] = denormaliseNumbers(v[key])
+		}
+
+		return m
+	}
+
+	return value
+}
+
+func makeTreeJob(config *Configuration, bts []byte, decoded interface{}, encode func(tree map[string]interface{}) ([]byte, error)) (*treeJob, error) {
+	tree, ok := normaliseDecoded(decoded).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("job must be an object, got %T", decoded)
+	}
+
+	return &treeJob{config: config, bytes: bts, tree: tree, encode: encode}, nil
+}
@@ -0,0 +1,101 @@
+package job
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"testing"
+)
+
+func TestTreeJob_formats(t *testing.T) {
+	yamlJob := []byte("class: WebhookWorker\nretry_count: 4\nargs:\n  - 12388\n  - some\nerror_message: boom\nretry: true\n")
+	msgpackJob, err := msgpack.Marshal(map[string]interface{}{
+		"class":         "WebhookWorker",
+		"retry_count":   int8(4),
+		"args":          []interface{}{uint16(12388), []byte("some")},
+		"error_message": "boom",
+		"retry":         true,
+	})
+	require.Nil(t, err)
+
+	for format, bts := range map[RawJobType][]byte{
+		JsonRawJobType:    []byte(`{"class":"WebhookWorker","retry_count":4,"args":[12388,"some"],"error_message":"boom","retry":true}`),
+		YamlRawJobType:    yamlJob,
+		MsgpackRawJobType: msgpackJob,
+	} {
+		t.Run(string(format), func(t *testing.T) {
+			j, err := NewBuilder(&Configuration{Type: format}).MakeJob(bts)
+			require.Nil(t, err)
+
+			for key, expected := range map[string]interface{}{
+				"class":       "WebhookWorker",
+				"retry_count": float64(4),
+				"args[0]":     float64(12388),
+				"args[1]":     "some",
+				"retry":       true,
+			} {
+				value, err := j.GetValue(key)
+				require.Nil(t, err, key)
+				if number, ok := ToFloat64(value); ok {
+					assert.Equal(t, expected, number, key)
+					continue
+				}
+				assert.Equal(t, expected, value.Interface(), key)
+			}
+
+			require.Nil(t, j.SetValue("retry_count", 0))
+			require.Nil(t, j.Delete("error_message"))
+
+			reread, err := NewBuilder(&Configuration{Type: format}).MakeJob(j.Raw())
+			require.Nil(t, err)
+
+			value, err := reread.GetValue("retry_count")
+			require.Nil(t, err)
+			assert.Equal(t, "0", FormatNumber(value))
+
+			_, err = reread.GetValue("error_message")
+			assert.ErrorIs(t, err, ErrFieldNotFound)
+			assert.Equal(t, bts, j.Original())
+		})
+	}
+
+	t.Run("msgpack keeps integers", func(t *testing.T) {
+		j, err := NewBuilder(&Configuration{Type: MsgpackRawJobType}).MakeJob(msgpackJob)
+		require.Nil(t, err)
+		require.Nil(t, j.SetValue("retry_count", 5))
+
+		var decoded map[string]interface{}
+		require.Nil(t, msgpack.Unmarshal(j.Raw(), &decoded))
+		assert.Equal(t, int8(5), decoded["retry_count"])
+	})
+	t.Run("integers keep their exact value", func(t *testing.T) {
+		msgpackIds, err := msgpack.Marshal(map[string]interface{}{"id": int64(9007199254740993), "offset": uint64(18446744073709551615)})
+		require.Nil(t, err)
+
+		for format, bts := range map[RawJobType][]byte{
+			YamlRawJobType:    []byte("id: 9007199254740993\noffset: 18446744073709551615\n"),
+			MsgpackRawJobType: msgpackIds,
+		} {
+			j, err := NewBuilder(&Configuration{Type: format}).MakeJob(bts)
+			require.Nil(t, err, format)
+			require.Nil(t, j.SetValue("created_at", uint64(1700000000123456789)), format)
+
+			reread, err := NewBuilder(&Configuration{Type: format}).MakeJob(j.Raw())
+			require.Nil(t, err, format)
+
+			for key, expected := range map[string]string{
+				"id":         "9007199254740993",
+				"offset":     "18446744073709551615",
+				"created_at": "1700000000123456789",
+			} {
+				value, err := reread.GetValue(key)
+				require.Nil(t, err, key)
+				assert.Equal(t, expected, FormatNumber(value), format, key)
+			}
+		}
+	})
+	t.Run("yaml must be an object", func(t *testing.T) {
+		_, err := NewBuilder(&Configuration{Type: YamlRawJobType}).MakeJob([]byte("- a\n- b\n"))
+		assert.NotNil(t, err)
+	})
+}
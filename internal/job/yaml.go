@@ -0,0 +1,18 @@
+package job
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+func makeYamlJob(config *Configuration, bts []byte) (*treeJob, error) {
+	var decoded interface{}
+	if err := yaml.Unmarshal(bts, &decoded); err != nil {
+		return nil, err
+	}
+
+	return makeTreeJob(config, bts, decoded, encodeYaml)
+}
+
+func encodeYaml(tree map[string]interface{}) ([]byte, error) {
+	return yaml.Marshal(denormaliseNumbers(tree))
+}
@@ -15,16 +15,19 @@ import (
 type conditionReturnFunc executers.ExecFunc
 type DecisionTree struct {
 	name      string
-	condition conditionals.ConditionFunc
+	condition conditionals.CheckFunc
 
 	trueMethod executers.ExecFunc
 
 	falseMethod   executers.ExecFunc
 	returnOnFalse bool
 	returnOnTrue  bool
+
+	errorMethod   executers.ExecFunc
+	returnOnError bool
 }
 
-func NewConditionTree(name string, condition conditionals.ConditionFunc, trueFunction, falseFunc executers.ExecFunc, returnOnTrue, returnOnFalse bool) DecisionTree {
+func NewConditionTree(name string, condition conditionals.CheckFunc, trueFunction, falseFunc executers.ExecFunc, returnOnTrue, returnOnFalse bool) DecisionTree {
 	return DecisionTree{
 		name:          name,
 		condition:     condition,
@@ -35,6 +38,13 @@ func NewConditionTree(name string, condition conditionals.ConditionFunc, trueFun
 	}
 }
 
+// OnError routes jobs whose condition could not be evaluated to errorFunc.
+// Without it those jobs take the false branch.
+func (c *DecisionTree) OnError(errorFunc executers.ExecFunc, returnOnError bool) {
+	c.errorMethod = errorFunc
+	c.returnOnError = returnOnError
+}
+
 func (c *DecisionTree) Middleware(meter api.Meter) executers.FilterMiddleware {
 	return func(next executers.ExecFunc) executers.ExecFunc {
 		return func(ctx context.Context, job job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
@@ -48,17 +58,41 @@ func (c *DecisionTree) Middleware(meter api.Meter) executers.FilterMiddleware {
 			}
 			// if we hit the true method we want to hit the push before continuing in the
 			// pipeline
-			condition := c.condition(ctx, job)
+			condition, conditionErr := c.condition(ctx, job)
 			opts := api.WithAttributes(
 				attribute.Key("decisionTree").String(c.name),
 				attribute.Key("returnOnTrue").Bool(c.returnOnTrue),
 				attribute.Key("returnOnFalse").Bool(c.returnOnFalse),
 				attribute.Key("condition").Bool(condition),
+				attribute.Key("error").Bool(conditionErr != nil),
 			)
 
 			defer counter.Add(ctx, 1, opts)
 
-			if condition {
+			if conditionErr != nil {
+				logs.Warn(ctx, "could not evaluate condition", logs.WithError(conditionErr), logs.WithValue("decisionTree", c.name))
+
+				// the error branch handles the job, so its own result decides
+				// whether the job failed
+				errorMethod, returnOnError := c.falseMethod, c.returnOnFalse
+				if c.errorMethod != nil {
+					errorMethod, returnOnError = c.errorMethod, c.returnOnError
+				} else {
+					errChan <- conditionErr
+				}
+
+				newErrorChan := make(chan error)
+				go func() {
+					errorMethod(ctx, job, stdOut, stdErr, newErrorChan)
+				}()
+				for err := range newErrorChan {
+					errChan <- err
+				}
+
+				if returnOnError {
+					return
+				}
+			} else if condition {
 				// make the true condition
 				newErrorChan := make(chan error)
 				go func() {
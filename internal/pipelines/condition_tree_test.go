@@ -1,9 +1,16 @@
 package pipelines_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/pipelines"
+	"go.opentelemetry.io/otel/metric/noop"
+	"io"
 	"testing"
 )
 
@@ -11,16 +18,154 @@ type conditionMock struct {
 	mock.Mock
 }
 
-func (m *conditionMock) call(ctx context.Context, job job.Job) bool {
+func (m *conditionMock) call(ctx context.Context, job job.Job) (bool, error) {
 	args := m.Called(ctx, job)
-	return args.Bool(0)
+	return args.Bool(0), args.Error(1)
+}
+
+// branch records that it was called and closes its error channel
+func branch(called *string, name string) func(ctx context.Context, job job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	return func(ctx context.Context, job job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+		defer close(errChan)
+		*called = name
+	}
+}
+
+func runTree(t *testing.T, tree pipelines.DecisionTree, j job.Job) []error {
+	t.Helper()
+
+	next := func(ctx context.Context, job job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+		close(errChan)
+	}
+
+	errChan := make(chan error)
+	go tree.Middleware(noop.NewMeterProvider().Meter("test"))(next)(context.Background(), j, bytes.NewBuffer(nil), bytes.NewBuffer(nil), errChan)
+
+	errs := make([]error, 0)
+	for err := range errChan {
+		errs = append(errs, err)
+	}
+
+	return errs
 }
 
 func TestDecisionTree_Middleware(t *testing.T) {
+	builder := job.NewBuilder(&job.Configuration{Type: "json"})
+	j, err := builder.MakeJob([]byte(`{"retry_count": 3}`))
+	require.Nil(t, err)
+
 	t.Run("success", func(t *testing.T) {
 		cMock := &conditionMock{}
-		cMock.On("call", mock.Anything)
-		type ConditionFunc func(ctx context.Context, job job.Job) bool
-		//pipelines.NewConditionTree(cMock.call)
+		cMock.On("call", mock.Anything, mock.Anything).Return(true, nil)
+
+		called := ""
+		tree := pipelines.NewConditionTree("test", cMock.call, branch(&called, "true"), branch(&called, "false"), true, true)
+
+		assert.Empty(t, runTree(t, tree, j))
+		assert.Equal(t, "true", called)
+	})
+	t.Run("error takes the false branch", func(t *testing.T) {
+		cMock := &conditionMock{}
+		cMock.On("call", mock.Anything, mock.Anything).Return(false, job.ErrFieldNotFound)
+
+		called := ""
+		tree := pipelines.NewConditionTree("test", cMock.call, branch(&called, "true"), branch(&called, "false"), true, true)
+
+		errs := runTree(t, tree, j)
+		require.Len(t, errs, 1)
+		assert.True(t, errors.Is(errs[0], job.ErrFieldNotFound))
+		assert.Equal(t, "false", called)
+	})
+	t.Run("error branch", func(t *testing.T) {
+		cMock := &conditionMock{}
+		cMock.On("call", mock.Anything, mock.Anything).Return(false, job.ErrFieldNotFound)
+
+		called := ""
+		tree := pipelines.NewConditionTree("test", cMock.call, branch(&called, "true"), branch(&called, "false"), true, true)
+		tree.OnError(branch(&called, "error"), true)
+
+		assert.Empty(t, runTree(t, tree, j))
+		assert.Equal(t, "error", called)
+	})
+	t.Run("error branch failure", func(t *testing.T) {
+		cMock := &conditionMock{}
+		cMock.On("call", mock.Anything, mock.Anything).Return(false, job.ErrFieldNotFound)
+
+		branchErr := errors.New("could not push")
+		tree := pipelines.NewConditionTree("test", cMock.call, nil, nil, true, true)
+		tree.OnError(func(ctx context.Context, job job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+			defer close(errChan)
+			errChan <- branchErr
+		}, true)
+
+		assert.Equal(t, []error{branchErr}, runTree(t, tree, j))
+	})
+}
+
+// ackQueue hands out a job and records how it was acked.
+type ackQueue struct {
+	job    job.Job
+	cancel context.CancelFunc
+	acked  chan bool
+}
+
+func (q *ackQueue) GetItems(ctx context.Context, jobChan chan<- job.Job) error {
+	defer close(jobChan)
+
+	jobChan <- q.job
+	<-ctx.Done()
+
+	return nil
+}
+
+func (q *ackQueue) Ack(ctx context.Context, job job.Job, success bool) error {
+	q.acked <- success
+	q.cancel()
+
+	return nil
+}
+
+func TestDecisionTree_Ack(t *testing.T) {
+	builder := job.NewBuilder(&job.Configuration{Type: "json"})
+	j, err := builder.MakeJob([]byte(`{"retry_count": 3}`))
+	require.Nil(t, err)
+
+	run := func(t *testing.T, tree *pipelines.DecisionTree) bool {
+		t.Helper()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		queue := &ackQueue{job: j, cancel: cancel, acked: make(chan bool, 1)}
+		next := func(ctx context.Context, job job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+			close(errChan)
+		}
+
+		pipeline := pipelines.NewPipeline("test", noop.NewMeterProvider().Meter("test"), queue, next, tree)
+		require.Nil(t, pipeline.Start(ctx))
+
+		return <-queue.acked
+	}
+
+	t.Run("success", func(t *testing.T) {
+		cMock := &conditionMock{}
+		cMock.On("call", mock.Anything, mock.Anything).Return(false, job.ErrFieldNotFound)
+
+		called := ""
+		tree := pipelines.NewConditionTree("test", cMock.call, branch(&called, "true"), branch(&called, "false"), true, true)
+		tree.OnError(branch(&called, "error"), true)
+
+		assert.True(t, run(t, &tree))
+		assert.Equal(t, "error", called)
+	})
+	t.Run("failure", func(t *testing.T) {
+		cMock := &conditionMock{}
+		cMock.On("call", mock.Anything, mock.Anything).Return(false, job.ErrFieldNotFound)
+
+		called := ""
+		tree := pipelines.NewConditionTree("test", cMock.call, branch(&called, "true"), branch(&called, "false"), true, true)
+
+		assert.False(t, run(t, &tree))
+		assert.Equal(t, "false", called)
 	})
 }
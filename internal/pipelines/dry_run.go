@@ -0,0 +1,236 @@
+package pipelines
+
+import (
+	"bytes"
+	"context"
+	"github.com/thethan/goqueue/internal/conditionals"
+	"github.com/thethan/goqueue/internal/executers"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"go.opentelemetry.io/otel/metric/noop"
+	"io"
+	"sync"
+)
+
+type CallKind string
+
+const (
+	PushCall   CallKind = "push"
+	RemoveCall CallKind = "remove"
+	ActionCall CallKind = "action"
+	ExecCall   CallKind = "exec"
+)
+
+// Call is what a job would have had done to it: pushed to or removed from
+// Target, Target's Action called on it, or the executor Target run.
+type Call struct {
+	Kind   CallKind `json:"kind"`
+	Target string   `json:"target"`
+	Action string   `json:"action,omitempty"`
+}
+
+// TreeReport is how a decision tree evaluated a job: whether its condition
+// matched, or the error it could not be evaluated with, the branch that fired
+// and the calls the branch would have made. Return is set when the branch
+// stopped the job going through the rest of the pipeline.
+type TreeReport struct {
+	Name    string `json:"name"`
+	Matched bool   `json:"matched"`
+	Error   string `json:"error,omitempty"`
+	Branch  string `json:"branch,omitempty"`
+	Return  bool   `json:"return,omitempty"`
+	Calls   []Call `json:"calls,omitempty"`
+}
+
+// JobReport is what a pipeline would have done with a job. Calls are the
+// calls made outside the decision trees, by the pipeline's executor.
+type JobReport struct {
+	Job    string       `json:"job"`
+	Trees  []TreeReport `json:"trees"`
+	Calls  []Call       `json:"calls,omitempty"`
+	Errors []string     `json:"errors,omitempty"`
+}
+
+// Recorder builds the conditions and queue functions of a dry run, which
+// record what they are asked to do to the job being evaluated instead of
+// doing it.
+type Recorder struct {
+	mu      sync.Mutex
+	current *JobReport
+	// inBranch is set while a branch of the current job's last tree runs
+	inBranch bool
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// start begins the report of a job.
+func (r *Recorder) start(j job.Job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.current = &JobReport{Job: string(j.Original()), Trees: make([]TreeReport, 0)}
+}
+
+// finish returns the report of the job being evaluated.
+func (r *Recorder) finish(errs []error) JobReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := *r.current
+	for _, err := range errs {
+		report.Errors = append(report.Errors, err.Error())
+	}
+
+	r.current = nil
+
+	return report
+}
+
+// Condition records the result of the decision tree name's condition.
+func (r *Recorder) Condition(name string, check conditionals.CheckFunc) conditionals.CheckFunc {
+	return func(ctx context.Context, j job.Job) (bool, error) {
+		matched, err := check(ctx, j)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.current != nil {
+			tree := TreeReport{Name: name, Matched: matched}
+			if err != nil {
+				tree.Error = err.Error()
+			}
+
+			r.current.Trees = append(r.current.Trees, tree)
+		}
+
+		return matched, err
+	}
+}
+
+// Branch records that the branch of the last evaluated decision tree fired
+// and attributes the calls next makes to it.
+func (r *Recorder) Branch(branch string, returns bool, next executers.ExecFunc) executers.ExecFunc {
+	return func(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+		r.mu.Lock()
+		if r.current != nil && len(r.current.Trees) > 0 {
+			tree := &r.current.Trees[len(r.current.Trees)-1]
+			tree.Branch, tree.Return = branch, returns
+			r.inBranch = true
+		}
+		r.mu.Unlock()
+
+		// the branch is over once next closes its channel, not when it
+		// returns
+		defer func() {
+			r.mu.Lock()
+			r.inBranch = false
+			r.mu.Unlock()
+
+			close(errChan)
+		}()
+
+		nextErrChan := make(chan error)
+		go next(ctx, j, stdOut, stdErr, nextErrChan)
+		for err := range nextErrChan {
+			errChan <- err
+		}
+	}
+}
+
+// Push records a push to the queue name.
+func (r *Recorder) Push(name string) executers.ExecFunc {
+	return r.call(Call{Kind: PushCall, Target: name})
+}
+
+// Remove records a removal from the queue name.
+func (r *Recorder) Remove(name string) executers.ExecFunc {
+	return r.call(Call{Kind: RemoveCall, Target: name})
+}
+
+// Action records a call of the queue name's action.
+func (r *Recorder) Action(name, action string) executers.ExecFunc {
+	return r.call(Call{Kind: ActionCall, Target: name, Action: action})
+}
+
+// Exec records a run of the executor name.
+func (r *Recorder) Exec(name string) executers.ExecFunc {
+	return r.call(Call{Kind: ExecCall, Target: name})
+}
+
+func (r *Recorder) call(call Call) executers.ExecFunc {
+	return func(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+		defer func() {
+			close(errChan)
+		}()
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		switch {
+		case r.current == nil:
+		case r.inBranch:
+			tree := &r.current.Trees[len(r.current.Trees)-1]
+			tree.Calls = append(tree.Calls, call)
+		default:
+			r.current.Calls = append(r.current.Calls, call)
+		}
+	}
+}
+
+// dryRunPageSize is how many jobs a dry run reads at a time.
+const dryRunPageSize = 100
+
+// DryRun evaluates the jobs of a queue through a pipeline's decision trees
+// built with a Recorder. The queue is paged through rather than read with
+// GetItems, so its jobs are neither claimed nor removed.
+type DryRun struct {
+	name     string
+	source   queues.PageQueue
+	execFunc executers.ExecFunc
+	recorder *Recorder
+}
+
+func NewDryRun(name string, source queues.PageQueue, execFunc executers.ExecFunc, recorder *Recorder, decisionTrees ...*DecisionTree) *DryRun {
+	meter := noop.NewMeterProvider().Meter("dryrun")
+	for idx := len(decisionTrees) - 1; idx >= 0; idx-- {
+		execFunc = decisionTrees[idx].Middleware(meter)(execFunc)
+	}
+
+	return &DryRun{name: name, source: source, execFunc: execFunc, recorder: recorder}
+}
+
+// Run evaluates up to limit jobs, every job in the queue when it starts when
+// limit is zero, in the order the queue would hand them out.
+func (d *DryRun) Run(ctx context.Context, limit int) ([]JobReport, error) {
+	reports := make([]JobReport, 0)
+	err := queues.Scan(ctx, d.source, 0, dryRunPageSize, func(j job.Job) (bool, error) {
+		reports = append(reports, d.evaluate(ctx, j))
+
+		return limit == 0 || len(reports) < limit, nil
+	})
+	if err != nil {
+		return reports, err
+	}
+
+	logs.Info(ctx, "dry run done", logs.WithValue("pipeline", d.name), logs.WithValue("count", len(reports)))
+
+	return reports, nil
+}
+
+// evaluate runs a job through the decision trees and returns its report.
+func (d *DryRun) evaluate(ctx context.Context, j job.Job) JobReport {
+	d.recorder.start(j)
+
+	errChan := make(chan error)
+	go d.execFunc(ctx, j, bytes.NewBuffer(nil), bytes.NewBuffer(nil), errChan)
+
+	errs := make([]error, 0)
+	for err := range errChan {
+		errs = append(errs, err)
+	}
+
+	return d.recorder.finish(errs)
+}
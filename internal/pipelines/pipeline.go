@@ -9,8 +9,12 @@ import (
 	"github.com/thethan/goqueue/internal/queues"
 	"go.opentelemetry.io/otel/attribute"
 	metric2 "go.opentelemetry.io/otel/metric"
+	"time"
 )
 
+// drainTimeout is how long a stopped pipeline waits for its queue to stop.
+const drainTimeout = 10 * time.Second
+
 type ProcessPipeline interface {
 	Start(ctx context.Context) error
 }
@@ -54,36 +58,96 @@ func (p *pipeline) Start(ctx context.Context) error {
 		}
 	}()
 
+	jobs := (<-chan job.Job)(jobChan)
 	for {
 		select {
 		case <-ctx.Done():
+			p.drain(ctx, counter, jobs)
 			return nil
 		case err := <-errorChan:
 			logs.Error(ctx, "error in pipeline", logs.WithError(err))
-		case jb := <-jobChan:
-			newErrChan := make(chan error)
-			stdOut := bytes.NewBuffer([]byte{})
-			stdErr := bytes.NewBuffer([]byte{})
-
-			go func() {
-				p.execFunc(ctx, jb, stdOut, stdErr, newErrChan)
-			}()
-
-			success := true
-			for err := range newErrChan {
-				logs.Error(ctx, "error in executing from pipeline", logs.WithError(err))
-				success = false
+		case jb, ok := <-jobs:
+			if !ok {
+				// the queue has stopped, wait for ctx or its error
+				jobs = nil
+				continue
 			}
 
-			opt := metric2.WithAttributes(
-				attribute.Key("pipeline").String(p.name),
-				attribute.Key("pipeline").String(p.name),
-				attribute.Key("success").Bool(success),
-			)
+			p.process(ctx, counter, jb)
+		}
+	}
+}
+
+// drain processes the jobs the queue hands out while it stops, such as those
+// accepted over http, until it closes jobs or drainTimeout has passed.
+func (p *pipeline) drain(ctx context.Context, counter metric2.Int64Counter, jobs <-chan job.Job) {
+	if jobs == nil {
+		return
+	}
+
+	timeout := time.NewTimer(drainTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case jb, ok := <-jobs:
+			if !ok {
+				return
+			}
 
-			counter.Add(ctx, 1, opt)
-		default:
-			// noop
+			p.process(withoutCancel{ctx}, counter, jb)
+		case <-timeout.C:
+			logs.Warn(ctx, "queue did not stop, no longer reading it", logs.WithValue("pipeline", p.name))
+			return
 		}
 	}
 }
+
+// process runs the pipeline on a job and acks it.
+func (p *pipeline) process(ctx context.Context, counter metric2.Int64Counter, jb job.Job) {
+	newErrChan := make(chan error)
+	stdOut := bytes.NewBuffer([]byte{})
+	stdErr := bytes.NewBuffer([]byte{})
+
+	go func() {
+		p.execFunc(ctx, jb, stdOut, stdErr, newErrChan)
+	}()
+
+	success := true
+	for err := range newErrChan {
+		logs.Error(ctx, "error in executing from pipeline", logs.WithError(err))
+		success = false
+	}
+
+	if acker, ok := p.getItems.(queues.AckQueue); ok {
+		if err := acker.Ack(ctx, jb, success); err != nil {
+			logs.Error(ctx, "could not ack job", logs.WithError(err))
+		}
+	}
+
+	opt := metric2.WithAttributes(
+		attribute.Key("pipeline").String(p.name),
+		attribute.Key("pipeline").String(p.name),
+		attribute.Key("success").Bool(success),
+	)
+
+	counter.Add(ctx, 1, opt)
+}
+
+// withoutCancel keeps the values of a context that is done so jobs can be
+// processed after it.
+type withoutCancel struct {
+	context.Context
+}
+
+func (withoutCancel) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (withoutCancel) Done() <-chan struct{} {
+	return nil
+}
+
+func (withoutCancel) Err() error {
+	return nil
+}
@@ -0,0 +1,66 @@
+package pipelines_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/pipelines"
+	"go.opentelemetry.io/otel/metric/noop"
+	"io"
+	"testing"
+	"time"
+)
+
+// stoppingQueue hands out its jobs once ctx is done, as a queue with jobs
+// already accepted does when it stops.
+type stoppingQueue struct {
+	jobs  []job.Job
+	acked []bool
+}
+
+func (q *stoppingQueue) GetItems(ctx context.Context, jobChan chan<- job.Job) error {
+	defer close(jobChan)
+
+	<-ctx.Done()
+	for _, j := range q.jobs {
+		jobChan <- j
+	}
+
+	return nil
+}
+
+func (q *stoppingQueue) Ack(ctx context.Context, job job.Job, success bool) error {
+	q.acked = append(q.acked, success)
+
+	return nil
+}
+
+func TestPipeline_Start(t *testing.T) {
+	builder := job.NewBuilder(&job.Configuration{Type: "json"})
+	j, err := builder.MakeJob([]byte(`{"jid":"1"}`))
+	require.Nil(t, err)
+
+	t.Run("processes the jobs handed out while stopping", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		queue := &stoppingQueue{jobs: []job.Job{j, j}}
+		processed := 0
+		exec := func(ctx context.Context, job job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+			defer close(errChan)
+
+			if ctx.Err() != nil {
+				errChan <- ctx.Err()
+			}
+
+			processed++
+		}
+
+		pipeline := pipelines.NewPipeline("test", noop.NewMeterProvider().Meter("test"), queue, exec)
+		require.Nil(t, pipeline.Start(ctx))
+
+		assert.Equal(t, 2, processed)
+		assert.Equal(t, []bool{true, true}, queue.acked)
+	})
+}
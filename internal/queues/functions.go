@@ -23,6 +23,45 @@ type RemoveQueue interface {
 	RemoveItems(ctx context.Context, job job.Job, stdOut, stdErr io.ReadWriter, error chan error)
 }
 
+// ActionQueue is a queue with operations other than push and remove, such as
+// moving a job between a backend's sets, that pipelines refer to by name.
+type ActionQueue interface {
+	Queue
+	Action(name string) (Action, bool)
+}
+
+// AckQueue is a queue that keeps the jobs it hands out pending until the
+// pipeline that read them has processed them. Jobs that were not processed
+// successfully are left pending to be delivered again.
+type AckQueue interface {
+	Ack(ctx context.Context, job job.Job, success bool) error
+}
+
+// PageQueue is a queue whose jobs can be inspected without handing them out.
+// Page returns up to count jobs from offset, in the order the queue hands
+// them out, without claiming or removing them.
+type PageQueue interface {
+	Count(ctx context.Context) (int64, error)
+	Page(ctx context.Context, offset, count int64) ([]job.Job, error)
+}
+
 type GetItems func(ctx context.Context, jobChan chan<- job.Job) error
 type PushItems func(ctx context.Context, job job.Job, stdOut, stdErr io.ReadWriter, error chan error)
 type RemoveItem func(ctx context.Context, job job.Job, stdOut, stdErr io.ReadWriter, error chan error)
+type Action func(ctx context.Context, job job.Job, stdOut, stdErr io.ReadWriter, error chan error)
+
+// Collect runs fn, which closes errChan when it is done, and returns the
+// first error it sent.
+func Collect(fn func(errChan chan error)) error {
+	errChan := make(chan error)
+	go fn(errChan)
+
+	var first error
+	for err := range errChan {
+		if first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,39 @@
+package queues
+
+import (
+	"github.com/thethan/goqueue/internal/job"
+)
+
+// Options are the settings shared by every queue implementation.
+type Options struct {
+	JobConfiguration *job.Configuration
+	// Quarantine receives payloads that could not be made into a valid job.
+	// Without one they are logged and left on the queue.
+	Quarantine PushQueue
+}
+
+type Option func(opts *Options)
+
+func WithJobConfiguration(configuration *job.Configuration) Option {
+	return func(opts *Options) {
+		opts.JobConfiguration = configuration
+	}
+}
+
+func WithQuarantine(queue PushQueue) Option {
+	return func(opts *Options) {
+		opts.Quarantine = queue
+	}
+}
+
+func NewOptions(opts ...Option) Options {
+	options := Options{
+		JobConfiguration: &job.Configuration{Type: job.JsonRawJobType},
+	}
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return options
+}
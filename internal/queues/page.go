@@ -0,0 +1,54 @@
+package queues
+
+import (
+	"context"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+)
+
+// PageJob builds a job read by a PageQueue's Page. Payloads that can't be
+// decoded are skipped rather than quarantined, inspecting a queue leaves it
+// as it is.
+func PageJob(ctx context.Context, builder *job.Builder, bts []byte) job.Job {
+	j, err := builder.MakeJob(bts)
+	if err != nil {
+		logs.Warn(ctx, "skipping invalid job", logs.WithError(err))
+		return nil
+	}
+
+	return j
+}
+
+// Scan reads the queue's jobs a page at a time from offset and calls fn
+// with each of them until fn returns false or an error. Only the jobs in the
+// queue when the scan starts are read.
+func Scan(ctx context.Context, pageQueue PageQueue, offset, pageSize int64, fn func(j job.Job) (bool, error)) error {
+	total, err := pageQueue.Count(ctx)
+	if err != nil {
+		return err
+	}
+
+	for ; offset < total; offset += pageSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		jobs, err := pageQueue.Page(ctx, offset, pageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, j := range jobs {
+			more, err := fn(j)
+			if err != nil {
+				return err
+			}
+
+			if !more {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package queues
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+)
+
+// ErrNotRemoved is wrapped by the error of a Move that pushed the job but
+// could not remove it, leaving it in both queues.
+var ErrNotRemoved = errors.New("could not remove moved job")
+
+// MakeJob builds a job read from source. Payloads that can't be decoded or
+// fail validation are moved to the quarantine queue in opts and a nil job is
+// returned so the caller can carry on with the rest of the queue.
+func MakeJob(ctx context.Context, builder *job.Builder, opts Options, source RemoveQueue, bts []byte) job.Job {
+	j, err := builder.MakeJob(bts)
+	if err == nil {
+		return j
+	}
+
+	if opts.Quarantine == nil {
+		logs.Warn(ctx, "skipping invalid job", logs.WithError(err))
+		return nil
+	}
+
+	if j == nil {
+		j = job.NewRawJob(bts)
+	}
+
+	if err := Quarantine(ctx, opts.Quarantine, source, j); err != nil {
+		logs.Error(ctx, "could not quarantine invalid job", logs.WithError(err))
+		return nil
+	}
+
+	logs.Info(ctx, "quarantined invalid job", logs.WithError(err))
+
+	return nil
+}
+
+// Quarantine moves j from source to destination.
+func Quarantine(ctx context.Context, destination PushQueue, source RemoveQueue, j job.Job) error {
+	return Move(ctx, destination, source, j)
+}
+
+// Move pushes j to destination, then removes it from source. A job that
+// can't be removed is left in both queues rather than lost, and the error
+// wraps ErrNotRemoved.
+func Move(ctx context.Context, destination PushQueue, source RemoveQueue, j job.Job) error {
+	if err := Collect(func(errChan chan error) {
+		destination.PushItems(ctx, j, bytes.NewBuffer(nil), bytes.NewBuffer(nil), errChan)
+	}); err != nil {
+		return fmt.Errorf("could not push job: %w", err)
+	}
+
+	if err := Collect(func(errChan chan error) {
+		source.RemoveItems(ctx, j, bytes.NewBuffer(nil), bytes.NewBuffer(nil), errChan)
+	}); err != nil {
+		return fmt.Errorf("%w: %w", ErrNotRemoved, err)
+	}
+
+	return nil
+}
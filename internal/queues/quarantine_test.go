@@ -0,0 +1,98 @@
+package queues
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/job"
+	"io"
+	"testing"
+)
+
+type recordingQueue struct {
+	pushed  [][]byte
+	removed [][]byte
+}
+
+func (r *recordingQueue) PushItems(ctx context.Context, job job.Job, stdOut, stdErr io.ReadWriter, errChan chan error) {
+	defer close(errChan)
+	r.pushed = append(r.pushed, job.Raw())
+}
+
+func (r *recordingQueue) RemoveItems(ctx context.Context, job job.Job, stdOut, stdErr io.ReadWriter, errChan chan error) {
+	defer close(errChan)
+	r.removed = append(r.removed, job.Original())
+}
+
+// unremovableQueue can't remove jobs.
+type unremovableQueue struct{}
+
+func (unremovableQueue) RemoveItems(ctx context.Context, job job.Job, stdOut, stdErr io.ReadWriter, errChan chan error) {
+	defer close(errChan)
+	errChan <- errors.New("connection refused")
+}
+
+func TestMakeJob(t *testing.T) {
+	ctx := context.Background()
+	configuration := &job.Configuration{Type: job.JsonRawJobType, Fields: []job.Field{
+		{Name: "class", Type: job.StringField, Required: true},
+	}}
+	builder := job.NewBuilder(configuration)
+
+	t.Run("success", func(t *testing.T) {
+		queue := &recordingQueue{}
+		opts := NewOptions(WithJobConfiguration(configuration), WithQuarantine(queue))
+
+		j := MakeJob(ctx, builder, opts, queue, []byte(`{"class":"WebhookWorker"}`))
+		require.NotNil(t, j)
+		assert.Empty(t, queue.pushed)
+	})
+	t.Run("invalid job is quarantined", func(t *testing.T) {
+		source := &recordingQueue{}
+		quarantine := &recordingQueue{}
+		opts := NewOptions(WithJobConfiguration(configuration), WithQuarantine(quarantine))
+
+		assert.Nil(t, MakeJob(ctx, builder, opts, source, []byte(`{"class":1}`)))
+		assert.Equal(t, [][]byte{[]byte(`{"class":1}`)}, quarantine.pushed)
+		assert.Equal(t, [][]byte{[]byte(`{"class":1}`)}, source.removed)
+	})
+	t.Run("undecodable job is quarantined", func(t *testing.T) {
+		source := &recordingQueue{}
+		quarantine := &recordingQueue{}
+		opts := NewOptions(WithJobConfiguration(configuration), WithQuarantine(quarantine))
+
+		assert.Nil(t, MakeJob(ctx, builder, opts, source, []byte(`not json`)))
+		assert.Equal(t, [][]byte{[]byte(`not json`)}, quarantine.pushed)
+	})
+	t.Run("invalid job is skipped without quarantine", func(t *testing.T) {
+		source := &recordingQueue{}
+
+		assert.Nil(t, MakeJob(ctx, builder, NewOptions(WithJobConfiguration(configuration)), source, []byte(`{}`)))
+		assert.Empty(t, source.removed)
+	})
+}
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+	builder := job.NewBuilder(&job.Configuration{Type: job.JsonRawJobType})
+	j, err := builder.MakeJob([]byte(`{"jid":"1"}`))
+	require.Nil(t, err)
+
+	t.Run("success", func(t *testing.T) {
+		source := &recordingQueue{}
+		destination := &recordingQueue{}
+
+		require.Nil(t, Move(ctx, destination, source, j))
+		assert.Equal(t, [][]byte{[]byte(`{"jid":"1"}`)}, destination.pushed)
+		assert.Equal(t, [][]byte{[]byte(`{"jid":"1"}`)}, source.removed)
+	})
+	t.Run("failure", func(t *testing.T) {
+		destination := &recordingQueue{}
+
+		err := Move(ctx, destination, unremovableQueue{}, j)
+		assert.ErrorIs(t, err, ErrNotRemoved)
+		assert.EqualError(t, err, "could not remove moved job: connection refused")
+		assert.Len(t, destination.pushed, 1)
+	})
+}
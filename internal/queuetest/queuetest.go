@@ -0,0 +1,18 @@
+// Package queuetest holds the fixtures the tests of queues share.
+package queuetest
+
+import (
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/job"
+	"testing"
+)
+
+// MakeJob makes a json job from payload, failing t when it can't be decoded.
+func MakeJob(t testing.TB, payload string) job.Job {
+	t.Helper()
+
+	j, err := job.NewBuilder(&job.Configuration{Type: job.JsonRawJobType}).MakeJob([]byte(payload))
+	require.Nil(t, err)
+
+	return j
+}
@@ -0,0 +1,90 @@
+package transforms
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/thethan/goqueue/internal/job"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// valueTemplate renders a set value from the job being transformed.
+//
+// Templates have access to:
+//
+//	{{ value "retry_count" }}  the value of a job field
+//	{{ now }}                 the current unix time in seconds, as sidekiq stores it
+//
+// The rendered string is stored as a number or bool when it parses as one.
+type valueTemplate struct {
+	tmpl *template.Template
+}
+
+func parseValueTemplate(name string, value interface{}) (*valueTemplate, error) {
+	str, ok := value.(string)
+	if !ok || !strings.Contains(str, "{{") {
+		return nil, nil
+	}
+
+	tmpl, err := template.New(name).Funcs(template.FuncMap{
+		// replaced per job in render
+		"value": func(string) (interface{}, error) { return nil, nil },
+		"now":   now,
+	}).Parse(str)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse template for %s: %w", name, err)
+	}
+
+	return &valueTemplate{tmpl: tmpl}, nil
+}
+
+func (v *valueTemplate) render(j job.Job) (interface{}, error) {
+	tmpl, err := v.tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl.Funcs(template.FuncMap{
+		"value": func(key string) (interface{}, error) {
+			val, err := j.GetValue(key)
+			if errors.Is(err, job.ErrFieldNotFound) || (err == nil && !val.IsValid()) {
+				return nil, nil
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			return val.Interface(), nil
+		},
+	})
+
+	buf := bytes.NewBuffer([]byte{})
+	if err := tmpl.Execute(buf, nil); err != nil {
+		return nil, fmt.Errorf("could not render template for %s: %w", v.tmpl.Name(), err)
+	}
+
+	return parseRendered(buf.String()), nil
+}
+
+func now() float64 {
+	return float64(time.Now().UnixNano()) / float64(time.Second)
+}
+
+func parseRendered(str string) interface{} {
+	if f, err := strconv.ParseFloat(str, 64); err == nil {
+		return f
+	}
+
+	switch str {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	return str
+}
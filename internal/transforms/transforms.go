@@ -0,0 +1,222 @@
+package transforms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/thethan/goqueue/internal/executers"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"io"
+	"math/big"
+	"reflect"
+)
+
+type Action string
+
+const (
+	Set       Action = "set"
+	Unset     Action = "unset"
+	Increment Action = "increment"
+	Rename    Action = "rename"
+	Copy      Action = "copy"
+)
+
+// Transformer applies a list of steps to a job before handing it to the next
+// function in a decision tree branch. The changes are made to the job itself,
+// so they persist for the rest of the pipeline, not only the branch.
+type Transformer struct {
+	name  string
+	steps []Step
+}
+
+func NewTransformer(name string, steps ...Step) *Transformer {
+	return &Transformer{name: name, steps: steps}
+}
+
+// Apply applies the steps to a clone of j and only copies the result onto j
+// once they have all succeeded, so a failing step leaves j as it was. j
+// itself is kept as queues track the jobs they hand out by pointer.
+func (t *Transformer) Apply(ctx context.Context, j job.Job) error {
+	clone := j.Clone()
+	for idx := range t.steps {
+		if err := t.steps[idx].Apply(ctx, clone); err != nil {
+			return fmt.Errorf("transform %s step %d: %w", t.name, idx, err)
+		}
+	}
+
+	return j.Replace(clone)
+}
+
+func (t *Transformer) Middleware() executers.FilterMiddleware {
+	return func(next executers.ExecFunc) executers.ExecFunc {
+		return func(ctx context.Context, job job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+			defer func() {
+				close(errChan)
+			}()
+
+			if err := t.Apply(ctx, job); err != nil {
+				errChan <- err
+				return
+			}
+
+			logs.Debug(ctx, "transformed job", logs.WithValue("transform", t.name))
+
+			nextErrChan := make(chan error)
+			go next(ctx, job, stdOut, stdErr, nextErrChan)
+			for err := range nextErrChan {
+				errChan <- err
+			}
+		}
+	}
+}
+
+type Step struct {
+	action Action
+	path   string
+	from   string
+	value  interface{}
+	tmpl   *valueTemplate
+}
+
+// NewStep builds a single transform step. path is the field being written,
+// from is the source field for rename and copy, and value is the value for
+// set or the amount for increment. String values containing "{{" are parsed
+// as templates and rendered against the job when the step is applied.
+func NewStep(action Action, path, from string, value interface{}) (Step, error) {
+	if path == "" {
+		return Step{}, errors.New("transform step requires a path")
+	}
+
+	parsed, err := job.ParsePath(path)
+	if err != nil {
+		return Step{}, err
+	}
+
+	// a wildcard can be set or unset in one go but the other actions read a
+	// single value
+	if parsed.HasWildcard() && action != Set && action != Unset {
+		return Step{}, fmt.Errorf("%s does not support wildcards in %s", action, path)
+	}
+
+	step := Step{action: action, path: path, from: from, value: value}
+
+	switch action {
+	case Set:
+		tmpl, err := parseValueTemplate(path, value)
+		if err != nil {
+			return Step{}, err
+		}
+
+		step.tmpl = tmpl
+	case Increment:
+		if value == nil {
+			step.value = float64(1)
+		}
+
+		if _, ok := toFloat(step.value); !ok {
+			return Step{}, fmt.Errorf("increment of %s must be a number", path)
+		}
+	case Rename, Copy:
+		if from == "" {
+			return Step{}, fmt.Errorf("%s to %s requires a from field", action, path)
+		}
+
+		parsedFrom, err := job.ParsePath(from)
+		if err != nil {
+			return Step{}, err
+		}
+
+		if parsedFrom.HasWildcard() {
+			return Step{}, fmt.Errorf("%s does not support wildcards in %s", action, from)
+		}
+	case Unset:
+	default:
+		return Step{}, fmt.Errorf("unknown transform action %q", action)
+	}
+
+	return step, nil
+}
+
+func (s Step) Apply(ctx context.Context, j job.Job) error {
+	switch s.action {
+	case Set:
+		value := s.value
+		if s.tmpl != nil {
+			rendered, err := s.tmpl.render(j)
+			if err != nil {
+				return err
+			}
+
+			value = rendered
+		}
+
+		return j.SetValue(s.path, value)
+	case Unset:
+		return j.Delete(s.path)
+	case Increment:
+		current := new(big.Rat)
+		val, err := j.GetValue(s.path)
+		if err != nil && !errors.Is(err, job.ErrFieldNotFound) {
+			return err
+		}
+
+		if val.IsValid() {
+			rat, ok := job.ToRat(val)
+			if !ok {
+				return fmt.Errorf("could not increment %s: value is %s", s.path, val.Kind())
+			}
+
+			current = rat
+		}
+
+		by, _ := job.ToRat(reflect.ValueOf(s.value))
+
+		return j.SetValue(s.path, ratValue(current.Add(current, by)))
+	case Rename, Copy:
+		val, err := j.GetValue(s.from)
+		if errors.Is(err, job.ErrFieldNotFound) {
+			logs.Debug(ctx, "transform source field missing", logs.WithValue("from", s.from))
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var value interface{}
+		if val.IsValid() {
+			value = val.Interface()
+		}
+
+		if err := j.SetValue(s.path, value); err != nil {
+			return err
+		}
+
+		if s.action == Rename {
+			return j.Delete(s.from)
+		}
+	}
+
+	return nil
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	return job.ToFloat64(reflect.ValueOf(value))
+}
+
+// ratValue converts the result of an increment back to a value jobs can
+// hold, keeping integers exact.
+func ratValue(rat *big.Rat) interface{} {
+	if !rat.IsInt() {
+		f, _ := rat.Float64()
+		return f
+	}
+
+	if rat.Num().IsInt64() {
+		return rat.Num().Int64()
+	}
+
+	return json.Number(rat.Num().String())
+}
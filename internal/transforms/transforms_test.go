@@ -0,0 +1,131 @@
+package transforms
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	job2 "github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/queuetest"
+	"testing"
+)
+
+func TestTransformer_Apply(t *testing.T) {
+	ctx := context.Background()
+	t.Run("success", func(t *testing.T) {
+		t.Run("sidekiq retry reset", func(t *testing.T) {
+			j := queuetest.MakeJob(t, `{"class":"WebhookWorker","queue":"retry","retry_count":4,"error_message":"boom","enqueued_at":1}`)
+
+			steps := make([]Step, 0)
+			for _, s := range []struct {
+				action Action
+				path   string
+				from   string
+				value  interface{}
+			}{
+				{Set, "retry_count", "", 0},
+				{Unset, "error_message", "", nil},
+				{Set, "queue", "", "default"},
+				{Set, "enqueued_at", "", "{{ now }}"},
+			} {
+				step, err := NewStep(s.action, s.path, s.from, s.value)
+				require.Nil(t, err)
+				steps = append(steps, step)
+			}
+
+			require.Nil(t, NewTransformer("reset", steps...).Apply(ctx, j))
+
+			retryCount, err := j.GetValue("retry_count")
+			require.Nil(t, err)
+			assert.Equal(t, "0", job2.FormatNumber(retryCount))
+
+			_, err = j.GetValue("error_message")
+			assert.ErrorIs(t, err, job2.ErrFieldNotFound)
+
+			enqueuedAt, err := j.GetValue("enqueued_at")
+			require.Nil(t, err)
+			now, ok := job2.ToFloat64(enqueuedAt)
+			require.True(t, ok)
+			assert.Greater(t, now, float64(1))
+
+			assert.NotContains(t, string(j.Raw()), "error_message")
+			assert.Contains(t, string(j.Original()), "error_message")
+		})
+		t.Run("increment rename and copy", func(t *testing.T) {
+			j := queuetest.MakeJob(t, `{"retry_count":2,"payload":{"id":"abc"}}`)
+
+			increment, err := NewStep(Increment, "retry_count", "", nil)
+			require.Nil(t, err)
+			rename, err := NewStep(Rename, "id", "payload.id", nil)
+			require.Nil(t, err)
+			cp, err := NewStep(Copy, "meta.original_id", "id", nil)
+			require.Nil(t, err)
+
+			require.Nil(t, NewTransformer("move", increment, rename, cp).Apply(ctx, j))
+
+			assert.JSONEq(t, `{"retry_count":3,"payload":{},"id":"abc","meta":{"original_id":"abc"}}`, string(j.Raw()))
+		})
+		t.Run("increment is exact", func(t *testing.T) {
+			j := queuetest.MakeJob(t, `{"id":9223372036854775807,"amount":0.1}`)
+
+			id, err := NewStep(Increment, "id", "", nil)
+			require.Nil(t, err)
+			amount, err := NewStep(Increment, "amount", "", 0.2)
+			require.Nil(t, err)
+
+			require.Nil(t, NewTransformer("exact", id, amount).Apply(ctx, j))
+
+			assert.Equal(t, `{"id":9223372036854775808,"amount":0.3}`, string(j.Raw()))
+		})
+		t.Run("templated value from job", func(t *testing.T) {
+			j := queuetest.MakeJob(t, `{"queue":"critical"}`)
+
+			step, err := NewStep(Set, "original_queue", "", `queue:{{ value "queue" }}`)
+			require.Nil(t, err)
+			require.Nil(t, step.Apply(ctx, j))
+
+			val, err := j.GetValue("original_queue")
+			require.Nil(t, err)
+			assert.Equal(t, "queue:critical", val.String())
+		})
+	})
+	t.Run("failure", func(t *testing.T) {
+		t.Run("unknown action", func(t *testing.T) {
+			_, err := NewStep("explode", "retry_count", "", nil)
+			assert.NotNil(t, err)
+		})
+		t.Run("increment non number", func(t *testing.T) {
+			j := queuetest.MakeJob(t, `{"retry_count":"two"}`)
+
+			step, err := NewStep(Increment, "retry_count", "", 1)
+			require.Nil(t, err)
+			assert.NotNil(t, step.Apply(ctx, j))
+		})
+		t.Run("failing step leaves the job as it was", func(t *testing.T) {
+			j := queuetest.MakeJob(t, `{"queue":"retry","retry_count":"two"}`)
+
+			set, err := NewStep(Set, "queue", "", "default")
+			require.Nil(t, err)
+			increment, err := NewStep(Increment, "retry_count", "", 1)
+			require.Nil(t, err)
+
+			assert.NotNil(t, NewTransformer("reset", set, increment).Apply(ctx, j))
+
+			queue, err := j.GetValue("queue")
+			require.Nil(t, err)
+			assert.Equal(t, "retry", queue.String())
+			assert.Equal(t, j.Original(), j.Raw())
+		})
+		t.Run("invalid path", func(t *testing.T) {
+			_, err := NewStep(Set, "args[", "", 1)
+			assert.NotNil(t, err)
+		})
+		t.Run("wildcard increment", func(t *testing.T) {
+			_, err := NewStep(Increment, "args[*].attempt", "", 1)
+			assert.NotNil(t, err)
+		})
+		t.Run("bad template", func(t *testing.T) {
+			_, err := NewStep(Set, "queue", "", "{{ value ")
+			assert.NotNil(t, err)
+		})
+	})
+}
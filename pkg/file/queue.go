@@ -0,0 +1,604 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPattern = "*.jsonl"
+	// checkpointSuffix is added to a file's path for its checkpoint, a
+	// directory's checkpoint is the hidden file .checkpoint in it
+	checkpointSuffix = ".checkpoint"
+	// rotatedFormat is the time added to the name of a rotated file
+	rotatedFormat = "20060102T150405.000000000"
+)
+
+// Configuration of a Queue.
+type Configuration struct {
+	// Path is a file of one job per line, or a directory of them
+	Path string
+	// Pattern matches the files read from a directory, *.jsonl by default
+	Pattern string
+	// Checkpoint is the file the offsets processed up to are kept in,
+	// <path>.checkpoint for a file and <path>/.checkpoint for a directory
+	// by default
+	Checkpoint string
+	// MaxBytes is the size pushed files are rotated at, zero to never rotate
+	MaxBytes int64
+}
+
+// position is where a job's line is in a file.
+type position struct {
+	name       string
+	start, end int64
+}
+
+// Queue reads jobs a line at a time from a file, or from every file in a
+// directory, following them as lines are added. The offset of each file
+// processed up to is checkpointed once the pipeline has processed its jobs,
+// so a restarted queue resumes where it left off. Jobs that failed hold the
+// checkpoint back, so they and the lines after them are read again when the
+// queue restarts. Pushed jobs are appended
+// to the file, which is renamed with the time added once it reaches
+// MaxBytes.
+type Queue struct {
+	jobbuilder    *job.Builder
+	options       queues.Options
+	configuration Configuration
+	now           func() time.Time
+
+	mu sync.Mutex
+	// checkpoint is the offset each file has been processed up to, read the
+	// offset it has been read up to, both keyed by the file's name
+	checkpoint map[string]int64
+	read       map[string]int64
+	// pending are the lines of jobs handed out and not yet processed
+	pending map[job.Job]position
+
+	pushMu sync.Mutex
+}
+
+func NewQueue(configuration *Configuration, opts ...queues.Option) *Queue {
+	options := queues.NewOptions(opts...)
+	jobJuilder := job.NewBuilder(options.JobConfiguration)
+
+	q := &Queue{
+		jobbuilder: jobJuilder,
+		options:    options,
+		now:        time.Now,
+		checkpoint: map[string]int64{},
+		read:       map[string]int64{},
+		pending:    map[job.Job]position{},
+	}
+	if configuration != nil {
+		q.configuration = *configuration
+	}
+
+	if q.configuration.Pattern == "" {
+		q.configuration.Pattern = defaultPattern
+	}
+
+	return q
+}
+
+// directory returns the directory the queue's files are in and whether the
+// queue reads all of them.
+func (q *Queue) directory() (string, bool) {
+	info, err := os.Stat(q.configuration.Path)
+	if err == nil && info.IsDir() {
+		return q.configuration.Path, true
+	}
+
+	return filepath.Dir(q.configuration.Path), false
+}
+
+func (q *Queue) checkpointPath() string {
+	if q.configuration.Checkpoint != "" {
+		return q.configuration.Checkpoint
+	}
+
+	if dir, ok := q.directory(); ok {
+		return filepath.Join(dir, checkpointSuffix)
+	}
+
+	return q.configuration.Path + checkpointSuffix
+}
+
+func (q *Queue) loadCheckpoint() error {
+	checkpoint, err := q.readCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for name, offset := range checkpoint {
+		q.checkpoint[name] = offset
+		q.read[name] = offset
+	}
+
+	return nil
+}
+
+// readCheckpoint returns the offsets in the checkpoint file, none when there
+// isn't one yet.
+func (q *Queue) readCheckpoint() (map[string]int64, error) {
+	checkpoint := map[string]int64{}
+	bts, err := os.ReadFile(q.checkpointPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bts, &checkpoint); err != nil {
+		return nil, fmt.Errorf("could not read checkpoint %s: %w", q.checkpointPath(), err)
+	}
+
+	return checkpoint, nil
+}
+
+func (q *Queue) GetItems(ctx context.Context, jobChan chan<- job.Job) error {
+	defer func() {
+		close(jobChan)
+	}()
+
+	if err := q.loadCheckpoint(); err != nil {
+		return err
+	}
+
+	dir, _ := q.directory()
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = watcher.Close()
+	}()
+
+	if err := watcher.Add(dir); err != nil {
+		return err
+	}
+
+	for {
+		names, err := q.files()
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			if err := q.readFile(ctx, dir, name, jobChan); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			return err
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+		}
+	}
+}
+
+// files returns the names of the files to read, in order.
+func (q *Queue) files() ([]string, error) {
+	dir, ok := q.directory()
+	if !ok {
+		if _, err := os.Stat(q.configuration.Path); errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return []string{filepath.Base(q.configuration.Path)}, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, q.configuration.Pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if path == q.checkpointPath() {
+			continue
+		}
+
+		names = append(names, filepath.Base(path))
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// readFile hands out the jobs on the complete lines added to a file since it
+// was last read.
+func (q *Queue) readFile(ctx context.Context, dir, name string, jobChan chan<- job.Job) error {
+	f, err := os.Open(filepath.Join(dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	offset := q.read[name]
+	if info.Size() < offset {
+		logs.Warn(ctx, "file was truncated, reading it from the start", logs.WithValue("file", name))
+		offset, q.read[name], q.checkpoint[name] = 0, 0, 0
+	}
+	q.mu.Unlock()
+
+	if info.Size() == offset {
+		return nil
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		// a line without a newline is still being written
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		pos := position{name: name, start: offset, end: offset + int64(len(line))}
+		offset = pos.end
+
+		var j job.Job
+		if payload := bytes.TrimSpace(line); len(payload) > 0 {
+			j = queues.MakeJob(ctx, q.jobbuilder, q.options, lineSource{}, payload)
+		}
+
+		q.mu.Lock()
+		q.read[name] = pos.end
+		if j != nil {
+			q.pending[j] = pos
+		}
+		err = q.advance(name)
+		q.mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+
+		if j == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case jobChan <- j:
+		}
+	}
+}
+
+// Count returns how many jobs are on the complete lines after the
+// checkpoint, the jobs a restarted queue would read.
+func (q *Queue) Count(ctx context.Context) (int64, error) {
+	n := int64(0)
+	err := q.scan(func(payload []byte) bool {
+		n++
+		return true
+	})
+
+	return n, err
+}
+
+// Page returns count of the jobs Count counts from offset, without handing
+// them out or moving the checkpoint.
+func (q *Queue) Page(ctx context.Context, offset, count int64) ([]job.Job, error) {
+	jobs := make([]job.Job, 0, count)
+	idx := int64(0)
+	err := q.scan(func(payload []byte) bool {
+		if idx >= offset {
+			if j := queues.PageJob(ctx, q.jobbuilder, payload); j != nil {
+				jobs = append(jobs, j)
+			}
+		}
+
+		idx++
+
+		return idx < offset+count
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// scan calls fn with the payload of each complete line after the checkpoint
+// until it returns false.
+func (q *Queue) scan(fn func(payload []byte) bool) error {
+	checkpoint, err := q.readCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	names, err := q.files()
+	if err != nil {
+		return err
+	}
+
+	dir, _ := q.directory()
+	for _, name := range names {
+		more, err := scanFile(filepath.Join(dir, name), checkpoint[name], fn)
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanFile calls fn with the payload of each complete line of the file at
+// path from offset, and reports whether fn wanted more.
+func scanFile(path string, offset int64, fn func(payload []byte) bool) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	// a truncated file is read from the start
+	if info.Size() < offset {
+		offset = 0
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		payload := bytes.TrimSpace(line)
+		if len(payload) == 0 {
+			continue
+		}
+
+		if !fn(payload) {
+			return false, nil
+		}
+	}
+}
+
+// advance moves the checkpoint of a file up to the first line still being
+// processed, or else up to where it has been read, with q.mu held.
+func (q *Queue) advance(name string) error {
+	offset := q.read[name]
+	for _, pos := range q.pending {
+		if pos.name == name && pos.start < offset {
+			offset = pos.start
+		}
+	}
+
+	if offset == q.checkpoint[name] {
+		return nil
+	}
+
+	q.checkpoint[name] = offset
+
+	bts, err := json.Marshal(q.checkpoint)
+	if err != nil {
+		return err
+	}
+
+	path := q.checkpointPath()
+	if err := os.WriteFile(path+".tmp", bts, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// finish marks the job processed.
+func (q *Queue) finish(j job.Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	pos, ok := q.pending[j]
+	if !ok {
+		return nil
+	}
+
+	delete(q.pending, j)
+
+	return q.advance(pos.name)
+}
+
+// Ack marks the job processed when it was processed successfully. Failed
+// jobs stay pending so the checkpoint stays behind their line.
+func (q *Queue) Ack(ctx context.Context, j job.Job, success bool) error {
+	if !success {
+		logs.Warn(ctx, "job failed, keeping the checkpoint behind it", logs.WithValue("path", q.configuration.Path))
+		return nil
+	}
+
+	return q.finish(j)
+}
+
+// RemoveItems marks the job processed. Lines are never removed from files,
+// so jobs read by Page, which aren't handed out, can't be removed.
+func (q *Queue) RemoveItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	q.mu.Lock()
+	_, ok := q.pending[j]
+	q.mu.Unlock()
+
+	if !ok {
+		errChan <- errors.New("job was not handed out, lines can't be removed from files")
+		return
+	}
+
+	if err := q.finish(j); err != nil {
+		errChan <- err
+	}
+}
+
+// PushItems appends the job to the file as a line, rotating the file first
+// when the line would take it past MaxBytes.
+func (q *Queue) PushItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	if _, ok := q.directory(); ok {
+		errChan <- fmt.Errorf("can not push to the directory %s", q.configuration.Path)
+		return
+	}
+
+	bts, err := q.line(j)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	q.pushMu.Lock()
+	defer q.pushMu.Unlock()
+
+	if err := q.rotate(int64(len(bts))); err != nil {
+		errChan <- err
+		return
+	}
+
+	f, err := os.OpenFile(q.configuration.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	if _, err := f.Write(bts); err != nil {
+		_ = f.Close()
+		errChan <- err
+		return
+	}
+
+	if err := f.Close(); err != nil {
+		errChan <- err
+	}
+}
+
+// line encodes the job on a single line.
+func (q *Queue) line(j job.Job) ([]byte, error) {
+	bts, err := q.jobbuilder.Encode(j)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.ContainsAny(bts, "\r\n") {
+		compacted := bytes.NewBuffer(nil)
+		if err := json.Compact(compacted, bts); err != nil {
+			return nil, errors.New("jobs pushed to a file must be encoded on a single line")
+		}
+
+		bts = compacted.Bytes()
+	}
+
+	return append(bts, '\n'), nil
+}
+
+// rotate renames the file with the time added when size more bytes would
+// take it past MaxBytes.
+func (q *Queue) rotate(size int64) error {
+	if q.configuration.MaxBytes <= 0 {
+		return nil
+	}
+
+	info, err := os.Stat(q.configuration.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 || info.Size()+size <= q.configuration.MaxBytes {
+		return nil
+	}
+
+	ext := filepath.Ext(q.configuration.Path)
+	rotated := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(q.configuration.Path, ext), q.now().UTC().Format(rotatedFormat), ext)
+
+	return os.Rename(q.configuration.Path, rotated)
+}
+
+// lineSource is the source of jobs quarantined before they are handed out.
+// Their lines are passed over like any other.
+type lineSource struct{}
+
+func (lineSource) RemoveItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	close(errChan)
+}
@@ -0,0 +1,239 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/internal/queuetest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// read returns the first n jobs q hands out, calling during once the first
+// job has been handed out, by which time q is watching its files.
+func read(t *testing.T, q *Queue, n int, during func()) []job.Job {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	jobChan := make(chan job.Job)
+	go func() {
+		_ = q.GetItems(ctx, jobChan)
+	}()
+
+	jobs := make([]job.Job, 0, n)
+	for j := range jobChan {
+		if len(jobs) < n {
+			jobs = append(jobs, j)
+		}
+
+		if len(jobs) == 1 && during != nil {
+			during()
+			during = nil
+		}
+
+		if len(jobs) == n {
+			cancel()
+		}
+	}
+
+	require.Len(t, jobs, n)
+
+	return jobs
+}
+
+func originals(jobs []job.Job) []string {
+	payloads := make([]string, len(jobs))
+	for idx := range jobs {
+		payloads[idx] = string(jobs[idx].Original())
+	}
+
+	return payloads
+}
+
+func write(t *testing.T, path, contents string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	require.Nil(t, err)
+	_, err = f.WriteString(contents)
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+}
+
+func TestQueue_GetItems(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jobs.jsonl")
+		write(t, path, "{\"jid\":\"1\"}\n\n{\"jid\":\"2\"}\n{\"jid\":")
+
+		q := NewQueue(&Configuration{Path: path})
+		jobs := read(t, q, 3, func() {
+			// the partial line is read once it is finished
+			write(t, path, "\"3\"}\n")
+		})
+
+		assert.Equal(t, []string{`{"jid":"1"}`, `{"jid":"2"}`, `{"jid":"3"}`}, originals(jobs))
+	})
+
+	t.Run("resumes from the checkpoint", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jobs.jsonl")
+		write(t, path, "{\"jid\":\"1\"}\n{\"jid\":\"2\"}\n{\"jid\":\"3\"}\n")
+
+		q := NewQueue(&Configuration{Path: path})
+		jobs := read(t, q, 2, nil)
+		require.Nil(t, q.Ack(context.Background(), jobs[0], true))
+
+		// the second job was handed out but not processed
+		jobs = read(t, NewQueue(&Configuration{Path: path}), 2, nil)
+		assert.Equal(t, []string{`{"jid":"2"}`, `{"jid":"3"}`}, originals(jobs))
+
+		q = NewQueue(&Configuration{Path: path})
+		jobs = read(t, q, 2, nil)
+		for idx := range jobs {
+			require.Nil(t, queues.Collect(func(errChan chan error) {
+				q.RemoveItems(context.Background(), jobs[idx], &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			}))
+		}
+
+		write(t, path, "{\"jid\":\"4\"}\n")
+		jobs = read(t, NewQueue(&Configuration{Path: path}), 1, nil)
+		assert.Equal(t, []string{`{"jid":"4"}`}, originals(jobs))
+
+		checkpoint, err := os.ReadFile(path + ".checkpoint")
+		require.Nil(t, err)
+		assert.JSONEq(t, `{"jobs.jsonl":36}`, string(checkpoint))
+	})
+
+	t.Run("watches a directory", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, filepath.Join(dir, "a.jsonl"), "{\"jid\":\"1\"}\n")
+		write(t, filepath.Join(dir, "ignored.txt"), "{\"jid\":\"0\"}\n")
+
+		q := NewQueue(&Configuration{Path: dir})
+		jobs := read(t, q, 2, func() {
+			write(t, filepath.Join(dir, "b.jsonl"), "{\"jid\":\"2\"}\n")
+		})
+		assert.Equal(t, []string{`{"jid":"1"}`, `{"jid":"2"}`}, originals(jobs))
+
+		for idx := range jobs {
+			require.Nil(t, q.Ack(context.Background(), jobs[idx], true))
+		}
+
+		checkpoint, err := os.ReadFile(filepath.Join(dir, ".checkpoint"))
+		require.Nil(t, err)
+		assert.JSONEq(t, `{"a.jsonl":12,"b.jsonl":12}`, string(checkpoint))
+	})
+
+	t.Run("reads failed jobs again", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jobs.jsonl")
+		write(t, path, "{\"jid\":\"1\"}\n{\"jid\":\"2\"}\n{\"jid\":\"3\"}\n")
+
+		q := NewQueue(&Configuration{Path: path})
+		jobs := read(t, q, 3, nil)
+		require.Nil(t, q.Ack(context.Background(), jobs[0], true))
+		require.Nil(t, q.Ack(context.Background(), jobs[1], false))
+		require.Nil(t, q.Ack(context.Background(), jobs[2], true))
+
+		jobs = read(t, NewQueue(&Configuration{Path: path}), 2, nil)
+		assert.Equal(t, []string{`{"jid":"2"}`, `{"jid":"3"}`}, originals(jobs))
+
+		checkpoint, err := os.ReadFile(path + ".checkpoint")
+		require.Nil(t, err)
+		assert.JSONEq(t, `{"jobs.jsonl":12}`, string(checkpoint))
+	})
+
+	t.Run("reads a truncated file from the start", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jobs.jsonl")
+		write(t, path, "{\"jid\":\"1\"}\n{\"jid\":\"2\"}\n")
+		require.Nil(t, os.WriteFile(path+".checkpoint", []byte(`{"jobs.jsonl":24}`), 0o644))
+		require.Nil(t, os.Truncate(path, 0))
+		write(t, path, "{\"jid\":\"3\"}\n")
+
+		jobs := read(t, NewQueue(&Configuration{Path: path}), 1, nil)
+		assert.Equal(t, []string{`{"jid":"3"}`}, originals(jobs))
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jobs.jsonl")
+		require.Nil(t, os.WriteFile(path+".checkpoint", []byte("not json"), 0o644))
+
+		err := NewQueue(&Configuration{Path: path}).GetItems(context.Background(), make(chan job.Job))
+		assert.NotNil(t, err)
+	})
+}
+
+func TestQueue_Page(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jobs.jsonl")
+		write(t, path, "{\"jid\":\"1\"}\n{\"jid\":\"2\"}\n\n{\"jid\":\"3\"}\n{\"jid\":\"4\"}\n{\"jid\":")
+		require.Nil(t, os.WriteFile(path+".checkpoint", []byte(`{"jobs.jsonl":12}`), 0o644))
+
+		q := NewQueue(&Configuration{Path: path})
+		n, err := q.Count(ctx)
+		require.Nil(t, err)
+		assert.Equal(t, int64(3), n)
+
+		jobs, err := q.Page(ctx, 1, 5)
+		require.Nil(t, err)
+		assert.Equal(t, []string{`{"jid":"3"}`, `{"jid":"4"}`}, originals(jobs))
+
+		// paging doesn't move the checkpoint
+		checkpoint, err := os.ReadFile(path + ".checkpoint")
+		require.Nil(t, err)
+		assert.JSONEq(t, `{"jobs.jsonl":12}`, string(checkpoint))
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jobs.jsonl")
+		write(t, path, "{\"jid\":\"1\"}\n")
+
+		q := NewQueue(&Configuration{Path: path})
+		jobs, err := q.Page(ctx, 0, 1)
+		require.Nil(t, err)
+
+		// a paged job wasn't handed out, so its line can't be removed
+		err = queues.Collect(func(errChan chan error) {
+			q.RemoveItems(ctx, jobs[0], &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestQueue_PushItems(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "out.jsonl")
+
+		q := NewQueue(&Configuration{Path: path, MaxBytes: 30})
+		q.now = func() time.Time {
+			return time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+		}
+
+		for _, payload := range []string{"{\n  \"jid\": \"1\"\n}", `{"jid":"2"}`, `{"jid":"3"}`} {
+			require.Nil(t, queues.Collect(func(errChan chan error) {
+				q.PushItems(context.Background(), queuetest.MakeJob(t, payload), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			}))
+		}
+
+		rotated, err := os.ReadFile(filepath.Join(dir, "out-20220102T030405.000000000.jsonl"))
+		require.Nil(t, err)
+		assert.Equal(t, "{\"jid\":\"1\"}\n{\"jid\":\"2\"}\n", string(rotated))
+
+		current, err := os.ReadFile(path)
+		require.Nil(t, err)
+		assert.Equal(t, "{\"jid\":\"3\"}\n", string(current))
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		q := NewQueue(&Configuration{Path: t.TempDir()})
+
+		err := queues.Collect(func(errChan chan error) {
+			q.PushItems(context.Background(), queuetest.MakeJob(t, `{"jid":"1"}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		})
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,384 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxBodyBytes = 1 << 20
+	defaultBuffer       = 100
+	shutdownTimeout     = 5 * time.Second
+	// retryAfter is the seconds clients are told to wait when a source's
+	// buffer is full
+	retryAfter = "1"
+)
+
+// Configuration of a Server.
+type Configuration struct {
+	// Addr is the address the server listens on, for example :8080
+	Addr string
+	// Token is the shared token requests authenticate with, sent as
+	// Authorization: Bearer <token>
+	Token string
+	// MaxBodyBytes limits the size of a request, 1MiB by default
+	MaxBodyBytes int64
+}
+
+// Server accepts jobs for its sources over http, at POST
+// /queues/{name}/jobs. The body is a job or an array of jobs, as json. It
+// listens while at least one of its sources is being read.
+type Server struct {
+	configuration Configuration
+
+	mu      sync.Mutex
+	sources map[string]*Source
+	// reading is how many sources are being read
+	reading  int
+	server   *http.Server
+	listener net.Listener
+}
+
+func NewServer(configuration *Configuration) *Server {
+	s := &Server{sources: map[string]*Source{}}
+	if configuration != nil {
+		s.configuration = *configuration
+	}
+
+	if s.configuration.MaxBodyBytes <= 0 {
+		s.configuration.MaxBodyBytes = defaultMaxBodyBytes
+	}
+
+	return s
+}
+
+// Source returns the source of the jobs posted to /queues/{name}/jobs.
+// Accepted jobs wait in a buffer of up to buffer jobs, 100 when zero, until
+// the pipeline reads them. Jobs are always json, the format of the queue's
+// configuration is ignored.
+func (s *Server) Source(name string, buffer int, opts ...queues.Option) *Source {
+	if buffer <= 0 {
+		buffer = defaultBuffer
+	}
+
+	options := queues.NewOptions(opts...)
+	configuration := *options.JobConfiguration
+	configuration.Type = job.JsonRawJobType
+	options.JobConfiguration = &configuration
+
+	source := &Source{server: s, name: name, jobbuilder: job.NewBuilder(options.JobConfiguration), buffer: make(chan job.Job, buffer)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sources[name] = source
+
+	return source
+}
+
+// Addr is the address the server is listening on, empty when it isn't.
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return ""
+	}
+
+	return s.listener.Addr().String()
+}
+
+// start listens when the first source starts being read.
+func (s *Server) start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reading++
+	if s.reading > 1 {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", s.configuration.Addr)
+	if err != nil {
+		s.reading--
+		return err
+	}
+
+	s.listener = listener
+	s.server = &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logs.Error(ctx, "http ingestion server stopped", logs.WithError(err))
+		}
+	}(s.server)
+
+	logs.Info(ctx, "accepting jobs over http", logs.WithValue("addr", listener.Addr().String()))
+
+	return nil
+}
+
+// stop shuts the server down when the last source stops being read.
+func (s *Server) stop(ctx context.Context) {
+	s.mu.Lock()
+	s.reading--
+	if s.reading > 0 {
+		s.mu.Unlock()
+		return
+	}
+
+	// requests in progress need s.mu to finish, so the server is shut down
+	// without it
+	server := s.server
+	s.server, s.listener = nil, nil
+	s.mu.Unlock()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logs.Error(ctx, "could not shut down http ingestion server", logs.WithError(err))
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := sourceName(r.URL.Path)
+	if !ok {
+		respond(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		respond(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if !s.authenticated(r) {
+		respond(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	s.mu.Lock()
+	source, ok := s.sources[name]
+	s.mu.Unlock()
+
+	if !ok {
+		respond(w, http.StatusNotFound, fmt.Sprintf("no queue %s", name))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.configuration.MaxBodyBytes))
+	if err != nil {
+		respond(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+
+	jobs, err := source.makeJobs(body)
+	if err != nil {
+		respond(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch err := source.accept(jobs); {
+	case errors.Is(err, errFull):
+		w.Header().Set("Retry-After", retryAfter)
+		respond(w, http.StatusTooManyRequests, err.Error())
+		return
+	case errors.Is(err, errTooLarge):
+		respond(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	case err != nil:
+		respond(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]int{"accepted": len(jobs)})
+}
+
+// sourceName returns the name in a /queues/{name}/jobs path.
+func sourceName(path string) (string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "queues" || parts[1] == "" || parts[2] != "jobs" {
+		return "", false
+	}
+
+	return parts[1], true
+}
+
+func (s *Server) authenticated(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return s.configuration.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.configuration.Token)) == 1
+}
+
+func respond(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+var (
+	errFull     = errors.New("queue is full, retry later")
+	errTooLarge = errors.New("batch is larger than the queue's buffer")
+	errStopped  = errors.New("queue is not being read")
+)
+
+// Source hands the jobs posted to it to the pipeline reading it. Invalid
+// jobs are rejected rather than quarantined.
+type Source struct {
+	server     *Server
+	name       string
+	jobbuilder *job.Builder
+	buffer     chan job.Job
+
+	mu      sync.Mutex
+	reading bool
+}
+
+// makeJobs decodes a job or an array of jobs, failing if any is invalid.
+func (s *Source) makeJobs(body []byte) ([]job.Job, error) {
+	body = bytes.TrimSpace(body)
+	payloads := []json.RawMessage{body}
+	if bytes.HasPrefix(body, []byte("[")) {
+		payloads = nil
+		if err := json.Unmarshal(body, &payloads); err != nil {
+			return nil, fmt.Errorf("invalid batch: %w", err)
+		}
+	}
+
+	if len(payloads) == 0 || len(payloads[0]) == 0 {
+		return nil, errors.New("no jobs")
+	}
+
+	jobs := make([]job.Job, len(payloads))
+	for idx := range payloads {
+		j, err := s.jobbuilder.MakeJob(payloads[idx])
+		if err != nil {
+			return nil, fmt.Errorf("job %d: %w", idx, err)
+		}
+
+		jobs[idx] = j
+	}
+
+	return jobs, nil
+}
+
+// accept buffers all of the jobs or none of them.
+func (s *Source) accept(jobs []job.Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case !s.reading:
+		return errStopped
+	case len(jobs) > cap(s.buffer):
+		return errTooLarge
+	case len(jobs) > cap(s.buffer)-len(s.buffer):
+		return errFull
+	}
+
+	for _, j := range jobs {
+		s.buffer <- j
+	}
+
+	return nil
+}
+
+// GetItems serves the jobs posted to the source until ctx is done. Jobs
+// accepted by then are still handed out, for up to shutdownTimeout, while
+// new ones are refused.
+func (s *Source) GetItems(ctx context.Context, jobChan chan<- job.Job) error {
+	defer func() {
+		close(jobChan)
+	}()
+
+	if err := s.server.start(ctx); err != nil {
+		return err
+	}
+
+	defer s.server.stop(ctx)
+
+	s.setReading(true)
+	defer s.setReading(false)
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.drain(ctx, jobChan, nil)
+			return nil
+		case j := <-s.buffer:
+			select {
+			case <-ctx.Done():
+				s.drain(ctx, jobChan, j)
+				return nil
+			case jobChan <- j:
+			}
+		}
+	}
+}
+
+// drain stops accepting jobs and hands out j, when it isn't nil, and the
+// jobs left in the buffer. Jobs not read within shutdownTimeout are dropped.
+func (s *Source) drain(ctx context.Context, jobChan chan<- job.Job, j job.Job) {
+	s.setReading(false)
+
+	timeout := time.NewTimer(shutdownTimeout)
+	defer timeout.Stop()
+
+	for {
+		if j == nil {
+			select {
+			case j = <-s.buffer:
+			default:
+				return
+			}
+		}
+
+		select {
+		case jobChan <- j:
+			j = nil
+		case <-timeout.C:
+			logs.Warn(ctx, "dropping jobs accepted over http", logs.WithValue("queue", s.name), logs.WithValue("count", len(s.buffer)+1))
+			return
+		}
+	}
+}
+
+// PushItems accepts the job as if it had been posted.
+func (s *Source) PushItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	if err := s.accept([]job.Job{j}); err != nil {
+		errChan <- err
+	}
+}
+
+// RemoveItems does nothing, jobs leave the source when they are read.
+func (s *Source) RemoveItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	close(errChan)
+}
+
+func (s *Source) setReading(reading bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reading = reading
+}
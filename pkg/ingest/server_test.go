@@ -0,0 +1,155 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/job"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const token = "secret"
+
+// serve reads source until the test ends and returns the jobs it hands out.
+func serve(t *testing.T, server *Server, source *Source) <-chan job.Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	jobChan := make(chan job.Job)
+	go func() {
+		_ = source.GetItems(ctx, jobChan)
+	}()
+
+	require.Eventually(t, func() bool {
+		return server.Addr() != ""
+	}, time.Second, 10*time.Millisecond)
+
+	return jobChan
+}
+
+func post(t *testing.T, server *Server, path, auth, body string) *http.Response {
+	request, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s%s", server.Addr(), path), strings.NewReader(body))
+	require.Nil(t, err)
+	request.Header.Set("Authorization", auth)
+
+	response, err := http.DefaultClient.Do(request)
+	require.Nil(t, err)
+	_ = response.Body.Close()
+
+	return response
+}
+
+func TestServer(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		server := NewServer(&Configuration{Addr: "127.0.0.1:0", Token: token})
+		jobChan := serve(t, server, server.Source("webhooks", 0))
+
+		response := post(t, server, "/queues/webhooks/jobs", "Bearer "+token, `{"jid":"1"}`)
+		assert.Equal(t, http.StatusAccepted, response.StatusCode)
+		assert.Equal(t, `{"jid":"1"}`, string((<-jobChan).Original()))
+
+		response = post(t, server, "/queues/webhooks/jobs", "Bearer "+token, `[{"jid":"2"},{"jid":"3"}]`)
+		assert.Equal(t, http.StatusAccepted, response.StatusCode)
+		assert.Equal(t, `{"jid":"2"}`, string((<-jobChan).Original()))
+		assert.Equal(t, `{"jid":"3"}`, string((<-jobChan).Original()))
+	})
+
+	t.Run("applies backpressure", func(t *testing.T) {
+		server := NewServer(&Configuration{Addr: "127.0.0.1:0", Token: token})
+		source := server.Source("webhooks", 1)
+		jobChan := serve(t, server, source)
+
+		// the first job waits for the pipeline outside the buffer
+		assert.Equal(t, http.StatusAccepted, post(t, server, "/queues/webhooks/jobs", "Bearer "+token, `{"jid":"1"}`).StatusCode)
+		require.Eventually(t, func() bool {
+			return len(source.buffer) == 0
+		}, time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, http.StatusAccepted, post(t, server, "/queues/webhooks/jobs", "Bearer "+token, `{"jid":"2"}`).StatusCode)
+
+		response := post(t, server, "/queues/webhooks/jobs", "Bearer "+token, `{"jid":"3"}`)
+		assert.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+		assert.Equal(t, retryAfter, response.Header.Get("Retry-After"))
+
+		response = post(t, server, "/queues/webhooks/jobs", "Bearer "+token, `[{"jid":"4"},{"jid":"5"}]`)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+
+		assert.Equal(t, `{"jid":"1"}`, string((<-jobChan).Original()))
+		assert.Equal(t, `{"jid":"2"}`, string((<-jobChan).Original()))
+	})
+
+	t.Run("hands out accepted jobs when stopped", func(t *testing.T) {
+		server := NewServer(&Configuration{Addr: "127.0.0.1:0", Token: token})
+		source := server.Source("webhooks", 0)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		jobChan := make(chan job.Job)
+		done := make(chan error, 1)
+		go func() {
+			done <- source.GetItems(ctx, jobChan)
+		}()
+
+		require.Eventually(t, func() bool {
+			return server.Addr() != ""
+		}, time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, http.StatusAccepted, post(t, server, "/queues/webhooks/jobs", "Bearer "+token, `{"jid":"1"}`).StatusCode)
+		assert.Equal(t, http.StatusAccepted, post(t, server, "/queues/webhooks/jobs", "Bearer "+token, `[{"jid":"2"},{"jid":"3"}]`).StatusCode)
+		cancel()
+
+		jobs := make([]string, 0, 3)
+		for j := range jobChan {
+			jobs = append(jobs, string(j.Original()))
+		}
+
+		assert.Equal(t, []string{`{"jid":"1"}`, `{"jid":"2"}`, `{"jid":"3"}`}, jobs)
+		assert.Nil(t, <-done)
+		assert.ErrorIs(t, source.accept([]job.Job{}), errStopped)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		server := NewServer(&Configuration{Addr: "127.0.0.1:0", Token: token})
+		serve(t, server, server.Source("webhooks", 0))
+
+		tests := map[string]struct {
+			path, auth, body string
+			status           int
+		}{
+			"no token":      {path: "/queues/webhooks/jobs", body: `{"jid":"1"}`, status: http.StatusUnauthorized},
+			"wrong token":   {path: "/queues/webhooks/jobs", auth: "Bearer wrong", body: `{"jid":"1"}`, status: http.StatusUnauthorized},
+			"unknown path":  {path: "/jobs", auth: "Bearer " + token, body: `{"jid":"1"}`, status: http.StatusNotFound},
+			"unknown queue": {path: "/queues/other/jobs", auth: "Bearer " + token, body: `{"jid":"1"}`, status: http.StatusNotFound},
+			"invalid job":   {path: "/queues/webhooks/jobs", auth: "Bearer " + token, body: `{"jid":`, status: http.StatusBadRequest},
+			"invalid batch": {path: "/queues/webhooks/jobs", auth: "Bearer " + token, body: `[{"jid":"1"},`, status: http.StatusBadRequest},
+			"empty batch":   {path: "/queues/webhooks/jobs", auth: "Bearer " + token, body: `[]`, status: http.StatusBadRequest},
+		}
+
+		for name, test := range tests {
+			t.Run(name, func(t *testing.T) {
+				assert.Equal(t, test.status, post(t, server, test.path, test.auth, test.body).StatusCode)
+			})
+		}
+
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/queues/webhooks/jobs", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	})
+
+	t.Run("not being read", func(t *testing.T) {
+		server := NewServer(&Configuration{Token: token})
+		server.Source("webhooks", 0)
+
+		request := httptest.NewRequest(http.MethodPost, "/queues/webhooks/jobs", strings.NewReader(`{"jid":"1"}`))
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	})
+}
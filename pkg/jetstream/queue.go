@@ -0,0 +1,306 @@
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"github.com/nats-io/nats.go"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"io"
+	"sync"
+	"time"
+)
+
+const count = 100
+
+const (
+	defaultDurable    = "goqueue"
+	defaultAckWait    = 30 * time.Second
+	defaultRetryDelay = 5 * time.Second
+	// wait is how long a fetch waits for messages before checking if the
+	// queue has been stopped
+	wait = time.Second
+)
+
+// Configuration is how a Queue reads from and publishes to its stream.
+type Configuration struct {
+	// Stream is the stream read, created for Subject if it doesn't exist
+	Stream string
+	// Subject is the subject jobs are published to and read from
+	Subject string
+	// Durable is the name of the pull consumer jobs are read through,
+	// goqueue by default. It is created if it doesn't exist.
+	Durable string
+	// AckWait is how long a delivered job can go unacknowledged before it is
+	// delivered again, 30 seconds by default
+	AckWait time.Duration
+	// MaxDeliver is how many times a job is delivered before the server
+	// gives up on it, zero for no limit
+	MaxDeliver int
+	// RetryDelay is how long a job that failed waits to be delivered again,
+	// five seconds by default
+	RetryDelay time.Duration
+}
+
+// Queue is a JetStream stream read through a durable pull consumer. Jobs
+// processed successfully are acked, jobs that failed are naked to be
+// delivered again after RetryDelay and removed jobs are terminated.
+type Queue struct {
+	jobbuilder    *job.Builder
+	options       queues.Options
+	configuration Configuration
+	js            nats.JetStreamContext
+
+	mu sync.Mutex
+	// messages are the messages of the jobs handed out and not yet acked
+	messages map[job.Job]*nats.Msg
+	ensured  bool
+}
+
+func NewQueue(js nats.JetStreamContext, configuration *Configuration, opts ...queues.Option) *Queue {
+	options := queues.NewOptions(opts...)
+	jobJuilder := job.NewBuilder(options.JobConfiguration)
+
+	q := &Queue{js: js, jobbuilder: jobJuilder, options: options, messages: map[job.Job]*nats.Msg{}}
+	if configuration != nil {
+		q.configuration = *configuration
+	}
+
+	if q.configuration.Durable == "" {
+		q.configuration.Durable = defaultDurable
+	}
+
+	if q.configuration.AckWait <= 0 {
+		q.configuration.AckWait = defaultAckWait
+	}
+
+	if q.configuration.RetryDelay <= 0 {
+		q.configuration.RetryDelay = defaultRetryDelay
+	}
+
+	return q
+}
+
+// ensureStream creates the stream for the queue's subject if it doesn't
+// exist.
+func (q *Queue) ensureStream(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.ensured {
+		return nil
+	}
+
+	_, err := q.js.StreamInfo(q.configuration.Stream, nats.Context(ctx))
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = q.js.AddStream(&nats.StreamConfig{Name: q.configuration.Stream, Subjects: []string{q.configuration.Subject}}, nats.Context(ctx))
+		if err == nil {
+			logs.Info(ctx, "created stream", logs.WithValue("stream", q.configuration.Stream), logs.WithValue("subject", q.configuration.Subject))
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	q.ensured = true
+
+	return nil
+}
+
+// subscribe binds to the durable consumer, creating it if need be. Bound
+// subscriptions leave the consumer behind when they unsubscribe.
+func (q *Queue) subscribe(ctx context.Context) (*nats.Subscription, error) {
+	if err := q.ensureStream(ctx); err != nil {
+		return nil, err
+	}
+
+	_, err := q.js.ConsumerInfo(q.configuration.Stream, q.configuration.Durable, nats.Context(ctx))
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = q.js.AddConsumer(q.configuration.Stream, &nats.ConsumerConfig{
+			Durable:       q.configuration.Durable,
+			FilterSubject: q.configuration.Subject,
+			AckPolicy:     nats.AckExplicitPolicy,
+			AckWait:       q.configuration.AckWait,
+			MaxDeliver:    q.configuration.MaxDeliver,
+		}, nats.Context(ctx))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return q.js.PullSubscribe(q.configuration.Subject, q.configuration.Durable, nats.Bind(q.configuration.Stream, q.configuration.Durable))
+}
+
+func (q *Queue) GetItems(ctx context.Context, jobChan chan<- job.Job) error {
+	defer func() {
+		close(jobChan)
+	}()
+
+	sub, err := q.subscribe(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = sub.Unsubscribe()
+	}()
+
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, wait)
+		messages, err := sub.Fetch(count, nats.Context(fetchCtx))
+		cancel()
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, nats.ErrTimeout) {
+			return err
+		}
+
+		if len(messages) == 0 {
+			continue
+		}
+
+		logs.Info(ctx, "got items from stream", logs.WithValue("stream", q.configuration.Stream), logs.WithValue("count", len(messages)))
+
+		for idx, msg := range messages {
+			j := queues.MakeJob(ctx, q.jobbuilder, q.options, &message{msg: msg}, msg.Data)
+			if j == nil {
+				continue
+			}
+
+			q.mu.Lock()
+			q.messages[j] = msg
+			q.mu.Unlock()
+
+			if !q.send(ctx, jobChan, j, messages[idx:]) {
+				q.take(j)
+				q.release(ctx, messages[idx:])
+
+				return nil
+			}
+		}
+	}
+}
+
+// send hands the job out, telling the server the messages waiting in the
+// batch are still in progress so they aren't delivered again while the
+// pipeline is busy. It returns false if the queue was stopped first.
+func (q *Queue) send(ctx context.Context, jobChan chan<- job.Job, j job.Job, waiting []*nats.Msg) bool {
+	ticker := time.NewTicker(q.configuration.AckWait / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case jobChan <- j:
+			return true
+		case <-ticker.C:
+			for _, msg := range waiting {
+				if err := msg.InProgress(); err != nil {
+					logs.Warn(ctx, "could not extend stream message", logs.WithValue("stream", q.configuration.Stream), logs.WithError(err))
+				}
+			}
+		}
+	}
+}
+
+// release naks the messages fetched but never handed out, so they are
+// delivered again at once instead of after AckWait.
+func (q *Queue) release(ctx context.Context, messages []*nats.Msg) {
+	for _, msg := range messages {
+		// ctx is done, so the naks can't wait on it
+		if err := msg.Nak(); err != nil {
+			logs.Warn(ctx, "could not release stream message", logs.WithValue("stream", q.configuration.Stream), logs.WithError(err))
+		}
+	}
+}
+
+// PushItems publishes the job to the queue's subject.
+func (q *Queue) PushItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	if err := q.ensureStream(ctx); err != nil {
+		errChan <- err
+		return
+	}
+
+	bts, err := q.jobbuilder.Encode(j)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	if _, err := q.js.Publish(q.configuration.Subject, bts, nats.Context(ctx)); err != nil {
+		errChan <- err
+	}
+}
+
+// RemoveItems terminates the job's message, so it is never delivered again.
+func (q *Queue) RemoveItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	msg, ok := q.take(j)
+	if !ok {
+		errChan <- errors.New("job was not read from this stream")
+		return
+	}
+
+	if err := msg.Term(nats.Context(ctx)); err != nil {
+		errChan <- err
+		return
+	}
+
+	logs.Info(ctx, "terminated stream message", logs.WithValue("stream", q.configuration.Stream), logs.WithValue("subject", msg.Subject))
+}
+
+// Ack acks the job's message once the pipeline processed it, or naks it to
+// be delivered again after RetryDelay when it failed.
+func (q *Queue) Ack(ctx context.Context, j job.Job, success bool) error {
+	msg, ok := q.take(j)
+	if !ok {
+		return nil
+	}
+
+	if !success {
+		return msg.NakWithDelay(q.configuration.RetryDelay, nats.Context(ctx))
+	}
+
+	return msg.Ack(nats.Context(ctx))
+}
+
+// take returns and forgets the message of a job read from the stream.
+func (q *Queue) take(j job.Job) (*nats.Msg, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msg, ok := q.messages[j]
+	delete(q.messages, j)
+
+	return msg, ok
+}
+
+// message terminates a single message, the source of jobs quarantined
+// before they were handed out.
+type message struct {
+	msg *nats.Msg
+}
+
+func (m *message) RemoveItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	if err := m.msg.Term(nats.Context(ctx)); err != nil {
+		errChan <- err
+	}
+}
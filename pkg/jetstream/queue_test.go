@@ -0,0 +1,184 @@
+package jetstream
+
+import (
+	"bytes"
+	"context"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/internal/queuetest"
+	"testing"
+	"time"
+)
+
+// run starts an embedded JetStream server for the test and connects to it.
+func run(t *testing.T) nats.JetStreamContext {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	require.Nil(t, err)
+
+	go s.Start()
+	require.True(t, s.ReadyForConnections(5*time.Second))
+	t.Cleanup(s.Shutdown)
+
+	nc, err := nats.Connect(s.ClientURL())
+	require.Nil(t, err)
+	t.Cleanup(nc.Close)
+
+	js, err := nc.JetStream()
+	require.Nil(t, err)
+
+	return js
+}
+
+func push(t *testing.T, q *Queue, payload string) {
+	err := queues.Collect(func(errChan chan error) {
+		q.PushItems(context.Background(), queuetest.MakeJob(t, payload), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+	})
+	require.Nil(t, err)
+}
+
+// read returns the first n jobs q hands out.
+func read(t *testing.T, q *Queue, n int) []job.Job {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	jobChan := make(chan job.Job)
+	go func() {
+		_ = q.GetItems(ctx, jobChan)
+	}()
+
+	jobs := make([]job.Job, 0, n)
+	for j := range jobChan {
+		if len(jobs) < n {
+			jobs = append(jobs, j)
+		}
+
+		if len(jobs) == n {
+			cancel()
+		}
+	}
+
+	require.Len(t, jobs, n)
+
+	return jobs
+}
+
+func pending(t *testing.T, js nats.JetStreamContext) int {
+	info, err := js.ConsumerInfo("JOBS", defaultDurable)
+	require.Nil(t, err)
+
+	return int(info.NumPending) + info.NumAckPending + info.NumRedelivered
+}
+
+func TestQueue(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		js := run(t)
+		q := NewQueue(js, &Configuration{Stream: "JOBS", Subject: "jobs.webhooks", RetryDelay: 10 * time.Millisecond})
+		push(t, q, `{"jid":"1"}`)
+		push(t, q, `{"jid":"2"}`)
+		push(t, q, `{"jid":"3"}`)
+
+		jobs := read(t, q, 3)
+		assert.Equal(t, `{"jid":"1"}`, string(jobs[0].Original()))
+
+		require.Nil(t, q.Ack(context.Background(), jobs[0], true))
+		require.Nil(t, q.Ack(context.Background(), jobs[1], false))
+		require.Nil(t, queues.Collect(func(errChan chan error) {
+			q.RemoveItems(context.Background(), jobs[2], &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		}))
+		// a removed job has already been acked
+		require.Nil(t, q.Ack(context.Background(), jobs[2], true))
+
+		// the failed job is delivered again, through the same durable
+		jobs = read(t, NewQueue(js, &Configuration{Stream: "JOBS", Subject: "jobs.webhooks"}), 1)
+		assert.Equal(t, `{"jid":"2"}`, string(jobs[0].Original()))
+	})
+
+	t.Run("releases jobs not handed out", func(t *testing.T) {
+		js := run(t)
+		q := NewQueue(js, &Configuration{Stream: "JOBS", Subject: "jobs.webhooks"})
+		push(t, q, `{"jid":"1"}`)
+		push(t, q, `{"jid":"2"}`)
+		push(t, q, `{"jid":"3"}`)
+
+		// the other jobs were fetched with the first and naked when it stopped
+		read(t, q, 1)
+
+		jobs := read(t, NewQueue(js, &Configuration{Stream: "JOBS", Subject: "jobs.webhooks"}), 2)
+		assert.Equal(t, `{"jid":"2"}`, string(jobs[0].Original()))
+		assert.Equal(t, `{"jid":"3"}`, string(jobs[1].Original()))
+	})
+
+	t.Run("keeps waiting jobs in progress", func(t *testing.T) {
+		js := run(t)
+		q := NewQueue(js, &Configuration{Stream: "JOBS", Subject: "jobs.webhooks", AckWait: 200 * time.Millisecond})
+		push(t, q, `{"jid":"1"}`)
+		push(t, q, `{"jid":"2"}`)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		jobChan := make(chan job.Job)
+		go func() {
+			_ = q.GetItems(ctx, jobChan)
+		}()
+
+		first := <-jobChan
+		require.Nil(t, q.Ack(ctx, first, true))
+
+		// the second job waits in the batch for longer than AckWait
+		time.Sleep(500 * time.Millisecond)
+		second := <-jobChan
+		require.Nil(t, q.Ack(ctx, second, true))
+		assert.Equal(t, `{"jid":"2"}`, string(second.Original()))
+
+		// it wasn't delivered again meanwhile
+		select {
+		case j := <-jobChan:
+			assert.Failf(t, "job delivered again", "%s", j.Original())
+		case <-time.After(500 * time.Millisecond):
+		}
+	})
+
+	t.Run("terminates invalid jobs", func(t *testing.T) {
+		js := run(t)
+		q := NewQueue(js, &Configuration{Stream: "JOBS", Subject: "jobs.webhooks", AckWait: time.Second})
+		require.Nil(t, q.ensureStream(context.Background()))
+
+		_, err := js.Publish("jobs.webhooks", []byte("not json"))
+		require.Nil(t, err)
+		push(t, q, `{"jid":"1"}`)
+
+		quarantine := NewQueue(js, &Configuration{Stream: "DEAD", Subject: "jobs.dead"})
+		q.options.Quarantine = quarantine
+
+		jobs := read(t, q, 1)
+		assert.Equal(t, `{"jid":"1"}`, string(jobs[0].Original()))
+		require.Nil(t, q.Ack(context.Background(), jobs[0], true))
+
+		require.Eventually(t, func() bool {
+			return pending(t, js) == 0
+		}, 2*time.Second, 10*time.Millisecond)
+
+		msg, err := js.GetMsg("DEAD", 1)
+		require.Nil(t, err)
+		assert.Equal(t, "not json", string(msg.Data))
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		q := NewQueue(run(t), &Configuration{Stream: "JOBS", Subject: "jobs.webhooks"})
+
+		err := queues.Collect(func(errChan chan error) {
+			q.RemoveItems(context.Background(), queuetest.MakeJob(t, `{"jid":"1"}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		})
+		assert.NotNil(t, err)
+
+		// the consumer's subject isn't one of the stream's
+		require.Nil(t, q.ensureStream(context.Background()))
+		err = NewQueue(q.js, &Configuration{Stream: "JOBS", Subject: "jobs.emails"}).GetItems(context.Background(), make(chan job.Job))
+		assert.NotNil(t, err)
+	})
+}
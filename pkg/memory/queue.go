@@ -0,0 +1,317 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const count = 100
+
+// Mode is how a Queue orders and hands out its jobs.
+type Mode string
+
+const (
+	// List queues behave like an lrange queue: jobs are pushed onto the head
+	// and read from the head until they are removed
+	List Mode = "list"
+	// ZSet queues behave like a zset queue: jobs are unique, ordered by
+	// score and read once their score is due
+	ZSet Mode = "zset"
+	// FIFO queues hand each job out once, in the order it was pushed
+	FIFO Mode = "fifo"
+)
+
+// Configuration of a Queue.
+type Configuration struct {
+	// Mode is list (the default), zset or fifo
+	Mode Mode
+	// ScoreField is the field holding the unix time in seconds a job in a
+	// zset queue is due. Jobs without it are due when they are pushed.
+	ScoreField string
+	// Jobs are the queue's initial contents, head first
+	Jobs [][]byte
+}
+
+type item struct {
+	payload []byte
+	score   float64
+}
+
+// Queue keeps jobs in memory, encoded as they would be in redis, so
+// pipelines can run without a redis server. Jobs left in a list or zset
+// queue are read again when the queue next changes.
+type Queue struct {
+	jobbuilder    *job.Builder
+	options       queues.Options
+	configuration Configuration
+	now           func() time.Time
+
+	mu    sync.Mutex
+	items []item
+	// changed is closed and replaced whenever a job is pushed or removed
+	changed chan struct{}
+}
+
+func NewQueue(configuration *Configuration, opts ...queues.Option) *Queue {
+	options := queues.NewOptions(opts...)
+	jobJuilder := job.NewBuilder(options.JobConfiguration)
+
+	q := &Queue{jobbuilder: jobJuilder, options: options, now: time.Now, changed: make(chan struct{})}
+	if configuration != nil {
+		q.configuration = *configuration
+	}
+
+	if q.configuration.Mode == "" {
+		q.configuration.Mode = List
+	}
+
+	now := timestamp(q.now())
+	for idx := range q.configuration.Jobs {
+		q.items = append(q.items, item{payload: q.configuration.Jobs[idx], score: now})
+	}
+
+	return q
+}
+
+func (q *Queue) GetItems(ctx context.Context, jobChan chan<- job.Job) error {
+	defer func() {
+		close(jobChan)
+	}()
+
+	for {
+		payloads, changed := q.next()
+
+		if len(payloads) > 0 {
+			logs.Info(ctx, "got items from queue", logs.WithValue("count", len(payloads)))
+		}
+
+		for idx := range payloads {
+			j := queues.MakeJob(ctx, q.jobbuilder, q.options, q, payloads[idx])
+			if j == nil {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case jobChan <- j:
+			}
+		}
+
+		// fifo queues have more to hand out straight away
+		if q.configuration.Mode == FIFO && len(payloads) == count {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		case <-q.due():
+		}
+	}
+}
+
+// next returns the jobs to hand out and a channel closed when the queue next
+// changes.
+func (q *Queue) next() ([][]byte, chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	payloads := make([][]byte, 0, count)
+	switch q.configuration.Mode {
+	case FIFO:
+		for len(q.items) > 0 && len(payloads) < count {
+			payloads = append(payloads, q.items[0].payload)
+			q.items = q.items[1:]
+		}
+	case ZSet:
+		now := timestamp(q.now())
+		for idx := range q.items {
+			if q.items[idx].score > now || len(payloads) == count {
+				break
+			}
+
+			payloads = append(payloads, q.items[idx].payload)
+		}
+	default:
+		for idx := range q.items {
+			if len(payloads) == count {
+				break
+			}
+
+			payloads = append(payloads, q.items[idx].payload)
+		}
+	}
+
+	return payloads, q.changed
+}
+
+// due returns a channel that fires when the next job of a zset queue that
+// isn't due yet is, or nil when there is none.
+func (q *Queue) due() <-chan time.Time {
+	if q.configuration.Mode != ZSet {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := timestamp(q.now())
+	for idx := range q.items {
+		if q.items[idx].score > now {
+			return time.After(time.Duration((q.items[idx].score - now) * float64(time.Second)))
+		}
+	}
+
+	return nil
+}
+
+// PushItems adds the job to the head of a list queue, the tail of a fifo
+// queue or at its score in a zset queue, replacing the same job.
+func (q *Queue) PushItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	payload, err := q.jobbuilder.Encode(j)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	switch q.configuration.Mode {
+	case FIFO:
+		q.items = append(q.items, item{payload: payload})
+	case ZSet:
+		q.items = remove(q.items, payload, -1)
+		q.items = append(q.items, item{payload: payload, score: q.score(j)})
+		sort.SliceStable(q.items, func(a, b int) bool {
+			return q.items[a].score < q.items[b].score
+		})
+	default:
+		q.items = append([]item{{payload: payload}}, q.items...)
+	}
+
+	q.notify()
+}
+
+func (q *Queue) score(j job.Job) float64 {
+	if q.configuration.ScoreField != "" {
+		if val, err := j.GetValue(q.configuration.ScoreField); err == nil {
+			if score, ok := job.ToFloat64(val); ok {
+				return score
+			}
+		}
+	}
+
+	return timestamp(q.now())
+}
+
+// RemoveItems removes the job, found by the payload it was read with.
+func (q *Queue) RemoveItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	before := len(q.items)
+	q.items = remove(q.items, j.Original(), 1)
+
+	// jobs handed out by a fifo queue have already left it
+	if len(q.items) == before && q.configuration.Mode != FIFO {
+		errChan <- errors.New("job is not in the queue")
+		return
+	}
+
+	q.notify()
+}
+
+// remove removes up to n items with payload, or all of them when n is -1.
+func remove(items []item, payload []byte, n int) []item {
+	kept := items[:0:0]
+	for idx := range items {
+		if n != 0 && bytes.Equal(items[idx].payload, payload) {
+			n--
+			continue
+		}
+
+		kept = append(kept, items[idx])
+	}
+
+	return kept
+}
+
+// notify wakes readers waiting for the queue to change, with q.mu held.
+func (q *Queue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// Items returns the queue's jobs, head first.
+func (q *Queue) Items() [][]byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	payloads := make([][]byte, len(q.items))
+	for idx := range q.items {
+		payloads[idx] = q.items[idx].payload
+	}
+
+	return payloads
+}
+
+// Len returns how many jobs are in the queue.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.items)
+}
+
+// Count returns how many jobs are in the queue.
+func (q *Queue) Count(ctx context.Context) (int64, error) {
+	return int64(q.Len()), nil
+}
+
+// Page returns count jobs from offset, head first, without removing them.
+func (q *Queue) Page(ctx context.Context, offset, count int64) ([]job.Job, error) {
+	payloads := q.Items()
+	if offset >= int64(len(payloads)) {
+		return []job.Job{}, nil
+	}
+
+	payloads = payloads[offset:]
+	if count < int64(len(payloads)) {
+		payloads = payloads[:count]
+	}
+
+	jobs := make([]job.Job, 0, len(payloads))
+	for idx := range payloads {
+		j := queues.PageJob(ctx, q.jobbuilder, payloads[idx])
+		if j == nil {
+			continue
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
+
+func timestamp(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
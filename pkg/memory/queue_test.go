@@ -0,0 +1,159 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/internal/queuetest"
+	"testing"
+	"time"
+)
+
+func push(t *testing.T, q *Queue, payload string) {
+	err := queues.Collect(func(errChan chan error) {
+		q.PushItems(context.Background(), queuetest.MakeJob(t, payload), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+	})
+	require.Nil(t, err)
+}
+
+// read returns the first n jobs q hands out.
+func read(t *testing.T, q *Queue, n int) []string {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jobChan := make(chan job.Job)
+	go func() {
+		_ = q.GetItems(ctx, jobChan)
+	}()
+
+	payloads := make([]string, 0, n)
+	for j := range jobChan {
+		payloads = append(payloads, string(j.Original()))
+		if len(payloads) == n {
+			cancel()
+		}
+	}
+
+	return payloads
+}
+
+func strings(payloads [][]byte) []string {
+	s := make([]string, len(payloads))
+	for idx := range payloads {
+		s[idx] = string(payloads[idx])
+	}
+
+	return s
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		t.Run("list", func(t *testing.T) {
+			q := NewQueue(&Configuration{Jobs: [][]byte{[]byte(`{"id":1}`)}})
+			push(t, q, `{"id":2}`)
+
+			assert.Equal(t, []string{`{"id":2}`, `{"id":1}`}, strings(q.Items()))
+			// jobs stay in the queue until they are removed
+			assert.Equal(t, []string{`{"id":2}`, `{"id":1}`}, read(t, q, 2))
+			assert.Equal(t, 2, q.Len())
+
+			err := queues.Collect(func(errChan chan error) {
+				q.RemoveItems(ctx, queuetest.MakeJob(t, `{"id":2}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+			assert.Equal(t, []string{`{"id":1}`}, strings(q.Items()))
+		})
+		t.Run("fifo", func(t *testing.T) {
+			q := NewQueue(&Configuration{Mode: FIFO})
+			push(t, q, `{"id":1}`)
+			push(t, q, `{"id":2}`)
+
+			assert.Equal(t, []string{`{"id":1}`, `{"id":2}`}, read(t, q, 2))
+			assert.Equal(t, 0, q.Len())
+
+			err := queues.Collect(func(errChan chan error) {
+				q.RemoveItems(ctx, queuetest.MakeJob(t, `{"id":1}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			assert.Nil(t, err)
+		})
+		t.Run("zset", func(t *testing.T) {
+			now := time.Unix(1700000000, 0)
+			q := NewQueue(&Configuration{Mode: ZSet, ScoreField: "at"})
+			q.now = func() time.Time {
+				return now
+			}
+
+			push(t, q, `{"id":1,"at":1700000010}`)
+			push(t, q, `{"id":2,"at":1699999990}`)
+			push(t, q, `{"id":3}`)
+			// pushing the same job again moves it
+			push(t, q, `{"id":2,"at":1699999990}`)
+
+			assert.Equal(t, []string{`{"id":2,"at":1699999990}`, `{"id":3}`, `{"id":1,"at":1700000010}`}, strings(q.Items()))
+			assert.Equal(t, []string{`{"id":2,"at":1699999990}`, `{"id":3}`}, read(t, q, 2))
+		})
+		t.Run("wakes on push", func(t *testing.T) {
+			q := NewQueue(&Configuration{Mode: FIFO})
+
+			readCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			jobChan := make(chan job.Job)
+			go func() {
+				_ = q.GetItems(readCtx, jobChan)
+			}()
+
+			push(t, q, `{"id":1}`)
+
+			select {
+			case j := <-jobChan:
+				assert.Equal(t, `{"id":1}`, string(j.Original()))
+			case <-time.After(time.Second):
+				t.Fatal("job was not read")
+			}
+		})
+	})
+	t.Run("failure", func(t *testing.T) {
+		t.Run("remove missing job", func(t *testing.T) {
+			q := NewQueue(nil)
+
+			err := queues.Collect(func(errChan chan error) {
+				q.RemoveItems(ctx, queuetest.MakeJob(t, `{"id":1}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			assert.NotNil(t, err)
+		})
+	})
+}
+
+func TestQueue_Page(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		q := NewQueue(&Configuration{Mode: FIFO, Jobs: [][]byte{[]byte(`{"id":1}`), []byte("not json"), []byte(`{"id":2}`)}})
+
+		n, err := q.Count(ctx)
+		require.Nil(t, err)
+		assert.Equal(t, int64(3), n)
+
+		jobs, err := q.Page(ctx, 0, 2)
+		require.Nil(t, err)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, `{"id":1}`, string(jobs[0].Original()))
+
+		jobs, err = q.Page(ctx, 2, 2)
+		require.Nil(t, err)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, `{"id":2}`, string(jobs[0].Original()))
+
+		jobs, err = q.Page(ctx, 3, 2)
+		require.Nil(t, err)
+		assert.Empty(t, jobs)
+
+		// paging doesn't hand the jobs of a fifo queue out
+		assert.Equal(t, 3, q.Len())
+	})
+}
@@ -2,8 +2,11 @@ package queue
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/go-redis/redis/v8"
+	"github.com/nats-io/nats.go"
 	"github.com/spf13/viper"
 	"github.com/thethan/goqueue/internal/conditionals"
 	"github.com/thethan/goqueue/internal/executers"
@@ -11,11 +14,22 @@ import (
 	"github.com/thethan/goqueue/internal/logs"
 	"github.com/thethan/goqueue/internal/pipelines"
 	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/internal/transforms"
+	"github.com/thethan/goqueue/pkg/file"
+	"github.com/thethan/goqueue/pkg/ingest"
+	"github.com/thethan/goqueue/pkg/jetstream"
+	"github.com/thethan/goqueue/pkg/memory"
+	"github.com/thethan/goqueue/pkg/redis/redis/bullmq"
+	"github.com/thethan/goqueue/pkg/redis/redis/celery"
 	"github.com/thethan/goqueue/pkg/redis/redis/lrange"
+	"github.com/thethan/goqueue/pkg/redis/redis/reference"
+	"github.com/thethan/goqueue/pkg/redis/redis/resque"
+	"github.com/thethan/goqueue/pkg/redis/redis/sidekiq"
+	"github.com/thethan/goqueue/pkg/redis/redis/stream"
 	"github.com/thethan/goqueue/pkg/redis/redis/zset"
+	"github.com/thethan/goqueue/pkg/sqlite"
 	"go.opentelemetry.io/otel"
 	metric2 "go.opentelemetry.io/otel/metric"
-	"gopkg.in/yaml.v3"
 	"io"
 	"os"
 )
@@ -29,6 +43,10 @@ const (
 
 	envRedisAuth = "REDIS_AUTH"
 
+	envHTTPToken = "GOQUEUE_HTTP_TOKEN"
+
+	envNATSURL = "NATS_URL"
+
 	defaultRedisHost     = "localhost:6379"
 	defaultRedisUsername = ""
 
@@ -38,12 +56,15 @@ const (
 )
 
 func init() {
-	_ = viper.BindEnv(envRedisAuth, envRedisUsername, envRedisHost, envRedisPassword, envRedisHost)
+	for _, env := range []string{envRedisAuth, envRedisUsername, envRedisHost, envRedisPassword, envHTTPToken, envNATSURL} {
+		_ = viper.BindEnv(env)
+	}
 
 	viper.SetDefault(envRedisHost, defaultRedisHost)
 	viper.SetDefault(envRedisUsername, defaultRedisUsername)
 	viper.SetDefault(envRedisPassword, defaultRedisPassword)
 	viper.SetDefault(envRedisAuth, defaultRedisAuth)
+	viper.SetDefault(envNATSURL, nats.DefaultURL)
 }
 
 func BuildPipeline(ctx context.Context, configFileLocation string) (pipelines.ProcessPipeline, error) {
@@ -53,59 +74,110 @@ func BuildPipeline(ctx context.Context, configFileLocation string) (pipelines.Pr
 		return nil, err
 	}
 
-	decoder := yaml.NewDecoder(file)
-	configuration := Configuration{}
-	//exp, err := otlpmetricgrpc.New(ctx)
-	//if err != nil {
-	//	panic(err)
-	//}
-	//
-	//meterProvider := metric.NewMeterProvider(metric.WithReader(metric.NewPeriodicReader(exp)))
-	//defer func() {
-	//	if err := meterProvider.Shutdown(ctx); err != nil {
-	//		panic(err)
-	//	}
-	//}()
-	//otel.SetMeterProvider(meterProvider)
-	//exporter, err := prometheus.New()
-	//if err != nil {
-	//	log.Fatal(err)
-	//}
-	//provider := metric.NewMeterProvider(metric.WithReader(exporter))
-	//meter := provider.Meter("github.com/open-telemetry/opentelemetry-go/example/prometheus")
+	defer func() {
+		_ = file.Close()
+	}()
+
+	configuration, err := ValidateConfiguration(file)
+	if err != nil {
+		logs.Error(ctx, "invalid config file", logs.WithError(err), logs.WithValue("configFileLocation", configFileLocation))
+		return nil, err
+	}
 
 	meter := otel.GetMeterProvider().Meter("github.com/open-telemetry/opentelemetry-go/example/prometheus")
-	err = decoder.Decode(&configuration)
+	pipeline, _, err := build(ctx, configuration, meter)
 	if err != nil {
-		logs.Fatal(ctx, "could not open config file", logs.WithError(err), logs.WithValue("configFileLocation", configFileLocation))
+		logs.Error(ctx, "could not build pipeline", logs.WithError(err), logs.WithValue("configFileLocation", configFileLocation))
 		return nil, err
 	}
 
+	return pipeline, nil
+}
+
+// build makes the pipeline a configuration describes and returns it with
+// its queues.
+func build(ctx context.Context, configuration Configuration, meter metric2.Meter) (pipelines.ProcessPipeline, map[string]queues.Queue, error) {
 	queuesMap, err := makeQueues(configuration)
 	if err != nil {
-		logs.Error(ctx, "could not open config file", logs.WithError(err), logs.WithValue("configFileLocation", configFileLocation))
-		return nil, err
+		logs.Error(ctx, "could not make queues", logs.WithError(err))
+		return nil, nil, err
 	}
 
 	conditionalMap, err := makeConditionals(configuration)
 	if err != nil {
-		logs.Error(ctx, "could not open config file", logs.WithError(err), logs.WithValue("configFileLocation", configFileLocation))
-		return nil, err
+		logs.Error(ctx, "could not make conditionals", logs.WithError(err))
+		return nil, nil, err
 	}
 
 	executors, err := makeExecutors(configuration)
 	if err != nil {
-		logs.Error(ctx, "could not get executors ", logs.WithError(err), logs.WithValue("configFileLocation", configFileLocation))
-		return nil, err
+		logs.Error(ctx, "could not get executors ", logs.WithError(err))
+		return nil, nil, err
 	}
 
-	pipeline, err := makePipeline(configuration, queuesMap, conditionalMap, executors, meter)
+	transformMap, err := makeTransforms(configuration)
 	if err != nil {
-		logs.Error(ctx, "could not open config file", logs.WithError(err), logs.WithValue("configFileLocation", configFileLocation))
-		return nil, err
+		logs.Error(ctx, "could not build transforms", logs.WithError(err))
+		return nil, nil, err
 	}
 
-	return pipeline, nil
+	pipeline, err := makePipeline(configuration, queuesMap, conditionalMap, executors, transformMap, meter)
+	if err != nil {
+		logs.Error(ctx, "could not make pipeline", logs.WithError(err))
+		return nil, nil, err
+	}
+
+	schedulers, err := makeSchedulers(configuration, queuesMap)
+	if err != nil {
+		logs.Error(ctx, "could not build schedulers", logs.WithError(err))
+		return nil, nil, err
+	}
+
+	if len(schedulers) > 0 {
+		return &scheduledPipeline{ProcessPipeline: pipeline, schedulers: schedulers}, queuesMap, nil
+	}
+
+	return pipeline, queuesMap, nil
+}
+
+func makeSchedulers(configuration Configuration, queueMap map[string]queues.Queue) ([]*sidekiq.Scheduler, error) {
+	schedulers := make([]*sidekiq.Scheduler, 0, len(configuration.Schedulers))
+	for _, schedulerConfiguration := range configuration.Schedulers {
+		sets := make([]*sidekiq.Queue, 0, len(schedulerConfiguration.Queues))
+		for _, queueName := range schedulerConfiguration.Queues {
+			queue, err := getQueue(queueMap, queueName.Name)
+			if err != nil {
+				return nil, err
+			}
+
+			set, ok := queue.(*sidekiq.Queue)
+			if !ok || (set.Key() != sidekiq.ScheduleKey && set.Key() != sidekiq.RetryKey) {
+				return nil, fmt.Errorf("scheduler %s: queue %s is not a sidekiq schedule or retry set", schedulerConfiguration.Name, queueName.Name)
+			}
+
+			sets = append(sets, set)
+		}
+
+		schedulers = append(schedulers, sidekiq.NewScheduler(schedulerConfiguration.Interval, sets...))
+	}
+
+	return schedulers, nil
+}
+
+// scheduledPipeline runs the configured schedulers alongside the pipeline.
+type scheduledPipeline struct {
+	pipelines.ProcessPipeline
+	schedulers []*sidekiq.Scheduler
+}
+
+func (p *scheduledPipeline) Start(ctx context.Context) error {
+	for _, scheduler := range p.schedulers {
+		go func(scheduler *sidekiq.Scheduler) {
+			_ = scheduler.Start(ctx)
+		}(scheduler)
+	}
+
+	return p.ProcessPipeline.Start(ctx)
 }
 func makeQueues(configuration Configuration) (map[string]queues.Queue, error) {
 	dataSourceNames := map[string]interface{}{}
@@ -113,76 +185,431 @@ func makeQueues(configuration Configuration) (map[string]queues.Queue, error) {
 	for _, queueConfiguration := range configuration.DataSources {
 		// todo move to its won function
 		if queueConfiguration.RedisConfiguration != nil {
-			redisClient := redis.NewClient(&redis.Options{
-				Addr:     queueConfiguration.RedisConfiguration.Host,
-				Username: queueConfiguration.RedisConfiguration.Username,
-				Password: queueConfiguration.RedisConfiguration.Password,
-			})
+			redisClient, err := newRedisClient(queueConfiguration.RedisConfiguration)
+			if err != nil {
+				return nil, fmt.Errorf("data source %s: %w", queueConfiguration.Name, err)
+			}
+
 			dataSourceNames[queueConfiguration.Name] = redisClient
 		}
+
+		if queueConfiguration.SQLiteConfiguration != nil {
+			db, err := sqlite.Open(context.Background(), queueConfiguration.SQLiteConfiguration.Path, queueConfiguration.SQLiteConfiguration.BusyTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("data source %s: %w", queueConfiguration.Name, err)
+			}
+
+			dataSourceNames[queueConfiguration.Name] = db
+		}
+
+		if queueConfiguration.HTTPConfiguration != nil {
+			server, err := newIngestServer(queueConfiguration.HTTPConfiguration)
+			if err != nil {
+				return nil, fmt.Errorf("data source %s: %w", queueConfiguration.Name, err)
+			}
+
+			dataSourceNames[queueConfiguration.Name] = server
+		}
+
+		if queueConfiguration.NATSConfiguration != nil {
+			js, err := newJetStream(queueConfiguration.NATSConfiguration)
+			if err != nil {
+				return nil, fmt.Errorf("data source %s: %w", queueConfiguration.Name, err)
+			}
+
+			dataSourceNames[queueConfiguration.Name] = js
+		}
 	}
 	// todo move to its own function
 	for _, queueConfiguration := range configuration.Queues {
+		switch queueConfiguration.Format {
+		case "", job.JsonRawJobType, job.YamlRawJobType, job.MsgpackRawJobType, job.CeleryRawJobType:
+		case job.ProtoRawJobType:
+			if queueConfiguration.Proto == nil {
+				return nil, fmt.Errorf("queue %s: proto format requires proto.descriptorSet and proto.messageType", queueConfiguration.Name)
+			}
+		default:
+			return nil, fmt.Errorf("queue %s: unknown format %q", queueConfiguration.Name, queueConfiguration.Format)
+		}
+
+		if backends(queueConfiguration) > 1 {
+			return nil, fmt.Errorf("queue %s: can only be one of redis, memory, file, sqlite, http and nats", queueConfiguration.Name)
+		}
+
+		if queueConfiguration.MemoryConfiguration != nil {
+			memoryQueue, err := newMemoryQueue(queueConfiguration, queueMap)
+			if err != nil {
+				return nil, err
+			}
+
+			queueMap[queueConfiguration.Name] = memoryQueue
+		}
+
+		if queueConfiguration.FileConfiguration != nil {
+			if queueConfiguration.FileConfiguration.Path == "" {
+				return nil, fmt.Errorf("queue %s: file queues require a path", queueConfiguration.Name)
+			}
+
+			queueMap[queueConfiguration.Name] = file.NewQueue(&file.Configuration{
+				Path:       queueConfiguration.FileConfiguration.Path,
+				Pattern:    queueConfiguration.FileConfiguration.Pattern,
+				Checkpoint: queueConfiguration.FileConfiguration.Checkpoint,
+				MaxBytes:   queueConfiguration.FileConfiguration.MaxBytes,
+			}, queueOptions(queueConfiguration, queueMap)...)
+		}
+
+		if queueConfiguration.SQLiteConfiguration != nil {
+			sqliteQueue, err := newSQLiteQueue(queueConfiguration, dataSourceNames, queueMap)
+			if err != nil {
+				return nil, err
+			}
+
+			queueMap[queueConfiguration.Name] = sqliteQueue
+		}
+
+		if queueConfiguration.HTTPConfiguration != nil {
+			server, ok := dataSourceNames[queueConfiguration.HTTPConfiguration.Datasource].(*ingest.Server)
+			if !ok {
+				return nil, fmt.Errorf("queue %s: %s is not an http data source", queueConfiguration.Name, queueConfiguration.HTTPConfiguration.Datasource)
+			}
+
+			name := queueConfiguration.HTTPConfiguration.Name
+			if name == "" {
+				name = queueConfiguration.Name
+			}
+
+			queueMap[queueConfiguration.Name] = server.Source(name, queueConfiguration.HTTPConfiguration.Buffer, queueOptions(queueConfiguration, queueMap)...)
+		}
+
+		if queueConfiguration.NATSConfiguration != nil {
+			jetStreamQueue, err := newJetStreamQueue(queueConfiguration, dataSourceNames, queueMap)
+			if err != nil {
+				return nil, err
+			}
+
+			queueMap[queueConfiguration.Name] = jetStreamQueue
+		}
+
 		if queueConfiguration.RedisConfiguration != nil {
+			redisClient, err := getRedisClient(dataSourceNames, queueConfiguration.RedisConfiguration.Datasource)
+			if err != nil {
+				return nil, fmt.Errorf("queue %s: %w", queueConfiguration.Name, err)
+			}
+
+			references, err := referenceStore(queueConfiguration, redisClient)
+			if err != nil {
+				return nil, err
+			}
+
 			// todo move to its own function
 			switch queueConfiguration.RedisConfiguration.Type {
 			case ZType:
-				client, ok := dataSourceNames[queueConfiguration.RedisConfiguration.Datasource]
-				if !ok {
-					logs.Fatal(context.Background(), "could not find redisclient", logs.WithValue("datasource", queueConfiguration.RedisConfiguration.Datasource))
+				zsetQueue := zset.NewZSetQueue(queueConfiguration.RedisConfiguration.Key, redisClient, queueOptions(queueConfiguration, queueMap)...)
+				if references != nil {
+					zsetQueue.LoadFrom(references)
 				}
 
-				redisClient, ok := client.(*redis.Client)
-				if !ok {
-					logs.Fatal(context.Background(), "could not find redisclient", logs.WithValue("datasource", queueConfiguration.RedisConfiguration.Datasource))
-				}
-
-				zsetQueue := zset.NewZSetQueue(queueConfiguration.RedisConfiguration.Key, redisClient)
-				_ = zsetQueue
-
 				queueMap[queueConfiguration.Name] = zsetQueue
 			case LRange:
-				client, ok := dataSourceNames[queueConfiguration.RedisConfiguration.Datasource]
-				if !ok {
-					logs.Fatal(context.Background(), "could not find redisclient", logs.WithValue("datasource", queueConfiguration.RedisConfiguration.Datasource))
-				}
-
-				redisClient, ok := client.(*redis.Client)
-				if !ok {
-					logs.Fatal(context.Background(), "could not find redisclient", logs.WithValue("datasource", queueConfiguration.RedisConfiguration.Datasource))
+				larangeQueue := lrange.NewLRangeQueue(queueConfiguration.RedisConfiguration.Key, redisClient, queueOptions(queueConfiguration, queueMap)...)
+				if references != nil {
+					larangeQueue.LoadFrom(references)
 				}
 
-				larangeQueue := lrange.NewLRangeQueue(queueConfiguration.RedisConfiguration.Key, redisClient)
-
 				queueMap[queueConfiguration.Name] = larangeQueue
+			case Sidekiq:
+				queueMap[queueConfiguration.Name] = sidekiq.NewQueue(queueConfiguration.RedisConfiguration.Key, redisClient, queueOptions(queueConfiguration, queueMap)...)
+			case Resque:
+				queueMap[queueConfiguration.Name] = resque.NewQueue(queueConfiguration.RedisConfiguration.Key, redisClient, queueOptions(queueConfiguration, queueMap)...)
+			case Celery:
+				queueMap[queueConfiguration.Name] = celery.NewQueue(queueConfiguration.RedisConfiguration.Key, redisClient, queueOptions(queueConfiguration, queueMap)...)
+			case BullMQ:
+				queueMap[queueConfiguration.Name] = bullmq.NewQueue(queueConfiguration.RedisConfiguration.Key, redisClient, queueOptions(queueConfiguration, queueMap)...)
+			case Stream:
+				queueMap[queueConfiguration.Name] = stream.NewQueue(queueConfiguration.RedisConfiguration.Key, redisClient, streamConfiguration(queueConfiguration.RedisConfiguration.Stream), queueOptions(queueConfiguration, queueMap)...)
+			default:
+				return nil, fmt.Errorf("queue %s: unknown redis queue type %q", queueConfiguration.Name, queueConfiguration.RedisConfiguration.Type)
 			}
 
 		}
 	}
 
+	for _, queueConfiguration := range configuration.Queues {
+		if queueConfiguration.Quarantine == "" {
+			continue
+		}
+
+		if _, ok := queueMap[queueConfiguration.Quarantine]; !ok {
+			logs.Error(context.Background(), "could not find quarantine queue", logs.WithValue("queueName", queueConfiguration.Name), logs.WithValue("quarantine", queueConfiguration.Quarantine))
+
+			return nil, errors.New("could not find quarantine queue")
+		}
+	}
+
 	return queueMap, nil
 }
 
-func makeConditionals(configuration Configuration) (map[string]conditionals.ConditionFunc, error) {
-	conditionalMap := make(map[string]conditionals.ConditionFunc)
+func getRedisClient(dataSourceNames map[string]interface{}, datasource string) (redis.UniversalClient, error) {
+	redisClient, ok := dataSourceNames[datasource].(redis.UniversalClient)
+	if !ok {
+		logs.Error(context.Background(), "could not find redisclient", logs.WithValue("datasource", datasource))
+
+		return nil, fmt.Errorf("%s is not a redis data source", datasource)
+	}
+
+	return redisClient, nil
+}
+
+// backends returns how many of the kinds of queue the queue is configured
+// as.
+func backends(queueConfiguration QueueConfiguration) int {
+	configured := 0
+	for _, backend := range []bool{
+		queueConfiguration.RedisConfiguration != nil,
+		queueConfiguration.MemoryConfiguration != nil,
+		queueConfiguration.FileConfiguration != nil,
+		queueConfiguration.SQLiteConfiguration != nil,
+		queueConfiguration.HTTPConfiguration != nil,
+		queueConfiguration.NATSConfiguration != nil,
+	} {
+		if backend {
+			configured++
+		}
+	}
+
+	return configured
+}
+
+func newMemoryQueue(queueConfiguration QueueConfiguration, queueMap map[string]queues.Queue) (*memory.Queue, error) {
+	configuration := queueConfiguration.MemoryConfiguration
+	switch memory.Mode(configuration.Type) {
+	case "", memory.List, memory.ZSet, memory.FIFO:
+	default:
+		return nil, fmt.Errorf("queue %s: unknown memory queue type %q", queueConfiguration.Name, configuration.Type)
+	}
+
+	jobs := make([][]byte, len(configuration.Jobs))
+	for idx := range configuration.Jobs {
+		jobs[idx] = []byte(configuration.Jobs[idx])
+	}
+
+	return memory.NewQueue(&memory.Configuration{
+		Mode:       memory.Mode(configuration.Type),
+		ScoreField: configuration.ScoreField,
+		Jobs:       jobs,
+	}, queueOptions(queueConfiguration, queueMap)...), nil
+}
+
+// newIngestServer returns the server of an http data source, whose token
+// defaults to GOQUEUE_HTTP_TOKEN.
+func newIngestServer(configuration *HTTPServer) (*ingest.Server, error) {
+	token := configuration.Token
+	if token == "" {
+		token = viper.GetString(envHTTPToken)
+	}
+
+	if token == "" {
+		return nil, fmt.Errorf("http data sources require a token or %s", envHTTPToken)
+	}
+
+	return ingest.NewServer(&ingest.Configuration{
+		Addr:         configuration.Addr,
+		Token:        token,
+		MaxBodyBytes: configuration.MaxBodyBytes,
+	}), nil
+}
+
+// newJetStream connects to a NATS data source. The connection is retried in
+// the background, so pipelines can start before the server is up.
+func newJetStream(configuration *NATSServer) (nats.JetStreamContext, error) {
+	url := configuration.URL
+	if url == "" {
+		url = viper.GetString(envNATSURL)
+	}
+
+	options := []nats.Option{nats.Name("goqueue"), nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1)}
+	if configuration.Credentials != "" {
+		options = append(options, nats.UserCredentials(configuration.Credentials))
+	}
+
+	conn, err := nats.Connect(url, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.JetStream()
+}
+
+func newJetStreamQueue(queueConfiguration QueueConfiguration, dataSourceNames map[string]interface{}, queueMap map[string]queues.Queue) (*jetstream.Queue, error) {
+	configuration := queueConfiguration.NATSConfiguration
+	js, ok := dataSourceNames[configuration.Datasource].(nats.JetStreamContext)
+	if !ok {
+		return nil, fmt.Errorf("queue %s: %s is not a nats data source", queueConfiguration.Name, configuration.Datasource)
+	}
+
+	if configuration.Stream == "" || configuration.Subject == "" {
+		return nil, fmt.Errorf("queue %s: nats queues require a stream and a subject", queueConfiguration.Name)
+	}
+
+	return jetstream.NewQueue(js, &jetstream.Configuration{
+		Stream:     configuration.Stream,
+		Subject:    configuration.Subject,
+		Durable:    configuration.Durable,
+		AckWait:    configuration.AckWait,
+		MaxDeliver: configuration.MaxDeliver,
+		RetryDelay: configuration.RetryDelay,
+	}, queueOptions(queueConfiguration, queueMap)...), nil
+}
+
+func newSQLiteQueue(queueConfiguration QueueConfiguration, dataSourceNames map[string]interface{}, queueMap map[string]queues.Queue) (*sqlite.Queue, error) {
+	configuration := queueConfiguration.SQLiteConfiguration
+	db, ok := dataSourceNames[configuration.Datasource].(*sql.DB)
+	if !ok {
+		return nil, fmt.Errorf("queue %s: %s is not a sqlite data source", queueConfiguration.Name, configuration.Datasource)
+	}
+
+	switch sqlite.State(configuration.State) {
+	case "", sqlite.Ready, sqlite.Scheduled, sqlite.InFlight, sqlite.Dead:
+	default:
+		return nil, fmt.Errorf("queue %s: unknown sqlite state %q", queueConfiguration.Name, configuration.State)
+	}
+
+	name := configuration.Queue
+	if name == "" {
+		name = queueConfiguration.Name
+	}
+
+	return sqlite.NewQueue(db, &sqlite.Configuration{
+		Name:              name,
+		State:             sqlite.State(configuration.State),
+		VisibilityTimeout: configuration.VisibilityTimeout,
+		PollInterval:      configuration.PollInterval,
+		PriorityField:     configuration.PriorityField,
+		RunAtField:        configuration.RunAtField,
+	}, queueOptions(queueConfiguration, queueMap)...), nil
+}
+
+// referenceStore returns the store of the jobs a queue's members refer to,
+// nil for queues whose members are jobs.
+func referenceStore(queueConfiguration QueueConfiguration, redisClient redis.UniversalClient) (*reference.Store, error) {
+	configuration := queueConfiguration.RedisConfiguration.Reference
+	if configuration == nil {
+		return nil, nil
+	}
+
+	switch queueConfiguration.RedisConfiguration.Type {
+	case ZType, LRange:
+	default:
+		return nil, fmt.Errorf("queue %s: references are only supported by zset and lrange queues", queueConfiguration.Name)
+	}
+
+	switch reference.Type(configuration.Type) {
+	case "", reference.String, reference.Hash:
+	default:
+		return nil, fmt.Errorf("queue %s: unknown reference type %q", queueConfiguration.Name, configuration.Type)
+	}
+
+	return reference.NewStore(redisClient, &reference.Configuration{
+		Prefix:  configuration.Prefix,
+		Type:    reference.Type(configuration.Type),
+		IDField: configuration.IDField,
+	}), nil
+}
+
+func streamConfiguration(configuration *StreamConfiguration) *stream.Configuration {
+	if configuration == nil {
+		return nil
+	}
+
+	return &stream.Configuration{
+		Group:     configuration.Group,
+		Consumer:  configuration.Consumer,
+		Field:     configuration.Field,
+		ClaimIdle: configuration.ClaimIdle,
+		MaxLen:    configuration.MaxLen,
+	}
+}
+
+func queueOptions(queueConfiguration QueueConfiguration, queueMap map[string]queues.Queue) []queues.Option {
+	opts := []queues.Option{
+		queues.WithJobConfiguration(jobConfiguration(queueConfiguration)),
+	}
+
+	if queueConfiguration.Quarantine != "" {
+		opts = append(opts, queues.WithQuarantine(&queueRef{name: queueConfiguration.Quarantine, queues: queueMap}))
+	}
+
+	return opts
+}
+
+func jobConfiguration(queueConfiguration QueueConfiguration) *job.Configuration {
+	configuration := &job.Configuration{
+		Type:   queueConfiguration.Format,
+		Fields: queueConfiguration.Schema,
+	}
+
+	if configuration.Type == "" {
+		configuration.Type = job.JsonRawJobType
+	}
+
+	// celery queues hold celery messages unless told otherwise
+	if queueConfiguration.Format == "" && queueConfiguration.RedisConfiguration != nil && queueConfiguration.RedisConfiguration.Type == Celery {
+		configuration.Type = job.CeleryRawJobType
+	}
+
+	if queueConfiguration.Proto != nil {
+		configuration.DescriptorSet = queueConfiguration.Proto.DescriptorSet
+		configuration.MessageType = queueConfiguration.Proto.MessageType
+	}
+
+	return configuration
+}
+
+// queueRef looks a queue up by name when it is used so queues can refer to
+// queues declared after them.
+type queueRef struct {
+	name   string
+	queues map[string]queues.Queue
+}
+
+func (r *queueRef) PushItems(ctx context.Context, job job.Job, stdOut, stdErr io.ReadWriter, errChan chan error) {
+	r.queues[r.name].PushItems(ctx, job, stdOut, stdErr, errChan)
+}
+
+func makeConditionals(configuration Configuration) (map[string]conditionals.CheckFunc, error) {
+	conditionalMap := make(map[string]conditionals.CheckFunc)
 	for _, configCondition := range configuration.Conditionals {
-		// todo move to its own function
-		switch configCondition.Operator {
-		case ">":
-			conditional := conditionals.NewCondition(configCondition.Element, conditionals.GreaterThan, configCondition.Comparison)
-			conditionalMap[configCondition.Name] = conditional.Evaluate
-		case "contains":
-			conditional := conditionals.NewCondition(configCondition.Element, conditionals.Contains, configCondition.Comparison)
-			conditionalMap[configCondition.Name] = conditional.Evaluate
-		case "==":
-			conditional := conditionals.NewCondition(configCondition.Element, conditionals.Equal, configCondition.Comparison)
-			conditionalMap[configCondition.Name] = conditional.Evaluate
+		opts := make([]conditionals.ConditionOption, 0)
+		switch missing := conditionals.Missing(configCondition.Missing); missing {
+		case "":
+		case conditionals.MissingFalse, conditionals.MissingTrue, conditionals.MissingError:
+			opts = append(opts, conditionals.WithMissing(missing))
+		default:
+			return nil, fmt.Errorf("conditional %s: unknown missing behaviour %q", configCondition.Name, configCondition.Missing)
 		}
+
+		operator, err := operator(configCondition.Operator)
+		if err != nil {
+			return nil, fmt.Errorf("conditional %s: %w", configCondition.Name, err)
+		}
+
+		conditional := conditionals.NewCondition(configCondition.Element, operator, configCondition.Comparison, opts...)
+		conditionalMap[configCondition.Name] = conditional.Check
 	}
 
 	return conditionalMap, nil
 }
 
+// operator returns the conditional operator named in a configuration.
+func operator(name string) (conditionals.Operator, error) {
+	switch operator := conditionals.Operator(name); operator {
+	case conditionals.GreaterThan, conditionals.GreaterThanEqualTo, conditionals.LessThan, conditionals.LessThanEqualTo, conditionals.Equal, conditionals.Contains:
+		return operator, nil
+	}
+
+	return "", fmt.Errorf("unknown operator %q", name)
+}
+
 func makeExecutors(configuration Configuration) (map[string]executers.ExecFunc, error) {
 	execMap := make(map[string]executers.ExecFunc)
 	for _, executorConfiguration := range configuration.Executors {
@@ -201,13 +628,50 @@ func makeExecutors(configuration Configuration) (map[string]executers.ExecFunc,
 	return execMap, nil
 }
 
-func makePipeline(configuration Configuration, queues map[string]queues.Queue, conditionalMap map[string]conditionals.ConditionFunc, executorsMap map[string]executers.ExecFunc, meter metric2.Meter) (pipelines.ProcessPipeline, error) {
-	// todo check length
+func makeTransforms(configuration Configuration) (map[string]*transforms.Transformer, error) {
+	transformMap := make(map[string]*transforms.Transformer)
+	for _, transformConfiguration := range configuration.Transforms {
+		steps := make([]transforms.Step, 0, len(transformConfiguration.Steps))
+		for _, stepConfiguration := range transformConfiguration.Steps {
+			step, err := transforms.NewStep(transforms.Action(stepConfiguration.Action), stepConfiguration.Path, stepConfiguration.From, stepConfiguration.Value)
+			if err != nil {
+				return nil, fmt.Errorf("transform %s: %w", transformConfiguration.Name, err)
+			}
+
+			steps = append(steps, step)
+		}
+
+		transformMap[transformConfiguration.Name] = transforms.NewTransformer(transformConfiguration.Name, steps...)
+	}
+
+	return transformMap, nil
+}
+
+func makePipeline(configuration Configuration, queues map[string]queues.Queue, conditionalMap map[string]conditionals.CheckFunc, executorsMap map[string]executers.ExecFunc, transformMap map[string]*transforms.Transformer, meter metric2.Meter) (pipelines.ProcessPipeline, error) {
+	queueGetItems, execFunc, decisionTrees, err := makeDecisionTrees(configuration, queues, conditionalMap, executorsMap, transformMap, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := pipelines.NewPipeline(configuration.Name, meter, queueGetItems, execFunc, decisionTrees...)
+
+	return pipeline, nil
+}
+
+// makeDecisionTrees returns the queue a pipeline reads, its executor and its
+// decision trees. With a recorder the conditions and branches record what
+// they do, and the queue functions and executors only record what they would
+// have done.
+func makeDecisionTrees(configuration Configuration, queues map[string]queues.Queue, conditionalMap map[string]conditionals.CheckFunc, executorsMap map[string]executers.ExecFunc, transformMap map[string]*transforms.Transformer, recorder *pipelines.Recorder) (queues.GetQueue, executers.ExecFunc, []*pipelines.DecisionTree, error) {
+	if len(configuration.Pipelines.GetItems) == 0 {
+		return nil, nil, nil, errors.New("pipeline has no get items queue")
+	}
+
 	queueGetItems, ok := queues[configuration.Pipelines.GetItems[0].Name]
 	if !ok {
 		logs.Error(context.Background(), "could not find get items queue", logs.WithValue("queueName", configuration.Pipelines.GetItems[0].Name))
 
-		return nil, errors.New("could not find get items queue")
+		return nil, nil, nil, errors.New("could not find get items queue")
 	}
 
 	decisionTrees := make([]*pipelines.DecisionTree, 0)
@@ -215,44 +679,97 @@ func makePipeline(configuration Configuration, queues map[string]queues.Queue, c
 		close(errChan)
 	}
 	if configuration.Pipelines.Executor != nil {
-		execFunc = executorsMap[configuration.Pipelines.Executor.Name]
+		executor, ok := executorsMap[configuration.Pipelines.Executor.Name]
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("could not find executor %q", configuration.Pipelines.Executor.Name)
+		}
+
+		execFunc = executor
+		if recorder != nil {
+			execFunc = recorder.Exec(configuration.Pipelines.Executor.Name)
+		}
 	}
 	for _, configConditional := range configuration.Pipelines.DecisionTree {
 		if conditional, ok := conditionalMap[configConditional.Name]; ok {
+			if recorder != nil {
+				conditional = recorder.Condition(configConditional.Name, conditional)
+			}
 
 			// get queue
 			successQueue, err := getQueueForQueueFunc(queues, configConditional.Success)
 			if err != nil {
 				logs.Error(context.Background(), "could not find queue", logs.WithValue("queueName", configConditional.Success.Name))
 
-				return nil, err
+				return nil, nil, nil, err
 			}
 
 			failureQueue, err := getQueueForQueueFunc(queues, configConditional.Failure)
 			if err != nil && !configConditional.Failure.Return {
 				logs.Error(context.Background(), "could not find queue", logs.WithValue("queueName", configConditional.Failure.Name))
 
-				return nil, err
+				return nil, nil, nil, err
 			}
 
 			successFunc, successReturn := getQueueFunc(successQueue, configConditional.Success)
 			failureFunc, falseReturn := getQueueFunc(failureQueue, configConditional.Failure)
 			if err != nil {
-				return nil, err
+				return nil, nil, nil, err
+			}
+
+			if recorder != nil {
+				successFunc, failureFunc = dryRunFunc(recorder, configConditional.Success), dryRunFunc(recorder, configConditional.Failure)
+			}
+
+			successFunc, err = withTransforms(transformMap, configConditional.Success, successFunc)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+
+			failureFunc, err = withTransforms(transformMap, configConditional.Failure, failureFunc)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+
+			if recorder != nil {
+				successFunc = recorder.Branch("success", successReturn, successFunc)
+				failureFunc = recorder.Branch("failure", falseReturn, failureFunc)
 			}
 
 			// then return function
 			decisionTree := pipelines.NewConditionTree(configConditional.Name, conditional, successFunc, failureFunc, successReturn, falseReturn)
+
+			if configConditional.Error != nil {
+				errorQueue, err := getQueueForQueueFunc(queues, configConditional.Error)
+				if err != nil {
+					logs.Error(context.Background(), "could not find queue", logs.WithValue("queueName", configConditional.Error.Name))
+
+					return nil, nil, nil, err
+				}
+
+				errorFunc, errorReturn := getQueueFunc(errorQueue, configConditional.Error)
+				if recorder != nil {
+					errorFunc = dryRunFunc(recorder, configConditional.Error)
+				}
+
+				errorFunc, err = withTransforms(transformMap, configConditional.Error, errorFunc)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+
+				if recorder != nil {
+					errorFunc = recorder.Branch("error", errorReturn, errorFunc)
+				}
+
+				decisionTree.OnError(errorFunc, errorReturn)
+			}
 			decisionTrees = append(decisionTrees, &decisionTree)
 		} else {
 			logs.Error(context.Background(), "could not find conditional", logs.WithValue("conditionalName", configConditional.Name))
-			return nil, errors.New("could not find conditional")
+			return nil, nil, nil, errors.New("could not find conditional")
 		}
 	}
 
-	pipeline := pipelines.NewPipeline(configuration.Name, meter, queueGetItems, execFunc, decisionTrees...)
-
-	return pipeline, nil
+	return queueGetItems, execFunc, decisionTrees, nil
 }
 
 func getQueue(queues map[string]queues.Queue, name string) (queues.Queue, error) {
@@ -279,9 +796,33 @@ func getQueueForQueueFunc(queues map[string]queues.Queue, queueFunc *PipelineCon
 		return getQueue(queues, queueFunc.RemoveItem[0].Name)
 	}
 
+	if queueFunc.Actions != nil {
+		return getActionQueue(queues, queueFunc.Actions[0])
+	}
+
 	return &noopQueue{}, nil
 }
 
+// getActionQueue returns the queue of a branch action, checking the queue
+// has the action so getQueueFunc can look it up.
+func getActionQueue(queueMap map[string]queues.Queue, action *PipelineConditionTreeFuncAction) (queues.Queue, error) {
+	queue, err := getQueue(queueMap, action.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	actionQueue, ok := queue.(queues.ActionQueue)
+	if !ok {
+		return nil, fmt.Errorf("queue %s has no actions", action.Name)
+	}
+
+	if _, ok := actionQueue.Action(action.Action); !ok {
+		return nil, fmt.Errorf("queue %s has no action %q", action.Name, action.Action)
+	}
+
+	return queue, nil
+}
+
 func getExecutorFromMap(queues map[string]executers.ExecFunc, queueFunc *PipelineConditionTreeFunc) (executers.ExecFunc, error) {
 	if queueFunc == nil {
 		return nil, nil
@@ -317,6 +858,12 @@ func getQueueFunc(queue queues.Queue, condFunc *PipelineConditionTreeFunc) (exec
 		return queue.RemoveItems, condFunc.Return
 	}
 
+	if condFunc.Actions != nil {
+		action, _ := queue.(queues.ActionQueue).Action(condFunc.Actions[0].Action)
+
+		return executers.ExecFunc(action), condFunc.Return
+	}
+
 	return func(ctx context.Context, job job.Job, stdOut, stdErr io.ReadWriter, errChan chan error) {
 		defer close(errChan)
 		logs.Debug(ctx, "noop queue func", logs.WithValue("job", job))
@@ -324,13 +871,52 @@ func getQueueFunc(queue queues.Queue, condFunc *PipelineConditionTreeFunc) (exec
 	}, condFunc.Return
 }
 
+// dryRunFunc records what the branch's queue function would have done.
+func dryRunFunc(recorder *pipelines.Recorder, condFunc *PipelineConditionTreeFunc) executers.ExecFunc {
+	switch {
+	case condFunc == nil:
+	case condFunc.PushItem != nil:
+		return recorder.Push(condFunc.PushItem[0].Name)
+	case condFunc.RemoveItem != nil:
+		return recorder.Remove(condFunc.RemoveItem[0].Name)
+	case condFunc.Actions != nil:
+		return recorder.Action(condFunc.Actions[0].Name, condFunc.Actions[0].Action)
+	}
+
+	return func(ctx context.Context, job job.Job, stdOut, stdErr io.ReadWriter, errChan chan error) {
+		close(errChan)
+	}
+}
+
+// withTransforms wraps the branch function so the branch's transforms are
+// applied to the job before it is pushed, removed or executed
+func withTransforms(transformMap map[string]*transforms.Transformer, condFunc *PipelineConditionTreeFunc, execFunc executers.ExecFunc) (executers.ExecFunc, error) {
+	if condFunc == nil {
+		return execFunc, nil
+	}
+
+	for idx := len(condFunc.Transforms) - 1; idx >= 0; idx-- {
+		name := condFunc.Transforms[idx].Name
+		transformer, ok := transformMap[name]
+		if !ok {
+			logs.Error(context.Background(), "could not find transform", logs.WithValue("transformName", name))
+
+			return nil, errors.New("could not find transform")
+		}
+
+		execFunc = transformer.Middleware()(execFunc)
+	}
+
+	return execFunc, nil
+}
+
 // make conditional middleware
 
 type noopQueue struct {
 }
 
 func (queue *noopQueue) GetItems(ctx context.Context, jobChan chan<- job.Job) error {
-	//TODO implement me
+	close(jobChan)
 	return nil
 }
 
@@ -2,9 +2,14 @@ package queue
 
 import (
 	"context"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/pkg/memory"
+	"go.opentelemetry.io/otel/metric/noop"
+	"gopkg.in/yaml.v3"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestConfiguration(t *testing.T) {
@@ -53,3 +58,67 @@ func TestConfiguration(t *testing.T) {
 		//cancel()
 	})
 }
+
+func TestBuild_Memory(t *testing.T) {
+	configuration := Configuration{}
+	require.Nil(t, yaml.Unmarshal([]byte(`
+name: memory
+queues:
+  - name: retry
+    memory:
+      jobs:
+        - '{"class":"WebhookWorker","jid":"1"}'
+        - '{"class":"MailWorker","jid":"2"}'
+  - name: dead
+    memory:
+      type: fifo
+conditionals:
+  - name: isWebhook
+    operator: "=="
+    element: class
+    comparison: WebhookWorker
+pipeline:
+  getItems:
+    - name: retry
+  decisionTree:
+    - name: isWebhook
+      success:
+        name: pushToDead
+        pushItems:
+          - name: dead
+    - name: isWebhook
+      success:
+        name: removeFromRetry
+        removeItems:
+          - name: retry
+        return: true
+`), &configuration))
+
+	t.Run("success", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		pipeline, queueMap, err := build(ctx, configuration, noop.NewMeterProvider().Meter("test"))
+		require.Nil(t, err)
+
+		go func() {
+			_ = pipeline.Start(ctx)
+		}()
+
+		retry := queueMap["retry"].(*memory.Queue)
+		dead := queueMap["dead"].(*memory.Queue)
+		require.Eventually(t, func() bool {
+			return retry.Len() == 1 && dead.Len() == 1
+		}, time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, [][]byte{[]byte(`{"class":"MailWorker","jid":"2"}`)}, retry.Items())
+		assert.Equal(t, [][]byte{[]byte(`{"class":"WebhookWorker","jid":"1"}`)}, dead.Items())
+	})
+	t.Run("failure", func(t *testing.T) {
+		invalid := configuration
+		invalid.Queues = []QueueConfiguration{{Name: "retry", MemoryConfiguration: &MemoryConfiguration{Type: "stack"}}}
+
+		_, _, err := build(context.Background(), invalid, noop.NewMeterProvider().Meter("test"))
+		assert.NotNil(t, err)
+	})
+}
@@ -1,18 +1,54 @@
 package queue
 
+import (
+	"github.com/thethan/goqueue/internal/job"
+	"time"
+)
+
 type Configuration struct {
 	Name        string       `yaml:"name"`
 	DataSources []DataSource `yaml:"dataSources"`
 	// Queues have the ability
-	Queues       []QueueConfiguration    `yaml:"queues"`
-	Conditionals []Conditional           `yaml:"conditionals"`
-	Pipelines    Pipeline                `yaml:"pipeline"`
-	Executors    []ExecutorConfiguration `yaml:"executors"`
+	Queues       []QueueConfiguration     `yaml:"queues"`
+	Conditionals []Conditional            `yaml:"conditionals"`
+	Pipelines    Pipeline                 `yaml:"pipeline"`
+	Executors    []ExecutorConfiguration  `yaml:"executors"`
+	Transforms   []TransformConfiguration `yaml:"transforms"`
+	Schedulers   []SchedulerConfiguration `yaml:"schedulers,omitempty"`
 }
 
 type QueueConfiguration struct {
 	Name               string              `yaml:"name"`
 	RedisConfiguration *RedisConfiguration `yaml:"redis,omitempty"`
+	// MemoryConfiguration keeps the queue in memory instead of redis
+	MemoryConfiguration *MemoryConfiguration `yaml:"memory,omitempty"`
+	// FileConfiguration reads the queue from json lines files instead
+	FileConfiguration *FileConfiguration `yaml:"file,omitempty"`
+	// SQLiteConfiguration reads the queue from a sqlite data source
+	SQLiteConfiguration *SQLiteConfiguration `yaml:"sqlite,omitempty"`
+	// HTTPConfiguration reads the queue from jobs posted to an http data
+	// source
+	HTTPConfiguration *HTTPConfiguration `yaml:"http,omitempty"`
+	// NATSConfiguration reads the queue from a JetStream stream
+	NATSConfiguration *NATSConfiguration `yaml:"nats,omitempty"`
+	// Format is how jobs are encoded in the queue: json (the default), yaml,
+	// msgpack, proto or celery. Jobs pushed from a queue with another format
+	// are converted.
+	Format job.RawJobType `yaml:"format,omitempty"`
+	Proto  *ProtoFormat   `yaml:"proto,omitempty"`
+	// Schema is validated against every job read from the queue
+	Schema []job.Field `yaml:"schema,omitempty"`
+	// Quarantine is the name of the queue jobs that can't be decoded or fail
+	// the schema are moved to. Without it they are skipped.
+	Quarantine string `yaml:"quarantine,omitempty"`
+}
+
+// ProtoFormat names the message proto jobs are encoded as and the
+// descriptor set, written by protoc --include_imports --descriptor_set_out,
+// that describes it.
+type ProtoFormat struct {
+	DescriptorSet string `yaml:"descriptorSet"`
+	MessageType   string `yaml:"messageType"`
 }
 
 type RedisQueueType string
@@ -20,24 +56,196 @@ type RedisQueueType string
 const (
 	ZType  RedisQueueType = "zset"
 	LRange RedisQueueType = "lrange"
+	// Sidekiq queues use sidekiq's key layout, their key is the name of a
+	// sidekiq queue or one of the schedule, retry and dead sets
+	Sidekiq RedisQueueType = "sidekiq"
+	// Resque queues' key is a resque queue, resque:queue:<name>, or the
+	// failed list, resque:failed
+	Resque RedisQueueType = "resque"
+	// Celery queues' key is the name of a celery queue or kombu's unacked
+	// hash, their format defaults to celery
+	Celery RedisQueueType = "celery"
+	// BullMQ queues' key is one of a queue's states, <prefix>:<queue>:<state>
+	// such as bull:emails:failed
+	BullMQ RedisQueueType = "bullmq"
+	// Stream queues' key is a redis stream read through a consumer group,
+	// see StreamConfiguration
+	Stream RedisQueueType = "stream"
 )
 
+// MemoryConfiguration is a queue kept in memory, for tests and trying
+// pipelines out without redis. Type is list (the default), zset or fifo.
+// Jobs in a zset queue are due at the unix time in their ScoreField, or when
+// pushed without one. Jobs are the queue's initial contents, head first.
+type MemoryConfiguration struct {
+	Type       string   `yaml:"type,omitempty"`
+	ScoreField string   `yaml:"scoreField,omitempty"`
+	Jobs       []string `yaml:"jobs,omitempty"`
+}
+
+// FileConfiguration is a queue read a line at a time from a file, or from
+// the files in a directory matching Pattern (*.jsonl by default), resuming
+// from the offsets kept in Checkpoint (<path>.checkpoint, or .checkpoint in
+// the directory, by default). Jobs pushed to a file queue are appended to
+// the file, which is rotated when it would grow past MaxBytes.
+type FileConfiguration struct {
+	Path       string `yaml:"path"`
+	Pattern    string `yaml:"pattern,omitempty"`
+	Checkpoint string `yaml:"checkpoint,omitempty"`
+	MaxBytes   int64  `yaml:"maxBytes,omitempty"`
+}
+
+// SQLiteConfiguration is a queue in a sqlite data source. Queue is its name
+// in the database, the queue's name by default. State is the table read:
+// ready (the default) claims jobs, moving them in flight until they are
+// acked or VisibilityTimeout (30s by default) passes, while scheduled,
+// inflight and dead read jobs where they are. Pushed jobs are ordered by the
+// number in PriorityField, highest first, and scheduled for the unix time in
+// RunAtField.
+type SQLiteConfiguration struct {
+	Datasource        string        `yaml:"dataSource"`
+	Queue             string        `yaml:"queue,omitempty"`
+	State             string        `yaml:"state,omitempty"`
+	VisibilityTimeout time.Duration `yaml:"visibilityTimeout,omitempty"`
+	PollInterval      time.Duration `yaml:"pollInterval,omitempty"`
+	PriorityField     string        `yaml:"priorityField,omitempty"`
+	RunAtField        string        `yaml:"runAtField,omitempty"`
+}
+
+// HTTPConfiguration is a queue of the json jobs, or arrays of them, posted
+// to /queues/{name}/jobs on an http data source. Name defaults to the
+// queue's name. Accepted jobs wait for the pipeline in a buffer of Buffer
+// jobs (100 by default), requests are answered 429 while it is full.
+type HTTPConfiguration struct {
+	Datasource string `yaml:"dataSource"`
+	Name       string `yaml:"name,omitempty"`
+	Buffer     int    `yaml:"buffer,omitempty"`
+}
+
+// NATSConfiguration is a JetStream stream, created for Subject if it
+// doesn't exist, read through the durable pull consumer Durable (goqueue by
+// default). Jobs are delivered again when they aren't acked within AckWait
+// (30s by default), up to MaxDeliver times, and jobs that failed after
+// RetryDelay (5s by default). Removed jobs are terminated.
+type NATSConfiguration struct {
+	Datasource string        `yaml:"dataSource"`
+	Stream     string        `yaml:"stream"`
+	Subject    string        `yaml:"subject"`
+	Durable    string        `yaml:"durable,omitempty"`
+	AckWait    time.Duration `yaml:"ackWait,omitempty"`
+	MaxDeliver int           `yaml:"maxDeliver,omitempty"`
+	RetryDelay time.Duration `yaml:"retryDelay,omitempty"`
+}
+
 type RedisConfiguration struct {
 	Key        string         `yaml:"key"`
 	Datasource string         `yaml:"dataSource"`
 	Type       RedisQueueType `yaml:"type"`
+	// Stream configures stream queues
+	Stream *StreamConfiguration `yaml:"stream,omitempty"`
+	// Reference makes the members of zset and lrange queues ids of jobs
+	// stored under their own keys
+	Reference *ReferenceConfiguration `yaml:"reference,omitempty"`
+}
+
+// ReferenceConfiguration is where the jobs a queue's members refer to are
+// kept, <prefix><id>. Type is string (the default) for jobs read with GET or
+// hash for jobs read with HGETALL. Pushed jobs are stored under the value of
+// their IDField, jid by default.
+type ReferenceConfiguration struct {
+	Prefix  string `yaml:"prefix,omitempty"`
+	Type    string `yaml:"type,omitempty"`
+	IDField string `yaml:"idField,omitempty"`
+}
+
+// StreamConfiguration is the consumer group a stream queue reads under. The
+// group defaults to goqueue and the consumer to the host's name. Entries of
+// failed jobs are read again by the consumer, entries pending for longer
+// than ClaimIdle on another consumer are claimed, and pushes trim the stream
+// to about MaxLen entries when it is set.
+type StreamConfiguration struct {
+	Group     string        `yaml:"group,omitempty"`
+	Consumer  string        `yaml:"consumer,omitempty"`
+	Field     string        `yaml:"field,omitempty"`
+	ClaimIdle time.Duration `yaml:"claimIdle,omitempty"`
+	MaxLen    int64         `yaml:"maxLen,omitempty"`
 }
 
 type DataSource struct {
 	Name               string       `yaml:"name"`
 	RedisConfiguration *RedisClient `yaml:"redis,omitempty"`
+	// SQLiteConfiguration is a sqlite database holding durable queues
+	SQLiteConfiguration *SQLiteDatabase `yaml:"sqlite,omitempty"`
+	// HTTPConfiguration is a server accepting jobs for http queues
+	HTTPConfiguration *HTTPServer `yaml:"http,omitempty"`
+	// NATSConfiguration is a NATS server with JetStream enabled
+	NATSConfiguration *NATSServer `yaml:"nats,omitempty"`
+}
+
+// NATSServer is how to connect to NATS. URL defaults to NATS_URL, or
+// nats://127.0.0.1:4222 without it. Credentials is a .creds file for
+// servers that require one.
+type NATSServer struct {
+	URL         string `yaml:"url,omitempty"`
+	Credentials string `yaml:"credentials,omitempty"`
+}
+
+// HTTPServer listens on Addr, such as :8080, for jobs posted to
+// /queues/{name}/jobs while its queues are read. Requests authenticate with
+// Authorization: Bearer <token>, the token defaulting to
+// GOQUEUE_HTTP_TOKEN. MaxBodyBytes defaults to 1MiB.
+type HTTPServer struct {
+	Addr         string `yaml:"addr"`
+	Token        string `yaml:"token,omitempty"`
+	MaxBodyBytes int64  `yaml:"maxBodyBytes,omitempty"`
 }
 
+// SQLiteDatabase is the file of a sqlite database, created with its tables
+// if it doesn't exist. BusyTimeout is how long writers wait for each other,
+// 5s by default.
+type SQLiteDatabase struct {
+	Path        string        `yaml:"path"`
+	BusyTimeout time.Duration `yaml:"busyTimeout,omitempty"`
+}
+
+// RedisClient is how to connect to a redis server, cluster or the master
+// monitored by sentinels. Username and password are sent when they are set,
+// and default to REDIS_USERNAME and REDIS_PASSWORD when Auth or REDIS_AUTH
+// is set. Host defaults to REDIS_HOST. In a cluster
+// transactions are split by slot, so they are only atomic when their keys
+// share a hash tag, for example {bull}:emails:wait.
 type RedisClient struct {
-	Host     string `yaml:"host"`
-	Auth     bool   `yaml:"auth,omitempty"`
-	Username string `yaml:"username,omitempty"`
-	Password string `yaml:"password,omitempty"`
+	Host string `yaml:"host"`
+	// Addrs are the addresses of the cluster's nodes or of the sentinels
+	Addrs []string `yaml:"addrs,omitempty"`
+	// Cluster connects to a cluster even through a single address. More
+	// than one address without a master name always is one.
+	Cluster bool `yaml:"cluster,omitempty"`
+	// MasterName is the name of the master the sentinels at Addrs monitor
+	MasterName string `yaml:"masterName,omitempty"`
+	// DB is the database selected, not supported by clusters
+	DB       int               `yaml:"db,omitempty"`
+	Auth     bool              `yaml:"auth,omitempty"`
+	Username string            `yaml:"username,omitempty"`
+	Password string            `yaml:"password,omitempty"`
+	TLS      *TLSConfiguration `yaml:"tls,omitempty"`
+	PoolSize int               `yaml:"poolSize,omitempty"`
+	// DialTimeout, ReadTimeout and WriteTimeout default to go-redis' 5s, 3s
+	// and the read timeout
+	DialTimeout  time.Duration `yaml:"dialTimeout,omitempty"`
+	ReadTimeout  time.Duration `yaml:"readTimeout,omitempty"`
+	WriteTimeout time.Duration `yaml:"writeTimeout,omitempty"`
+}
+
+// TLSConfiguration enables TLS. CA is a PEM file of the certificates to
+// trust instead of the system's, Cert and Key a PEM client certificate and
+// its key for servers that require one.
+type TLSConfiguration struct {
+	CA                 string `yaml:"ca,omitempty"`
+	Cert               string `yaml:"cert,omitempty"`
+	Key                string `yaml:"key,omitempty"`
+	ServerName         string `yaml:"serverName,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify,omitempty"`
 }
 
 type Conditional struct {
@@ -45,6 +253,10 @@ type Conditional struct {
 	Operator   string      `yaml:"operator"`
 	Element    string      `yaml:"element"`
 	Comparison interface{} `yaml:"comparison"`
+	// Missing is how the conditional evaluates when the element is not in
+	// the job: false (the default), true, or error to route the job to the
+	// decision tree's error branch, or its failure branch without one
+	Missing string `yaml:"missing,omitempty"`
 }
 
 type Pipeline struct {
@@ -61,6 +273,7 @@ type PipelineConditionTree struct {
 	Name     string                     `yaml:"name"`
 	Success  *PipelineConditionTreeFunc `yaml:"success,omitempty"`
 	Failure  *PipelineConditionTreeFunc `yaml:"failure,omitempty"`
+	Error    *PipelineConditionTreeFunc `yaml:"error,omitempty"`
 	Executor *PipelineConditionTreeFunc `yaml:"executor,omitempty"`
 	Return   bool                       `yaml:"return"`
 }
@@ -70,14 +283,50 @@ type PipelineConditionTreeFunc struct {
 	PushItem   []*PipelineConditionTreeFuncQueueName `yaml:"pushItems,omitempty"`
 	RemoveItem []*PipelineConditionTreeFuncQueueName `yaml:"removeItems,omitempty"`
 	Executors  []*PipelineConditionTreeFuncQueueName `yaml:"executors,omitempty"`
-	Return     bool                                  `yaml:"return"`
+	// Transforms are applied in order to the job before the branch's queue
+	// function is called
+	Transforms []*PipelineConditionTreeFuncQueueName `yaml:"transforms,omitempty"`
+	// Actions call an operation of the named queue, such as a sidekiq
+	// queue's retryNow, kill or delete
+	Actions []*PipelineConditionTreeFuncAction `yaml:"actions,omitempty"`
+	Return  bool                               `yaml:"return"`
 }
 
 type PipelineConditionTreeFuncQueueName struct {
 	Name string `yaml:"name"`
 }
 
+type PipelineConditionTreeFuncAction struct {
+	Name   string `yaml:"name"`
+	Action string `yaml:"action"`
+}
+
+// SchedulerConfiguration enqueues due jobs from sidekiq queues for the
+// schedule and retry sets onto the queues named in the jobs, every Interval
+// (5s by default).
+type SchedulerConfiguration struct {
+	Name     string             `yaml:"name"`
+	Queues   []PipelineGetItems `yaml:"queues"`
+	Interval time.Duration      `yaml:"interval,omitempty"`
+}
+
 type ExecutorConfiguration struct {
 	Name       string `yaml:"name"`
 	SprintfCMD string `yaml:"command"`
 }
+
+type TransformConfiguration struct {
+	Name  string          `yaml:"name"`
+	Steps []TransformStep `yaml:"steps"`
+}
+
+// TransformStep is one mutation of a job. Action is one of set, unset,
+// increment, rename or copy. Value is the value to set (templated when it
+// contains "{{") or the amount to increment by, From is the source field for
+// rename and copy.
+type TransformStep struct {
+	Action string      `yaml:"action"`
+	Path   string      `yaml:"path"`
+	From   string      `yaml:"from,omitempty"`
+	Value  interface{} `yaml:"value,omitempty"`
+}
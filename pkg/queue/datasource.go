@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+	"os"
+)
+
+// newRedisClient connects to the server, cluster or sentinel monitored
+// master of a data source.
+func newRedisClient(configuration *RedisClient) (redis.UniversalClient, error) {
+	options, err := redisOptions(configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case options.MasterName != "":
+		return redis.NewFailoverClient(options.Failover()), nil
+	case configuration.Cluster || len(options.Addrs) > 1:
+		return redis.NewClusterClient(options.Cluster()), nil
+	}
+
+	return redis.NewClient(options.Simple()), nil
+}
+
+func redisOptions(configuration *RedisClient) (*redis.UniversalOptions, error) {
+	addrs := configuration.Addrs
+	if len(addrs) == 0 && configuration.Host != "" {
+		addrs = []string{configuration.Host}
+	}
+
+	if len(addrs) == 0 {
+		addrs = []string{viper.GetString(envRedisHost)}
+	}
+
+	cluster := configuration.Cluster || (len(addrs) > 1 && configuration.MasterName == "")
+	if cluster && configuration.DB != 0 {
+		return nil, errors.New("clusters only have database 0")
+	}
+
+	options := &redis.UniversalOptions{
+		Addrs:        addrs,
+		MasterName:   configuration.MasterName,
+		DB:           configuration.DB,
+		PoolSize:     configuration.PoolSize,
+		DialTimeout:  configuration.DialTimeout,
+		ReadTimeout:  configuration.ReadTimeout,
+		WriteTimeout: configuration.WriteTimeout,
+	}
+
+	// configured credentials are always sent, auth only falls back to the
+	// environment's
+	options.Username = configuration.Username
+	options.Password = configuration.Password
+	if configuration.Auth || viper.GetBool(envRedisAuth) {
+		if options.Username == "" {
+			options.Username = viper.GetString(envRedisUsername)
+		}
+
+		if options.Password == "" {
+			options.Password = viper.GetString(envRedisPassword)
+		}
+	}
+
+	if configuration.TLS != nil {
+		tlsConfig, err := tlsConfiguration(configuration.TLS)
+		if err != nil {
+			return nil, err
+		}
+
+		options.TLSConfig = tlsConfig
+	}
+
+	return options, nil
+}
+
+func tlsConfiguration(configuration *TLSConfiguration) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         configuration.ServerName,
+		InsecureSkipVerify: configuration.InsecureSkipVerify,
+	}
+
+	if configuration.CA != "" {
+		bts, err := os.ReadFile(configuration.CA)
+		if err != nil {
+			return nil, fmt.Errorf("could not read tls ca: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bts) {
+			return nil, fmt.Errorf("no certificates in tls ca %s", configuration.CA)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if configuration.Cert != "" || configuration.Key != "" {
+		certificate, err := tls.LoadX509KeyPair(configuration.Cert, configuration.Key)
+		if err != nil {
+			return nil, fmt.Errorf("could not load tls certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,110 @@
+package queue
+
+import (
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNewRedisClient(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		t.Run("single", func(t *testing.T) {
+			client, err := newRedisClient(&RedisClient{Host: "localhost:6379", DB: 2})
+			require.Nil(t, err)
+			assert.IsType(t, &redis.Client{}, client)
+			assert.Equal(t, 2, client.(*redis.Client).Options().DB)
+		})
+		t.Run("cluster", func(t *testing.T) {
+			client, err := newRedisClient(&RedisClient{Addrs: []string{"node1:6379", "node2:6379"}})
+			require.Nil(t, err)
+			assert.IsType(t, &redis.ClusterClient{}, client)
+
+			client, err = newRedisClient(&RedisClient{Host: "cluster:6379", Cluster: true})
+			require.Nil(t, err)
+			assert.IsType(t, &redis.ClusterClient{}, client)
+		})
+		t.Run("sentinel", func(t *testing.T) {
+			client, err := newRedisClient(&RedisClient{Addrs: []string{"sentinel1:26379", "sentinel2:26379"}, MasterName: "mymaster"})
+			require.Nil(t, err)
+			assert.IsType(t, &redis.Client{}, client)
+		})
+		t.Run("options", func(t *testing.T) {
+			options, err := redisOptions(&RedisClient{
+				Host:         "localhost:6379",
+				PoolSize:     20,
+				DialTimeout:  time.Second,
+				ReadTimeout:  2 * time.Second,
+				WriteTimeout: 3 * time.Second,
+				TLS:          &TLSConfiguration{InsecureSkipVerify: true, ServerName: "redis"},
+			})
+			require.Nil(t, err)
+			assert.Equal(t, []string{"localhost:6379"}, options.Addrs)
+			assert.Equal(t, 20, options.PoolSize)
+			assert.Equal(t, time.Second, options.DialTimeout)
+			assert.Equal(t, 2*time.Second, options.ReadTimeout)
+			assert.Equal(t, 3*time.Second, options.WriteTimeout)
+			require.NotNil(t, options.TLSConfig)
+			assert.True(t, options.TLSConfig.InsecureSkipVerify)
+			assert.Equal(t, "redis", options.TLSConfig.ServerName)
+		})
+		t.Run("auth", func(t *testing.T) {
+			// configured credentials are sent without auth
+			options, err := redisOptions(&RedisClient{Host: "localhost:6379", Username: "user", Password: "secret"})
+			require.Nil(t, err)
+			assert.Equal(t, "user", options.Username)
+			assert.Equal(t, "secret", options.Password)
+
+			options, err = redisOptions(&RedisClient{Host: "localhost:6379", Auth: true, Username: "user", Password: "secret"})
+			require.Nil(t, err)
+			assert.Equal(t, "user", options.Username)
+			assert.Equal(t, "secret", options.Password)
+
+			viper.Set(envRedisPassword, "from-env")
+			defer viper.Set(envRedisPassword, defaultRedisPassword)
+
+			options, err = redisOptions(&RedisClient{Host: "localhost:6379", Auth: true})
+			require.Nil(t, err)
+			assert.Equal(t, "from-env", options.Password)
+
+			// the environment's are only used with auth
+			options, err = redisOptions(&RedisClient{Host: "localhost:6379"})
+			require.Nil(t, err)
+			assert.Empty(t, options.Password)
+		})
+	})
+	t.Run("failure", func(t *testing.T) {
+		t.Run("cluster database", func(t *testing.T) {
+			_, err := newRedisClient(&RedisClient{Addrs: []string{"node1:6379", "node2:6379"}, DB: 1})
+			assert.NotNil(t, err)
+		})
+		t.Run("missing ca", func(t *testing.T) {
+			_, err := newRedisClient(&RedisClient{Host: "localhost:6379", TLS: &TLSConfiguration{CA: t.TempDir() + "/ca.pem"}})
+			assert.NotNil(t, err)
+		})
+		t.Run("missing certificate", func(t *testing.T) {
+			_, err := newRedisClient(&RedisClient{Host: "localhost:6379", TLS: &TLSConfiguration{Cert: "cert.pem", Key: "key.pem"}})
+			assert.NotNil(t, err)
+		})
+	})
+}
+
+func TestNewIngestServer(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		_, err := newIngestServer(&HTTPServer{Addr: ":8080", Token: "secret"})
+		require.Nil(t, err)
+
+		viper.Set(envHTTPToken, "from-env")
+		defer viper.Set(envHTTPToken, "")
+
+		_, err = newIngestServer(&HTTPServer{Addr: ":8080"})
+		require.Nil(t, err)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		_, err := newIngestServer(&HTTPServer{Addr: ":8080"})
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/pipelines"
+	"github.com/thethan/goqueue/internal/queues"
+	"os"
+)
+
+// BuildDryRun builds the pipeline of a configuration file as a dry run, which
+// reads its jobs and evaluates them through its decision trees but only
+// records the pushes, removals, actions and executors it would have run.
+// Jobs that can't be decoded are skipped rather than quarantined and
+// schedulers aren't started. Pipelines whose queue can only be read by
+// claiming or taking its jobs, such as http, JetStream and stream queues,
+// can't be dry run.
+func BuildDryRun(ctx context.Context, configFileLocation string) (*pipelines.DryRun, error) {
+	file, err := os.Open(configFileLocation)
+	if err != nil {
+		logs.Error(ctx, "could not open config file", logs.WithError(err), logs.WithValue("configFileLocation", configFileLocation))
+		return nil, err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	configuration, err := ValidateConfiguration(file)
+	if err != nil {
+		logs.Error(ctx, "invalid config file", logs.WithError(err), logs.WithValue("configFileLocation", configFileLocation))
+		return nil, err
+	}
+
+	return buildDryRun(ctx, configuration)
+}
+
+func buildDryRun(ctx context.Context, configuration Configuration) (*pipelines.DryRun, error) {
+	// quarantining moves jobs, so the queues are built without
+	queueConfigurations := make([]QueueConfiguration, 0, len(configuration.Queues))
+	for _, queueConfiguration := range configuration.Queues {
+		queueConfiguration.Quarantine = ""
+		queueConfigurations = append(queueConfigurations, queueConfiguration)
+	}
+
+	configuration.Queues = queueConfigurations
+
+	queuesMap, err := makeQueues(configuration)
+	if err != nil {
+		logs.Error(ctx, "could not make queues", logs.WithError(err))
+		return nil, err
+	}
+
+	conditionalMap, err := makeConditionals(configuration)
+	if err != nil {
+		logs.Error(ctx, "could not make conditionals", logs.WithError(err))
+		return nil, err
+	}
+
+	executors, err := makeExecutors(configuration)
+	if err != nil {
+		logs.Error(ctx, "could not get executors ", logs.WithError(err))
+		return nil, err
+	}
+
+	transformMap, err := makeTransforms(configuration)
+	if err != nil {
+		logs.Error(ctx, "could not build transforms", logs.WithError(err))
+		return nil, err
+	}
+
+	recorder := pipelines.NewRecorder()
+	getItems, execFunc, decisionTrees, err := makeDecisionTrees(configuration, queuesMap, conditionalMap, executors, transformMap, recorder)
+	if err != nil {
+		logs.Error(ctx, "could not make pipeline", logs.WithError(err))
+		return nil, err
+	}
+
+	source, ok := getItems.(queues.PageQueue)
+	if !ok {
+		name := configuration.Pipelines.GetItems[0].Name
+		logs.Error(ctx, "queue has no paging", logs.WithValue("queueName", name))
+		return nil, fmt.Errorf("queue %s has no paging, so it can't be dry run", name)
+	}
+
+	return pipelines.NewDryRun(configuration.Name, source, execFunc, recorder, decisionTrees...), nil
+}
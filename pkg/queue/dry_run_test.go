@@ -0,0 +1,141 @@
+package queue
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/pipelines"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func TestBuildDryRun(t *testing.T) {
+	configuration := Configuration{}
+	require.Nil(t, yaml.Unmarshal([]byte(`
+name: triage
+queues:
+  - name: retry
+    memory:
+      jobs:
+        - '{"class":"WebhookWorker","jid":"1","retry_count":5}'
+        - '{"class":"MailWorker","jid":"2"}'
+        - '{"class":"MailWorker","jid":"3","retry_count":1}'
+  - name: dead
+    memory:
+      type: fifo
+    quarantine: retry
+conditionals:
+  - name: isWebhook
+    operator: "=="
+    element: class
+    comparison: WebhookWorker
+  - name: retriedTooOften
+    operator: ">"
+    element: retry_count
+    comparison: 3
+    missing: error
+executors:
+  - name: notify
+    command: "echo {jid}"
+pipeline:
+  getItems:
+    - name: retry
+  executor:
+    name: notify
+  decisionTree:
+    - name: isWebhook
+      success:
+        name: pushToDead
+        pushItems:
+          - name: dead
+    - name: retriedTooOften
+      success:
+        name: removeFromRetry
+        removeItems:
+          - name: retry
+        return: true
+      error:
+        name: keep
+        return: true
+`), &configuration))
+
+	t.Run("success", func(t *testing.T) {
+		dryRun, err := buildDryRun(context.Background(), configuration)
+		require.Nil(t, err)
+
+		reports, err := dryRun.Run(context.Background(), 0)
+		require.Nil(t, err)
+
+		assert.Equal(t, []pipelines.JobReport{
+			{
+				Job: `{"class":"WebhookWorker","jid":"1","retry_count":5}`,
+				Trees: []pipelines.TreeReport{
+					{Name: "isWebhook", Matched: true, Branch: "success", Calls: []pipelines.Call{{Kind: pipelines.PushCall, Target: "dead"}}},
+					{Name: "retriedTooOften", Matched: true, Branch: "success", Return: true, Calls: []pipelines.Call{{Kind: pipelines.RemoveCall, Target: "retry"}}},
+				},
+			},
+			{
+				Job: `{"class":"MailWorker","jid":"2"}`,
+				Trees: []pipelines.TreeReport{
+					{Name: "isWebhook", Branch: "failure"},
+					{Name: "retriedTooOften", Error: "field not found: retry_count", Branch: "error", Return: true},
+				},
+			},
+			{
+				Job: `{"class":"MailWorker","jid":"3","retry_count":1}`,
+				Trees: []pipelines.TreeReport{
+					{Name: "isWebhook", Branch: "failure"},
+					{Name: "retriedTooOften", Branch: "failure"},
+				},
+				Calls: []pipelines.Call{{Kind: pipelines.ExecCall, Target: "notify"}},
+			},
+		}, reports)
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		dryRun, err := buildDryRun(context.Background(), configuration)
+		require.Nil(t, err)
+
+		reports, err := dryRun.Run(context.Background(), 1)
+		require.Nil(t, err)
+		require.Len(t, reports, 1)
+	})
+
+	t.Run("leaves the queue as it is", func(t *testing.T) {
+		dryRun, err := buildDryRun(context.Background(), configuration)
+		require.Nil(t, err)
+
+		first, err := dryRun.Run(context.Background(), 0)
+		require.Nil(t, err)
+
+		second, err := dryRun.Run(context.Background(), 0)
+		require.Nil(t, err)
+		assert.Equal(t, first, second)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		_, err := BuildDryRun(context.Background(), "missing.yaml")
+		assert.NotNil(t, err)
+
+		// jobs posted over http are taken as they are read
+		httpConfiguration := Configuration{}
+		require.Nil(t, yaml.Unmarshal([]byte(`
+name: ingest
+dataSources:
+  - name: server
+    http:
+      addr: 127.0.0.1:0
+      token: secret
+queues:
+  - name: webhooks
+    http:
+      dataSource: server
+pipeline:
+  getItems:
+    - name: webhooks
+`), &httpConfiguration))
+
+		_, err = buildDryRun(context.Background(), httpConfiguration)
+		assert.ErrorContains(t, err, "queue webhooks has no paging")
+	})
+}
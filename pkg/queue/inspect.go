@@ -0,0 +1,208 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"github.com/thethan/goqueue/internal/conditionals"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/pkg/redis/redis/bullmq"
+	"github.com/thethan/goqueue/pkg/redis/redis/celery"
+	"github.com/thethan/goqueue/pkg/redis/redis/resque"
+	"github.com/thethan/goqueue/pkg/redis/redis/sidekiq"
+	"github.com/thethan/goqueue/pkg/sqlite"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strings"
+)
+
+// QueueInfo describes a queue of a configuration: its backend, the redis
+// queue type, and where its jobs are kept.
+type QueueInfo struct {
+	Name    string `json:"name"`
+	Backend string `json:"backend"`
+	Type    string `json:"type,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Format  string `json:"format"`
+}
+
+// Inspector builds the queues of a configuration file on their own, without
+// its pipeline, so their jobs can be read and changed by hand.
+type Inspector struct {
+	configuration Configuration
+}
+
+// NewInspector reads and validates a configuration file.
+func NewInspector(ctx context.Context, configFileLocation string) (*Inspector, error) {
+	file, err := os.Open(configFileLocation)
+	if err != nil {
+		logs.Error(ctx, "could not open config file", logs.WithError(err), logs.WithValue("configFileLocation", configFileLocation))
+		return nil, err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	configuration, err := ValidateConfiguration(file)
+	if err != nil {
+		logs.Error(ctx, "invalid config file", logs.WithError(err), logs.WithValue("configFileLocation", configFileLocation))
+		return nil, err
+	}
+
+	return &Inspector{configuration: configuration}, nil
+}
+
+// List describes the configuration's queues in the order they are declared.
+func (i *Inspector) List() []QueueInfo {
+	infos := make([]QueueInfo, 0, len(i.configuration.Queues))
+	for _, queueConfiguration := range i.configuration.Queues {
+		info := QueueInfo{Name: queueConfiguration.Name, Format: string(queueConfiguration.Format)}
+		if info.Format == "" {
+			info.Format = "json"
+		}
+
+		switch {
+		case queueConfiguration.RedisConfiguration != nil:
+			info.Backend, info.Type, info.Key = "redis", string(queueConfiguration.RedisConfiguration.Type), queueConfiguration.RedisConfiguration.Key
+		case queueConfiguration.MemoryConfiguration != nil:
+			info.Backend, info.Type = "memory", queueConfiguration.MemoryConfiguration.Type
+		case queueConfiguration.FileConfiguration != nil:
+			info.Backend, info.Key = "file", queueConfiguration.FileConfiguration.Path
+		case queueConfiguration.SQLiteConfiguration != nil:
+			info.Backend, info.Type, info.Key = "sqlite", queueConfiguration.SQLiteConfiguration.State, queueConfiguration.SQLiteConfiguration.Queue
+			if info.Key == "" {
+				info.Key = queueConfiguration.Name
+			}
+		case queueConfiguration.HTTPConfiguration != nil:
+			info.Backend, info.Key = "http", queueConfiguration.HTTPConfiguration.Name
+		case queueConfiguration.NATSConfiguration != nil:
+			info.Backend, info.Key = "nats", queueConfiguration.NATSConfiguration.Subject
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos
+}
+
+// Has reports whether the configuration declares the queue name.
+func (i *Inspector) Has(name string) bool {
+	for _, queueConfiguration := range i.configuration.Queues {
+		if queueConfiguration.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Queues builds the queues called names and connects to the data sources
+// they use, and only those. Jobs that can't be decoded aren't quarantined.
+func (i *Inspector) Queues(names ...string) (map[string]queues.Queue, error) {
+	configuration := Configuration{}
+	dataSources := map[string]bool{}
+	for _, name := range names {
+		found := false
+		for _, queueConfiguration := range i.configuration.Queues {
+			if queueConfiguration.Name != name {
+				continue
+			}
+
+			queueConfiguration.Quarantine = ""
+			configuration.Queues = append(configuration.Queues, queueConfiguration)
+			dataSources[dataSourceOf(queueConfiguration)] = true
+			found = true
+
+			break
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unknown queue %q", name)
+		}
+	}
+
+	for _, dataSource := range i.configuration.DataSources {
+		if dataSources[dataSource.Name] {
+			configuration.DataSources = append(configuration.DataSources, dataSource)
+		}
+	}
+
+	return makeQueues(configuration)
+}
+
+// requeueActions are the actions that put a job back to be run, by redis
+// queue type.
+var requeueActions = map[RedisQueueType]string{
+	Sidekiq: sidekiq.RetryNowAction,
+	Resque:  resque.RetryAction,
+	Celery:  celery.RestoreAction,
+	BullMQ:  bullmq.RetryAction,
+}
+
+// RequeueAction returns the action of the queue name that puts a job back to
+// be run, such as retrying a sidekiq job now, or false when its queue has
+// none.
+func (i *Inspector) RequeueAction(name string) (string, bool) {
+	for _, queueConfiguration := range i.configuration.Queues {
+		if queueConfiguration.Name != name {
+			continue
+		}
+
+		switch {
+		case queueConfiguration.RedisConfiguration != nil:
+			action, ok := requeueActions[queueConfiguration.RedisConfiguration.Type]
+			return action, ok
+		case queueConfiguration.SQLiteConfiguration != nil:
+			// ready jobs are already waiting to be run
+			state := sqlite.State(queueConfiguration.SQLiteConfiguration.State)
+			return sqlite.RetryAction, state != "" && state != sqlite.Ready
+		}
+
+		return "", false
+	}
+
+	return "", false
+}
+
+// dataSourceOf returns the name of the data source a queue uses, empty when
+// it uses none.
+func dataSourceOf(queueConfiguration QueueConfiguration) string {
+	switch {
+	case queueConfiguration.RedisConfiguration != nil:
+		return queueConfiguration.RedisConfiguration.Datasource
+	case queueConfiguration.SQLiteConfiguration != nil:
+		return queueConfiguration.SQLiteConfiguration.Datasource
+	case queueConfiguration.HTTPConfiguration != nil:
+		return queueConfiguration.HTTPConfiguration.Datasource
+	case queueConfiguration.NATSConfiguration != nil:
+		return queueConfiguration.NATSConfiguration.Datasource
+	}
+
+	return ""
+}
+
+// ParseCondition parses a condition written as "element operator
+// comparison", for example "class == WebhookWorker" or "retry_count > 3",
+// into the check a conditional with the same fields would make. The
+// comparison is read as yaml, so numbers and booleans compare as such and
+// quoted strings can hold spaces. Jobs without the element don't match.
+func ParseCondition(expression string) (conditionals.CheckFunc, error) {
+	fields := strings.Fields(expression)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("condition %q is not element operator comparison", expression)
+	}
+
+	operator, err := operator(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("condition %q: %w", expression, err)
+	}
+
+	raw := strings.Join(fields[2:], " ")
+	var comparison interface{}
+	if err := yaml.Unmarshal([]byte(raw), &comparison); err != nil {
+		comparison = raw
+	}
+
+	return conditionals.NewCondition(fields[0], operator, comparison).Check, nil
+}
@@ -0,0 +1,120 @@
+package queue
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/queues"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInspector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.Nil(t, os.WriteFile(path, []byte(`
+dataSources:
+  - name: cache
+    redis:
+      host: localhost:6379
+queues:
+  - name: retry
+    memory:
+      jobs:
+        - '{"class":"WebhookWorker","jid":"1"}'
+        - '{"class":"MailWorker","jid":"2"}'
+    quarantine: dead
+  - name: dead
+    memory:
+      type: fifo
+  - name: scheduled
+    format: yaml
+    redis:
+      dataSource: cache
+      type: zset
+      key: schedule
+  - name: sidekiqRetry
+    redis:
+      dataSource: cache
+      type: sidekiq
+      key: retry
+pipeline:
+  getItems:
+    - name: retry
+`), 0o600))
+
+	inspector, err := NewInspector(context.Background(), path)
+	require.Nil(t, err)
+
+	t.Run("success", func(t *testing.T) {
+		assert.Equal(t, []QueueInfo{
+			{Name: "retry", Backend: "memory", Format: "json"},
+			{Name: "dead", Backend: "memory", Type: "fifo", Format: "json"},
+			{Name: "scheduled", Backend: "redis", Type: "zset", Key: "schedule", Format: "yaml"},
+			{Name: "sidekiqRetry", Backend: "redis", Type: "sidekiq", Key: "retry", Format: "json"},
+		}, inspector.List())
+		assert.True(t, inspector.Has("dead"))
+		assert.False(t, inspector.Has("missing"))
+
+		action, ok := inspector.RequeueAction("retry")
+		assert.False(t, ok)
+		assert.Empty(t, action)
+
+		action, ok = inspector.RequeueAction("sidekiqRetry")
+		assert.True(t, ok)
+		assert.Equal(t, "retryNow", action)
+
+		queueMap, err := inspector.Queues("retry")
+		require.Nil(t, err)
+		require.Len(t, queueMap, 1)
+
+		pageQueue, ok := queueMap["retry"].(queues.PageQueue)
+		require.True(t, ok)
+
+		jobs, err := pageQueue.Page(context.Background(), 0, 10)
+		require.Nil(t, err)
+		require.Len(t, jobs, 2)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		_, err := inspector.Queues("missing")
+		assert.NotNil(t, err)
+
+		_, err = NewInspector(context.Background(), filepath.Join(t.TempDir(), "missing.yaml"))
+		assert.NotNil(t, err)
+	})
+}
+
+func TestParseCondition(t *testing.T) {
+	webhook, err := job.NewBuilder(&job.Configuration{Type: job.JsonRawJobType}).MakeJob([]byte(`{"class":"WebhookWorker","retry_count":5,"error_message":"connection refused"}`))
+	require.Nil(t, err)
+
+	t.Run("success", func(t *testing.T) {
+		for expression, matched := range map[string]bool{
+			"class == WebhookWorker":                true,
+			"class == MailWorker":                   false,
+			"retry_count > 3":                       true,
+			"retry_count <= 3":                      false,
+			`error_message == "connection refused"`: true,
+			"error_message contains refused":        true,
+			"queue == default":                      false,
+		} {
+			t.Run(expression, func(t *testing.T) {
+				check, err := ParseCondition(expression)
+				require.Nil(t, err)
+
+				ok, err := check(context.Background(), webhook)
+				require.Nil(t, err)
+				assert.Equal(t, matched, ok)
+			})
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		for _, expression := range []string{"", "class ==", "class ~ WebhookWorker"} {
+			_, err := ParseCondition(expression)
+			assert.NotNil(t, err, expression)
+		}
+	})
+}
@@ -0,0 +1,579 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/thethan/goqueue/internal/conditionals"
+	"github.com/thethan/goqueue/internal/executers"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/transforms"
+	"github.com/thethan/goqueue/pkg/memory"
+	"github.com/thethan/goqueue/pkg/redis/redis/bullmq"
+	"github.com/thethan/goqueue/pkg/redis/redis/celery"
+	"github.com/thethan/goqueue/pkg/redis/redis/reference"
+	"github.com/thethan/goqueue/pkg/redis/redis/resque"
+	"github.com/thethan/goqueue/pkg/redis/redis/sidekiq"
+	"github.com/thethan/goqueue/pkg/sqlite"
+	"gopkg.in/yaml.v3"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Problem is something wrong with a configuration, at the line and column of
+// the YAML it was found in. Problems YAML could not place have no column.
+type Problem struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (p Problem) Error() string {
+	if p.Column == 0 {
+		return fmt.Sprintf("%d: %s", p.Line, p.Message)
+	}
+
+	return fmt.Sprintf("%d:%d: %s", p.Line, p.Column, p.Message)
+}
+
+// Problems is every problem found in a configuration, in the order they
+// appear in it.
+type Problems []Problem
+
+func (p Problems) Error() string {
+	messages := make([]string, 0, len(p))
+	for _, problem := range p {
+		messages = append(messages, problem.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+var (
+	// yamlError is a syntax error of the yaml parser
+	yamlError = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+	// typeError is one of the errors of a yaml.TypeError
+	typeError = regexp.MustCompile(`^line \d+: `)
+)
+
+// ValidateConfiguration decodes a configuration, rejecting fields it doesn't
+// have, and checks it without connecting to its data sources: that the names
+// it refers to are declared once, with the kind the reference needs, and that
+// its operators, actions, commands and templates parse. The error is
+// Problems when the configuration is invalid.
+func ValidateConfiguration(r io.Reader) (Configuration, error) {
+	configuration := Configuration{}
+	document := yaml.Node{}
+	if err := yaml.NewDecoder(r).Decode(&document); err != nil {
+		if errors.Is(err, io.EOF) {
+			return configuration, Problems{{Line: 1, Message: "configuration is empty"}}
+		}
+
+		if match := yamlError.FindStringSubmatch(err.Error()); match != nil {
+			line, _ := strconv.Atoi(match[1])
+
+			return configuration, Problems{{Line: line, Message: match[2]}}
+		}
+
+		return configuration, err
+	}
+
+	v := &validator{nodes: map[string]*yaml.Node{}}
+	root := &document
+	if len(document.Content) > 0 {
+		root = document.Content[0]
+	}
+
+	v.walk(root, reflect.TypeOf(configuration), "")
+	if err := root.Decode(&configuration); err != nil && len(v.problems) == 0 {
+		v.problems = append(v.problems, Problem{Line: root.Line, Column: root.Column, Message: strings.TrimPrefix(err.Error(), "yaml: ")})
+	}
+
+	v.check(configuration)
+	if len(v.problems) == 0 {
+		return configuration, nil
+	}
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		if v.problems[i].Line != v.problems[j].Line {
+			return v.problems[i].Line < v.problems[j].Line
+		}
+
+		return v.problems[i].Column < v.problems[j].Column
+	})
+
+	return configuration, v.problems
+}
+
+type validator struct {
+	// nodes are the yaml nodes of the configuration by their path, such as
+	// queues[0].redis.dataSource
+	nodes    map[string]*yaml.Node
+	problems Problems
+}
+
+// report adds a problem at the node of path, or of the closest of its
+// parents in the yaml when path wasn't set.
+func (v *validator) report(path string, format string, args ...interface{}) {
+	node, ok := v.nodes[path]
+	for !ok && path != "" {
+		path = parent(path)
+		node, ok = v.nodes[path]
+	}
+
+	problem := Problem{Line: 1, Column: 1, Message: fmt.Sprintf(format, args...)}
+	if ok {
+		problem.Line, problem.Column = node.Line, node.Column
+	}
+
+	v.problems = append(v.problems, problem)
+}
+
+func parent(path string) string {
+	idx := strings.LastIndexAny(path, ".[")
+	if idx < 0 {
+		return ""
+	}
+
+	return path[:idx]
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// in names the path a field is in, unless it is the configuration itself.
+func in(path string) string {
+	if path == "" {
+		return ""
+	}
+
+	return " in " + path
+}
+
+var unmarshaler = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// walk records the nodes of the configuration by path and reports keys t
+// has no field for and values that don't decode into their field.
+func (v *validator) walk(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	v.nodes[path] = node
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+
+	if reflect.PointerTo(t).Implements(unmarshaler) {
+		v.decode(node, t, path)
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		v.walk(node, t.Elem(), path)
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			v.decode(node, t, path)
+			return
+		}
+
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if name != "" && name != "-" {
+				fields[name] = t.Field(i).Type
+			}
+		}
+
+		seen := map[string]bool{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			switch {
+			case !ok:
+				v.nodes[join(path, key.Value)] = key
+				v.report(join(path, key.Value), "unknown field %q%s", key.Value, in(path))
+			case seen[key.Value]:
+				v.nodes[join(path, key.Value)] = key
+				v.report(join(path, key.Value), "field %q is set more than once%s", key.Value, in(path))
+			default:
+				v.walk(value, field, join(path, key.Value))
+			}
+
+			seen[key.Value] = true
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			v.decode(node, t, path)
+			return
+		}
+
+		for i, item := range node.Content {
+			v.walk(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			v.decode(node, t, path)
+			return
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.walk(node.Content[i+1], t.Elem(), fmt.Sprintf("%s[%s]", path, node.Content[i].Value))
+		}
+	default:
+		v.decode(node, t, path)
+	}
+}
+
+// decode reports the node when it doesn't decode into a t.
+func (v *validator) decode(node *yaml.Node, t reflect.Type, path string) {
+	err := node.Decode(reflect.New(t).Interface())
+	var typeErr *yaml.TypeError
+	switch {
+	case err == nil:
+	case errors.As(err, &typeErr):
+		for _, message := range typeErr.Errors {
+			v.report(path, "%s", typeError.ReplaceAllString(message, ""))
+		}
+	default:
+		v.report(path, "%s", err)
+	}
+}
+
+// actions are the actions of the kinds of queue that have them.
+var actions = map[RedisQueueType][]string{
+	Sidekiq: {sidekiq.RetryNowAction, sidekiq.KillAction, sidekiq.DeleteAction},
+	Resque:  {resque.RetryAction, resque.DeleteAction},
+	Celery:  {celery.RestoreAction, celery.DeleteAction},
+	BullMQ:  {bullmq.RetryAction, bullmq.DeleteAction},
+}
+
+var sqliteActions = []string{sqlite.RetryAction, sqlite.KillAction}
+
+// check reports the problems of a decoded configuration.
+func (v *validator) check(configuration Configuration) {
+	dataSourceNames := map[string]bool{}
+	dataSources := map[string]string{}
+	for i, dataSource := range configuration.DataSources {
+		path := fmt.Sprintf("dataSources[%d]", i)
+		if !v.unique(path, "data source", dataSource.Name, dataSourceNames) {
+			continue
+		}
+
+		kinds := make([]string, 0, 1)
+		if dataSource.RedisConfiguration != nil {
+			kinds = append(kinds, "redis")
+		}
+
+		if dataSource.SQLiteConfiguration != nil {
+			kinds = append(kinds, "sqlite")
+			if dataSource.SQLiteConfiguration.Path == "" {
+				v.report(path+".sqlite", "data source %s: sqlite data sources require a path", dataSource.Name)
+			}
+		}
+
+		if dataSource.HTTPConfiguration != nil {
+			kinds = append(kinds, "http")
+			if dataSource.HTTPConfiguration.Token == "" && viper.GetString(envHTTPToken) == "" {
+				v.report(path+".http", "data source %s: http data sources require a token or %s", dataSource.Name, envHTTPToken)
+			}
+		}
+
+		if dataSource.NATSConfiguration != nil {
+			kinds = append(kinds, "nats")
+		}
+
+		if len(kinds) != 1 {
+			v.report(path, "data source %s: must be one of redis, sqlite, http and nats", dataSource.Name)
+			continue
+		}
+
+		dataSources[dataSource.Name] = kinds[0]
+	}
+
+	queueConfigurations := map[string]QueueConfiguration{}
+	names := map[string]bool{}
+	for i, queueConfiguration := range configuration.Queues {
+		path := fmt.Sprintf("queues[%d]", i)
+		if v.unique(path, "queue", queueConfiguration.Name, names) {
+			queueConfigurations[queueConfiguration.Name] = queueConfiguration
+		}
+
+		v.checkQueue(path, queueConfiguration, dataSources)
+	}
+
+	for i, queueConfiguration := range configuration.Queues {
+		if queueConfiguration.Quarantine == "" {
+			continue
+		}
+
+		if _, ok := queueConfigurations[queueConfiguration.Quarantine]; !ok {
+			v.report(fmt.Sprintf("queues[%d].quarantine", i), "queue %s: unknown quarantine queue %q", queueConfiguration.Name, queueConfiguration.Quarantine)
+		}
+	}
+
+	conditionalNames := map[string]bool{}
+	for i, conditional := range configuration.Conditionals {
+		path := fmt.Sprintf("conditionals[%d]", i)
+		v.unique(path, "conditional", conditional.Name, conditionalNames)
+
+		if _, err := operator(conditional.Operator); err != nil {
+			v.report(path+".operator", "conditional %s: %s", conditional.Name, err)
+		}
+
+		switch conditionals.Missing(conditional.Missing) {
+		case "", conditionals.MissingFalse, conditionals.MissingTrue, conditionals.MissingError:
+		default:
+			v.report(path+".missing", "conditional %s: unknown missing behaviour %q", conditional.Name, conditional.Missing)
+		}
+	}
+
+	executorNames := map[string]bool{}
+	for i, executorConfiguration := range configuration.Executors {
+		path := fmt.Sprintf("executors[%d]", i)
+		v.unique(path, "executor", executorConfiguration.Name, executorNames)
+
+		if _, err := executers.NewExecutor(&executers.Configuration{Sprintf: executorConfiguration.SprintfCMD}); err != nil {
+			v.report(path+".command", "executor %s: %s", executorConfiguration.Name, err)
+		}
+	}
+
+	transformNames := map[string]bool{}
+	for i, transformConfiguration := range configuration.Transforms {
+		path := fmt.Sprintf("transforms[%d]", i)
+		v.unique(path, "transform", transformConfiguration.Name, transformNames)
+
+		for j, step := range transformConfiguration.Steps {
+			if _, err := transforms.NewStep(transforms.Action(step.Action), step.Path, step.From, step.Value); err != nil {
+				v.report(fmt.Sprintf("%s.steps[%d]", path, j), "transform %s: %s", transformConfiguration.Name, err)
+			}
+		}
+	}
+
+	schedulerNames := map[string]bool{}
+	for i, scheduler := range configuration.Schedulers {
+		path := fmt.Sprintf("schedulers[%d]", i)
+		v.unique(path, "scheduler", scheduler.Name, schedulerNames)
+
+		for j, queueName := range scheduler.Queues {
+			queueConfiguration, ok := queueConfigurations[queueName.Name]
+			switch {
+			case !ok:
+				v.report(fmt.Sprintf("%s.queues[%d].name", path, j), "scheduler %s: unknown queue %q", scheduler.Name, queueName.Name)
+			case queueConfiguration.RedisConfiguration == nil || queueConfiguration.RedisConfiguration.Type != Sidekiq ||
+				(queueConfiguration.RedisConfiguration.Key != sidekiq.ScheduleKey && queueConfiguration.RedisConfiguration.Key != sidekiq.RetryKey):
+				v.report(fmt.Sprintf("%s.queues[%d].name", path, j), "scheduler %s: queue %s is not a sidekiq schedule or retry set", scheduler.Name, queueName.Name)
+			}
+		}
+	}
+
+	pipeline := configuration.Pipelines
+	if len(pipeline.GetItems) == 0 {
+		v.report("pipeline.getItems", "pipeline has no getItems queue")
+	}
+
+	for i, getItems := range pipeline.GetItems {
+		if _, ok := queueConfigurations[getItems.Name]; !ok {
+			v.report(fmt.Sprintf("pipeline.getItems[%d].name", i), "pipeline: unknown queue %q", getItems.Name)
+		}
+	}
+
+	if pipeline.Executor != nil {
+		if !executorNames[pipeline.Executor.Name] {
+			v.report("pipeline.executor.name", "pipeline: unknown executor %q", pipeline.Executor.Name)
+		}
+	}
+
+	for i, tree := range pipeline.DecisionTree {
+		path := fmt.Sprintf("pipeline.decisionTree[%d]", i)
+		if !conditionalNames[tree.Name] {
+			v.report(path+".name", "decision tree: unknown conditional %q", tree.Name)
+		}
+
+		for branch, branchFunc := range map[string]*PipelineConditionTreeFunc{
+			"success":  tree.Success,
+			"failure":  tree.Failure,
+			"error":    tree.Error,
+			"executor": tree.Executor,
+		} {
+			if branchFunc != nil {
+				v.checkBranch(join(path, branch), tree.Name, branchFunc, queueConfigurations, executorNames, transformNames)
+			}
+		}
+	}
+}
+
+// unique reports a missing name or one already in names, the names of the
+// things of a kind, and adds it otherwise.
+func (v *validator) unique(path, kind, name string, names map[string]bool) bool {
+	if name == "" {
+		v.report(path, "%s requires a name", kind)
+		return false
+	}
+
+	if names[name] {
+		v.report(path+".name", "%s %s is declared more than once", kind, name)
+		return false
+	}
+
+	names[name] = true
+
+	return true
+}
+
+// dataSource reports a reference to a data source that isn't declared or
+// isn't of the kind the queue needs.
+func (v *validator) dataSource(path, queueName, name, kind string, dataSources map[string]string) {
+	declared, ok := dataSources[name]
+	switch {
+	case !ok:
+		v.report(path, "queue %s: unknown data source %q", queueName, name)
+	case declared != kind:
+		v.report(path, "queue %s: %s is not a %s data source", queueName, name, kind)
+	}
+}
+
+func (v *validator) checkQueue(path string, queueConfiguration QueueConfiguration, dataSources map[string]string) {
+	name := queueConfiguration.Name
+	switch queueConfiguration.Format {
+	case "", job.JsonRawJobType, job.YamlRawJobType, job.MsgpackRawJobType, job.CeleryRawJobType:
+	case job.ProtoRawJobType:
+		if queueConfiguration.Proto == nil {
+			v.report(path+".format", "queue %s: proto format requires proto.descriptorSet and proto.messageType", name)
+		}
+	default:
+		v.report(path+".format", "queue %s: unknown format %q", name, queueConfiguration.Format)
+	}
+
+	switch backends(queueConfiguration) {
+	case 0:
+		v.report(path, "queue %s: must be one of redis, memory, file, sqlite, http and nats", name)
+	case 1:
+	default:
+		v.report(path, "queue %s: can only be one of redis, memory, file, sqlite, http and nats", name)
+	}
+
+	if configuration := queueConfiguration.MemoryConfiguration; configuration != nil {
+		switch memory.Mode(configuration.Type) {
+		case "", memory.List, memory.ZSet, memory.FIFO:
+		default:
+			v.report(path+".memory.type", "queue %s: unknown memory queue type %q", name, configuration.Type)
+		}
+	}
+
+	if configuration := queueConfiguration.FileConfiguration; configuration != nil && configuration.Path == "" {
+		v.report(path+".file", "queue %s: file queues require a path", name)
+	}
+
+	if configuration := queueConfiguration.SQLiteConfiguration; configuration != nil {
+		v.dataSource(path+".sqlite.dataSource", name, configuration.Datasource, "sqlite", dataSources)
+
+		switch sqlite.State(configuration.State) {
+		case "", sqlite.Ready, sqlite.Scheduled, sqlite.InFlight, sqlite.Dead:
+		default:
+			v.report(path+".sqlite.state", "queue %s: unknown sqlite state %q", name, configuration.State)
+		}
+	}
+
+	if configuration := queueConfiguration.HTTPConfiguration; configuration != nil {
+		v.dataSource(path+".http.dataSource", name, configuration.Datasource, "http", dataSources)
+	}
+
+	if configuration := queueConfiguration.NATSConfiguration; configuration != nil {
+		v.dataSource(path+".nats.dataSource", name, configuration.Datasource, "nats", dataSources)
+
+		if configuration.Stream == "" || configuration.Subject == "" {
+			v.report(path+".nats", "queue %s: nats queues require a stream and a subject", name)
+		}
+	}
+
+	if configuration := queueConfiguration.RedisConfiguration; configuration != nil {
+		v.dataSource(path+".redis.dataSource", name, configuration.Datasource, "redis", dataSources)
+
+		switch configuration.Type {
+		case ZType, LRange, Sidekiq, Resque, Celery, BullMQ, Stream:
+		default:
+			v.report(path+".redis.type", "queue %s: unknown redis queue type %q", name, configuration.Type)
+		}
+
+		if configuration.Reference != nil {
+			if configuration.Type != ZType && configuration.Type != LRange {
+				v.report(path+".redis.reference", "queue %s: references are only supported by zset and lrange queues", name)
+			}
+
+			switch reference.Type(configuration.Reference.Type) {
+			case "", reference.String, reference.Hash:
+			default:
+				v.report(path+".redis.reference.type", "queue %s: unknown reference type %q", name, configuration.Reference.Type)
+			}
+		}
+	}
+}
+
+// checkBranch reports the queues, executors and transforms a branch of a
+// decision tree refers to that aren't declared, and the actions their queue
+// doesn't have.
+func (v *validator) checkBranch(path, tree string, branch *PipelineConditionTreeFunc, queueConfigurations map[string]QueueConfiguration, executorNames, transformNames map[string]bool) {
+	for key, refs := range map[string][]*PipelineConditionTreeFuncQueueName{"pushItems": branch.PushItem, "removeItems": branch.RemoveItem} {
+		for i, ref := range refs {
+			if _, ok := queueConfigurations[ref.Name]; !ok {
+				v.report(fmt.Sprintf("%s.%s[%d].name", path, key, i), "decision tree %s: unknown queue %q", tree, ref.Name)
+			}
+		}
+	}
+
+	for i, ref := range branch.Executors {
+		if !executorNames[ref.Name] {
+			v.report(fmt.Sprintf("%s.executors[%d].name", path, i), "decision tree %s: unknown executor %q", tree, ref.Name)
+		}
+	}
+
+	for i, ref := range branch.Transforms {
+		if !transformNames[ref.Name] {
+			v.report(fmt.Sprintf("%s.transforms[%d].name", path, i), "decision tree %s: unknown transform %q", tree, ref.Name)
+		}
+	}
+
+	for i, action := range branch.Actions {
+		queueConfiguration, ok := queueConfigurations[action.Name]
+		if !ok {
+			v.report(fmt.Sprintf("%s.actions[%d].name", path, i), "decision tree %s: unknown queue %q", tree, action.Name)
+			continue
+		}
+
+		var queueActions []string
+		switch {
+		case queueConfiguration.RedisConfiguration != nil:
+			queueActions = actions[queueConfiguration.RedisConfiguration.Type]
+		case queueConfiguration.SQLiteConfiguration != nil:
+			queueActions = sqliteActions
+		}
+
+		switch {
+		case len(queueActions) == 0:
+			v.report(fmt.Sprintf("%s.actions[%d].name", path, i), "decision tree %s: queue %s has no actions", tree, action.Name)
+		case !contains(queueActions, action.Action):
+			v.report(fmt.Sprintf("%s.actions[%d].action", path, i), "decision tree %s: queue %s has no action %q, only %s", tree, action.Name, action.Action, strings.Join(queueActions, ", "))
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
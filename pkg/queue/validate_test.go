@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfiguration(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		file, err := os.Open("configuration.yaml")
+		require.Nil(t, err)
+		defer file.Close()
+
+		configuration, err := ValidateConfiguration(file)
+		require.Nil(t, err)
+		assert.Equal(t, "retry", configuration.Name)
+		assert.Len(t, configuration.Conditionals, 2)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		_, err := ValidateConfiguration(strings.NewReader(`
+dataSources:
+  - name: cache
+    redis:
+      host: localhost:6379
+  - name: db
+    sqlite:
+      path: /tmp/goqueue.db
+queues:
+  - name: retry
+    redis:
+      dataSource: cahce
+      type: sidekiq
+      key: retry
+      tll: 3
+  - name: retry
+    memory: {}
+  - name: jobs
+    redis:
+      dataSource: db
+      type: lrange
+      key: jobs
+  - name: dead
+    memory:
+      type: fifo
+    quarantine: nowhere
+conditionals:
+  - name: tooMany
+    operator: "=>"
+    element: retry_count
+    comparison: 3
+transforms:
+  - name: bump
+    steps:
+      - action: set
+        path: at
+        value: "{{ now "
+pipeline:
+  executor:
+    name: notify
+  decisionTree:
+    - name: tooMny
+      success:
+        pushItems:
+          - name: dead
+        actions:
+          - name: retry
+            action: retry
+          - name: jobs
+            action: retry
+        transforms:
+          - name: bmp
+      failure:
+        return: maybe
+`))
+		var problems Problems
+		require.ErrorAs(t, err, &problems)
+
+		assert.Equal(t, Problems{
+			{Line: 12, Column: 19, Message: `queue retry: unknown data source "cahce"`},
+			{Line: 15, Column: 7, Message: `unknown field "tll" in queues[0].redis`},
+			{Line: 16, Column: 11, Message: `queue retry is declared more than once`},
+			{Line: 20, Column: 19, Message: `queue jobs: db is not a redis data source`},
+			{Line: 26, Column: 17, Message: `queue dead: unknown quarantine queue "nowhere"`},
+			{Line: 29, Column: 15, Message: `conditional tooMany: unknown operator "=>"`},
+			{Line: 35, Column: 9, Message: `transform bump: could not parse template for at: template: at:1: unclosed action`},
+			{Line: 39, Column: 3, Message: `pipeline has no getItems queue`},
+			{Line: 40, Column: 11, Message: `pipeline: unknown executor "notify"`},
+			{Line: 42, Column: 13, Message: `decision tree: unknown conditional "tooMny"`},
+			{Line: 48, Column: 21, Message: `decision tree tooMny: queue retry has no action "retry", only retryNow, kill, delete`},
+			{Line: 49, Column: 19, Message: `decision tree tooMny: queue jobs has no actions`},
+			{Line: 52, Column: 19, Message: `decision tree tooMny: unknown transform "bmp"`},
+			{Line: 54, Column: 17, Message: "cannot unmarshal !!str `maybe` into bool"},
+		}, problems)
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		_, err := ValidateConfiguration(strings.NewReader("queues:\n  - name: retry\n    memory: [\n"))
+
+		var problems Problems
+		require.ErrorAs(t, err, &problems)
+		require.Len(t, problems, 1)
+		assert.Equal(t, 3, problems[0].Line)
+	})
+}
@@ -0,0 +1,523 @@
+package bullmq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const count = 100
+
+// States of a BullMQ job, the last segment of the key of the list or sorted
+// set holding the ids of the jobs in that state.
+const (
+	Wait        = "wait"
+	Active      = "active"
+	Paused      = "paused"
+	Delayed     = "delayed"
+	Prioritized = "prioritized"
+	Failed      = "failed"
+	Completed   = "completed"
+)
+
+// Actions a Queue exposes to pipelines.
+const (
+	// RetryAction moves a job back to be processed, clearing its failure
+	RetryAction = "retry"
+	// DeleteAction removes a job and its hash
+	DeleteAction = "delete"
+)
+
+// jsonFields are the job hash fields BullMQ stores as json
+var jsonFields = map[string]bool{"data": true, "opts": true, "stacktrace": true, "returnvalue": true}
+
+// numberFields are the job hash fields read as numbers
+var numberFields = map[string]bool{"timestamp": true, "delay": true, "priority": true, "attemptsMade": true, "processedOn": true, "finishedOn": true}
+
+// Queue is one of the lists or sorted sets of a BullMQ queue,
+// <prefix>:<queue>:<state>, holding the ids of jobs stored in hashes at
+// <prefix>:<queue>:<id>. Jobs are read as json objects of the hash's fields
+// with the id, data, opts and stacktrace decoded, for example data.userId,
+// attemptsMade and failedReason.
+type Queue struct {
+	jobbuilder *job.Builder
+	options    queues.Options
+	// prefix is <prefix>:<queue>, the start of every key of the queue
+	prefix string
+	state  string
+	client redis.UniversalClient
+	now    func() time.Time
+}
+
+// NewQueue returns the BullMQ state stored at key, for example
+// bull:emails:failed. Jobs are always read as json, the format of the queue's
+// configuration is ignored.
+func NewQueue(key string, client redis.UniversalClient, opts ...queues.Option) *Queue {
+	options := queues.NewOptions(opts...)
+	configuration := *options.JobConfiguration
+	configuration.Type = job.JsonRawJobType
+	options.JobConfiguration = &configuration
+	jobJuilder := job.NewBuilder(options.JobConfiguration)
+
+	idx := strings.LastIndex(key, ":")
+
+	return &Queue{prefix: key[:idx+1], state: key[idx+1:], client: client, jobbuilder: jobJuilder, options: options, now: time.Now}
+}
+
+// Key is the redis key of the queue's state.
+func (q *Queue) Key() string {
+	return q.key(q.state)
+}
+
+func (q *Queue) key(segment string) string {
+	return q.prefix + segment
+}
+
+func isSet(state string) bool {
+	switch state {
+	case Delayed, Prioritized, Failed, Completed:
+		return true
+	}
+
+	return false
+}
+
+func (q *Queue) GetItems(ctx context.Context, jobChan chan<- job.Job) error {
+	defer func() {
+		close(jobChan)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+			views, err := q.jobs(ctx)
+			if err != nil {
+				return err
+			}
+
+			logs.Info(ctx, "got items from queue", logs.WithValue("key", q.Key()), logs.WithValue("count", len(views)))
+
+			for idx := range views {
+				j := queues.MakeJob(ctx, q.jobbuilder, q.options, q, views[idx])
+				if j == nil {
+					continue
+				}
+
+				jobChan <- j
+			}
+		}
+	}
+}
+
+// jobs reads the next ids in the queue's state and their hashes. BullMQ
+// pushes onto the head of its lists and takes jobs from the tail.
+func (q *Queue) jobs(ctx context.Context) ([][]byte, error) {
+	start, stop := int64(0), int64(count-1)
+	if !isSet(q.state) {
+		start, stop = -count, -1
+	}
+
+	ids, err := q.ids(ctx, start, stop)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.views(ctx, ids)
+}
+
+// ids returns the ids in the queue's state from start to stop.
+func (q *Queue) ids(ctx context.Context, start, stop int64) ([]string, error) {
+	if isSet(q.state) {
+		return q.client.ZRange(ctx, q.Key(), start, stop).Result()
+	}
+
+	return q.client.LRange(ctx, q.Key(), start, stop).Result()
+}
+
+// Count returns how many jobs are in the queue's state.
+func (q *Queue) Count(ctx context.Context) (int64, error) {
+	if isSet(q.state) {
+		return q.client.ZCard(ctx, q.Key()).Result()
+	}
+
+	return q.client.LLen(ctx, q.Key()).Result()
+}
+
+// Page returns the jobs of count ids from offset, lowest score or head of
+// the list first, without removing them.
+func (q *Queue) Page(ctx context.Context, offset, count int64) ([]job.Job, error) {
+	ids, err := q.ids(ctx, offset, offset+count-1)
+	if err != nil {
+		return nil, err
+	}
+
+	views, err := q.views(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]job.Job, 0, len(views))
+	for idx := range views {
+		j := queues.PageJob(ctx, q.jobbuilder, views[idx])
+		if j == nil {
+			continue
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
+
+// views reads the hashes of the jobs ids in one pipeline, as the objects
+// jobs are read as.
+func (q *Queue) views(ctx context.Context, ids []string) ([][]byte, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	pipe := q.client.Pipeline()
+	hashes := make([]*redis.StringStringMapCmd, len(ids))
+	for idx := range ids {
+		hashes[idx] = pipe.HGetAll(ctx, q.key(ids[idx]))
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	views := make([][]byte, 0, len(ids))
+	for idx := range ids {
+		fields := hashes[idx].Val()
+		// removed between reading the ids and the hashes
+		if len(fields) == 0 {
+			continue
+		}
+
+		view, err := json.Marshal(toView(ids[idx], fields))
+		if err != nil {
+			return nil, err
+		}
+
+		views = append(views, view)
+	}
+
+	return views, nil
+}
+
+// toView decodes a job hash into the object jobs are read as.
+func toView(id string, fields map[string]string) map[string]interface{} {
+	view := make(map[string]interface{}, len(fields)+1)
+	for key, value := range fields {
+		view[key] = value
+
+		if jsonFields[key] {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(value), &decoded); err == nil {
+				view[key] = decoded
+			}
+
+			continue
+		}
+
+		if _, err := strconv.ParseFloat(value, 64); err == nil && numberFields[key] {
+			view[key] = json.Number(value)
+		}
+	}
+
+	view["id"] = id
+
+	return view
+}
+
+// toHash encodes a job into the fields of its hash. Jobs from other systems
+// become the data of a job named after their name or class.
+func (q *Queue) toHash(j job.Job) (map[string]interface{}, error) {
+	bts, err := q.jobbuilder.Encode(j)
+	if err != nil {
+		return nil, err
+	}
+
+	var view map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(bts))
+	decoder.UseNumber()
+	if err := decoder.Decode(&view); err != nil {
+		return nil, err
+	}
+
+	if _, ok := view["data"]; !ok {
+		name, _ := view["class"].(string)
+		view = map[string]interface{}{"name": name, "data": view}
+	}
+
+	now := q.now().UnixMilli()
+	hash := map[string]interface{}{
+		"name":         "",
+		"opts":         "{}",
+		"timestamp":    now,
+		"delay":        0,
+		"priority":     0,
+		"attemptsMade": 0,
+	}
+
+	for key, value := range view {
+		if key == "id" || value == nil {
+			continue
+		}
+
+		if jsonFields[key] {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+
+			hash[key] = string(encoded)
+			continue
+		}
+
+		hash[key] = fmt.Sprint(value)
+	}
+
+	return hash, nil
+}
+
+// PushItems adds the job to the queue's state. Jobs read from this BullMQ
+// queue keep their id, other jobs are given the queue's next id.
+func (q *Queue) PushItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	hash, err := q.toHash(j)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	id := q.id(ctx, j, hash)
+	if id == "" {
+		next, err := q.client.Incr(ctx, q.key("id")).Result()
+		if err != nil {
+			errChan <- err
+			return
+		}
+
+		id = strconv.FormatInt(next, 10)
+	}
+
+	score, err := q.score(ctx, q.state, hash["delay"], hash["priority"])
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, q.key(id), hash)
+		q.add(ctx, pipe, q.state, id, score)
+
+		return nil
+	})
+	if err != nil {
+		errChan <- err
+	}
+}
+
+// id returns the id of a job read from this queue whose hash still exists,
+// recognised by its id and timestamp.
+func (q *Queue) id(ctx context.Context, j job.Job, hash map[string]interface{}) string {
+	val, err := j.GetValue("id")
+	if err != nil || val.Kind() != reflect.String {
+		return ""
+	}
+
+	timestamp, err := q.client.HGet(ctx, q.key(val.String()), "timestamp").Result()
+	if err != nil || timestamp != fmt.Sprint(hash["timestamp"]) {
+		return ""
+	}
+
+	return val.String()
+}
+
+// score returns the score BullMQ gives a job added to the set for state.
+// Prioritized jobs are ordered by priority and then by the queue's priority
+// counter, which is incremented, so it must be called before the
+// transaction adding the job.
+func (q *Queue) score(ctx context.Context, state string, delay, priority interface{}) (float64, error) {
+	now := q.now().UnixMilli()
+
+	switch state {
+	case Delayed:
+		delay, _ := strconv.ParseInt(fmt.Sprint(delay), 10, 64)
+		// the timestamp is shifted to leave room for a counter
+		return float64((now + delay) * 0x1000), nil
+	case Prioritized:
+		priority, _ := strconv.ParseInt(fmt.Sprint(priority), 10, 64)
+		counter, err := q.client.Incr(ctx, q.key("pc")).Result()
+		if err != nil {
+			return 0, err
+		}
+
+		return float64(priority*0x100000000 + counter%0x100000000), nil
+	case Failed, Completed:
+		return float64(now), nil
+	}
+
+	return 0, nil
+}
+
+// add adds id to the list or set for state with the score for it.
+func (q *Queue) add(ctx context.Context, pipe redis.Pipeliner, state, id string, score float64) {
+	switch state {
+	case Delayed, Failed, Completed:
+		pipe.ZAdd(ctx, q.key(state), &redis.Z{Member: id, Score: score})
+	case Prioritized:
+		pipe.ZAdd(ctx, q.key(state), &redis.Z{Member: id, Score: score})
+		// wakes workers blocked waiting for jobs
+		pipe.ZAdd(ctx, q.key("marker"), &redis.Z{Member: "0", Score: 0})
+	case Wait:
+		pipe.LPush(ctx, q.key(state), id)
+		// wakes workers blocked waiting for jobs
+		pipe.ZAdd(ctx, q.key("marker"), &redis.Z{Member: "0", Score: 0})
+	default:
+		pipe.LPush(ctx, q.key(state), id)
+	}
+}
+
+func (q *Queue) remove(ctx context.Context, pipe redis.Pipeliner, id string) {
+	if isSet(q.state) {
+		pipe.ZRem(ctx, q.Key(), id)
+		return
+	}
+
+	pipe.LRem(ctx, q.Key(), 0, id)
+}
+
+// RemoveItems removes the job from the queue's state and deletes its hash
+// and logs, like BullMQ's job.remove.
+func (q *Queue) RemoveItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	val, err := j.GetValue("id")
+	if err != nil || val.Kind() != reflect.String {
+		errChan <- errors.New("job has no id")
+		return
+	}
+
+	id := val.String()
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		q.remove(ctx, pipe, id)
+		// deleted one at a time, the keys are only in the same cluster slot
+		// when the prefix has a hash tag
+		pipe.Del(ctx, q.key(id))
+		pipe.Del(ctx, q.key(id+":logs"))
+
+		return nil
+	})
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	logs.Info(ctx, "removed from bullmq queue", logs.WithValue("key", q.Key()), logs.WithValue("id", id))
+}
+
+// Action returns the pipeline action called name.
+func (q *Queue) Action(name string) (queues.Action, bool) {
+	switch name {
+	case RetryAction:
+		return q.Retry, true
+	case DeleteAction:
+		return q.RemoveItems, true
+	}
+
+	return nil, false
+}
+
+// Retry moves the job from the queue's state to the wait list, or the paused
+// list of a paused queue or the prioritized set for jobs with a priority,
+// and clears its failure, like BullMQ's job.retry.
+func (q *Queue) Retry(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	if q.state == Wait {
+		errChan <- errors.New("job is already waiting")
+		return
+	}
+
+	val, err := j.GetValue("id")
+	if err != nil || val.Kind() != reflect.String {
+		errChan <- errors.New("job has no id")
+		return
+	}
+
+	id := val.String()
+	target, priority, err := q.retryTarget(ctx, id)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	score, err := q.score(ctx, target, nil, priority)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		q.remove(ctx, pipe, id)
+		pipe.HDel(ctx, q.key(id), "finishedOn", "processedOn", "failedReason", "returnvalue")
+		q.add(ctx, pipe, target, id, score)
+
+		return nil
+	})
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	logs.Info(ctx, "retried bullmq job", logs.WithValue("from", q.Key()), logs.WithValue("to", q.key(target)), logs.WithValue("id", id))
+}
+
+// retryTarget returns the state a retried job moves to and its priority.
+// Jobs with a priority are prioritized, others wait, in the paused list when
+// the queue's meta hash marks it paused.
+func (q *Queue) retryTarget(ctx context.Context, id string) (string, int64, error) {
+	var priority *redis.StringCmd
+	var paused *redis.BoolCmd
+	_, err := q.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		priority = pipe.HGet(ctx, q.key(id), "priority")
+		paused = pipe.HExists(ctx, q.key("meta"), "paused")
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", 0, err
+	}
+
+	if value, _ := strconv.ParseInt(priority.Val(), 10, 64); value > 0 {
+		return Prioritized, value, nil
+	}
+
+	if paused.Val() {
+		return Paused, 0, nil
+	}
+
+	return Wait, 0, nil
+}
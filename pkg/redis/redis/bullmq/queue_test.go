@@ -0,0 +1,248 @@
+package bullmq
+
+import (
+	"bytes"
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/internal/queuetest"
+	"testing"
+	"time"
+)
+
+func newTestQueue(t *testing.T, key string) (*Queue, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	q := NewQueue(key, client)
+	q.now = func() time.Time {
+		return time.UnixMilli(1700000000000)
+	}
+
+	return q, server
+}
+
+func addFailed(t *testing.T, server *miniredis.Miniredis) {
+	server.HSet("bull:emails:7",
+		"name", "send",
+		"data", `{"userId":42}`,
+		"opts", `{"attempts":3}`,
+		"timestamp", "1699999990000",
+		"attemptsMade", "3",
+		"failedReason", "smtp timeout",
+		"finishedOn", "1699999995000",
+	)
+	_, err := server.ZAdd("bull:emails:failed", 1699999995000, "7")
+	require.Nil(t, err)
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		t.Run("get items", func(t *testing.T) {
+			q, server := newTestQueue(t, "bull:emails:failed")
+			assert.Equal(t, "bull:emails:failed", q.Key())
+			addFailed(t, server)
+
+			ctx, cancel := context.WithCancel(ctx)
+			jobChan := make(chan job.Job)
+			go func() {
+				_ = q.GetItems(ctx, jobChan)
+			}()
+
+			j := <-jobChan
+			cancel()
+			for range jobChan {
+			}
+
+			id, err := j.GetValue("id")
+			require.Nil(t, err)
+			assert.Equal(t, "7", id.String())
+
+			userId, err := j.GetValue("data.userId")
+			require.Nil(t, err)
+			assert.Equal(t, "42", job.FormatNumber(userId))
+
+			attempts, err := j.GetValue("attemptsMade")
+			require.Nil(t, err)
+			assert.True(t, job.IsNumber(attempts))
+
+			reason, err := j.GetValue("failedReason")
+			require.Nil(t, err)
+			assert.Equal(t, "smtp timeout", reason.String())
+		})
+		t.Run("page", func(t *testing.T) {
+			q, server := newTestQueue(t, "bull:emails:wait")
+			for _, id := range []string{"1", "2", "3"} {
+				server.HSet("bull:emails:"+id, "name", "send", "data", `{"userId":`+id+`}`)
+			}
+			_, err := server.Lpush("bull:emails:wait", "1")
+			require.Nil(t, err)
+			_, err = server.Lpush("bull:emails:wait", "2")
+			require.Nil(t, err)
+			_, err = server.Lpush("bull:emails:wait", "3")
+			require.Nil(t, err)
+
+			n, err := q.Count(ctx)
+			require.Nil(t, err)
+			assert.Equal(t, int64(3), n)
+
+			jobs, err := q.Page(ctx, 1, 5)
+			require.Nil(t, err)
+			require.Len(t, jobs, 2)
+
+			id, err := jobs[0].GetValue("id")
+			require.Nil(t, err)
+			assert.Equal(t, "2", id.String())
+
+			// paging leaves the jobs where they are
+			list, err := server.List("bull:emails:wait")
+			require.Nil(t, err)
+			assert.Equal(t, []string{"3", "2", "1"}, list)
+		})
+		t.Run("push new job", func(t *testing.T) {
+			q, server := newTestQueue(t, "bull:emails:wait")
+			_, err := server.Incr("bull:emails:id", 10)
+			require.Nil(t, err)
+
+			err = queues.Collect(func(errChan chan error) {
+				q.PushItems(ctx, queuetest.MakeJob(t, `{"class":"send","userId":42}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			list, err := server.List("bull:emails:wait")
+			require.Nil(t, err)
+			assert.Equal(t, []string{"11"}, list)
+			assert.True(t, server.Exists("bull:emails:marker"))
+
+			assert.Equal(t, "send", server.HGet("bull:emails:11", "name"))
+			assert.JSONEq(t, `{"class":"send","userId":42}`, server.HGet("bull:emails:11", "data"))
+			assert.Equal(t, "1700000000000", server.HGet("bull:emails:11", "timestamp"))
+		})
+		t.Run("push delayed", func(t *testing.T) {
+			q, server := newTestQueue(t, "bull:emails:delayed")
+
+			err := queues.Collect(func(errChan chan error) {
+				q.PushItems(ctx, queuetest.MakeJob(t, `{"name":"send","data":{"userId":42},"delay":5000}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			score, err := server.ZScore("bull:emails:delayed", "1")
+			require.Nil(t, err)
+			assert.Equal(t, float64(1700000005000*0x1000), score)
+		})
+		t.Run("retry", func(t *testing.T) {
+			q, server := newTestQueue(t, "bull:emails:failed")
+			addFailed(t, server)
+
+			retry, ok := q.Action(RetryAction)
+			require.True(t, ok)
+
+			err := queues.Collect(func(errChan chan error) {
+				retry(ctx, queuetest.MakeJob(t, `{"id":"7"}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			assert.False(t, server.Exists("bull:emails:failed"))
+
+			list, err := server.List("bull:emails:wait")
+			require.Nil(t, err)
+			assert.Equal(t, []string{"7"}, list)
+			assert.Equal(t, "", server.HGet("bull:emails:7", "failedReason"))
+			assert.Equal(t, "", server.HGet("bull:emails:7", "finishedOn"))
+			assert.Equal(t, `{"userId":42}`, server.HGet("bull:emails:7", "data"))
+		})
+		t.Run("retry to a paused queue", func(t *testing.T) {
+			q, server := newTestQueue(t, "bull:emails:failed")
+			addFailed(t, server)
+			server.HSet("bull:emails:meta", "paused", "1")
+
+			err := queues.Collect(func(errChan chan error) {
+				q.Retry(ctx, queuetest.MakeJob(t, `{"id":"7"}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			assert.False(t, server.Exists("bull:emails:wait"))
+			list, err := server.List("bull:emails:paused")
+			require.Nil(t, err)
+			assert.Equal(t, []string{"7"}, list)
+		})
+		t.Run("retry prioritized", func(t *testing.T) {
+			q, server := newTestQueue(t, "bull:emails:failed")
+			addFailed(t, server)
+			server.HSet("bull:emails:7", "priority", "3")
+			_, err := server.Incr("bull:emails:pc", 4)
+			require.Nil(t, err)
+
+			err = queues.Collect(func(errChan chan error) {
+				q.Retry(ctx, queuetest.MakeJob(t, `{"id":"7"}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			// the priority is shifted above the queue's priority counter
+			score, err := server.ZScore("bull:emails:prioritized", "7")
+			require.Nil(t, err)
+			assert.Equal(t, float64(3*0x100000000+5), score)
+			assert.False(t, server.Exists("bull:emails:wait"))
+		})
+		t.Run("push prioritized", func(t *testing.T) {
+			q, server := newTestQueue(t, "bull:emails:prioritized")
+
+			for range []int{0, 1} {
+				err := queues.Collect(func(errChan chan error) {
+					q.PushItems(ctx, queuetest.MakeJob(t, `{"name":"send","data":{"userId":42},"priority":2}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+				})
+				require.Nil(t, err)
+			}
+
+			// jobs of the same priority are taken in the order they were added
+			members, err := server.ZMembers("bull:emails:prioritized")
+			require.Nil(t, err)
+			require.Len(t, members, 2)
+
+			first, err := server.ZScore("bull:emails:prioritized", members[0])
+			require.Nil(t, err)
+			second, err := server.ZScore("bull:emails:prioritized", members[1])
+			require.Nil(t, err)
+			assert.Equal(t, []float64{2*0x100000000 + 1, 2*0x100000000 + 2}, []float64{first, second})
+		})
+		t.Run("delete", func(t *testing.T) {
+			q, server := newTestQueue(t, "bull:emails:failed")
+			addFailed(t, server)
+			_, err := server.Lpush("bull:emails:7:logs", "attempt 1")
+			require.Nil(t, err)
+
+			err = queues.Collect(func(errChan chan error) {
+				q.RemoveItems(ctx, queuetest.MakeJob(t, `{"id":"7"}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			assert.False(t, server.Exists("bull:emails:failed"))
+			assert.False(t, server.Exists("bull:emails:7"))
+			assert.False(t, server.Exists("bull:emails:7:logs"))
+		})
+	})
+	t.Run("failure", func(t *testing.T) {
+		t.Run("retry waiting job", func(t *testing.T) {
+			q, _ := newTestQueue(t, "bull:emails:wait")
+
+			err := queues.Collect(func(errChan chan error) {
+				q.Retry(ctx, queuetest.MakeJob(t, `{"id":"7"}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			assert.NotNil(t, err)
+		})
+		t.Run("remove without id", func(t *testing.T) {
+			q, _ := newTestQueue(t, "bull:emails:failed")
+
+			err := queues.Collect(func(errChan chan error) {
+				q.RemoveItems(ctx, queuetest.MakeJob(t, `{"name":"send"}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			assert.NotNil(t, err)
+		})
+	})
+}
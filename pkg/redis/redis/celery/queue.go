@@ -0,0 +1,320 @@
+package celery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"io"
+	"reflect"
+	"sort"
+)
+
+const count = 100
+
+// Keys kombu keeps messages that have been delivered to a worker but not
+// acknowledged under.
+const (
+	UnackedKey      = "unacked"
+	unackedIndexKey = "unacked_index"
+)
+
+// Actions a Queue exposes to pipelines.
+const (
+	// RestoreAction moves an unacknowledged message back to the queue it was
+	// delivered from, like kombu does when a worker shuts down
+	RestoreAction = "restore"
+	// DeleteAction removes a message from the queue
+	DeleteAction = "delete"
+)
+
+// Queue is a celery queue, the list kombu publishes a queue's messages to,
+// or kombu's unacked hash of messages delivered to workers that haven't
+// acknowledged them, which is where the tasks of lost workers are left.
+// Messages are read with the celery format unless the queue is configured
+// with another.
+type Queue struct {
+	jobbuilder *job.Builder
+	options    queues.Options
+	key        string
+	client     redis.UniversalClient
+}
+
+// NewQueue returns the celery queue stored at key, the queue's name or
+// unacked.
+func NewQueue(key string, client redis.UniversalClient, opts ...queues.Option) *Queue {
+	options := queues.NewOptions(append([]queues.Option{queues.WithJobConfiguration(&job.Configuration{Type: job.CeleryRawJobType})}, opts...)...)
+	jobJuilder := job.NewBuilder(options.JobConfiguration)
+
+	return &Queue{key: key, client: client, jobbuilder: jobJuilder, options: options}
+}
+
+func (q *Queue) GetItems(ctx context.Context, jobChan chan<- job.Job) error {
+	defer func() {
+		close(jobChan)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+			messages, err := q.messages(ctx)
+			if err != nil {
+				return err
+			}
+
+			logs.Info(ctx, "got items from queue", logs.WithValue("key", q.key), logs.WithValue("count", len(messages)))
+
+			for idx := range messages {
+				j := queues.MakeJob(ctx, q.jobbuilder, q.options, q, messages[idx])
+				if j == nil {
+					continue
+				}
+
+				jobChan <- j
+			}
+		}
+	}
+}
+
+func (q *Queue) messages(ctx context.Context) ([][]byte, error) {
+	if q.key != UnackedKey {
+		// kombu pushes onto the head of the list and pops from the tail
+		members, err := q.client.LRange(ctx, q.key, -count, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		messages := make([][]byte, len(members))
+		for idx := range members {
+			messages[idx] = []byte(members[idx])
+		}
+
+		return messages, nil
+	}
+
+	unacked, err := q.client.HGetAll(ctx, q.key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([][]byte, 0, len(unacked))
+	for tag, entry := range unacked {
+		if message, ok := unackedMessage(ctx, tag, entry); ok {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages, nil
+}
+
+// unackedMessage returns the message of an entry of the unacked hash.
+func unackedMessage(ctx context.Context, tag, entry string) ([]byte, bool) {
+	// entries are [message, exchange, routing_key]
+	var fields []json.RawMessage
+	if err := json.Unmarshal([]byte(entry), &fields); err != nil || len(fields) != 3 {
+		logs.Warn(ctx, "skipping invalid unacked entry", logs.WithValue("deliveryTag", tag))
+		return nil, false
+	}
+
+	return fields[0], true
+}
+
+// Count returns how many messages the list or the unacked hash has.
+func (q *Queue) Count(ctx context.Context) (int64, error) {
+	if q.key == UnackedKey {
+		return q.client.HLen(ctx, q.key).Result()
+	}
+
+	return q.client.LLen(ctx, q.key).Result()
+}
+
+// Page returns count messages from offset without removing them, from the
+// head of the list, or in delivery tag order from the unacked hash.
+func (q *Queue) Page(ctx context.Context, offset, count int64) ([]job.Job, error) {
+	messages, err := q.page(ctx, offset, count)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]job.Job, 0, len(messages))
+	for idx := range messages {
+		j := queues.PageJob(ctx, q.jobbuilder, messages[idx])
+		if j == nil {
+			continue
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
+
+func (q *Queue) page(ctx context.Context, offset, count int64) ([][]byte, error) {
+	if q.key != UnackedKey {
+		members, err := q.client.LRange(ctx, q.key, offset, offset+count-1).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		messages := make([][]byte, len(members))
+		for idx := range members {
+			messages[idx] = []byte(members[idx])
+		}
+
+		return messages, nil
+	}
+
+	tags, err := q.client.HKeys(ctx, q.key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(tags)
+	if offset >= int64(len(tags)) {
+		return nil, nil
+	}
+
+	tags = tags[offset:]
+	if int64(len(tags)) > count {
+		tags = tags[:count]
+	}
+
+	entries, err := q.client.HMGet(ctx, q.key, tags...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([][]byte, 0, len(entries))
+	for idx := range entries {
+		// removed between reading the tags and the entries
+		entry, ok := entries[idx].(string)
+		if !ok {
+			continue
+		}
+
+		if message, ok := unackedMessage(ctx, tags[idx], entry); ok {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages, nil
+}
+
+// PushItems publishes the job to the queue. Jobs from other systems are
+// wrapped in a new celery message.
+func (q *Queue) PushItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	if q.key == UnackedKey {
+		errChan <- errors.New("can not push to the unacked hash")
+		return
+	}
+
+	member, err := q.jobbuilder.Encode(j)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	intCmd := q.client.LPush(ctx, q.key, member)
+	if intCmd.Err() != nil {
+		errChan <- intCmd.Err()
+	}
+}
+
+func (q *Queue) RemoveItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		return q.remove(ctx, pipe, j)
+	})
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	logs.Info(ctx, "removed from celery queue", logs.WithValue("key", q.key), logs.WithValue("id", field(j, "id")))
+}
+
+// Action returns the pipeline action called name.
+func (q *Queue) Action(name string) (queues.Action, bool) {
+	switch name {
+	case RestoreAction:
+		return q.Restore, true
+	case DeleteAction:
+		return q.RemoveItems, true
+	}
+
+	return nil, false
+}
+
+// Restore moves an unacknowledged message back to the queue in its routing
+// key.
+func (q *Queue) Restore(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	if q.key != UnackedKey {
+		errChan <- fmt.Errorf("can only restore messages from %s, not %s", UnackedKey, q.key)
+		return
+	}
+
+	queue := field(j, "properties.delivery_info.routing_key")
+	if queue == "" {
+		errChan <- errors.New("message has no routing key")
+		return
+	}
+
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if err := q.remove(ctx, pipe, j); err != nil {
+			return err
+		}
+
+		pipe.LPush(ctx, queue, j.Raw())
+
+		return nil
+	})
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	logs.Info(ctx, "restored celery message", logs.WithValue("queue", queue), logs.WithValue("id", field(j, "id")))
+}
+
+func (q *Queue) remove(ctx context.Context, pipe redis.Pipeliner, j job.Job) error {
+	if q.key != UnackedKey {
+		pipe.LRem(ctx, q.key, 1, j.Original())
+		return nil
+	}
+
+	tag := field(j, "properties.delivery_tag")
+	if tag == "" {
+		return errors.New("message has no delivery tag")
+	}
+
+	pipe.HDel(ctx, UnackedKey, tag)
+	pipe.ZRem(ctx, unackedIndexKey, tag)
+
+	return nil
+}
+
+func field(j job.Job, key string) string {
+	val, err := j.GetValue(key)
+	if err != nil || val.Kind() != reflect.String {
+		return ""
+	}
+
+	return val.String()
+}
@@ -0,0 +1,181 @@
+package celery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/queues"
+	"testing"
+)
+
+const message = `{"body":"W1s0Ml0sIHsidXJnZW50IjogdHJ1ZX0sIHsiY2FsbGJhY2tzIjogbnVsbCwgImVycmJhY2tzIjogbnVsbCwgImNoYWluIjogbnVsbCwgImNob3JkIjogbnVsbH1d","content-encoding":"utf-8","content-type":"application/json","headers":{"lang":"py","task":"tasks.send_email","id":"3b2e2c6d-4a1f-4d6e-9a55-1d2d3f4a5b6c","retries":0},"properties":{"correlation_id":"3b2e2c6d-4a1f-4d6e-9a55-1d2d3f4a5b6c","delivery_info":{"exchange":"","routing_key":"emails"},"body_encoding":"base64","delivery_tag":"tag-1"}}`
+
+func newTestQueue(t *testing.T, key string) (*Queue, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	return NewQueue(key, client), server
+}
+
+func makeJob(t *testing.T, q *Queue, payload string) job.Job {
+	j, err := q.jobbuilder.MakeJob([]byte(payload))
+	require.Nil(t, err)
+
+	return j
+}
+
+func unacked(t *testing.T) string {
+	entry, err := json.Marshal([]interface{}{json.RawMessage(message), "", "emails"})
+	require.Nil(t, err)
+
+	return string(entry)
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		t.Run("read message", func(t *testing.T) {
+			q, server := newTestQueue(t, UnackedKey)
+			server.HSet(UnackedKey, "tag-1", unacked(t))
+
+			ctx, cancel := context.WithCancel(ctx)
+			jobChan := make(chan job.Job)
+			go func() {
+				_ = q.GetItems(ctx, jobChan)
+			}()
+
+			j := <-jobChan
+			cancel()
+			for range jobChan {
+			}
+
+			task, err := j.GetValue("task")
+			require.Nil(t, err)
+			assert.Equal(t, "tasks.send_email", task.String())
+
+			urgent, err := j.GetValue("kwargs.urgent")
+			require.Nil(t, err)
+			assert.Equal(t, true, urgent.Interface())
+		})
+		t.Run("page", func(t *testing.T) {
+			q, server := newTestQueue(t, "emails")
+			_, err := server.Lpush("emails", message)
+			require.Nil(t, err)
+			_, err = server.Lpush("emails", message)
+			require.Nil(t, err)
+
+			n, err := q.Count(ctx)
+			require.Nil(t, err)
+			assert.Equal(t, int64(2), n)
+
+			jobs, err := q.Page(ctx, 1, 5)
+			require.Nil(t, err)
+			require.Len(t, jobs, 1)
+
+			task, err := jobs[0].GetValue("task")
+			require.Nil(t, err)
+			assert.Equal(t, "tasks.send_email", task.String())
+
+			list, err := server.List("emails")
+			require.Nil(t, err)
+			assert.Len(t, list, 2)
+		})
+		t.Run("page unacked", func(t *testing.T) {
+			q, server := newTestQueue(t, UnackedKey)
+			server.HSet(UnackedKey, "tag-1", unacked(t))
+			server.HSet(UnackedKey, "tag-2", "not an entry")
+
+			n, err := q.Count(ctx)
+			require.Nil(t, err)
+			assert.Equal(t, int64(2), n)
+
+			// the invalid entry is skipped
+			jobs, err := q.Page(ctx, 0, 5)
+			require.Nil(t, err)
+			assert.Len(t, jobs, 1)
+
+			jobs, err = q.Page(ctx, 1, 5)
+			require.Nil(t, err)
+			assert.Empty(t, jobs)
+		})
+		t.Run("push wraps other jobs", func(t *testing.T) {
+			q, server := newTestQueue(t, "emails")
+			other, err := job.NewBuilder(&job.Configuration{Type: job.JsonRawJobType}).MakeJob([]byte(`{"class":"tasks.send_email","args":[42]}`))
+			require.Nil(t, err)
+
+			err = queues.Collect(func(errChan chan error) {
+				q.PushItems(ctx, other, &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			list, err := server.List("emails")
+			require.Nil(t, err)
+			require.Len(t, list, 1)
+
+			pushed := makeJob(t, q, list[0])
+			task, err := pushed.GetValue("task")
+			require.Nil(t, err)
+			assert.Equal(t, "tasks.send_email", task.String())
+
+			arg, err := pushed.GetValue("args[0]")
+			require.Nil(t, err)
+			assert.Equal(t, "42", job.FormatNumber(arg))
+		})
+		t.Run("restore", func(t *testing.T) {
+			q, server := newTestQueue(t, UnackedKey)
+			server.HSet(UnackedKey, "tag-1", unacked(t))
+			_, err := server.ZAdd(unackedIndexKey, 1700000000, "tag-1")
+			require.Nil(t, err)
+
+			restore, ok := q.Action(RestoreAction)
+			require.True(t, ok)
+
+			err = queues.Collect(func(errChan chan error) {
+				restore(ctx, makeJob(t, q, message), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			assert.False(t, server.Exists(UnackedKey))
+			assert.False(t, server.Exists(unackedIndexKey))
+
+			list, err := server.List("emails")
+			require.Nil(t, err)
+			require.Len(t, list, 1)
+			assert.JSONEq(t, message, list[0])
+		})
+		t.Run("delete", func(t *testing.T) {
+			q, server := newTestQueue(t, "emails")
+			server.Lpush("emails", message)
+
+			err := queues.Collect(func(errChan chan error) {
+				q.RemoveItems(ctx, makeJob(t, q, message), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+			assert.False(t, server.Exists("emails"))
+		})
+	})
+	t.Run("failure", func(t *testing.T) {
+		t.Run("push to unacked", func(t *testing.T) {
+			q, _ := newTestQueue(t, UnackedKey)
+
+			err := queues.Collect(func(errChan chan error) {
+				q.PushItems(ctx, makeJob(t, q, message), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			assert.NotNil(t, err)
+		})
+		t.Run("restore from a queue", func(t *testing.T) {
+			q, _ := newTestQueue(t, "emails")
+
+			err := queues.Collect(func(errChan chan error) {
+				q.Restore(ctx, makeJob(t, q, message), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			assert.NotNil(t, err)
+		})
+	})
+}
@@ -0,0 +1,86 @@
+package hashtag
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+)
+
+// SlotCount is the number of hash slots of a redis cluster.
+const SlotCount = 16384
+
+// Key returns the part of key redis cluster hashes to pick its slot, the
+// hash tag between the first { and the next } when it isn't empty, or else
+// the whole key.
+func Key(key string) string {
+	start := -1
+	for idx := 0; idx < len(key); idx++ {
+		if key[idx] == '{' {
+			start = idx
+			break
+		}
+	}
+
+	if start < 0 {
+		return key
+	}
+
+	for idx := start + 1; idx < len(key); idx++ {
+		if key[idx] == '}' {
+			if idx == start+1 {
+				return key
+			}
+
+			return key[start+1 : idx]
+		}
+	}
+
+	return key
+}
+
+// Slot returns the cluster slot key is stored in.
+func Slot(key string) int {
+	return int(crc16(Key(key)) % SlotCount)
+}
+
+// SameSlot reports whether keys are all stored in the same cluster slot, so
+// they can be used together in a command or transaction.
+func SameSlot(keys ...string) bool {
+	for idx := 1; idx < len(keys); idx++ {
+		if Slot(keys[idx]) != Slot(keys[0]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) checksum redis cluster hashes keys with.
+func crc16(s string) uint16 {
+	crc := uint16(0)
+	for idx := 0; idx < len(s); idx++ {
+		crc ^= uint16(s[idx]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// TxPipelined runs fn in a transaction when keys can be used in one, and as a
+// plain pipeline when client is a cluster and they are in different slots,
+// where a transaction can't span them.
+func TxPipelined(ctx context.Context, client redis.UniversalClient, fn func(redis.Pipeliner) error, keys ...string) error {
+	if _, ok := client.(*redis.ClusterClient); ok && !SameSlot(keys...) {
+		_, err := client.Pipelined(ctx, fn)
+		return err
+	}
+
+	_, err := client.TxPipelined(ctx, fn)
+
+	return err
+}
@@ -0,0 +1,48 @@
+package hashtag
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		assert.Equal(t, "user1000", Key("{user1000}.following"))
+		assert.Equal(t, "user1000", Key("foo{user1000}{bar}"))
+		assert.Equal(t, "queue:default", Key("queue:default"))
+	})
+	t.Run("failure", func(t *testing.T) {
+		// empty and unterminated tags hash the whole key
+		assert.Equal(t, "foo{}{bar}", Key("foo{}{bar}"))
+		assert.Equal(t, "foo{bar", Key("foo{bar"))
+	})
+}
+
+func TestSlot(t *testing.T) {
+	// the check value of the cluster specification's crc16
+	assert.Equal(t, 0x31c3%SlotCount, Slot("123456789"))
+	assert.Equal(t, Slot("user1000"), Slot("{user1000}.following"))
+
+	assert.True(t, SameSlot("{bull:emails}:wait", "{bull:emails}:7", "{bull:emails}:7:logs"))
+	assert.False(t, SameSlot("retry", "queue:default"))
+}
+
+func TestTxPipelined(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	err := TxPipelined(ctx, client, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, "retry", "1", 0)
+		pipe.Set(ctx, "job:1", "{}", 0)
+
+		return nil
+	}, "retry", "job:1")
+	require.Nil(t, err)
+	assert.True(t, server.Exists("retry"))
+	assert.True(t, server.Exists("job:1"))
+}
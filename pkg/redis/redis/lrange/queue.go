@@ -2,23 +2,30 @@ package lrange
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"github.com/go-redis/redis/v8"
 	"github.com/thethan/goqueue/internal/job"
 	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/pkg/redis/redis/hashtag"
+	"github.com/thethan/goqueue/pkg/redis/redis/reference"
 	"io"
 )
 
 type LRangeQueue struct {
 	jobbuilder *job.Builder
+	options    queues.Options
 	key        string
-	client     *redis.Client
+	client     redis.UniversalClient
+	// references loads the jobs members refer to, nil when members are jobs
+	references *reference.Store
 }
 
-func NewLRangeQueue(key string, client *redis.Client) *LRangeQueue {
-	jobJuilder := job.NewBuilder(&job.Configuration{Type: "json"})
+func NewLRangeQueue(key string, client redis.UniversalClient, opts ...queues.Option) *LRangeQueue {
+	options := queues.NewOptions(opts...)
+	jobJuilder := job.NewBuilder(options.JobConfiguration)
 
-	return &LRangeQueue{key: key, client: client, jobbuilder: jobJuilder}
+	return &LRangeQueue{key: key, client: client, jobbuilder: jobJuilder, options: options}
 }
 
 func (l *LRangeQueue) GetItems(ctx context.Context, jobChan chan<- job.Job) error {
@@ -42,12 +49,20 @@ func (l *LRangeQueue) GetItems(ctx context.Context, jobChan chan<- job.Job) erro
 
 			logs.Info(ctx, "got items from queue", logs.WithValue("count", len(jobsString)))
 
+			if l.references != nil {
+				if err := l.loadReferences(ctx, jobsString, jobChan); err != nil {
+					return err
+				}
+
+				continue
+			}
+
 			for idx := range jobsString {
 				jobStr := jobsString[idx]
 
-				j, err := l.jobbuilder.MakeJob([]byte(jobStr))
-				if err != nil {
-					return fmt.Errorf("could not convert job to string")
+				j := queues.MakeJob(ctx, l.jobbuilder, l.options, l, []byte(jobStr))
+				if j == nil {
+					continue
 				}
 
 				jobChan <- j
@@ -56,22 +71,207 @@ func (l *LRangeQueue) GetItems(ctx context.Context, jobChan chan<- job.Job) erro
 	}
 }
 
-func (z *LRangeQueue) PushItems(context.Context, job.Job, io.ReadWriter, io.ReadWriter, chan error) {
-	//TODO implement me
-	panic("implement me")
+// loadReferences sends the jobs the members ids refer to.
+func (l *LRangeQueue) loadReferences(ctx context.Context, ids []string, jobChan chan<- job.Job) error {
+	entries, err := l.references.Load(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for idx := range entries {
+		source := &reference.Member{ID: entries[idx].ID, Remove: l.remove}
+		j := queues.MakeJob(ctx, l.jobbuilder, l.options, source, entries[idx].Payload)
+		if j == nil {
+			continue
+		}
+
+		l.references.Track(j, entries[idx].ID)
+		jobChan <- j
+	}
+
+	return nil
+}
+
+// LoadFrom makes the queue's members the ids of jobs kept in store.
+func (l *LRangeQueue) LoadFrom(store *reference.Store) {
+	l.references = store
+}
+
+// Count returns how many members the list has.
+func (l *LRangeQueue) Count(ctx context.Context) (int64, error) {
+	return l.client.LLen(ctx, l.key).Result()
+}
+
+// Page returns the jobs of count members from offset, head first, without
+// removing them.
+func (l *LRangeQueue) Page(ctx context.Context, offset, count int64) ([]job.Job, error) {
+	members, err := l.client.LRange(ctx, l.key, offset, offset+count-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if l.references != nil {
+		return l.pageReferences(ctx, members)
+	}
+
+	jobs := make([]job.Job, 0, len(members))
+	for idx := range members {
+		j := queues.PageJob(ctx, l.jobbuilder, []byte(members[idx]))
+		if j == nil {
+			continue
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
+
+// pageReferences returns the jobs the members ids refer to, tracked so they
+// can be removed.
+func (l *LRangeQueue) pageReferences(ctx context.Context, ids []string) ([]job.Job, error) {
+	entries, err := l.references.Load(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]job.Job, 0, len(entries))
+	for idx := range entries {
+		j := queues.PageJob(ctx, l.jobbuilder, entries[idx].Payload)
+		if j == nil {
+			continue
+		}
+
+		l.references.Track(j, entries[idx].ID)
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
+
+// PushItems pushes onto the head of the list, where sidekiq enqueues jobs.
+func (l *LRangeQueue) PushItems(ctx context.Context, job job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	member, err := l.jobbuilder.Encode(job)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	if l.references != nil {
+		_, err := l.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			id, err := l.references.Save(ctx, pipe, job, member)
+			if err != nil {
+				return err
+			}
+
+			pipe.LPush(ctx, l.key, id)
+
+			return nil
+		})
+		if err != nil {
+			errChan <- err
+		}
+
+		return
+	}
+
+	intCmd := l.client.LPush(ctx, l.key, member)
+	if intCmd.Err() != nil {
+		errChan <- intCmd.Err()
+	}
 }
 
 func (l *LRangeQueue) RemoveItems(ctx context.Context, job job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
-	close(errChan)
-	intCmd := l.client.LRem(ctx, l.key, 1, string(job.Raw()))
+	defer func() {
+		close(errChan)
+	}()
+
+	if l.references != nil {
+		id, ok := l.references.ID(job)
+		if !ok {
+			errChan <- errors.New("could not find the member of the job")
+			return
+		}
+
+		if err := l.remove(ctx, id); err != nil {
+			errChan <- err
+		}
+
+		return
+	}
+
+	intCmd := l.client.LRem(ctx, l.key, 1, string(job.Original()))
 	if intCmd.Err() != nil {
 		errChan <- intCmd.Err()
 	}
 
 	jidVal, err := job.GetValue("jid")
 	if err != nil {
-		errChan <- intCmd.Err()
+		logs.Warn(ctx, "removed job has no jid", logs.WithError(err))
 	}
 
 	logs.Info(ctx, "removed from lrange queue", logs.WithValue("key", l.key), logs.WithValue("id", jidVal.String()), logs.WithValue("int", intCmd.Val()))
 }
+
+// remove removes the member id and the job it refers to.
+func (l *LRangeQueue) remove(ctx context.Context, id string) error {
+	err := hashtag.TxPipelined(ctx, l.client, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, l.key, 1, id)
+		l.references.Delete(ctx, pipe, id)
+
+		return nil
+	}, l.key, l.references.Key(id))
+	if err != nil {
+		return err
+	}
+
+	logs.Info(ctx, "removed from lrange queue", logs.WithValue("key", l.key), logs.WithValue("id", id))
+
+	return nil
+}
+
+// Client is the client the list is stored with.
+func (l *LRangeQueue) Client() redis.UniversalClient {
+	return l.client
+}
+
+// TxKeys returns the list's key, or nil when its members refer to jobs,
+// which are saved under keys of their own.
+func (l *LRangeQueue) TxKeys() []string {
+	if l.references != nil {
+		return nil
+	}
+
+	return []string{l.key}
+}
+
+// Contains reports whether j is in the list.
+func (l *LRangeQueue) Contains(ctx context.Context, tx *redis.Tx, j job.Job) (bool, error) {
+	err := tx.LPos(ctx, l.key, string(j.Original()), redis.LPosArgs{}).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// TxPush queues pushing j onto the head of the list on pipe.
+func (l *LRangeQueue) TxPush(ctx context.Context, pipe redis.Pipeliner, j job.Job) error {
+	member, err := l.jobbuilder.Encode(j)
+	if err != nil {
+		return err
+	}
+
+	pipe.LPush(ctx, l.key, member)
+
+	return nil
+}
+
+// TxRemove queues removing j from the list on pipe.
+func (l *LRangeQueue) TxRemove(ctx context.Context, pipe redis.Pipeliner, j job.Job) {
+	pipe.LRem(ctx, l.key, 1, string(j.Original()))
+}
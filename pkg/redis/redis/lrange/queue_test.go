@@ -1,11 +1,15 @@
 package lrange
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/pkg/redis/redis/reference"
 	"reflect"
 	"testing"
 )
@@ -45,3 +49,95 @@ func Test_LRange_GetItems_Integrations(t *testing.T) {
 		}
 	})
 }
+
+func TestLRangeQueue_References(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	queue := NewLRangeQueue("queue:default", client)
+	queue.LoadFrom(reference.NewStore(client, &reference.Configuration{Prefix: "job:", Type: reference.Hash}))
+
+	t.Run("success", func(t *testing.T) {
+		server.Lpush("queue:default", "missing")
+		server.Lpush("queue:default", "abc")
+		server.HSet("job:abc", "class", "WebhookWorker")
+
+		readCtx, cancel := context.WithCancel(ctx)
+		jobChan := make(chan job.Job)
+		go func() {
+			_ = queue.GetItems(readCtx, jobChan)
+		}()
+
+		read := <-jobChan
+		cancel()
+		for range jobChan {
+		}
+
+		class, err := read.GetValue("class")
+		require.Nil(t, err)
+		assert.Equal(t, "WebhookWorker", class.String())
+
+		errChan := make(chan error)
+		go queue.RemoveItems(ctx, read, &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		for err := range errChan {
+			require.Nil(t, err)
+		}
+
+		list, err := server.List("queue:default")
+		require.Nil(t, err)
+		assert.Equal(t, []string{"missing"}, list)
+		assert.False(t, server.Exists("job:abc"))
+	})
+	t.Run("failure", func(t *testing.T) {
+		j, err := job.NewBuilder(&job.Configuration{Type: job.JsonRawJobType}).MakeJob([]byte(`{"class":"WebhookWorker"}`))
+		require.Nil(t, err)
+
+		errChan := make(chan error)
+		go queue.RemoveItems(ctx, j, &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+
+		errs := make([]error, 0)
+		for err := range errChan {
+			errs = append(errs, err)
+		}
+		assert.Len(t, errs, 1)
+	})
+}
+
+func TestLRangeQueue_Page(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	t.Run("success", func(t *testing.T) {
+		t.Run("members", func(t *testing.T) {
+			queue := NewLRangeQueue("queue:default", client)
+			server.Lpush("queue:default", `{"jid":"2"}`)
+			server.Lpush("queue:default", `{"jid":"1"}`)
+
+			n, err := queue.Count(ctx)
+			require.Nil(t, err)
+			assert.Equal(t, int64(2), n)
+
+			jobs, err := queue.Page(ctx, 1, 10)
+			require.Nil(t, err)
+			require.Len(t, jobs, 1)
+			assert.Equal(t, `{"jid":"2"}`, string(jobs[0].Original()))
+		})
+		t.Run("references", func(t *testing.T) {
+			queue := NewLRangeQueue("queue:references", client)
+			queue.LoadFrom(reference.NewStore(client, &reference.Configuration{Prefix: "job:", Type: reference.Hash}))
+			server.Lpush("queue:references", "abc")
+			server.Lpush("queue:references", "missing")
+			server.HSet("job:abc", "class", "WebhookWorker")
+
+			jobs, err := queue.Page(ctx, 0, 10)
+			require.Nil(t, err)
+			require.Len(t, jobs, 1)
+
+			class, err := jobs[0].GetValue("class")
+			require.Nil(t, err)
+			assert.Equal(t, "WebhookWorker", class.String())
+		})
+	})
+}
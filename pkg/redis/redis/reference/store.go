@@ -0,0 +1,273 @@
+package reference
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// Type is how a referenced job is stored.
+type Type string
+
+const (
+	// String jobs are the value of a key, read with GET
+	String Type = "string"
+	// Hash jobs are the fields of a hash, read with HGETALL as a json object
+	// of strings
+	Hash Type = "hash"
+)
+
+const defaultIDField = "jid"
+
+// Configuration of a queue whose members are the ids of jobs stored under
+// their own keys, <prefix><id>.
+type Configuration struct {
+	Prefix string
+	// Type is string (the default) or hash
+	Type Type
+	// IDField is the field of pushed jobs whose value is their id, jid by
+	// default. Jobs without it are given a random one.
+	IDField string
+}
+
+// Entry is a queue member and the job it refers to.
+type Entry struct {
+	ID      string
+	Payload []byte
+}
+
+// Store loads and saves the jobs a queue's members refer to and remembers
+// which member each job it loaded came from, so they can be removed.
+type Store struct {
+	configuration Configuration
+	client        redis.UniversalClient
+
+	mu sync.Mutex
+	// ids are the members of the jobs of the last two loads, the older one
+	// kept for jobs still being processed when the next load happens
+	ids      map[job.Job]string
+	previous map[job.Job]string
+}
+
+func NewStore(client redis.UniversalClient, configuration *Configuration) *Store {
+	s := &Store{client: client, ids: map[job.Job]string{}, previous: map[job.Job]string{}}
+	if configuration != nil {
+		s.configuration = *configuration
+	}
+
+	if s.configuration.Type == "" {
+		s.configuration.Type = String
+	}
+
+	if s.configuration.IDField == "" {
+		s.configuration.IDField = defaultIDField
+	}
+
+	return s
+}
+
+// Key is the key the job with id is stored under.
+func (s *Store) Key(id string) string {
+	return s.configuration.Prefix + id
+}
+
+// Load reads the jobs ids refer to in one pipeline. Ids whose key no longer
+// exists are skipped.
+func (s *Store) Load(ctx context.Context, ids []string) ([]Entry, error) {
+	s.mu.Lock()
+	s.previous, s.ids = s.ids, map[job.Job]string{}
+	s.mu.Unlock()
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	pipe := s.client.Pipeline()
+	cmds := make([]redis.Cmder, len(ids))
+	for idx := range ids {
+		if s.configuration.Type == Hash {
+			cmds[idx] = pipe.HGetAll(ctx, s.Key(ids[idx]))
+		} else {
+			cmds[idx] = pipe.Get(ctx, s.Key(ids[idx]))
+		}
+	}
+
+	// a missing string key fails its GET with redis.Nil, checked per command
+	_, _ = pipe.Exec(ctx)
+
+	entries := make([]Entry, 0, len(ids))
+	for idx := range ids {
+		payload, err := payload(cmds[idx])
+		if err == redis.Nil {
+			logs.Warn(ctx, "skipping member without a job", logs.WithValue("key", s.Key(ids[idx])))
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, Entry{ID: ids[idx], Payload: payload})
+	}
+
+	return entries, nil
+}
+
+func payload(cmd redis.Cmder) ([]byte, error) {
+	switch cmd := cmd.(type) {
+	case *redis.StringCmd:
+		return cmd.Bytes()
+	case *redis.StringStringMapCmd:
+		fields, err := cmd.Result()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fields) == 0 {
+			return nil, redis.Nil
+		}
+
+		return json.Marshal(fields)
+	}
+
+	return nil, fmt.Errorf("unexpected command %s", cmd.Name())
+}
+
+// Track remembers that j was loaded from the member id.
+func (s *Store) Track(j job.Job, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ids[j] = id
+}
+
+// ID returns the member of a job, the one it was loaded from or else the
+// value of its id field.
+func (s *Store) ID(j job.Job) (string, bool) {
+	s.mu.Lock()
+	id, ok := s.ids[j]
+	if !ok {
+		id, ok = s.previous[j]
+	}
+	s.mu.Unlock()
+
+	if ok {
+		return id, true
+	}
+
+	return idField(j, s.configuration.IDField)
+}
+
+func idField(j job.Data, field string) (string, bool) {
+	val, err := j.GetValue(field)
+	if err != nil {
+		return "", false
+	}
+
+	if job.IsNumber(val) {
+		return job.FormatNumber(val), true
+	}
+
+	if val.Kind() != reflect.String || val.String() == "" {
+		return "", false
+	}
+
+	return val.String(), true
+}
+
+// Save stores the encoded job bts in the pipeline and returns the id to add
+// to the queue.
+func (s *Store) Save(ctx context.Context, pipe redis.Pipeliner, j job.Job, bts []byte) (string, error) {
+	id, ok := idField(j, s.configuration.IDField)
+	if !ok {
+		generated, err := newID()
+		if err != nil {
+			return "", fmt.Errorf("could not generate id: %w", err)
+		}
+
+		id = generated
+	}
+
+	if s.configuration.Type != Hash {
+		pipe.Set(ctx, s.Key(id), bts, 0)
+		return id, nil
+	}
+
+	fields, err := hashFields(bts)
+	if err != nil {
+		return "", err
+	}
+
+	pipe.Del(ctx, s.Key(id))
+	pipe.HSet(ctx, s.Key(id), fields)
+
+	return id, nil
+}
+
+// hashFields are the fields of a json job as stored in a hash, strings as
+// they are and other values as json.
+func hashFields(bts []byte) (map[string]interface{}, error) {
+	var object map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(bts))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, fmt.Errorf("hash jobs must be json objects: %w", err)
+	}
+
+	fields := make(map[string]interface{}, len(object))
+	for key, value := range object {
+		if s, ok := value.(string); ok {
+			fields[key] = s
+			continue
+		}
+
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+
+		fields[key] = string(encoded)
+	}
+
+	return fields, nil
+}
+
+// Delete removes the job with id in the pipeline.
+func (s *Store) Delete(ctx context.Context, pipe redis.Pipeliner, id string) {
+	pipe.Del(ctx, s.Key(id))
+}
+
+func newID() (string, error) {
+	bts := make([]byte, 12)
+	if _, err := rand.Read(bts); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bts), nil
+}
+
+// Member removes a single member, the source of jobs quarantined before they
+// are tracked.
+type Member struct {
+	ID     string
+	Remove func(ctx context.Context, id string) error
+}
+
+func (m *Member) RemoveItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	if err := m.Remove(ctx, m.ID); err != nil {
+		errChan <- err
+	}
+}
@@ -0,0 +1,115 @@
+package reference
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/queuetest"
+	"testing"
+)
+
+func newTestStore(t *testing.T, configuration *Configuration) (*Store, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	return NewStore(client, configuration), server
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		t.Run("load strings", func(t *testing.T) {
+			store, server := newTestStore(t, &Configuration{Prefix: "job:"})
+			require.Nil(t, server.Set("job:1", `{"class":"WebhookWorker"}`))
+
+			entries, err := store.Load(ctx, []string{"1", "2"})
+			require.Nil(t, err)
+			assert.Equal(t, []Entry{{ID: "1", Payload: []byte(`{"class":"WebhookWorker"}`)}}, entries)
+		})
+		t.Run("load hashes", func(t *testing.T) {
+			store, server := newTestStore(t, &Configuration{Prefix: "job:", Type: Hash})
+			server.HSet("job:1", "class", "WebhookWorker", "retry_count", "3")
+
+			entries, err := store.Load(ctx, []string{"1", "2"})
+			require.Nil(t, err)
+			require.Len(t, entries, 1)
+			assert.JSONEq(t, `{"class":"WebhookWorker","retry_count":"3"}`, string(entries[0].Payload))
+		})
+		t.Run("save", func(t *testing.T) {
+			store, server := newTestStore(t, &Configuration{Prefix: "job:"})
+
+			j := queuetest.MakeJob(t, `{"jid":"abc","class":"WebhookWorker"}`)
+			_, err := store.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				id, err := store.Save(ctx, pipe, j, j.Raw())
+				assert.Equal(t, "abc", id)
+
+				return err
+			})
+			require.Nil(t, err)
+
+			value, err := server.Get("job:abc")
+			require.Nil(t, err)
+			assert.Equal(t, `{"jid":"abc","class":"WebhookWorker"}`, value)
+		})
+		t.Run("save hash", func(t *testing.T) {
+			store, server := newTestStore(t, &Configuration{Prefix: "job:", Type: Hash, IDField: "id"})
+
+			j := queuetest.MakeJob(t, `{"id":7,"class":"WebhookWorker","args":[1]}`)
+			_, err := store.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				id, err := store.Save(ctx, pipe, j, j.Raw())
+				assert.Equal(t, "7", id)
+
+				return err
+			})
+			require.Nil(t, err)
+
+			assert.Equal(t, "WebhookWorker", server.HGet("job:7", "class"))
+			assert.Equal(t, "[1]", server.HGet("job:7", "args"))
+		})
+		t.Run("save without id", func(t *testing.T) {
+			store, _ := newTestStore(t, nil)
+
+			j := queuetest.MakeJob(t, `{"class":"WebhookWorker"}`)
+			_, err := store.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				id, err := store.Save(ctx, pipe, j, j.Raw())
+				assert.Len(t, id, 24)
+
+				return err
+			})
+			require.Nil(t, err)
+		})
+		t.Run("ids", func(t *testing.T) {
+			store, _ := newTestStore(t, nil)
+
+			loaded := queuetest.MakeJob(t, `{"class":"WebhookWorker"}`)
+			store.Track(loaded, "1")
+
+			// kept through the next load for jobs still being processed
+			_, err := store.Load(ctx, nil)
+			require.Nil(t, err)
+
+			id, ok := store.ID(loaded)
+			require.True(t, ok)
+			assert.Equal(t, "1", id)
+
+			_, err = store.Load(ctx, nil)
+			require.Nil(t, err)
+
+			_, ok = store.ID(loaded)
+			assert.False(t, ok)
+
+			id, ok = store.ID(queuetest.MakeJob(t, `{"jid":"abc"}`))
+			require.True(t, ok)
+			assert.Equal(t, "abc", id)
+		})
+	})
+	t.Run("failure", func(t *testing.T) {
+		t.Run("hash of a non object", func(t *testing.T) {
+			_, err := hashFields([]byte(`[1]`))
+			assert.NotNil(t, err)
+		})
+	})
+}
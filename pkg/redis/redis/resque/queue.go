@@ -0,0 +1,321 @@
+package resque
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const count = 100
+
+// Keys resque stores its queues under, after its namespace.
+const (
+	QueuesKey = "queues"
+	FailedKey = "failed"
+
+	queueSegment = "queue"
+)
+
+// Actions a Queue exposes to pipelines.
+const (
+	// RetryAction removes a failed job and enqueues its payload on the queue
+	// it failed on
+	RetryAction = "retry"
+	// DeleteAction removes a job from the queue
+	DeleteAction = "delete"
+)
+
+// failedAtFormat is how resque writes failed_at, ruby's %Y/%m/%d %H:%M:%S %Z.
+const failedAtFormat = "2006/01/02 15:04:05 MST"
+
+// Queue is a resque queue list, <namespace>:queue:<name>, or the failed list,
+// <namespace>:failed. Jobs in a queue are {"class", "args"} payloads, jobs in
+// the failed list wrap the payload with the failure, for example
+// payload.class and error.
+type Queue struct {
+	jobbuilder *job.Builder
+	options    queues.Options
+	key        string
+	namespace  string
+	// name is the queue's name, empty for the failed list
+	name   string
+	client redis.UniversalClient
+	now    func() time.Time
+}
+
+// NewQueue returns the resque queue or failed list stored at key, for
+// example resque:queue:mailer or resque:failed.
+func NewQueue(key string, client redis.UniversalClient, opts ...queues.Option) *Queue {
+	options := queues.NewOptions(opts...)
+	jobJuilder := job.NewBuilder(options.JobConfiguration)
+
+	q := &Queue{key: key, client: client, jobbuilder: jobJuilder, options: options, now: time.Now}
+
+	segments := strings.Split(key, ":")
+	switch {
+	case segments[len(segments)-1] == FailedKey:
+		q.namespace = strings.Join(segments[:len(segments)-1], ":")
+	case len(segments) >= 2 && segments[len(segments)-2] == queueSegment:
+		q.namespace = strings.Join(segments[:len(segments)-2], ":")
+		q.name = segments[len(segments)-1]
+	default:
+		q.name = key
+	}
+
+	return q
+}
+
+// Key is the redis key the queue is stored under.
+func (q *Queue) Key() string {
+	return q.key
+}
+
+func (q *Queue) namespaced(segments ...string) string {
+	if q.namespace == "" {
+		return strings.Join(segments, ":")
+	}
+
+	return q.namespace + ":" + strings.Join(segments, ":")
+}
+
+func (q *Queue) GetItems(ctx context.Context, jobChan chan<- job.Job) error {
+	defer func() {
+		close(jobChan)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+			// resque pushes onto the tail of the list and pops from the head
+			members, err := q.client.LRange(ctx, q.key, 0, count-1).Result()
+			if err != nil {
+				return err
+			}
+
+			logs.Info(ctx, "got items from queue", logs.WithValue("key", q.key), logs.WithValue("count", len(members)))
+
+			for idx := range members {
+				j := queues.MakeJob(ctx, q.jobbuilder, q.options, q, []byte(members[idx]))
+				if j == nil {
+					continue
+				}
+
+				jobChan <- j
+			}
+		}
+	}
+}
+
+// Count returns how many jobs the list has.
+func (q *Queue) Count(ctx context.Context) (int64, error) {
+	return q.client.LLen(ctx, q.key).Result()
+}
+
+// Page returns count jobs from offset, head first, without removing them.
+func (q *Queue) Page(ctx context.Context, offset, count int64) ([]job.Job, error) {
+	members, err := q.client.LRange(ctx, q.key, offset, offset+count-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]job.Job, 0, len(members))
+	for idx := range members {
+		j := queues.PageJob(ctx, q.jobbuilder, []byte(members[idx]))
+		if j == nil {
+			continue
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
+
+// PushItems enqueues the job. Failed jobs pushed to a queue are unwrapped to
+// their payload, jobs pushed to the failed list are wrapped in a failure
+// using their error_message and error_class fields.
+func (q *Queue) PushItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		return q.TxPush(ctx, pipe, j)
+	})
+	if err != nil {
+		errChan <- err
+	}
+}
+
+func (q *Queue) RemoveItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	intCmd := q.client.LRem(ctx, q.key, 1, j.Original())
+	if intCmd.Err() != nil {
+		errChan <- intCmd.Err()
+		return
+	}
+
+	logs.Info(ctx, "removed from resque queue", logs.WithValue("key", q.key), logs.WithValue("int", intCmd.Val()))
+}
+
+// Action returns the pipeline action called name.
+func (q *Queue) Action(name string) (queues.Action, bool) {
+	switch name {
+	case RetryAction:
+		return q.Retry, true
+	case DeleteAction:
+		return q.RemoveItems, true
+	}
+
+	return nil, false
+}
+
+// Retry removes a job from the failed list and enqueues its payload on the
+// queue it failed on.
+func (q *Queue) Retry(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	if q.name != "" {
+		errChan <- fmt.Errorf("can only retry jobs in the failed list, not %s", q.key)
+		return
+	}
+
+	queue, err := j.GetValue("queue")
+	if err != nil || queue.Kind() != reflect.String || queue.String() == "" {
+		errChan <- errors.New("failed job has no queue")
+		return
+	}
+
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, q.key, 1, j.Original())
+		return q.enqueue(ctx, pipe, queue.String(), j)
+	})
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	logs.Info(ctx, "retried resque job", logs.WithValue("queue", queue.String()))
+}
+
+// Client is the client the queue is stored with.
+func (q *Queue) Client() redis.UniversalClient {
+	return q.client
+}
+
+// TxKeys returns the keys pushing jobs to the queue and removing them
+// writes.
+func (q *Queue) TxKeys() []string {
+	if q.name == "" {
+		return []string{q.key}
+	}
+
+	return []string{q.key, q.namespaced(QueuesKey), q.namespaced(queueSegment, q.name)}
+}
+
+// Contains reports whether j is in the list.
+func (q *Queue) Contains(ctx context.Context, tx *redis.Tx, j job.Job) (bool, error) {
+	err := tx.LPos(ctx, q.key, string(j.Original()), redis.LPosArgs{}).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// TxPush queues pushing j the way PushItems does on pipe.
+func (q *Queue) TxPush(ctx context.Context, pipe redis.Pipeliner, j job.Job) error {
+	if q.name != "" {
+		return q.enqueue(ctx, pipe, q.name, j)
+	}
+
+	failure, err := q.failure(j)
+	if err != nil {
+		return err
+	}
+
+	pipe.RPush(ctx, q.key, failure)
+
+	return nil
+}
+
+// TxRemove queues removing j from the list on pipe.
+func (q *Queue) TxRemove(ctx context.Context, pipe redis.Pipeliner, j job.Job) {
+	pipe.LRem(ctx, q.key, 1, j.Original())
+}
+
+// enqueue adds the job's payload to the queue called name.
+func (q *Queue) enqueue(ctx context.Context, pipe redis.Pipeliner, name string, j job.Job) error {
+	var payload []byte
+	if val, err := j.GetValue("payload"); err == nil {
+		bts, err := json.Marshal(val.Interface())
+		if err != nil {
+			return err
+		}
+
+		payload = bts
+	} else {
+		bts, err := q.jobbuilder.Encode(j)
+		if err != nil {
+			return err
+		}
+
+		payload = bts
+	}
+
+	pipe.SAdd(ctx, q.namespaced(QueuesKey), name)
+	pipe.RPush(ctx, q.namespaced(queueSegment, name), payload)
+
+	return nil
+}
+
+// failure wraps a job in the entry resque writes to the failed list. Jobs
+// that are already failures are pushed as they are.
+func (q *Queue) failure(j job.Job) ([]byte, error) {
+	bts, err := q.jobbuilder.Encode(j)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := j.GetValue("payload"); err == nil {
+		return bts, nil
+	}
+
+	var payload map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(bts))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	exception, _ := payload["error_class"].(string)
+	message, _ := payload["error_message"].(string)
+	queue, _ := payload["queue"].(string)
+
+	return json.Marshal(map[string]interface{}{
+		"failed_at": q.now().UTC().Format(failedAtFormat),
+		"payload":   payload,
+		"exception": exception,
+		"error":     message,
+		"backtrace": []string{},
+		"worker":    "goqueue",
+		"queue":     queue,
+	})
+}
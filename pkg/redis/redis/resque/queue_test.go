@@ -0,0 +1,154 @@
+package resque
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/internal/queuetest"
+	"testing"
+	"time"
+)
+
+func newTestQueue(t *testing.T, key string) (*Queue, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	q := NewQueue(key, client)
+	q.now = func() time.Time {
+		return time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	}
+
+	return q, server
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	failed := `{"failed_at":"2023/11/14 22:00:00 UTC","payload":{"class":"Mailer","args":[7]},"exception":"Net::ReadTimeout","error":"timeout","backtrace":[],"worker":"host:1:mailer","queue":"mailer"}`
+
+	t.Run("success", func(t *testing.T) {
+		t.Run("keys", func(t *testing.T) {
+			q, _ := newTestQueue(t, "resque:queue:mailer")
+			assert.Equal(t, "resque", q.namespace)
+			assert.Equal(t, "mailer", q.name)
+			assert.Equal(t, "resque:queues", q.namespaced(QueuesKey))
+
+			q, _ = newTestQueue(t, "resque:failed")
+			assert.Equal(t, "resque", q.namespace)
+			assert.Equal(t, "", q.name)
+		})
+		t.Run("enqueue", func(t *testing.T) {
+			q, server := newTestQueue(t, "resque:queue:mailer")
+
+			err := queues.Collect(func(errChan chan error) {
+				q.PushItems(ctx, queuetest.MakeJob(t, `{"class":"Mailer","args":[7]}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			members, err := server.SMembers("resque:queues")
+			require.Nil(t, err)
+			assert.Equal(t, []string{"mailer"}, members)
+
+			list, err := server.List("resque:queue:mailer")
+			require.Nil(t, err)
+			assert.Equal(t, []string{`{"class":"Mailer","args":[7]}`}, list)
+		})
+		t.Run("retry failed job", func(t *testing.T) {
+			q, server := newTestQueue(t, "resque:failed")
+			server.RPush("resque:failed", failed)
+
+			retry, ok := q.Action(RetryAction)
+			require.True(t, ok)
+
+			err := queues.Collect(func(errChan chan error) {
+				retry(ctx, queuetest.MakeJob(t, failed), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			assert.False(t, server.Exists("resque:failed"))
+
+			list, err := server.List("resque:queue:mailer")
+			require.Nil(t, err)
+			assert.Equal(t, []string{`{"args":[7],"class":"Mailer"}`}, list)
+		})
+		t.Run("push to failed list", func(t *testing.T) {
+			q, server := newTestQueue(t, "resque:failed")
+
+			err := queues.Collect(func(errChan chan error) {
+				q.PushItems(ctx, queuetest.MakeJob(t, `{"class":"Mailer","args":[7],"queue":"mailer","error_class":"RuntimeError","error_message":"boom"}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			list, err := server.List("resque:failed")
+			require.Nil(t, err)
+			require.Len(t, list, 1)
+
+			entry := map[string]interface{}{}
+			require.Nil(t, json.Unmarshal([]byte(list[0]), &entry))
+			assert.Equal(t, "2023/11/14 22:13:20 UTC", entry["failed_at"])
+			assert.Equal(t, "RuntimeError", entry["exception"])
+			assert.Equal(t, "boom", entry["error"])
+			assert.Equal(t, "mailer", entry["queue"])
+			assert.Equal(t, "Mailer", entry["payload"].(map[string]interface{})["class"])
+		})
+		t.Run("delete", func(t *testing.T) {
+			q, server := newTestQueue(t, "resque:failed")
+			server.RPush("resque:failed", failed)
+
+			err := queues.Collect(func(errChan chan error) {
+				q.RemoveItems(ctx, queuetest.MakeJob(t, failed), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+			assert.False(t, server.Exists("resque:failed"))
+		})
+	})
+	t.Run("failure", func(t *testing.T) {
+		t.Run("retry from a queue", func(t *testing.T) {
+			q, _ := newTestQueue(t, "resque:queue:mailer")
+
+			err := queues.Collect(func(errChan chan error) {
+				q.Retry(ctx, queuetest.MakeJob(t, failed), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			assert.NotNil(t, err)
+		})
+		t.Run("retry without queue", func(t *testing.T) {
+			q, _ := newTestQueue(t, "resque:failed")
+
+			err := queues.Collect(func(errChan chan error) {
+				q.Retry(ctx, queuetest.MakeJob(t, `{"payload":{"class":"Mailer"}}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			assert.NotNil(t, err)
+		})
+	})
+}
+
+func TestQueue_Page(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		q, server := newTestQueue(t, "resque:queue:mailer")
+		server.RPush("resque:queue:mailer", `{"class":"Mailer","args":[1]}`)
+		server.RPush("resque:queue:mailer", `{"class":"Mailer","args":[2]}`)
+
+		n, err := q.Count(ctx)
+		require.Nil(t, err)
+		assert.Equal(t, int64(2), n)
+
+		jobs, err := q.Page(ctx, 1, 10)
+		require.Nil(t, err)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, `{"class":"Mailer","args":[2]}`, string(jobs[0].Original()))
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		q, server := newTestQueue(t, "resque:queue:mailer")
+		server.Close()
+
+		_, err := q.Page(ctx, 0, 10)
+		assert.NotNil(t, err)
+	})
+}
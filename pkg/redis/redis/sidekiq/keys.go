@@ -0,0 +1,57 @@
+package sidekiq
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Keys sidekiq stores its queues and sorted sets under.
+const (
+	QueuesKey   = "queues"
+	ScheduleKey = "schedule"
+	RetryKey    = "retry"
+	DeadKey     = "dead"
+
+	queuePrefix = "queue:"
+
+	defaultQueue = "default"
+)
+
+// Dead jobs older than deadTimeout or beyond the newest deadMaxJobs are
+// trimmed when a job is killed, the same limits sidekiq uses.
+const (
+	deadTimeout = 180 * 24 * time.Hour
+	deadMaxJobs = 10000
+)
+
+// QueueKey returns the key of the list a sidekiq queue is stored in.
+func QueueKey(name string) string {
+	return queuePrefix + strings.TrimPrefix(name, queuePrefix)
+}
+
+func isSet(name string) bool {
+	switch name {
+	case ScheduleKey, RetryKey, DeadKey:
+		return true
+	}
+
+	return false
+}
+
+// newJid generates a job id the way sidekiq does, 12 random bytes as hex.
+func newJid() (string, error) {
+	bts := make([]byte, 12)
+	if _, err := rand.Read(bts); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bts), nil
+}
+
+// timestamp is a time as the float seconds sidekiq stores in created_at and
+// enqueued_at.
+func timestamp(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
@@ -0,0 +1,414 @@
+package sidekiq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"io"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const count = 100
+
+// Actions a Queue exposes to pipelines.
+const (
+	// RetryNowAction moves a job to the queue named in its queue field so it
+	// runs straight away, the same as retry now in the sidekiq web UI
+	RetryNowAction = "retryNow"
+	// KillAction moves a job to the dead set
+	KillAction = "kill"
+	// DeleteAction removes a job from the queue
+	DeleteAction = "delete"
+)
+
+// Queue is one of sidekiq's queues, read from and pushed to the queue:<name>
+// list, or one of its schedule, retry and dead sorted sets.
+type Queue struct {
+	jobbuilder *job.Builder
+	options    queues.Options
+	name       string
+	client     redis.UniversalClient
+	now        func() time.Time
+	jitter     func(n int) int
+}
+
+// NewQueue returns the sidekiq queue called name. The names schedule, retry
+// and dead are sidekiq's sorted sets, any other name is a queue list.
+func NewQueue(name string, client redis.UniversalClient, opts ...queues.Option) *Queue {
+	options := queues.NewOptions(opts...)
+	jobJuilder := job.NewBuilder(options.JobConfiguration)
+
+	return &Queue{name: strings.TrimPrefix(name, queuePrefix), client: client, jobbuilder: jobJuilder, options: options, now: time.Now, jitter: rand.Intn}
+}
+
+// Key is the redis key the queue is stored under.
+func (q *Queue) Key() string {
+	if isSet(q.name) {
+		return q.name
+	}
+
+	return QueueKey(q.name)
+}
+
+func (q *Queue) GetItems(ctx context.Context, jobChan chan<- job.Job) error {
+	defer func() {
+		close(jobChan)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+			members, err := q.members(ctx)
+			if err != nil {
+				return err
+			}
+
+			logs.Info(ctx, "got items from queue", logs.WithValue("key", q.Key()), logs.WithValue("count", len(members)))
+
+			for idx := range members {
+				j := queues.MakeJob(ctx, q.jobbuilder, q.options, q, []byte(members[idx]))
+				if j == nil {
+					continue
+				}
+
+				jobChan <- j
+			}
+		}
+	}
+}
+
+func (q *Queue) members(ctx context.Context) ([]string, error) {
+	if isSet(q.name) {
+		return q.client.ZRangeArgs(ctx, redis.ZRangeArgs{
+			Key:     q.name,
+			Start:   "-inf",
+			Stop:    "+inf",
+			ByScore: true,
+			Count:   count,
+		}).Result()
+	}
+
+	// sidekiq pushes onto the head of the list and pops from the tail
+	return q.client.LRange(ctx, q.Key(), -count, -1).Result()
+}
+
+// Count returns how many jobs the queue list or set has.
+func (q *Queue) Count(ctx context.Context) (int64, error) {
+	if isSet(q.name) {
+		return q.client.ZCard(ctx, q.name).Result()
+	}
+
+	return q.client.LLen(ctx, q.Key()).Result()
+}
+
+// Page returns count jobs from offset without removing them, the next job
+// sidekiq would run first: a list's tail, a set's lowest score.
+func (q *Queue) Page(ctx context.Context, offset, count int64) ([]job.Job, error) {
+	var members []string
+	var err error
+	if isSet(q.name) {
+		members, err = q.client.ZRange(ctx, q.name, offset, offset+count-1).Result()
+	} else {
+		members, err = q.client.LRange(ctx, q.Key(), -(offset + count), -(offset + 1)).Result()
+		for a, b := 0, len(members)-1; a < b; a, b = a+1, b-1 {
+			members[a], members[b] = members[b], members[a]
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]job.Job, 0, len(members))
+	for idx := range members {
+		j := queues.PageJob(ctx, q.jobbuilder, []byte(members[idx]))
+		if j == nil {
+			continue
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
+
+// PushItems enqueues the job. Jobs pushed to a queue list get sidekiq's jid,
+// created_at and enqueued_at fields and the queue is added to the queues
+// set. Jobs pushed to the schedule set are scored by their at field, jobs
+// pushed to the retry set by when they should be retried, see RetryIn.
+func (q *Queue) PushItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		return q.push(ctx, pipe, q.name, j)
+	})
+	if err != nil {
+		errChan <- err
+	}
+}
+
+func (q *Queue) RemoveItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		q.remove(ctx, pipe, j)
+		return nil
+	})
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	logs.Info(ctx, "removed from sidekiq queue", logs.WithValue("key", q.Key()), logs.WithValue("jid", jid(j)))
+}
+
+// Action returns the pipeline action called name.
+func (q *Queue) Action(name string) (queues.Action, bool) {
+	switch name {
+	case RetryNowAction:
+		return q.RetryNow, true
+	case KillAction:
+		return q.Kill, true
+	case DeleteAction:
+		return q.RemoveItems, true
+	}
+
+	return nil, false
+}
+
+// RetryNow removes the job from this queue and enqueues it on the queue in
+// its queue field. Like sidekiq it takes one off retry_count so the retry
+// doesn't count as a failure.
+func (q *Queue) RetryNow(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	retry := j.Clone()
+	retryCount, err := retry.GetValue("retry_count")
+	if err == nil {
+		if retries, ok := job.ToFloat64(retryCount); ok && retries > 0 {
+			if err := retry.SetValue("retry_count", int64(retries)-1); err != nil {
+				errChan <- err
+				return
+			}
+		}
+	}
+
+	queue := queueOf(retry)
+
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		q.remove(ctx, pipe, j)
+		return q.push(ctx, pipe, queue, retry)
+	})
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	logs.Info(ctx, "retried sidekiq job", logs.WithValue("from", q.Key()), logs.WithValue("queue", queue), logs.WithValue("jid", jid(j)))
+}
+
+// Kill removes the job from this queue and adds it to the dead set, trimming
+// the set the same way sidekiq does.
+func (q *Queue) Kill(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	if q.name == DeadKey {
+		errChan <- errors.New("job is already dead")
+		return
+	}
+
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		q.remove(ctx, pipe, j)
+		return q.push(ctx, pipe, DeadKey, j)
+	})
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	logs.Info(ctx, "killed sidekiq job", logs.WithValue("from", q.Key()), logs.WithValue("jid", jid(j)))
+}
+
+// Client is the client the queue is stored with.
+func (q *Queue) Client() redis.UniversalClient {
+	return q.client
+}
+
+// TxKeys returns the keys pushing jobs to the queue and removing them
+// writes.
+func (q *Queue) TxKeys() []string {
+	if isSet(q.name) {
+		return []string{q.name}
+	}
+
+	return []string{q.Key(), QueuesKey}
+}
+
+// Contains reports whether j is in the queue.
+func (q *Queue) Contains(ctx context.Context, tx *redis.Tx, j job.Job) (bool, error) {
+	var err error
+	if isSet(q.name) {
+		err = tx.ZScore(ctx, q.name, string(j.Original())).Err()
+	} else {
+		err = tx.LPos(ctx, q.Key(), string(j.Original()), redis.LPosArgs{}).Err()
+	}
+
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// TxPush queues pushing j the way PushItems does on pipe.
+func (q *Queue) TxPush(ctx context.Context, pipe redis.Pipeliner, j job.Job) error {
+	return q.push(ctx, pipe, q.name, j)
+}
+
+// TxRemove queues removing j on pipe.
+func (q *Queue) TxRemove(ctx context.Context, pipe redis.Pipeliner, j job.Job) {
+	q.remove(ctx, pipe, j)
+}
+
+func (q *Queue) remove(ctx context.Context, pipe redis.Pipeliner, j job.Job) {
+	if isSet(q.name) {
+		pipe.ZRem(ctx, q.name, j.Original())
+		return
+	}
+
+	pipe.LRem(ctx, q.Key(), 1, j.Original())
+}
+
+// push adds j to the list or set called name in the pipeline.
+func (q *Queue) push(ctx context.Context, pipe redis.Pipeliner, name string, j job.Job) error {
+	now := q.now()
+
+	switch name {
+	case DeadKey:
+		member, err := q.jobbuilder.Encode(j)
+		if err != nil {
+			return err
+		}
+
+		score := timestamp(now)
+		pipe.ZAdd(ctx, DeadKey, &redis.Z{Member: member, Score: score})
+		pipe.ZRemRangeByScore(ctx, DeadKey, "-inf", strconv.FormatFloat(score-deadTimeout.Seconds(), 'f', -1, 64))
+		pipe.ZRemRangeByRank(ctx, DeadKey, 0, -deadMaxJobs-1)
+
+		return nil
+	case RetryKey:
+		member, err := q.jobbuilder.Encode(j)
+		if err != nil {
+			return err
+		}
+
+		pipe.ZAdd(ctx, RetryKey, &redis.Z{Member: member, Score: timestamp(now.Add(retryIn(j, q.jitter)))})
+
+		return nil
+	}
+
+	enqueued := j.Clone()
+	if err := prepare(enqueued, now); err != nil {
+		return err
+	}
+
+	if name == ScheduleKey {
+		score := timestamp(now)
+		if at, err := enqueued.GetValue("at"); err == nil {
+			if f, ok := job.ToFloat64(at); ok {
+				score = f
+			}
+		}
+
+		// sidekiq keeps the time in the score, not the payload
+		if err := enqueued.Delete("at"); err != nil {
+			return err
+		}
+
+		member, err := q.jobbuilder.Encode(enqueued)
+		if err != nil {
+			return err
+		}
+
+		pipe.ZAdd(ctx, ScheduleKey, &redis.Z{Member: member, Score: score})
+
+		return nil
+	}
+
+	if err := enqueued.SetValue("queue", name); err != nil {
+		return err
+	}
+
+	if err := enqueued.SetValue("enqueued_at", timestamp(now)); err != nil {
+		return err
+	}
+
+	member, err := q.jobbuilder.Encode(enqueued)
+	if err != nil {
+		return err
+	}
+
+	pipe.SAdd(ctx, QueuesKey, name)
+	pipe.LPush(ctx, QueueKey(name), member)
+
+	return nil
+}
+
+// prepare fills in the jid and created_at of a job that doesn't have them.
+func prepare(j job.Data, now time.Time) error {
+	if _, err := j.GetValue("jid"); errors.Is(err, job.ErrFieldNotFound) {
+		id, err := newJid()
+		if err != nil {
+			return fmt.Errorf("could not generate jid: %w", err)
+		}
+
+		if err := j.SetValue("jid", id); err != nil {
+			return err
+		}
+	}
+
+	if _, err := j.GetValue("created_at"); errors.Is(err, job.ErrFieldNotFound) {
+		if err := j.SetValue("created_at", timestamp(now)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queueOf is the queue a job runs on, from its queue field.
+func queueOf(j job.Data) string {
+	val, err := j.GetValue("queue")
+	if err != nil || val.Kind() != reflect.String || val.String() == "" {
+		return defaultQueue
+	}
+
+	return val.String()
+}
+
+func jid(j job.Job) string {
+	val, err := j.GetValue("jid")
+	if err != nil || val.Kind() != reflect.String {
+		return ""
+	}
+
+	return val.String()
+}
@@ -0,0 +1,236 @@
+package sidekiq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/internal/queuetest"
+	"testing"
+	"time"
+)
+
+func newTestQueue(t *testing.T, name string) (*Queue, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	q := NewQueue(name, client)
+	q.now = func() time.Time {
+		return time.Unix(1700000000, 0)
+	}
+
+	return q, server
+}
+
+func decode(t *testing.T, member string) map[string]interface{} {
+	decoded := map[string]interface{}{}
+	require.Nil(t, json.Unmarshal([]byte(member), &decoded))
+
+	return decoded
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	retryJob := `{"class":"WebhookWorker","args":[1],"queue":"webhooks","jid":"abc","retry":true,"retry_count":3,"error_message":"boom"}`
+
+	t.Run("success", func(t *testing.T) {
+		t.Run("enqueue", func(t *testing.T) {
+			q, server := newTestQueue(t, "queue:default")
+			assert.Equal(t, "queue:default", q.Key())
+
+			err := queues.Collect(func(errChan chan error) {
+				q.PushItems(ctx, queuetest.MakeJob(t, `{"class":"WebhookWorker","args":[]}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			members, err := server.SMembers(QueuesKey)
+			require.Nil(t, err)
+			assert.Equal(t, []string{"default"}, members)
+
+			list, err := server.List("queue:default")
+			require.Nil(t, err)
+			require.Len(t, list, 1)
+
+			pushed := decode(t, list[0])
+			assert.Equal(t, "default", pushed["queue"])
+			assert.Equal(t, float64(1700000000), pushed["enqueued_at"])
+			assert.Equal(t, float64(1700000000), pushed["created_at"])
+			assert.Len(t, pushed["jid"], 24)
+		})
+		t.Run("schedule", func(t *testing.T) {
+			q, server := newTestQueue(t, ScheduleKey)
+
+			err := queues.Collect(func(errChan chan error) {
+				q.PushItems(ctx, queuetest.MakeJob(t, `{"class":"WebhookWorker","args":[],"jid":"abc","at":1700000100.5}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			members, err := server.ZMembers(ScheduleKey)
+			require.Nil(t, err)
+			require.Len(t, members, 1)
+			assert.NotContains(t, decode(t, members[0]), "at")
+
+			score, err := server.ZScore(ScheduleKey, members[0])
+			require.Nil(t, err)
+			assert.Equal(t, 1700000100.5, score)
+		})
+		t.Run("retry now", func(t *testing.T) {
+			q, server := newTestQueue(t, RetryKey)
+			_, err := server.ZAdd(RetryKey, 1700000500, retryJob)
+			require.Nil(t, err)
+
+			err = queues.Collect(func(errChan chan error) {
+				q.RetryNow(ctx, queuetest.MakeJob(t, retryJob), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			assert.False(t, server.Exists(RetryKey))
+
+			list, err := server.List("queue:webhooks")
+			require.Nil(t, err)
+			require.Len(t, list, 1)
+
+			retried := decode(t, list[0])
+			assert.Equal(t, "abc", retried["jid"])
+			assert.Equal(t, float64(2), retried["retry_count"])
+			assert.Equal(t, float64(1700000000), retried["enqueued_at"])
+		})
+		t.Run("kill", func(t *testing.T) {
+			q, server := newTestQueue(t, RetryKey)
+			_, err := server.ZAdd(RetryKey, 1700000500, retryJob)
+			require.Nil(t, err)
+			// older than sidekiq keeps dead jobs
+			_, err = server.ZAdd(DeadKey, 1600000000, `{"jid":"old"}`)
+			require.Nil(t, err)
+
+			kill, ok := q.Action(KillAction)
+			require.True(t, ok)
+
+			err = queues.Collect(func(errChan chan error) {
+				kill(ctx, queuetest.MakeJob(t, retryJob), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			assert.False(t, server.Exists(RetryKey))
+
+			members, err := server.ZMembers(DeadKey)
+			require.Nil(t, err)
+			assert.Equal(t, []string{retryJob}, members)
+		})
+		t.Run("delete", func(t *testing.T) {
+			q, server := newTestQueue(t, "default")
+			server.Lpush("queue:default", retryJob)
+
+			del, ok := q.Action(DeleteAction)
+			require.True(t, ok)
+
+			err := queues.Collect(func(errChan chan error) {
+				del(ctx, queuetest.MakeJob(t, retryJob), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+			assert.False(t, server.Exists("queue:default"))
+		})
+		t.Run("get items", func(t *testing.T) {
+			q, server := newTestQueue(t, RetryKey)
+			_, err := server.ZAdd(RetryKey, 1700000500, retryJob)
+			require.Nil(t, err)
+
+			ctx, cancel := context.WithCancel(ctx)
+			jobChan := make(chan job.Job)
+			go func() {
+				_ = q.GetItems(ctx, jobChan)
+			}()
+
+			j := <-jobChan
+			cancel()
+			for range jobChan {
+			}
+
+			assert.Equal(t, retryJob, string(j.Original()))
+		})
+	})
+	t.Run("failure", func(t *testing.T) {
+		t.Run("unknown action", func(t *testing.T) {
+			q, _ := newTestQueue(t, RetryKey)
+			_, ok := q.Action("resurrect")
+			assert.False(t, ok)
+		})
+		t.Run("kill dead job", func(t *testing.T) {
+			q, _ := newTestQueue(t, DeadKey)
+
+			err := queues.Collect(func(errChan chan error) {
+				q.Kill(ctx, queuetest.MakeJob(t, retryJob), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			assert.NotNil(t, err)
+		})
+	})
+}
+
+func TestQueue_Page(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		t.Run("list", func(t *testing.T) {
+			q, server := newTestQueue(t, "queue:default")
+			for _, jid := range []string{"1", "2", "3"} {
+				server.Lpush("queue:default", `{"jid":"`+jid+`"}`)
+			}
+			server.Lpush("queue:default", "not json")
+
+			n, err := q.Count(ctx)
+			require.Nil(t, err)
+			assert.Equal(t, int64(4), n)
+
+			jobs, err := q.Page(ctx, 0, 2)
+			require.Nil(t, err)
+			require.Len(t, jobs, 2)
+			assert.Equal(t, `{"jid":"1"}`, string(jobs[0].Original()))
+			assert.Equal(t, `{"jid":"2"}`, string(jobs[1].Original()))
+
+			jobs, err = q.Page(ctx, 2, 2)
+			require.Nil(t, err)
+			require.Len(t, jobs, 1)
+			assert.Equal(t, `{"jid":"3"}`, string(jobs[0].Original()))
+
+			jobs, err = q.Page(ctx, 4, 2)
+			require.Nil(t, err)
+			assert.Empty(t, jobs)
+
+			list, err := server.List("queue:default")
+			require.Nil(t, err)
+			assert.Len(t, list, 4)
+		})
+		t.Run("set", func(t *testing.T) {
+			q, server := newTestQueue(t, RetryKey)
+			_, err := server.ZAdd(RetryKey, 2, `{"jid":"2"}`)
+			require.Nil(t, err)
+			_, err = server.ZAdd(RetryKey, 1, `{"jid":"1"}`)
+			require.Nil(t, err)
+
+			n, err := q.Count(ctx)
+			require.Nil(t, err)
+			assert.Equal(t, int64(2), n)
+
+			jobs, err := q.Page(ctx, 1, 10)
+			require.Nil(t, err)
+			require.Len(t, jobs, 1)
+			assert.Equal(t, `{"jid":"2"}`, string(jobs[0].Original()))
+		})
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		q, server := newTestQueue(t, "queue:default")
+		server.Close()
+
+		_, err := q.Count(ctx)
+		assert.NotNil(t, err)
+
+		_, err = q.Page(ctx, 0, 10)
+		assert.NotNil(t, err)
+	})
+}
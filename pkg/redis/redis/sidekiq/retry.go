@@ -0,0 +1,190 @@
+package sidekiq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/pkg/redis/redis/hashtag"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+const defaultPollInterval = 5 * time.Second
+
+// RetryIn returns how long sidekiq waits before retrying j. A retry_in field
+// in seconds overrides sidekiq's backoff of retry_count^4 + 15 seconds plus
+// up to 10 * (retry_count + 1) seconds of jitter.
+func RetryIn(j job.Data) time.Duration {
+	return retryIn(j, rand.Intn)
+}
+
+func retryIn(j job.Data, jitter func(n int) int) time.Duration {
+	if val, err := j.GetValue("retry_in"); err == nil {
+		if seconds, ok := job.ToFloat64(val); ok && seconds >= 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+	}
+
+	retryCount := 0
+	if val, err := j.GetValue("retry_count"); err == nil {
+		if count, ok := job.ToFloat64(val); ok && count > 0 {
+			retryCount = int(count)
+		}
+	}
+
+	seconds := math.Pow(float64(retryCount), 4) + 15 + float64(jitter(10)*(retryCount+1))
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Promote enqueues the jobs in the schedule or retry set that are due on the
+// queue named in their queue field and returns how many were enqueued. Jobs
+// are moved in a transaction watching the set, so a job promoted by another
+// scheduler at the same time is skipped rather than enqueued twice. In a
+// redis cluster, where the keys usually aren't in the same slot, the job is
+// only enqueued by the scheduler that removed it from the set.
+func (q *Queue) Promote(ctx context.Context) (int, error) {
+	if q.name != ScheduleKey && q.name != RetryKey {
+		return 0, fmt.Errorf("can only promote jobs from the %s and %s sets, not %s", ScheduleKey, RetryKey, q.Key())
+	}
+
+	members, err := q.client.ZRangeArgs(ctx, redis.ZRangeArgs{
+		Key:     q.name,
+		Start:   "-inf",
+		Stop:    strconv.FormatFloat(timestamp(q.now()), 'f', -1, 64),
+		ByScore: true,
+		Count:   count,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	promoted := 0
+	for idx := range members {
+		member := members[idx]
+
+		j := queues.MakeJob(ctx, q.jobbuilder, q.options, q, []byte(member))
+		if j == nil {
+			continue
+		}
+
+		queue := queueOf(j)
+		if !q.watchable(queue) {
+			ok, err := q.claim(ctx, member, queue, j)
+			if err != nil {
+				return promoted, err
+			}
+
+			if ok {
+				promoted++
+			}
+
+			continue
+		}
+
+		err := q.client.Watch(ctx, func(tx *redis.Tx) error {
+			// another scheduler has already promoted it
+			if err := tx.ZScore(ctx, q.name, member).Err(); err != nil {
+				return err
+			}
+
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.ZRem(ctx, q.name, member)
+				return q.push(ctx, pipe, queue, j)
+			})
+
+			return err
+		}, q.name)
+
+		if errors.Is(err, redis.Nil) || errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+
+		if err != nil {
+			return promoted, err
+		}
+
+		promoted++
+	}
+
+	return promoted, nil
+}
+
+// watchable reports whether the set and the keys promoting a job to queue
+// writes can be used in one transaction, which in a redis cluster needs them
+// all in the same slot.
+func (q *Queue) watchable(queue string) bool {
+	if _, ok := q.client.(*redis.ClusterClient); !ok {
+		return true
+	}
+
+	return hashtag.SameSlot(q.name, QueuesKey, QueueKey(queue))
+}
+
+// claim promotes a job the way sidekiq does without a transaction: the
+// scheduler that removes it from the set enqueues it.
+func (q *Queue) claim(ctx context.Context, member, queue string, j job.Job) (bool, error) {
+	removed, err := q.client.ZRem(ctx, q.name, member).Result()
+	if err != nil || removed == 0 {
+		return false, err
+	}
+
+	_, err = q.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		return q.push(ctx, pipe, queue, j)
+	})
+
+	return err == nil, err
+}
+
+// Scheduler polls sidekiq's schedule and retry sets and enqueues due jobs,
+// like sidekiq's scheduled job poller.
+type Scheduler struct {
+	sets     []*Queue
+	interval time.Duration
+}
+
+// NewScheduler returns a scheduler promoting jobs from sets every interval,
+// or every 5 seconds when interval is zero.
+func NewScheduler(interval time.Duration, sets ...*Queue) *Scheduler {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	return &Scheduler{sets: sets, interval: interval}
+}
+
+// Start polls the sets until ctx is done.
+func (s *Scheduler) Start(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		for _, set := range s.sets {
+			promoted, err := set.Promote(ctx)
+			if err != nil && ctx.Err() != nil {
+				return nil
+			}
+
+			if err != nil {
+				logs.Error(ctx, "could not promote scheduled jobs", logs.WithError(err), logs.WithValue("key", set.Key()))
+				continue
+			}
+
+			if promoted > 0 {
+				logs.Info(ctx, "promoted scheduled jobs", logs.WithValue("key", set.Key()), logs.WithValue("count", promoted))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
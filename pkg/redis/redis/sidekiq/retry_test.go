@@ -0,0 +1,122 @@
+package sidekiq
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/internal/queuetest"
+	"testing"
+	"time"
+)
+
+func TestRetryIn(t *testing.T) {
+	jitter := func(n int) int {
+		return n - 1
+	}
+
+	for payload, expected := range map[string]time.Duration{
+		`{"class":"WebhookWorker"}`:                  (15 + 9) * time.Second,
+		`{"retry_count":0}`:                          (15 + 9) * time.Second,
+		`{"retry_count":3}`:                          (81 + 15 + 9*4) * time.Second,
+		`{"retry_count":10,"retry":5}`:               (10000 + 15 + 9*11) * time.Second,
+		`{"retry_count":3,"retry_in":30}`:            30 * time.Second,
+		`{"retry_count":3,"retry_in":1.5}`:           1500 * time.Millisecond,
+		`{"retry_count":3,"retry_in":"soon"}`:        (81 + 15 + 9*4) * time.Second,
+		`{"retry_count":"three","retry_in":"later"}`: (15 + 9) * time.Second,
+	} {
+		assert.Equal(t, expected, retryIn(queuetest.MakeJob(t, payload), jitter), payload)
+	}
+
+	t.Run("jitter is bounded", func(t *testing.T) {
+		j := queuetest.MakeJob(t, `{"retry_count":2}`)
+		for i := 0; i < 100; i++ {
+			delay := RetryIn(j)
+			assert.GreaterOrEqual(t, delay, 31*time.Second)
+			assert.Less(t, delay, (31+30)*time.Second)
+		}
+	})
+}
+
+func TestQueue_Promote(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		t.Run("due jobs are enqueued", func(t *testing.T) {
+			q, server := newTestQueue(t, RetryKey)
+			due := `{"class":"WebhookWorker","queue":"webhooks","jid":"due","retry_count":1}`
+			later := `{"class":"WebhookWorker","queue":"webhooks","jid":"later","retry_count":1}`
+			noQueue := `{"class":"WebhookWorker","jid":"default"}`
+			_, err := server.ZAdd(RetryKey, 1699999999, due)
+			require.Nil(t, err)
+			_, err = server.ZAdd(RetryKey, 1700000000, noQueue)
+			require.Nil(t, err)
+			_, err = server.ZAdd(RetryKey, 1700000001, later)
+			require.Nil(t, err)
+
+			promoted, err := q.Promote(ctx)
+			require.Nil(t, err)
+			assert.Equal(t, 2, promoted)
+
+			members, err := server.ZMembers(RetryKey)
+			require.Nil(t, err)
+			assert.Equal(t, []string{later}, members)
+
+			list, err := server.List("queue:webhooks")
+			require.Nil(t, err)
+			require.Len(t, list, 1)
+			assert.Equal(t, "due", decode(t, list[0])["jid"])
+
+			list, err = server.List("queue:default")
+			require.Nil(t, err)
+			require.Len(t, list, 1)
+
+			queues, err := server.SMembers(QueuesKey)
+			require.Nil(t, err)
+			assert.Equal(t, []string{"default", "webhooks"}, queues)
+		})
+		t.Run("push scores by retry delay", func(t *testing.T) {
+			q, server := newTestQueue(t, RetryKey)
+			q.jitter = func(n int) int {
+				return 0
+			}
+
+			payload := `{"class":"WebhookWorker","jid":"abc","retry_count":2}`
+			err := queues.Collect(func(errChan chan error) {
+				q.PushItems(ctx, queuetest.MakeJob(t, payload), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			score, err := server.ZScore(RetryKey, payload)
+			require.Nil(t, err)
+			assert.Equal(t, float64(1700000000+16+15), score)
+		})
+		t.Run("scheduler promotes until stopped", func(t *testing.T) {
+			q, server := newTestQueue(t, ScheduleKey)
+			_, err := server.ZAdd(ScheduleKey, 1699999999, `{"class":"WebhookWorker","jid":"abc"}`)
+			require.Nil(t, err)
+
+			ctx, cancel := context.WithCancel(ctx)
+			done := make(chan error)
+			go func() {
+				done <- NewScheduler(time.Millisecond, q).Start(ctx)
+			}()
+
+			assert.Eventually(t, func() bool {
+				return server.Exists("queue:default")
+			}, time.Second, time.Millisecond)
+
+			cancel()
+			assert.Nil(t, <-done)
+			assert.False(t, server.Exists(ScheduleKey))
+		})
+	})
+	t.Run("failure", func(t *testing.T) {
+		t.Run("not a schedule set", func(t *testing.T) {
+			q, _ := newTestQueue(t, DeadKey)
+			_, err := q.Promote(ctx)
+			assert.NotNil(t, err)
+		})
+	})
+}
@@ -0,0 +1,436 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const count = 100
+
+const (
+	defaultGroup = "goqueue"
+	defaultField = "job"
+	// block is how long a read waits for new entries before checking if the
+	// queue has been stopped
+	block = time.Second
+)
+
+// Configuration is how a Queue reads from and writes to its stream.
+type Configuration struct {
+	// Group is the consumer group entries are read under, goqueue by
+	// default. It is created at the start of the stream if it doesn't exist.
+	Group string
+	// Consumer is the name the queue reads as within the group, the host's
+	// name by default.
+	Consumer string
+	// Field is the entry field holding the encoded job, job by default.
+	// Entries without it are read as a json object of their fields.
+	Field string
+	// ClaimIdle is how long an entry delivered to another consumer can go
+	// unacknowledged before it is claimed, zero to never claim entries.
+	ClaimIdle time.Duration
+	// MaxLen trims the stream to about this many entries when pushing, zero
+	// to never trim.
+	MaxLen int64
+}
+
+// Queue is a redis stream read through a consumer group. Entries stay
+// pending until the pipeline that read them acks them. Entries of jobs that
+// failed, and those left pending when the queue last stopped, are read again
+// by the same consumer. Entries of consumers that stopped without acking are
+// claimed after the configured idle time.
+type Queue struct {
+	jobbuilder    *job.Builder
+	options       queues.Options
+	key           string
+	configuration Configuration
+	client        redis.UniversalClient
+
+	mu sync.Mutex
+	// ids are the entry ids of the jobs handed out and not yet acked
+	ids map[job.Job]string
+	// reread is set when the consumer's pending entries that aren't handed
+	// out should be read again
+	reread bool
+}
+
+// NewQueue returns the stream stored at key.
+func NewQueue(key string, client redis.UniversalClient, configuration *Configuration, opts ...queues.Option) *Queue {
+	options := queues.NewOptions(opts...)
+	jobJuilder := job.NewBuilder(options.JobConfiguration)
+
+	q := &Queue{key: key, client: client, jobbuilder: jobJuilder, options: options, ids: map[job.Job]string{}, reread: true}
+	if configuration != nil {
+		q.configuration = *configuration
+	}
+
+	if q.configuration.Group == "" {
+		q.configuration.Group = defaultGroup
+	}
+
+	if q.configuration.Consumer == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = defaultGroup
+		}
+
+		q.configuration.Consumer = hostname
+	}
+
+	if q.configuration.Field == "" {
+		q.configuration.Field = defaultField
+	}
+
+	return q
+}
+
+// Key is the redis key of the stream.
+func (q *Queue) Key() string {
+	return q.key
+}
+
+func (q *Queue) GetItems(ctx context.Context, jobChan chan<- job.Job) error {
+	defer func() {
+		close(jobChan)
+	}()
+
+	if err := q.createGroup(ctx); err != nil {
+		return err
+	}
+
+	// start is where the next claim of idle entries carries on from
+	start := "0-0"
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+			messages, next, err := q.claim(ctx, start)
+			if err != nil {
+				return err
+			}
+
+			start = next
+
+			retried, err := q.retry(ctx)
+			if err != nil {
+				return err
+			}
+
+			messages = append(messages, retried...)
+
+			read, err := q.read(ctx, ">")
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+
+				return err
+			}
+
+			messages = append(messages, read...)
+			if len(messages) == 0 {
+				continue
+			}
+
+			logs.Info(ctx, "got items from stream", logs.WithValue("key", q.key), logs.WithValue("count", len(messages)))
+
+			for idx := range messages {
+				j := queues.MakeJob(ctx, q.jobbuilder, q.options, &entry{queue: q, id: messages[idx].ID}, q.payload(messages[idx]))
+				if j == nil {
+					continue
+				}
+
+				q.mu.Lock()
+				q.ids[j] = messages[idx].ID
+				q.mu.Unlock()
+
+				jobChan <- j
+			}
+		}
+	}
+}
+
+// createGroup creates the consumer group at the start of the stream,
+// creating the stream if need be.
+func (q *Queue) createGroup(ctx context.Context) error {
+	err := q.client.XGroupCreateMkStream(ctx, q.key, q.configuration.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	return nil
+}
+
+// claim takes over entries other consumers have left pending for longer than
+// ClaimIdle, returning them and the id to carry on claiming from.
+func (q *Queue) claim(ctx context.Context, start string) ([]redis.XMessage, string, error) {
+	if q.configuration.ClaimIdle <= 0 {
+		return nil, start, nil
+	}
+
+	// go-redis can't read the reply redis 7 sends, which adds the ids of
+	// deleted entries
+	reply, err := q.client.Do(ctx, "XAUTOCLAIM", q.key, q.configuration.Group, q.configuration.Consumer,
+		q.configuration.ClaimIdle.Milliseconds(), start, "COUNT", count).Slice()
+	if err != nil {
+		return nil, start, err
+	}
+
+	next, messages, err := parseClaim(reply)
+	if err != nil {
+		return nil, start, err
+	}
+
+	if len(messages) > 0 {
+		logs.Info(ctx, "claimed idle stream entries", logs.WithValue("key", q.key), logs.WithValue("count", len(messages)))
+	}
+
+	return messages, next, nil
+}
+
+// parseClaim reads the next id and claimed entries of an XAUTOCLAIM reply.
+func parseClaim(reply []interface{}) (string, []redis.XMessage, error) {
+	if len(reply) < 2 {
+		return "", nil, fmt.Errorf("unexpected XAUTOCLAIM reply of %d elements", len(reply))
+	}
+
+	next, ok := reply[0].(string)
+	entries, ok2 := reply[1].([]interface{})
+	if !ok || !ok2 {
+		return "", nil, errors.New("unexpected XAUTOCLAIM reply")
+	}
+
+	messages := make([]redis.XMessage, 0, len(entries))
+	for idx := range entries {
+		// entries deleted while pending are nil before redis 7
+		entry, ok := entries[idx].([]interface{})
+		if !ok || len(entry) != 2 {
+			continue
+		}
+
+		id, _ := entry[0].(string)
+		fields, _ := entry[1].([]interface{})
+		values := make(map[string]interface{}, len(fields)/2)
+		for field := 0; field+1 < len(fields); field += 2 {
+			key, _ := fields[field].(string)
+			values[key] = fields[field+1]
+		}
+
+		messages = append(messages, redis.XMessage{ID: id, Values: values})
+	}
+
+	return next, messages, nil
+}
+
+// retry reads the entries pending on this consumer again, once they have
+// failed or were left pending when the queue last stopped. Entries of jobs
+// still being processed are skipped.
+func (q *Queue) retry(ctx context.Context) ([]redis.XMessage, error) {
+	q.mu.Lock()
+	reread := q.reread
+	q.reread = false
+	handedOut := make(map[string]bool, len(q.ids))
+	for _, id := range q.ids {
+		handedOut[id] = true
+	}
+	q.mu.Unlock()
+
+	if !reread {
+		return nil, nil
+	}
+
+	messages := make([]redis.XMessage, 0)
+	for start := "0"; ; {
+		pending, err := q.read(ctx, start)
+		if err != nil {
+			q.mu.Lock()
+			q.reread = true
+			q.mu.Unlock()
+
+			return nil, err
+		}
+
+		for idx := range pending {
+			if !handedOut[pending[idx].ID] {
+				messages = append(messages, pending[idx])
+			}
+		}
+
+		if len(pending) < count {
+			break
+		}
+
+		start = pending[len(pending)-1].ID
+	}
+
+	if len(messages) > 0 {
+		logs.Info(ctx, "reading pending stream entries again", logs.WithValue("key", q.key), logs.WithValue("count", len(messages)))
+	}
+
+	return messages, nil
+}
+
+// read returns the consumer's pending entries after start, or with start >
+// entries never delivered to the group, waiting a short while for new ones.
+func (q *Queue) read(ctx context.Context, start string) ([]redis.XMessage, error) {
+	// only reads of new entries block, pending entries are read at once
+	wait := time.Duration(-1)
+	if start == ">" {
+		wait = block
+	}
+
+	streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    q.configuration.Group,
+		Consumer: q.configuration.Consumer,
+		Streams:  []string{q.key, start},
+		Count:    count,
+		Block:    wait,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]redis.XMessage, 0)
+	for idx := range streams {
+		messages = append(messages, streams[idx].Messages...)
+	}
+
+	return messages, nil
+}
+
+// payload is the encoded job in the entry's field, or the entry's fields as
+// a json object.
+func (q *Queue) payload(message redis.XMessage) []byte {
+	if value, ok := message.Values[q.configuration.Field]; ok {
+		if s, ok := value.(string); ok {
+			return []byte(s)
+		}
+	}
+
+	bts, err := json.Marshal(message.Values)
+	if err != nil {
+		return nil
+	}
+
+	return bts
+}
+
+// PushItems adds the job to the end of the stream, trimming it when MaxLen
+// is set.
+func (q *Queue) PushItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	bts, err := q.jobbuilder.Encode(j)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	err = q.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: q.key,
+		MaxLen: q.configuration.MaxLen,
+		Approx: q.configuration.MaxLen > 0,
+		Values: map[string]interface{}{q.configuration.Field: bts},
+	}).Err()
+	if err != nil {
+		errChan <- err
+	}
+}
+
+// RemoveItems acks the job's entry and deletes it from the stream.
+func (q *Queue) RemoveItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	id, ok := q.take(j)
+	if !ok {
+		errChan <- errors.New("job was not read from this stream")
+		return
+	}
+
+	if err := q.remove(ctx, id); err != nil {
+		errChan <- err
+	}
+}
+
+func (q *Queue) remove(ctx context.Context, id string) error {
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, q.key, q.configuration.Group, id)
+		pipe.XDel(ctx, q.key, id)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logs.Info(ctx, "removed from stream", logs.WithValue("key", q.key), logs.WithValue("id", id))
+
+	return nil
+}
+
+// Ack acknowledges the job's entry once the pipeline processed it. Entries
+// of jobs that failed stay pending and are read again.
+func (q *Queue) Ack(ctx context.Context, j job.Job, success bool) error {
+	id, ok := q.take(j)
+	if !ok {
+		return nil
+	}
+
+	if !success {
+		q.mu.Lock()
+		q.reread = true
+		q.mu.Unlock()
+
+		return nil
+	}
+
+	return q.client.XAck(ctx, q.key, q.configuration.Group, id).Err()
+}
+
+// take returns and forgets the entry id of a job read from the stream.
+func (q *Queue) take(j job.Job) (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id, ok := q.ids[j]
+	delete(q.ids, j)
+
+	return id, ok
+}
+
+// entry removes a single entry, the source of jobs quarantined before they
+// were handed out.
+type entry struct {
+	queue *Queue
+	id    string
+}
+
+func (e *entry) RemoveItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	if err := e.queue.remove(ctx, e.id); err != nil {
+		errChan <- err
+	}
+}
@@ -0,0 +1,182 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/internal/queuetest"
+	"testing"
+	"time"
+)
+
+func newTestQueue(t *testing.T, configuration *Configuration) (*Queue, *redis.Client) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	return NewQueue("events", client, configuration), client
+}
+
+// next reads a single job from q.
+func next(t *testing.T, q *Queue) job.Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	jobChan := make(chan job.Job)
+	go func() {
+		_ = q.GetItems(ctx, jobChan)
+	}()
+
+	j := <-jobChan
+	cancel()
+	for range jobChan {
+	}
+
+	return j
+}
+
+func pending(t *testing.T, client *redis.Client, group string) int64 {
+	summary, err := client.XPending(context.Background(), "events", group).Result()
+	require.Nil(t, err)
+
+	return summary.Count
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		t.Run("defaults", func(t *testing.T) {
+			q, _ := newTestQueue(t, nil)
+			assert.Equal(t, "events", q.Key())
+			assert.Equal(t, defaultGroup, q.configuration.Group)
+			assert.Equal(t, defaultField, q.configuration.Field)
+			assert.NotEmpty(t, q.configuration.Consumer)
+		})
+		t.Run("push and read", func(t *testing.T) {
+			q, client := newTestQueue(t, &Configuration{Group: "workers", Consumer: "one"})
+
+			err := queues.Collect(func(errChan chan error) {
+				q.PushItems(ctx, queuetest.MakeJob(t, `{"class":"WebhookWorker"}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			j := next(t, q)
+			assert.Equal(t, `{"class":"WebhookWorker"}`, string(j.Original()))
+			assert.Equal(t, int64(1), pending(t, client, "workers"))
+
+			require.Nil(t, q.Ack(ctx, j, true))
+			assert.Equal(t, int64(0), pending(t, client, "workers"))
+
+			length, err := client.XLen(ctx, "events").Result()
+			require.Nil(t, err)
+			assert.Equal(t, int64(1), length)
+		})
+		t.Run("entry fields", func(t *testing.T) {
+			q, client := newTestQueue(t, nil)
+			require.Nil(t, client.XAdd(ctx, &redis.XAddArgs{Stream: "events", Values: map[string]interface{}{"class": "WebhookWorker"}}).Err())
+
+			j := next(t, q)
+			class, err := j.GetValue("class")
+			require.Nil(t, err)
+			assert.Equal(t, "WebhookWorker", class.String())
+		})
+		t.Run("failed job is read again", func(t *testing.T) {
+			q, client := newTestQueue(t, &Configuration{Consumer: "one"})
+			require.Nil(t, client.XAdd(ctx, &redis.XAddArgs{Stream: "events", Values: map[string]interface{}{"job": `{"class":"WebhookWorker"}`}}).Err())
+
+			j := next(t, q)
+			require.Nil(t, q.Ack(ctx, j, false))
+
+			retried := next(t, q)
+			assert.Equal(t, `{"class":"WebhookWorker"}`, string(retried.Original()))
+			require.Nil(t, q.Ack(ctx, retried, true))
+			assert.Equal(t, int64(0), pending(t, client, defaultGroup))
+		})
+		t.Run("pending job is read again after a restart", func(t *testing.T) {
+			q, client := newTestQueue(t, &Configuration{Consumer: "one"})
+			require.Nil(t, client.XAdd(ctx, &redis.XAddArgs{Stream: "events", Values: map[string]interface{}{"job": `{"class":"WebhookWorker"}`}}).Err())
+
+			// stopped before the job was acked
+			next(t, q)
+
+			j := next(t, NewQueue("events", client, &Configuration{Consumer: "one"}))
+			assert.Equal(t, `{"class":"WebhookWorker"}`, string(j.Original()))
+		})
+		t.Run("failed job is claimed", func(t *testing.T) {
+			q, client := newTestQueue(t, &Configuration{Consumer: "one"})
+			require.Nil(t, client.XAdd(ctx, &redis.XAddArgs{Stream: "events", Values: map[string]interface{}{"job": `{"class":"WebhookWorker"}`}}).Err())
+
+			j := next(t, q)
+			require.Nil(t, q.Ack(ctx, j, false))
+			assert.Equal(t, int64(1), pending(t, client, defaultGroup))
+
+			other := NewQueue("events", client, &Configuration{Consumer: "two", ClaimIdle: time.Millisecond})
+			time.Sleep(5 * time.Millisecond)
+
+			claimed := next(t, other)
+			assert.Equal(t, `{"class":"WebhookWorker"}`, string(claimed.Original()))
+
+			entries, err := client.XPendingExt(ctx, &redis.XPendingExtArgs{Stream: "events", Group: defaultGroup, Start: "-", End: "+", Count: 10}).Result()
+			require.Nil(t, err)
+			require.Len(t, entries, 1)
+			assert.Equal(t, "two", entries[0].Consumer)
+		})
+		t.Run("parse claim", func(t *testing.T) {
+			next, messages, err := parseClaim([]interface{}{
+				"5-0",
+				[]interface{}{nil, []interface{}{"2-0", []interface{}{"job", "{}"}}},
+				[]interface{}{"1-0"},
+			})
+			require.Nil(t, err)
+			assert.Equal(t, "5-0", next)
+			assert.Equal(t, []redis.XMessage{{ID: "2-0", Values: map[string]interface{}{"job": "{}"}}}, messages)
+		})
+		t.Run("remove", func(t *testing.T) {
+			q, client := newTestQueue(t, nil)
+			require.Nil(t, client.XAdd(ctx, &redis.XAddArgs{Stream: "events", Values: map[string]interface{}{"job": `{"class":"WebhookWorker"}`}}).Err())
+
+			j := next(t, q)
+			err := queues.Collect(func(errChan chan error) {
+				q.RemoveItems(ctx, j, &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			require.Nil(t, err)
+
+			length, err := client.XLen(ctx, "events").Result()
+			require.Nil(t, err)
+			assert.Equal(t, int64(0), length)
+			assert.Equal(t, int64(0), pending(t, client, defaultGroup))
+		})
+		t.Run("max length", func(t *testing.T) {
+			q, client := newTestQueue(t, &Configuration{MaxLen: 2})
+
+			for idx := 0; idx < 5; idx++ {
+				err := queues.Collect(func(errChan chan error) {
+					q.PushItems(ctx, queuetest.MakeJob(t, `{"class":"WebhookWorker"}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+				})
+				require.Nil(t, err)
+			}
+
+			length, err := client.XLen(ctx, "events").Result()
+			require.Nil(t, err)
+			assert.LessOrEqual(t, length, int64(5))
+			assert.GreaterOrEqual(t, length, int64(2))
+		})
+	})
+	t.Run("failure", func(t *testing.T) {
+		t.Run("parse claim", func(t *testing.T) {
+			_, _, err := parseClaim([]interface{}{"0-0"})
+			assert.NotNil(t, err)
+		})
+		t.Run("remove unknown job", func(t *testing.T) {
+			q, _ := newTestQueue(t, nil)
+
+			err := queues.Collect(func(errChan chan error) {
+				q.RemoveItems(ctx, queuetest.MakeJob(t, `{"class":"WebhookWorker"}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			})
+			assert.NotNil(t, err)
+		})
+	})
+}
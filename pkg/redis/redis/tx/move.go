@@ -0,0 +1,95 @@
+package tx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/pkg/redis/redis/hashtag"
+)
+
+// attempts is how many times Move tries a transaction the source queue keeps
+// changing under.
+const attempts = 10
+
+// ErrNotFound is returned by Move when the job is no longer in the source
+// queue, such as when a worker has already taken it.
+var ErrNotFound = errors.New("job is no longer in the queue")
+
+// Queue is a redis queue that can push and remove jobs in a transaction, so
+// jobs can be moved between the queues of a client at once.
+type Queue interface {
+	// Client is the client the queue is stored with.
+	Client() redis.UniversalClient
+	// TxKeys returns the keys pushing and removing jobs writes, nil when the
+	// queue's jobs can't be moved in a transaction.
+	TxKeys() []string
+	// Contains reports whether j is in the queue.
+	Contains(ctx context.Context, tx *redis.Tx, j job.Job) (bool, error)
+	// TxPush queues pushing j on pipe.
+	TxPush(ctx context.Context, pipe redis.Pipeliner, j job.Job) error
+	// TxRemove queues removing j on pipe.
+	TxRemove(ctx context.Context, pipe redis.Pipeliner, j job.Job)
+}
+
+// Move moves j from source to destination. When both are queues of the same
+// client, j is removed and pushed in a transaction watching source, so it is
+// either moved or left where it is, never in both. Other queues are moved
+// with queues.Move, which leaves a job it can't remove in both queues.
+func Move(ctx context.Context, destination queues.PushQueue, source queues.RemoveQueue, j job.Job) error {
+	to, ok := destination.(Queue)
+	from, isQueue := source.(Queue)
+	if !ok || !isQueue || !transactional(from, to) {
+		return queues.Move(ctx, destination, source, j)
+	}
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		err := from.Client().Watch(ctx, func(tx *redis.Tx) error {
+			ok, err := from.Contains(ctx, tx, j)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				return ErrNotFound
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				from.TxRemove(ctx, pipe, j)
+				return to.TxPush(ctx, pipe, j)
+			})
+
+			return err
+		}, from.TxKeys()...)
+
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("could not move job, the queue changed %d times", attempts)
+}
+
+// transactional reports whether jobs can be moved from source to destination
+// in one transaction, which needs them on the same client and, in a redis
+// cluster, all their keys in the same slot.
+func transactional(source, destination Queue) bool {
+	client := source.Client()
+	if client != destination.Client() {
+		return false
+	}
+
+	keys := source.TxKeys()
+	destinationKeys := destination.TxKeys()
+	if len(keys) == 0 || len(destinationKeys) == 0 {
+		return false
+	}
+
+	if _, ok := client.(*redis.ClusterClient); !ok {
+		return true
+	}
+
+	return hashtag.SameSlot(append(keys, destinationKeys...)...)
+}
@@ -0,0 +1,75 @@
+package tx
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/queuetest"
+	"github.com/thethan/goqueue/pkg/redis/redis/lrange"
+	"github.com/thethan/goqueue/pkg/redis/redis/sidekiq"
+	"github.com/thethan/goqueue/pkg/redis/redis/zset"
+	"testing"
+)
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		_, err := server.ZAdd("retry", 1, `{"jid":"1"}`)
+		require.Nil(t, err)
+
+		j := queuetest.MakeJob(t, `{"jid":"1"}`)
+		require.Nil(t, Move(ctx, lrange.NewLRangeQueue("held", client), zset.NewZSetQueue("retry", client), j))
+
+		assert.False(t, server.Exists("retry"))
+		list, err := server.List("held")
+		require.Nil(t, err)
+		assert.Equal(t, []string{`{"jid":"1"}`}, list)
+	})
+
+	t.Run("moves between the sets of a sidekiq", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		_, err := server.ZAdd(sidekiq.RetryKey, 1, `{"jid":"1","queue":"default"}`)
+		require.Nil(t, err)
+
+		j := queuetest.MakeJob(t, `{"jid":"1","queue":"default"}`)
+		require.Nil(t, Move(ctx, sidekiq.NewQueue(sidekiq.DeadKey, client), sidekiq.NewQueue(sidekiq.RetryKey, client), j))
+
+		assert.False(t, server.Exists(sidekiq.RetryKey))
+		members, err := server.ZMembers(sidekiq.DeadKey)
+		require.Nil(t, err)
+		assert.Len(t, members, 1)
+	})
+
+	t.Run("moves queues of other clients one after the other", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		_, err := server.ZAdd("retry", 1, `{"jid":"1"}`)
+		require.Nil(t, err)
+
+		source := zset.NewZSetQueue("retry", redis.NewClient(&redis.Options{Addr: server.Addr()}))
+		destination := lrange.NewLRangeQueue("held", redis.NewClient(&redis.Options{Addr: server.Addr()}))
+		require.Nil(t, Move(ctx, destination, source, queuetest.MakeJob(t, `{"jid":"1"}`)))
+
+		assert.False(t, server.Exists("retry"))
+		list, err := server.List("held")
+		require.Nil(t, err)
+		assert.Equal(t, []string{`{"jid":"1"}`}, list)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		_, err := server.ZAdd("retry", 1, `{"jid":"2"}`)
+		require.Nil(t, err)
+
+		// the job was taken before it could be moved
+		err = Move(ctx, lrange.NewLRangeQueue("held", client), zset.NewZSetQueue("retry", client), queuetest.MakeJob(t, `{"jid":"1"}`))
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.False(t, server.Exists("held"))
+	})
+}
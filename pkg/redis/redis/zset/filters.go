@@ -3,6 +3,7 @@ package zset
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"github.com/thethan/goqueue/internal/executers"
 	"github.com/thethan/goqueue/internal/job"
 	"github.com/thethan/goqueue/internal/logs"
@@ -11,14 +12,14 @@ import (
 
 func (z *ZSetQueue) RemoveFilterMiddleWare(key string) executers.FilterMiddleware {
 	return func(next executers.ExecFunc) executers.ExecFunc {
-		return func(ctx context.Context, job job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+		return func(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
 			defer func() {
 				close(errChan)
 			}()
 
 			logs.Debug(ctx, "remove from retry")
 
-			val, err := job.GetValue("retry_count")
+			val, err := j.GetValue("retry_count")
 			if err != nil {
 				errChan <- err
 				return
@@ -26,12 +27,18 @@ func (z *ZSetQueue) RemoveFilterMiddleWare(key string) executers.FilterMiddlewar
 
 			// conditionals
 
-			if float64(val.Int()) > float64(2) {
+			retryCount, ok := job.ToFloat64(val)
+			if !ok {
+				errChan <- fmt.Errorf("retry_count is %s not a number", val.Kind())
+				return
+			}
+
+			if retryCount > float64(2) {
 				// remove from retry queue
 				newErrorChan := make(chan error)
 				stdOut := bytes.NewBuffer([]byte{})
 				stdErr := bytes.NewBuffer([]byte{})
-				go z.ZRangeRemove(ctx, key)(ctx, job, stdOut, stdErr, newErrorChan)
+				go z.ZRangeRemove(ctx, key)(ctx, j, stdOut, stdErr, newErrorChan)
 				for err = range newErrorChan {
 					errChan <- err
 				}
@@ -41,7 +48,7 @@ func (z *ZSetQueue) RemoveFilterMiddleWare(key string) executers.FilterMiddlewar
 
 			nextErrChan := make(chan error)
 			go func() {
-				next(ctx, job, stdOut, stdErr, nextErrChan)
+				next(ctx, j, stdOut, stdErr, nextErrChan)
 			}()
 
 			for err := range nextErrChan {
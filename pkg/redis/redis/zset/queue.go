@@ -3,22 +3,26 @@ package zset
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/thethan/goqueue/internal/job"
 	"github.com/thethan/goqueue/internal/logs"
 	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/pkg/redis/redis/hashtag"
+	"github.com/thethan/goqueue/pkg/redis/redis/reference"
+	"github.com/thethan/goqueue/pkg/redis/redis/sidekiq"
 	"io"
-	"math"
 	"time"
 )
 
 const count = 100
 
-func NewZSetQueue(key string, client *redis.Client) *ZSetQueue {
-	jobJuilder := job.NewBuilder(&job.Configuration{Type: "json"})
+func NewZSetQueue(key string, client redis.UniversalClient, opts ...queues.Option) *ZSetQueue {
+	options := queues.NewOptions(opts...)
+	jobJuilder := job.NewBuilder(options.JobConfiguration)
 
-	return &ZSetQueue{key: key, client: client, jobbuilder: jobJuilder}
+	return &ZSetQueue{key: key, client: client, jobbuilder: jobJuilder, options: options}
 }
 
 func (z *ZSetQueue) GetItems(ctx context.Context, jobChan chan<- job.Job) error {
@@ -49,6 +53,14 @@ func (z *ZSetQueue) GetItems(ctx context.Context, jobChan chan<- job.Job) error
 
 			logs.Info(ctx, "got items from queue", logs.WithValue("count", len(res)))
 
+			if z.references != nil {
+				if err := z.loadReferences(ctx, res, jobChan); err != nil {
+					return err
+				}
+
+				continue
+			}
+
 			for idx := range res {
 				r := res[idx]
 				jobStr, ok := r.Member.(string)
@@ -66,9 +78,9 @@ func (z *ZSetQueue) GetItems(ctx context.Context, jobChan chan<- job.Job) error
 					jobStr = string(byts)
 				}
 
-				j, err := z.jobbuilder.MakeJob([]byte(jobStr))
-				if err != nil {
-					return fmt.Errorf("could not convert job to string")
+				j := queues.MakeJob(ctx, z.jobbuilder, z.options, z, []byte(jobStr))
+				if j == nil {
+					continue
 				}
 
 				jobChan <- j
@@ -77,31 +89,197 @@ func (z *ZSetQueue) GetItems(ctx context.Context, jobChan chan<- job.Job) error
 	}
 }
 
-func (z *ZSetQueue) PushItems(context.Context, job.Job, io.ReadWriter, io.ReadWriter, chan error) {
-	//TODO implement me
-	panic("implement me")
+// loadReferences sends the jobs the members refer to.
+func (z *ZSetQueue) loadReferences(ctx context.Context, res []redis.Z, jobChan chan<- job.Job) error {
+	ids := make([]string, 0, len(res))
+	for idx := range res {
+		id, ok := res[idx].Member.(string)
+		if !ok {
+			return fmt.Errorf("could not convert member to string")
+		}
+
+		ids = append(ids, id)
+	}
+
+	entries, err := z.references.Load(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for idx := range entries {
+		source := &reference.Member{ID: entries[idx].ID, Remove: z.remove}
+		j := queues.MakeJob(ctx, z.jobbuilder, z.options, source, entries[idx].Payload)
+		if j == nil {
+			continue
+		}
+
+		z.references.Track(j, entries[idx].ID)
+		jobChan <- j
+	}
+
+	return nil
+}
+
+// LoadFrom makes the queue's members the ids of jobs kept in store.
+func (z *ZSetQueue) LoadFrom(store *reference.Store) {
+	z.references = store
+}
+
+// Count returns how many members the set has.
+func (z *ZSetQueue) Count(ctx context.Context) (int64, error) {
+	return z.client.ZCard(ctx, z.key).Result()
+}
+
+// Page returns the jobs of count members from offset, lowest score first,
+// without removing them.
+func (z *ZSetQueue) Page(ctx context.Context, offset, count int64) ([]job.Job, error) {
+	members, err := z.client.ZRange(ctx, z.key, offset, offset+count-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if z.references != nil {
+		return z.pageReferences(ctx, members)
+	}
+
+	jobs := make([]job.Job, 0, len(members))
+	for idx := range members {
+		j := queues.PageJob(ctx, z.jobbuilder, []byte(members[idx]))
+		if j == nil {
+			continue
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
+
+// pageReferences returns the jobs the members ids refer to, tracked so they
+// can be removed.
+func (z *ZSetQueue) pageReferences(ctx context.Context, ids []string) ([]job.Job, error) {
+	entries, err := z.references.Load(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]job.Job, 0, len(entries))
+	for idx := range entries {
+		j := queues.PageJob(ctx, z.jobbuilder, entries[idx].Payload)
+		if j == nil {
+			continue
+		}
+
+		z.references.Track(j, entries[idx].ID)
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
+
+func (z *ZSetQueue) PushItems(ctx context.Context, job job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	z.ZRangePushItems(ctx, z.key)(ctx, job, stdOut, stdErr, errChan)
 }
 
 func (z *ZSetQueue) RemoveItems(ctx context.Context, job job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
-	close(errChan)
-	intCmd := z.client.ZRem(ctx, z.key, job.Raw())
+	defer func() {
+		close(errChan)
+	}()
+
+	if z.references != nil {
+		id, ok := z.references.ID(job)
+		if !ok {
+			errChan <- errors.New("could not find the member of the job")
+			return
+		}
+
+		if err := z.remove(ctx, id); err != nil {
+			errChan <- err
+		}
+
+		return
+	}
+
+	intCmd := z.client.ZRem(ctx, z.key, job.Original())
 	if intCmd.Err() != nil {
 		errChan <- intCmd.Err()
 	}
 
 	jidVal, err := job.GetValue("jid")
 	if err != nil {
-		errChan <- intCmd.Err()
+		logs.Warn(ctx, "removed job has no jid", logs.WithError(err))
 	}
 
 	logs.Info(ctx, "removed from retry queue", logs.WithValue("id", jidVal.String()), logs.WithValue("int", intCmd.Val()))
 
 }
 
+// remove removes the member id and the job it refers to.
+func (z *ZSetQueue) remove(ctx context.Context, id string) error {
+	err := hashtag.TxPipelined(ctx, z.client, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, z.key, id)
+		z.references.Delete(ctx, pipe, id)
+
+		return nil
+	}, z.key, z.references.Key(id))
+	if err != nil {
+		return err
+	}
+
+	logs.Info(ctx, "removed from retry queue", logs.WithValue("id", id))
+
+	return nil
+}
+
+// Client is the client the set is stored with.
+func (z *ZSetQueue) Client() redis.UniversalClient {
+	return z.client
+}
+
+// TxKeys returns the set's key, or nil when its members refer to jobs, which
+// are saved under keys of their own.
+func (z *ZSetQueue) TxKeys() []string {
+	if z.references != nil {
+		return nil
+	}
+
+	return []string{z.key}
+}
+
+// Contains reports whether j is a member of the set.
+func (z *ZSetQueue) Contains(ctx context.Context, tx *redis.Tx, j job.Job) (bool, error) {
+	err := tx.ZScore(ctx, z.key, string(j.Original())).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// TxPush queues adding j to the set on pipe, scored like PushItems.
+func (z *ZSetQueue) TxPush(ctx context.Context, pipe redis.Pipeliner, j job.Job) error {
+	member, err := z.jobbuilder.Encode(j)
+	if err != nil {
+		return err
+	}
+
+	pipe.ZAdd(ctx, z.key, &redis.Z{Member: member, Score: getDelay(j)})
+
+	return nil
+}
+
+// TxRemove queues removing j from the set on pipe.
+func (z *ZSetQueue) TxRemove(ctx context.Context, pipe redis.Pipeliner, j job.Job) {
+	pipe.ZRem(ctx, z.key, j.Original())
+}
+
 type ZSetQueue struct {
 	jobbuilder *job.Builder
+	options    queues.Options
 	key        string
-	client     *redis.Client
+	client     redis.UniversalClient
+	// references loads the jobs members refer to, nil when members are jobs
+	references *reference.Store
 }
 
 func (z *ZSetQueue) ZRangeRemove(ctx context.Context, key string) queues.RemoveItem {
@@ -118,7 +296,7 @@ func (z *ZSetQueue) ZRangeRemove(ctx context.Context, key string) queues.RemoveI
 
 		jidVal, err := jobJob.GetValue("jid")
 		if err != nil {
-
+			logs.Warn(ctx, "removed job has no jid", logs.WithError(err))
 		}
 
 		logs.Info(ctx, "removed from retry queue", logs.WithValue("jid", jidVal.String()))
@@ -133,7 +311,31 @@ func (z *ZSetQueue) ZRangePushItems(ctx context.Context, key string) queues.Push
 			close(errChan)
 		}()
 
-		zQuery := &redis.Z{Member: jobJob.Raw(), Score: getDelay(jobJob)}
+		member, err := z.jobbuilder.Encode(jobJob)
+		if err != nil {
+			errChan <- err
+			return
+		}
+
+		if z.references != nil {
+			_, err := z.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				id, err := z.references.Save(ctx, pipe, jobJob, member)
+				if err != nil {
+					return err
+				}
+
+				pipe.ZAdd(ctx, key, &redis.Z{Member: id, Score: getDelay(jobJob)})
+
+				return nil
+			})
+			if err != nil {
+				errChan <- err
+			}
+
+			return
+		}
+
+		zQuery := &redis.Z{Member: member, Score: getDelay(jobJob)}
 		intCmd := z.client.ZAdd(ctx, key, zQuery)
 		if intCmd.Err() != nil {
 
@@ -143,27 +345,12 @@ func (z *ZSetQueue) ZRangePushItems(ctx context.Context, key string) queues.Push
 	}
 }
 
+// getDelay scores a job by when it should be retried, using sidekiq's retry
+// backoff.
 func getDelay(jobJob job.Job) float64 {
-	retry, err := jobJob.GetValue("retry")
-	if err != nil {
-		logs.Warn(context.Background(), "could not get retry value", logs.WithError(err))
-	}
-
-	switch retry.Interface().(type) {
-	case int, int32, int64:
-		return float64(retry.Int())
-	case bool:
-		return 0
-	case float32, float64:
-		break
-	}
-
-	retryFloat := retry.Float()
-
-	delay := math.Pow(2, retryFloat) + 15 + float64(time.Now().Unix())
-
-	return delay
+	retryAt := time.Now().Add(sidekiq.RetryIn(jobJob))
 
+	return float64(retryAt.UnixNano()) / float64(time.Second)
 }
 
 func (z *ZSetQueue) ZRangeGetItems(ctx context.Context, key string, errChan chan<- error, count int64) queues.GetItems {
@@ -1,13 +1,18 @@
 package zset
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/pkg/redis/redis/reference"
 	"reflect"
 	"testing"
+	"time"
 )
 
 const (
@@ -48,3 +53,120 @@ func Test_LRange_GetItems_Integrations(t *testing.T) {
 		}
 	})
 }
+
+func Test_getDelay(t *testing.T) {
+	builder := job.NewBuilder(&job.Configuration{Type: job.JsonRawJobType})
+
+	t.Run("retry count backoff", func(t *testing.T) {
+		// retry is sidekiq's max retries, not the retry count
+		j, err := builder.MakeJob([]byte(`{"retry":25,"retry_count":3}`))
+		require.Nil(t, err)
+
+		now := float64(time.Now().Unix())
+		delay := getDelay(j) - now
+		assert.GreaterOrEqual(t, delay, float64(81+15))
+		assert.Less(t, delay, float64(81+15+40+1))
+	})
+	t.Run("retry in", func(t *testing.T) {
+		j, err := builder.MakeJob([]byte(`{"retry":true,"retry_count":3,"retry_in":60}`))
+		require.Nil(t, err)
+
+		now := float64(time.Now().Unix())
+		assert.InDelta(t, now+60, getDelay(j), 1)
+	})
+}
+
+func TestZSetQueue_References(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	queue := NewZSetQueue("retry", client)
+	queue.LoadFrom(reference.NewStore(client, &reference.Configuration{Prefix: "job:"}))
+
+	t.Run("success", func(t *testing.T) {
+		j, err := job.NewBuilder(&job.Configuration{Type: job.JsonRawJobType}).MakeJob([]byte(`{"jid":"abc","class":"WebhookWorker"}`))
+		require.Nil(t, err)
+
+		errChan := make(chan error)
+		go queue.PushItems(ctx, j, &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		for err := range errChan {
+			require.Nil(t, err)
+		}
+
+		members, err := server.ZMembers("retry")
+		require.Nil(t, err)
+		assert.Equal(t, []string{"abc"}, members)
+
+		readCtx, cancel := context.WithCancel(ctx)
+		jobChan := make(chan job.Job)
+		go func() {
+			_ = queue.GetItems(readCtx, jobChan)
+		}()
+
+		read := <-jobChan
+		cancel()
+		for range jobChan {
+		}
+
+		class, err := read.GetValue("class")
+		require.Nil(t, err)
+		assert.Equal(t, "WebhookWorker", class.String())
+
+		errChan = make(chan error)
+		go queue.RemoveItems(ctx, read, &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		for err := range errChan {
+			require.Nil(t, err)
+		}
+
+		assert.False(t, server.Exists("retry"))
+		assert.False(t, server.Exists("job:abc"))
+	})
+}
+
+func TestZSetQueue_Page(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	t.Run("success", func(t *testing.T) {
+		t.Run("members", func(t *testing.T) {
+			queue := NewZSetQueue("retry", client)
+			_, err := server.ZAdd("retry", 2, `{"jid":"2"}`)
+			require.Nil(t, err)
+			_, err = server.ZAdd("retry", 1, `{"jid":"1"}`)
+			require.Nil(t, err)
+
+			n, err := queue.Count(ctx)
+			require.Nil(t, err)
+			assert.Equal(t, int64(2), n)
+
+			jobs, err := queue.Page(ctx, 0, 10)
+			require.Nil(t, err)
+			require.Len(t, jobs, 2)
+			assert.Equal(t, `{"jid":"1"}`, string(jobs[0].Original()))
+			assert.Equal(t, `{"jid":"2"}`, string(jobs[1].Original()))
+		})
+		t.Run("references", func(t *testing.T) {
+			queue := NewZSetQueue("scheduled", client)
+			queue.LoadFrom(reference.NewStore(client, &reference.Configuration{Prefix: "job:"}))
+			_, err := server.ZAdd("scheduled", 1, "abc")
+			require.Nil(t, err)
+			require.Nil(t, server.Set("job:abc", `{"jid":"abc"}`))
+
+			jobs, err := queue.Page(ctx, 0, 10)
+			require.Nil(t, err)
+			require.Len(t, jobs, 1)
+
+			// paged jobs can be removed
+			errChan := make(chan error)
+			go queue.RemoveItems(ctx, jobs[0], &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+			for err := range errChan {
+				require.Nil(t, err)
+			}
+
+			assert.False(t, server.Exists("scheduled"))
+			assert.False(t, server.Exists("job:abc"))
+		})
+	})
+}
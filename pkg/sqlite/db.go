@@ -0,0 +1,73 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	// registers the pure go sqlite driver
+	_ "modernc.org/sqlite"
+)
+
+const defaultBusyTimeout = 5 * time.Second
+
+// schema creates a table for each state a job can be in. Every table has
+// the same columns so jobs move between them with INSERT ... SELECT, at
+// being when the job was made ready, is due, stops being in flight or died,
+// in unix milliseconds.
+var schema = []string{
+	tableSchema(Ready),
+	`CREATE INDEX IF NOT EXISTS ready_order ON ready (queue, priority DESC, id)`,
+	tableSchema(Scheduled),
+	`CREATE INDEX IF NOT EXISTS scheduled_due ON scheduled (queue, at)`,
+	tableSchema(InFlight),
+	`CREATE INDEX IF NOT EXISTS inflight_deadline ON inflight (queue, at)`,
+	tableSchema(Dead),
+	`CREATE INDEX IF NOT EXISTS dead_order ON dead (queue, id)`,
+}
+
+func tableSchema(state State) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	queue TEXT NOT NULL,
+	priority INTEGER NOT NULL DEFAULT 0,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	at INTEGER NOT NULL,
+	payload BLOB NOT NULL
+)`, state)
+}
+
+// Open opens the database at path, creating it and its tables if need be.
+// Writers wait up to busyTimeout, five seconds when zero, for each other.
+func Open(ctx context.Context, path string, busyTimeout time.Duration) (*sql.DB, error) {
+	if busyTimeout <= 0 {
+		busyTimeout = defaultBusyTimeout
+	}
+
+	query := url.Values{}
+	query.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", busyTimeout.Milliseconds()))
+	query.Add("_pragma", "journal_mode(WAL)")
+	// transactions take the write lock up front, so two processes claiming
+	// jobs wait for each other instead of failing to upgrade their locks
+	query.Set("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite", path+"?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite has a single writer, queues in this process take turns on one
+	// connection
+	db.SetMaxOpenConns(1)
+
+	for _, statement := range schema {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("could not create tables in %s: %w", path, err)
+		}
+	}
+
+	return db, nil
+}
@@ -0,0 +1,573 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/logs"
+	"github.com/thethan/goqueue/internal/queues"
+	"io"
+	"sync"
+	"time"
+)
+
+const count = 100
+
+const (
+	defaultVisibilityTimeout = 30 * time.Second
+	defaultPollInterval      = time.Second
+)
+
+// State is the table a job is in.
+type State string
+
+const (
+	// Ready jobs wait to be claimed, highest priority first
+	Ready State = "ready"
+	// Scheduled jobs become ready once they are due
+	Scheduled State = "scheduled"
+	// InFlight jobs have been claimed and become ready again when they
+	// aren't acked before their visibility timeout
+	InFlight State = "inflight"
+	// Dead jobs are kept until they are retried or removed
+	Dead State = "dead"
+)
+
+// Actions a Queue exposes to pipelines.
+const (
+	// RetryAction moves a job to the ready table
+	RetryAction = "retry"
+	// KillAction moves a job to the dead table
+	KillAction = "kill"
+)
+
+// ErrNotFound is returned when a job is no longer in the table it was read
+// from.
+var ErrNotFound = errors.New("job is no longer in the queue")
+
+// Configuration of a Queue.
+type Configuration struct {
+	// Name is the queue's name in the database, several queues can share
+	// the tables
+	Name string
+	// State is the table the queue reads, ready by default. Ready queues
+	// claim jobs, other queues read them where they are.
+	State State
+	// VisibilityTimeout is how long a claimed job stays in flight before it
+	// is ready again, 30 seconds by default
+	VisibilityTimeout time.Duration
+	// PollInterval is how long to wait before reading again when there was
+	// nothing to claim, one second by default
+	PollInterval time.Duration
+	// PriorityField is the field holding a pushed job's priority, higher
+	// first. Jobs without it have priority 0.
+	PriorityField string
+	// RunAtField is the field holding the unix time in seconds a pushed job
+	// is due. Jobs pushed to a ready queue that aren't due yet are
+	// scheduled.
+	RunAtField string
+}
+
+type row struct {
+	id      int64
+	payload []byte
+}
+
+// Queue is one of the tables of a sqlite database. Ready queues move the
+// jobs they read to the in flight table in the same transaction, jobs acked
+// successfully are deleted and jobs that failed are ready again once their
+// visibility timeout passes. Due scheduled jobs are made ready before each
+// claim.
+type Queue struct {
+	jobbuilder    *job.Builder
+	options       queues.Options
+	configuration Configuration
+	db            *sql.DB
+	now           func() time.Time
+
+	mu sync.Mutex
+	// ids are the row ids of the jobs handed out and not yet acked
+	ids map[job.Job]int64
+	// page are the jobs of the last page read by Page by row id, which are
+	// in the queue's State table rather than in flight
+	page map[int64]job.Job
+}
+
+// NewQueue returns the queue in db, opened with Open.
+func NewQueue(db *sql.DB, configuration *Configuration, opts ...queues.Option) *Queue {
+	options := queues.NewOptions(opts...)
+	jobJuilder := job.NewBuilder(options.JobConfiguration)
+
+	q := &Queue{db: db, jobbuilder: jobJuilder, options: options, now: time.Now, ids: map[job.Job]int64{}, page: map[int64]job.Job{}}
+	if configuration != nil {
+		q.configuration = *configuration
+	}
+
+	if q.configuration.State == "" {
+		q.configuration.State = Ready
+	}
+
+	if q.configuration.VisibilityTimeout <= 0 {
+		q.configuration.VisibilityTimeout = defaultVisibilityTimeout
+	}
+
+	if q.configuration.PollInterval <= 0 {
+		q.configuration.PollInterval = defaultPollInterval
+	}
+
+	return q
+}
+
+// table is where the jobs the queue hands out are, ready queues hand out
+// the jobs they claimed into the in flight table.
+func (q *Queue) table() State {
+	if q.configuration.State == Ready {
+		return InFlight
+	}
+
+	return q.configuration.State
+}
+
+func (q *Queue) GetItems(ctx context.Context, jobChan chan<- job.Job) error {
+	defer func() {
+		close(jobChan)
+	}()
+
+	for {
+		rows, err := q.next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		if len(rows) > 0 {
+			logs.Info(ctx, "got items from sqlite", logs.WithValue("queue", q.configuration.Name), logs.WithValue("count", len(rows)))
+		}
+
+		for idx := range rows {
+			j := queues.MakeJob(ctx, q.jobbuilder, q.options, &entry{queue: q, id: rows[idx].id}, rows[idx].payload)
+			if j == nil {
+				continue
+			}
+
+			q.mu.Lock()
+			q.ids[j] = rows[idx].id
+			q.mu.Unlock()
+
+			select {
+			case <-ctx.Done():
+				q.release(rows[idx:])
+				return nil
+			case jobChan <- j:
+			}
+		}
+
+		// ready queues claim again straight away while there are jobs
+		if q.configuration.State == Ready && len(rows) > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(q.configuration.PollInterval):
+		}
+	}
+}
+
+func (q *Queue) next(ctx context.Context) ([]row, error) {
+	if q.configuration.State == Ready {
+		return q.claim(ctx)
+	}
+
+	order := "id"
+	if q.configuration.State == Scheduled {
+		order = "at, id"
+	}
+
+	return query(ctx, q.db, fmt.Sprintf(`SELECT id, payload FROM %s WHERE queue = ? ORDER BY %s LIMIT ?`, q.configuration.State, order),
+		q.configuration.Name, count)
+}
+
+// claim makes due scheduled jobs and expired in flight jobs ready, then
+// moves the first ready jobs in flight.
+func (q *Queue) claim(ctx context.Context) ([]row, error) {
+	var claimed []row
+	err := q.transaction(ctx, func(tx *sql.Tx) error {
+		now := q.now().UnixMilli()
+		for _, from := range []State{Scheduled, InFlight} {
+			_, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO ready (queue, priority, attempts, at, payload)
+				SELECT queue, priority, attempts, ?, payload FROM %s WHERE queue = ? AND at <= ? ORDER BY id`, from),
+				now, q.configuration.Name, now)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE queue = ? AND at <= ?`, from), q.configuration.Name, now)
+			if err != nil {
+				return err
+			}
+		}
+
+		ready, err := query(ctx, tx, `SELECT id, payload FROM ready WHERE queue = ? ORDER BY priority DESC, id LIMIT ?`,
+			q.configuration.Name, count)
+		if err != nil {
+			return err
+		}
+
+		deadline := now + q.configuration.VisibilityTimeout.Milliseconds()
+		claimed = make([]row, 0, len(ready))
+		for idx := range ready {
+			id, err := move(ctx, tx, Ready, InFlight, ready[idx].id, deadline, 1)
+			if err != nil {
+				return err
+			}
+
+			claimed = append(claimed, row{id: id, payload: ready[idx].payload})
+		}
+
+		return nil
+	})
+
+	return claimed, err
+}
+
+// Count returns how many jobs are in the queue's table.
+func (q *Queue) Count(ctx context.Context) (int64, error) {
+	var n int64
+	err := q.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE queue = ?`, q.configuration.State), q.configuration.Name).Scan(&n)
+
+	return n, err
+}
+
+// Page returns count jobs from offset in the order the queue hands them out,
+// without claiming them. The jobs of the last page read can be removed or
+// moved from where they are, until the next page is read.
+func (q *Queue) Page(ctx context.Context, offset, count int64) ([]job.Job, error) {
+	order := "id"
+	switch q.configuration.State {
+	case Ready:
+		order = "priority DESC, id"
+	case Scheduled:
+		order = "at, id"
+	}
+
+	rows, err := query(ctx, q.db, fmt.Sprintf(`SELECT id, payload FROM %s WHERE queue = ? ORDER BY %s LIMIT ? OFFSET ?`, q.configuration.State, order),
+		q.configuration.Name, count, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	page := make(map[int64]job.Job, len(rows))
+	jobs := make([]job.Job, 0, len(rows))
+	for idx := range rows {
+		j := queues.PageJob(ctx, q.jobbuilder, rows[idx].payload)
+		if j == nil {
+			continue
+		}
+
+		page[rows[idx].id] = j
+		jobs = append(jobs, j)
+	}
+
+	q.mu.Lock()
+	q.page = page
+	q.mu.Unlock()
+
+	return jobs, nil
+}
+
+// release makes jobs claimed but never handed out ready again.
+func (q *Queue) release(rows []row) {
+	ctx := context.Background()
+	err := q.transaction(ctx, func(tx *sql.Tx) error {
+		for idx := range rows {
+			if _, err := move(ctx, tx, InFlight, Ready, rows[idx].id, q.now().UnixMilli(), -1); err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		logs.Error(ctx, "could not release claimed jobs", logs.WithError(err), logs.WithValue("queue", q.configuration.Name))
+	}
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func query(ctx context.Context, db querier, statement string, args ...interface{}) ([]row, error) {
+	rows, err := db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	found := make([]row, 0)
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.payload); err != nil {
+			return nil, err
+		}
+
+		found = append(found, r)
+	}
+
+	return found, rows.Err()
+}
+
+// move moves the job with id between tables in the transaction, setting its
+// at and adding to its attempts, and returns its id in the new table.
+func move(ctx context.Context, tx *sql.Tx, from, to State, id, at int64, attempts int) (int64, error) {
+	result, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (queue, priority, attempts, at, payload)
+		SELECT queue, priority, attempts + ?, ?, payload FROM %s WHERE id = ?`, to, from), attempts, at, id)
+	if err != nil {
+		return 0, err
+	}
+
+	if moved, err := result.RowsAffected(); err != nil || moved == 0 {
+		return 0, ErrNotFound
+	}
+
+	moved, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, from), id); err != nil {
+		return 0, err
+	}
+
+	return moved, nil
+}
+
+func (q *Queue) transaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PushItems adds the job to the queue's table. Jobs pushed to a ready queue
+// that aren't due yet are scheduled.
+func (q *Queue) PushItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	if q.configuration.State == InFlight {
+		errChan <- errors.New("can not push jobs in flight")
+		return
+	}
+
+	bts, err := q.jobbuilder.Encode(j)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	state := q.configuration.State
+	now := q.now().UnixMilli()
+	at := now
+	if runAt, ok := field(j, q.configuration.RunAtField); ok {
+		at = int64(runAt * 1000)
+		if state == Ready && at > now {
+			state = Scheduled
+		}
+	}
+
+	priority, _ := field(j, q.configuration.PriorityField)
+
+	_, err = q.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (queue, priority, at, payload) VALUES (?, ?, ?, ?)`, state),
+		q.configuration.Name, int64(priority), at, bts)
+	if err != nil {
+		errChan <- err
+	}
+}
+
+// field returns the number in the job's field.
+func field(j job.Job, name string) (float64, bool) {
+	if name == "" {
+		return 0, false
+	}
+
+	val, err := j.GetValue(name)
+	if err != nil {
+		return 0, false
+	}
+
+	return job.ToFloat64(val)
+}
+
+// RemoveItems deletes the job.
+func (q *Queue) RemoveItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	table, id, ok := q.locate(j)
+	if !ok {
+		errChan <- errors.New("job was not read from this queue")
+		return
+	}
+
+	if err := q.remove(ctx, table, id); err != nil {
+		errChan <- err
+		return
+	}
+
+	q.forget(table, id)
+}
+
+func (q *Queue) remove(ctx context.Context, table State, id int64) error {
+	if _, err := q.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, table), id); err != nil {
+		return err
+	}
+
+	logs.Info(ctx, "removed from sqlite", logs.WithValue("queue", q.configuration.Name), logs.WithValue("table", table), logs.WithValue("id", id))
+
+	return nil
+}
+
+// Ack deletes a job a ready queue claimed once the pipeline processed it
+// successfully. Jobs that failed stay in flight until their visibility
+// timeout passes.
+func (q *Queue) Ack(ctx context.Context, j job.Job, success bool) error {
+	id, ok := q.take(j)
+	if !ok || !success || q.configuration.State != Ready {
+		return nil
+	}
+
+	_, err := q.db.ExecContext(ctx, `DELETE FROM inflight WHERE id = ?`, id)
+
+	return err
+}
+
+// locate returns the table and row id of a job read from the queue, handed
+// out or read by Page.
+func (q *Queue) locate(j job.Job) (State, int64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if id, ok := q.ids[j]; ok {
+		return q.table(), id, true
+	}
+
+	for id, paged := range q.page {
+		if paged == j {
+			return q.configuration.State, id, true
+		}
+	}
+
+	return "", 0, false
+}
+
+// forget stops tracking the row id of table, read by Page, once it left the
+// table.
+func (q *Queue) forget(table State, id int64) {
+	if table != q.configuration.State {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.page, id)
+}
+
+// take returns and forgets the row id of a job read from the queue.
+func (q *Queue) take(j job.Job) (int64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id, ok := q.ids[j]
+	delete(q.ids, j)
+
+	return id, ok
+}
+
+// Action returns the pipeline action called name.
+func (q *Queue) Action(name string) (queues.Action, bool) {
+	switch name {
+	case RetryAction:
+		return q.Retry, true
+	case KillAction:
+		return q.Kill, true
+	}
+
+	return nil, false
+}
+
+// Retry moves the job to the ready table.
+func (q *Queue) Retry(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	q.moveJob(ctx, j, Ready, errChan)
+}
+
+// Kill moves the job to the dead table.
+func (q *Queue) Kill(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	q.moveJob(ctx, j, Dead, errChan)
+}
+
+func (q *Queue) moveJob(ctx context.Context, j job.Job, to State, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	from, id, ok := q.locate(j)
+	if !ok {
+		errChan <- errors.New("job was not read from this queue")
+		return
+	}
+
+	if from == to {
+		errChan <- fmt.Errorf("job is already %s", to)
+		return
+	}
+
+	err := q.transaction(ctx, func(tx *sql.Tx) error {
+		_, err := move(ctx, tx, from, to, id, q.now().UnixMilli(), 0)
+		return err
+	})
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	q.forget(from, id)
+
+	logs.Info(ctx, "moved sqlite job", logs.WithValue("queue", q.configuration.Name), logs.WithValue("from", from), logs.WithValue("to", to), logs.WithValue("id", id))
+}
+
+// entry removes a single row, the source of jobs quarantined before they
+// were handed out.
+type entry struct {
+	queue *Queue
+	id    int64
+}
+
+func (e *entry) RemoveItems(ctx context.Context, j job.Job, stdOut io.ReadWriter, stdErr io.ReadWriter, errChan chan error) {
+	defer func() {
+		close(errChan)
+	}()
+
+	if err := e.queue.remove(ctx, e.queue.table(), e.id); err != nil {
+		errChan <- err
+	}
+}
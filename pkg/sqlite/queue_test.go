@@ -0,0 +1,287 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thethan/goqueue/internal/job"
+	"github.com/thethan/goqueue/internal/queues"
+	"github.com/thethan/goqueue/internal/queuetest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func open(t *testing.T) *sql.DB {
+	db, err := Open(context.Background(), filepath.Join(t.TempDir(), "queue.db"), 0)
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db
+}
+
+func push(t *testing.T, q *Queue, payload string) {
+	err := queues.Collect(func(errChan chan error) {
+		q.PushItems(context.Background(), queuetest.MakeJob(t, payload), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+	})
+	require.Nil(t, err)
+}
+
+// read returns the first n jobs q hands out.
+func read(t *testing.T, q *Queue, n int) []job.Job {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	jobChan := make(chan job.Job)
+	go func() {
+		_ = q.GetItems(ctx, jobChan)
+	}()
+
+	jobs := make([]job.Job, 0, n)
+	for j := range jobChan {
+		if len(jobs) < n {
+			jobs = append(jobs, j)
+		}
+
+		if len(jobs) == n {
+			cancel()
+		}
+	}
+
+	require.Len(t, jobs, n)
+
+	return jobs
+}
+
+func originals(jobs []job.Job) []string {
+	payloads := make([]string, len(jobs))
+	for idx := range jobs {
+		payloads[idx] = string(jobs[idx].Original())
+	}
+
+	return payloads
+}
+
+func rows(t *testing.T, db *sql.DB, state State) int {
+	var n int
+	require.Nil(t, db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s`, state)).Scan(&n))
+
+	return n
+}
+
+func TestOpen(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queue.db")
+		db, err := Open(context.Background(), path, 0)
+		require.Nil(t, err)
+		require.Nil(t, db.Close())
+
+		// the tables already exist
+		db, err = Open(context.Background(), path, time.Second)
+		require.Nil(t, err)
+		require.Nil(t, db.Close())
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		_, err := Open(context.Background(), filepath.Join(t.TempDir(), "missing", "queue.db"), 0)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestQueue_Ready(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		db := open(t)
+		q := NewQueue(db, &Configuration{Name: "webhooks", PriorityField: "priority", PollInterval: 10 * time.Millisecond})
+		push(t, q, `{"jid":"1"}`)
+		push(t, q, `{"jid":"2","priority":5}`)
+		push(t, q, `{"jid":"3","priority":1}`)
+		push(t, NewQueue(db, &Configuration{Name: "other"}), `{"jid":"4"}`)
+
+		jobs := read(t, q, 3)
+		assert.Equal(t, []string{`{"jid":"2","priority":5}`, `{"jid":"3","priority":1}`, `{"jid":"1"}`}, originals(jobs))
+		assert.Equal(t, 3, rows(t, db, InFlight))
+		assert.Equal(t, 1, rows(t, db, Ready))
+
+		require.Nil(t, q.Ack(context.Background(), jobs[0], true))
+		require.Nil(t, q.Ack(context.Background(), jobs[1], false))
+		require.Nil(t, queues.Collect(func(errChan chan error) {
+			q.RemoveItems(context.Background(), jobs[2], &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		}))
+		assert.Equal(t, 1, rows(t, db, InFlight))
+
+		// the failed job is ready again once its visibility timeout passes
+		q.now = func() time.Time {
+			return time.Now().Add(time.Minute)
+		}
+		jobs = read(t, q, 1)
+		assert.Equal(t, []string{`{"jid":"3","priority":1}`}, originals(jobs))
+	})
+
+	t.Run("schedules jobs that aren't due", func(t *testing.T) {
+		db := open(t)
+		q := NewQueue(db, &Configuration{Name: "webhooks", RunAtField: "at", PollInterval: 10 * time.Millisecond})
+		push(t, q, fmt.Sprintf(`{"jid":"1","at":%d}`, time.Now().Add(time.Hour).Unix()))
+		push(t, q, `{"jid":"2"}`)
+		assert.Equal(t, 1, rows(t, db, Scheduled))
+
+		jobs := read(t, q, 1)
+		assert.Equal(t, []string{`{"jid":"2"}`}, originals(jobs))
+
+		q.now = func() time.Time {
+			return time.Now().Add(2 * time.Hour)
+		}
+		jobs = read(t, q, 1)
+		jid, err := jobs[0].GetValue("jid")
+		require.Nil(t, err)
+		assert.Equal(t, "1", jid.String())
+		assert.Equal(t, 0, rows(t, db, Scheduled))
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		q := NewQueue(open(t), &Configuration{Name: "webhooks", State: InFlight})
+
+		err := queues.Collect(func(errChan chan error) {
+			q.PushItems(context.Background(), queuetest.MakeJob(t, `{"jid":"1"}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		})
+		assert.NotNil(t, err)
+
+		err = queues.Collect(func(errChan chan error) {
+			q.RemoveItems(context.Background(), queuetest.MakeJob(t, `{"jid":"1"}`), &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestQueue_Action(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		db := open(t)
+		ready := NewQueue(db, &Configuration{Name: "webhooks", PollInterval: 10 * time.Millisecond})
+		push(t, ready, `{"jid":"1"}`)
+
+		jobs := read(t, ready, 1)
+		kill, ok := ready.Action(KillAction)
+		require.True(t, ok)
+		require.Nil(t, queues.Collect(func(errChan chan error) {
+			kill(context.Background(), jobs[0], &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		}))
+		require.Nil(t, ready.Ack(context.Background(), jobs[0], true))
+		assert.Equal(t, 0, rows(t, db, InFlight))
+		assert.Equal(t, 1, rows(t, db, Dead))
+
+		dead := NewQueue(db, &Configuration{Name: "webhooks", State: Dead, PollInterval: 10 * time.Millisecond})
+		jobs = read(t, dead, 1)
+		assert.Equal(t, []string{`{"jid":"1"}`}, originals(jobs))
+		// dead jobs are read where they are
+		assert.Equal(t, 1, rows(t, db, Dead))
+
+		retry, ok := dead.Action(RetryAction)
+		require.True(t, ok)
+		require.Nil(t, queues.Collect(func(errChan chan error) {
+			retry(context.Background(), jobs[0], &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		}))
+		assert.Equal(t, 0, rows(t, db, Dead))
+
+		jobs = read(t, ready, 1)
+		assert.Equal(t, []string{`{"jid":"1"}`}, originals(jobs))
+
+		var attempts int
+		require.Nil(t, db.QueryRow(`SELECT attempts FROM inflight`).Scan(&attempts))
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		db := open(t)
+		dead := NewQueue(db, &Configuration{Name: "webhooks", State: Dead, PollInterval: 10 * time.Millisecond})
+		push(t, dead, `{"jid":"1"}`)
+
+		jobs := read(t, dead, 1)
+		kill, _ := dead.Action(KillAction)
+		err := queues.Collect(func(errChan chan error) {
+			kill(context.Background(), jobs[0], &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		})
+		assert.NotNil(t, err)
+
+		_, ok := dead.Action("unknown")
+		assert.False(t, ok)
+	})
+}
+
+func TestQueue_Page(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		db := open(t)
+		ready := NewQueue(db, &Configuration{Name: "webhooks", PriorityField: "priority"})
+		push(t, ready, `{"jid":"1"}`)
+		push(t, ready, `{"jid":"2","priority":5}`)
+		push(t, ready, `{"jid":"3"}`)
+
+		n, err := ready.Count(context.Background())
+		require.Nil(t, err)
+		assert.Equal(t, int64(3), n)
+
+		jobs, err := ready.Page(context.Background(), 0, 2)
+		require.Nil(t, err)
+		assert.Equal(t, []string{`{"jid":"2","priority":5}`, `{"jid":"1"}`}, originals(jobs))
+		// paged jobs aren't claimed but can be moved from where they are
+		assert.Equal(t, 3, rows(t, db, Ready))
+		assert.Equal(t, 0, rows(t, db, InFlight))
+		require.Nil(t, queues.Collect(func(errChan chan error) {
+			ready.Kill(context.Background(), jobs[0], &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		}))
+		assert.Equal(t, 2, rows(t, db, Ready))
+		assert.Equal(t, 1, rows(t, db, Dead))
+
+		dead := NewQueue(db, &Configuration{Name: "webhooks", State: Dead})
+		push(t, dead, `{"jid":"4"}`)
+		push(t, dead, `{"jid":"5"}`)
+
+		jobs, err = dead.Page(context.Background(), 2, 2)
+		require.Nil(t, err)
+		assert.Equal(t, []string{`{"jid":"5"}`}, originals(jobs))
+
+		require.Nil(t, queues.Collect(func(errChan chan error) {
+			dead.RemoveItems(context.Background(), jobs[0], &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		}))
+		assert.Equal(t, 2, rows(t, db, Dead))
+	})
+
+	t.Run("only the last page is tracked", func(t *testing.T) {
+		db := open(t)
+		q := NewQueue(db, &Configuration{Name: "webhooks"})
+		push(t, q, `{"jid":"1"}`)
+		push(t, q, `{"jid":"2"}`)
+		push(t, q, `{"jid":"3"}`)
+
+		first, err := q.Page(context.Background(), 0, 2)
+		require.Nil(t, err)
+
+		last, err := q.Page(context.Background(), 2, 2)
+		require.Nil(t, err)
+		assert.Len(t, q.page, 1)
+
+		assert.NotNil(t, queues.Collect(func(errChan chan error) {
+			q.RemoveItems(context.Background(), first[0], &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		}))
+		require.Nil(t, queues.Collect(func(errChan chan error) {
+			q.RemoveItems(context.Background(), last[0], &bytes.Buffer{}, &bytes.Buffer{}, errChan)
+		}))
+		assert.Empty(t, q.page)
+		assert.Equal(t, 2, rows(t, db, Ready))
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		db := open(t)
+		q := NewQueue(db, &Configuration{Name: "webhooks"})
+		require.Nil(t, db.Close())
+
+		_, err := q.Count(context.Background())
+		assert.NotNil(t, err)
+
+		_, err = q.Page(context.Background(), 0, 10)
+		assert.NotNil(t, err)
+	})
+}